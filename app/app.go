@@ -1,215 +1,933 @@
 package app
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"net/http"
 	"os"
-	"reflect"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/applicationautoscaling/applicationautoscalingiface"
 	"github.com/aws/aws-sdk-go/service/dynamodb"
 	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"github.com/aws/aws-sdk-go/service/kms/kmsiface"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
 	"github.com/marcoalmeida/callme/task"
 	"github.com/marcoalmeida/callme/util"
 	"go.uber.org/zap"
+	"golang.org/x/sync/singleflight"
 )
 
 const (
-	defaultListenIP        = "0.0.0.0"
-	defaultListenPort      = 6777
-	defaultDynamoDBTable   = "callme-tasks"
-	defaultDynamoDBRegion  = "us-east-1"
-	defaultDynamoDBIndex   = "inverted_index"
-	defaultConnectTimeout  = 1000
-	defaultClientTimeout   = 3000
-	defaultMaxRetires      = 3
-	defaultCatchupInterval = 5
+	defaultListenIP                = "0.0.0.0"
+	defaultListenPort              = 6777
+	defaultDynamoDBTable           = "callme-tasks"
+	defaultDynamoDBRegion          = "us-east-1"
+	defaultDynamoDBIndex           = "inverted_index"
+	defaultDynamoDBConfigTable     = "callme-config"
+	defaultDynamoDBArchiveTable    = "callme-archive"
+	defaultDynamoDBCountersTable   = "callme-counters"
+	defaultConnectTimeout          = 1000
+	defaultClientTimeout           = 3000
+	defaultMaxRetires              = 3
+	defaultCatchupInterval         = 5
+	defaultDeduplicationWindow     = 60000
+	defaultMaxCSVUploadBytes       = 10 << 20
+	defaultCallbackMaxIdleConns    = 100
+	defaultCallbackIdleConnTimeout = 90000
+	// DynamoDB items cannot be larger than 400KB, leave some room for all other attributes
+	defaultMaxPayloadBytes    = 256 << 10
+	defaultCallbackMethod     = "GET"
+	defaultExpectedStatus     = 200
+	defaultMaxStatusResults   = 1000
+	defaultMaxLoopDriftMs     = 5000
+	defaultLoopDriftAlert     = 3
+	defaultEventBusBuffer     = 1000
+	defaultStatusWebhookQueue = 1000
+	defaultStartupRetries     = 10
+	defaultStartupRetryMs     = 2000
+	defaultShutdownTimeout    = 30
+	defaultMinCapacity        = 5
+	defaultMaxCapacity        = 100
+	defaultTargetUtilization  = 70
 )
 
 type CallMe struct {
-	ListenIP         string `callme:"listen_ip"`
-	ListenPort       int    `callme:"listen_port"`
-	Debug            bool   `callme:"debug"`
-	DynamoDBTable    string `callme:"dynamodb_table"`
-	DynamoDBRegion   string `callme:"dynamodb_region"`
-	DynamoDBIndex    string `callme:"dynamodb_index"`
-	DynamoDBEndpoint string `callme:"dynamodb_endpoint"`
-	ConnectTimeout   int    `callme:"connect_timeout"`
-	ClientTimeout    int    `callme:"client_timeout"`
-	MaxRetries       int    `callme:"max_retries"`
-	CatchupInterval  int    `callme:"catchup_interval"`
-	Logger           *zap.Logger
-	ddb              *dynamodb.DynamoDB
-	httpClient       *http.Client
+	ListenIP                  string   `callme:"listen_ip" static:"true"`
+	ListenPort                int      `callme:"listen_port" static:"true"`
+	PathPrefix                string   `callme:"path_prefix" static:"true"`
+	Debug                     bool     `callme:"debug" static:"true"`
+	DynamoDBTable             string   `callme:"dynamodb_table" static:"true"`
+	DynamoDBRegion            string   `callme:"dynamodb_region" static:"true"`
+	DynamoDBIndex             string   `callme:"dynamodb_index" static:"true"`
+	DynamoDBEndpoint          string   `callme:"dynamodb_endpoint" static:"true"`
+	DynamoDBFailoverRegions   []string `callme:"dynamodb_failover_regions" static:"true"`
+	DynamoDBEndpointTemplate  string   `callme:"dynamodb_endpoint_template" static:"true"`
+	DynamoDBFIPS              bool     `callme:"dynamodb_fips" static:"true"`
+	DynamoDBConfigTable       string   `callme:"dynamodb_config_table" static:"true"`
+	DynamoDBArchiveTable      string   `callme:"dynamodb_archive_table" static:"true"`
+	DynamoDBCountersTable     string   `callme:"dynamodb_counters_table" static:"true"`
+	CountTasks                bool     `callme:"count_tasks" static:"true"`
+	ArchiveAfterDays          int      `callme:"archive_after_days" static:"true"`
+	ConnectTimeout            int      `callme:"connect_timeout" static:"true"`
+	ClientTimeout             int      `callme:"client_timeout" static:"true"`
+	ResponseBodyTimeout       int      `callme:"response_body_timeout" static:"true"`
+	MaxRetries                int      `callme:"max_retries"`
+	CatchupInterval           int      `callme:"catchup_interval"`
+	CatchupLease              bool     `callme:"catchup_lease" static:"true"`
+	StoreResponseBody         bool     `callme:"store_response_body"`
+	StoreResponseContentTypes []string `callme:"store_response_content_types"`
+	ResponseRetentionMinutes  int      `callme:"response_retention_minutes"`
+	DeduplicateCallbacks      bool     `callme:"deduplicate_callbacks" static:"true"`
+	DeduplicationWindowMs     int      `callme:"deduplication_window_ms" static:"true"`
+	MaxCSVUploadBytes         int      `callme:"max_csv_upload_bytes"`
+	CallbackMaxIdleConns      int      `callme:"callback_max_idle_conns" static:"true"`
+	CallbackIdleConnTimeoutMs int      `callme:"callback_idle_conn_timeout_ms" static:"true"`
+	CallbackMaxConnsPerHost   int      `callme:"callback_max_conns_per_host" static:"true"`
+	CallbackHTTP2             bool     `callme:"callback_http2"`
+	MaxPayloadBytes           int      `callme:"max_payload_bytes"`
+	DefaultCallbackMethod     string   `callme:"default_callback_method"`
+	DefaultExpectedStatus     int      `callme:"default_expected_status"`
+	AdminToken                string   `callme:"admin_token" secret:"true"`
+	InstanceID                string   `callme:"instance_id" static:"true"`
+	MaxStatusResults          int      `callme:"max_status_results"`
+	MaxConcurrentCallbacks    int      `callme:"max_concurrent_callbacks" static:"true"`
+	ConcurrencyRampSeconds    int      `callme:"concurrency_ramp_seconds" static:"true"`
+	RescheduleOverflow        bool     `callme:"reschedule_overflow"`
+	StatsDAddr                string   `callme:"statsd_addr" static:"true"`
+	StatsDEnv                 string   `callme:"statsd_env" static:"true"`
+	AutoCreateTable           bool     `callme:"auto_create_table" static:"true"`
+	DynamoDBAutoScaling       bool     `callme:"dynamodb_auto_scaling" static:"true"`
+	DynamoDBMinCapacity       int      `callme:"dynamodb_min_capacity" static:"true"`
+	DynamoDBMaxCapacity       int      `callme:"dynamodb_max_capacity" static:"true"`
+	DynamoDBTargetUtilization float64  `callme:"dynamodb_target_utilization" static:"true"`
+	DynamoDBStateIndex        string   `callme:"dynamodb_state_index" static:"true"`
+	MaxLoopDriftMs            int      `callme:"max_loop_drift_ms"`
+	LoopDriftAlertAfter       int      `callme:"loop_drift_alert_after"`
+	NotificationWebhook       string   `callme:"notification_webhook"`
+	EventBusBufferSize        int      `callme:"event_bus_buffer_size" static:"true"`
+	StatusWebhookEndpoint     string   `callme:"status_webhook_endpoint" static:"true"`
+	StatusWebhookSecret       string   `callme:"status_webhook_secret" static:"true" secret:"true"`
+	StatusWebhookQueueDepth   int      `callme:"status_webhook_queue_depth" static:"true"`
+	TaskCreationWebhook       string   `callme:"task_creation_webhook" static:"true"`
+	TaskCreationWebhookSecret string   `callme:"task_creation_webhook_secret" static:"true" secret:"true"`
+	StartupRetryAttempts      int      `callme:"startup_retry_attempts" static:"true"`
+	StartupRetryIntervalMs    int      `callme:"startup_retry_interval_ms" static:"true"`
+	ShutdownTimeoutSeconds    int      `callme:"shutdown_timeout_seconds"`
+	DedupFollowUps            bool     `callme:"dedup_follow_ups"`
+	StatusCacheTTL            int      `callme:"status_cache_ttl" static:"true"`
+	ReplayLogFile             string   `callme:"replay_log" static:"true"`
+	Logger                    *zap.Logger
+	ddb                       dynamodbiface.DynamoDBAPI
+	httpClient                *http.Client
+	http2Client               *http.Client
+	responseCache             *task.ResponseCache
+	limiter                   *callbackLimiter
+	statsd                    statsdClient
+	holidays                  []string
+	holidaysMutex             sync.RWMutex
+	// newly created tasks, so that those due in the current minute can be dispatched right away
+	TaskCreatedChan chan task.Task
+	// running against DynamoDB Local
+	local bool
+	// names of the tasks that are parked, i.e., postponed every minute until unparked
+	parked      map[string]bool
+	parkedMutex sync.RWMutex
+	// recurring periods of time during which callbacks to some hosts are skipped or delayed
+	maintenanceWindows []maintenanceWindow
+	maintenanceMutex   sync.RWMutex
+	// protects the configuration parameters that can be reloaded at runtime
+	configMutex sync.RWMutex
+	// description of the tasks table, fetched once
+	tableMetadata TableMetadata
+	metadataMutex sync.RWMutex
+	// number of tasks waiting to be executed, updated every minute
+	pendingTasks int64
+	// how long callbacks take
+	callbackDurations durationHistogram
+	// number of stored items found to be malformed, and skipped
+	malformedItems int64
+	// set while catching up, only one pass runs at a time
+	catchingUp int32
+	// what catching up found, and did, so far
+	catchupMetrics catchupMetrics
+	// tasks found by the main loop when there was no room left to run them
+	poolOverflows int64
+	// iterations of the main loop that fell behind, in total and in a row
+	loopDrifts       int64
+	driftyIterations int
+	// coalesces concurrent identical status queries
+	statusGroup singleflight.Group
+	// recently looked up tasks, if StatusCacheTTL is set
+	statusCache statusCache
+	// long-polling requests waiting for tasks to change state
+	stateChanges stateChanges
+	// callbacks in progress, so that deleting a task can cancel them, and tasks waiting to start one
+	inFlight      map[string]context.CancelFunc
+	queued        map[string]bool
+	inFlightMutex sync.Mutex
+	// shutting down, no more callbacks are started
+	stopping bool
+	// neither the main loop nor catch up passes execute tasks while paused (1)
+	paused int32
+	// the configuration table was found missing, which is only logged once (1)
+	configTableMissing int32
+	// encrypts and decrypts the data keys of tasks whose payload is stored encrypted
+	kms      kmsiface.KMSAPI
+	dataKeys dataKeyCache
+	// scales the capacity of the tasks table, if created with auto-scaling
+	autoScaler applicationautoscalingiface.ApplicationAutoScalingAPI
+	// source of the payloads referenced by s3 URLs, and those recently fetched
+	s3       s3iface.S3API
+	payloads payloadCache
+	// state changes waiting to be pushed to StatusWebhookEndpoint, if set
+	statusUpdates chan statusUpdate
+	// work carried out in the background, e.g., rescheduling many tasks
+	jobs jobRegistry
+	// tasks waiting to be pushed to TaskCreationWebhook, if set, and those that could not be
+	creations        chan task.Task
+	creationsDropped int64
+	// serves requests when used as an http.Handler
+	mux *http.ServeMux
+	// mutating requests are written to it, if ReplayLogFile is set
+	replayLog *replayLog
 }
 
+// errors that callers may want to handle differently
+var (
+	ErrTaskNotFound      = errors.New("task not found")
+	ErrTaskNotFailed     = errors.New("task has not failed")
+	ErrMaxRetriesReached = errors.New("maximum number of retries reached")
+	ErrAdminDisabled     = errors.New("administrative endpoints are disabled")
+	ErrUnauthorized      = errors.New("unauthorized")
+	ErrCatchupInProgress = errors.New("catch up already in progress")
+	ErrTaskExists        = errors.New("task already exists")
+	ErrTaskNotReserved   = errors.New("task is not reserved, or the reservation expired")
+	ErrCountersDisabled  = errors.New("task counters are disabled")
+	ErrInvalidRange      = errors.New("invalid range, to must not be before from, or more than a day after it")
+	ErrPaused            = errors.New("paused, no tasks are being executed")
+)
+
 // status of all tasks (submitted, running, succeeded, failed, attempted retries, return code/body from the callback)
 type Status struct {
 	Tasks []task.Task `json:"tasks"`
 	// TODO: make this easier for the client, something that just be directly passed to the next call
-	Next  task.Task   `json:"next"`
+	Next task.Task `json:"next"`
+	// order in which the tasks were returned, if any was requested
+	SortedBy      string `json:"sorted_by,omitempty"`
+	SortDirection string `json:"sort_direction,omitempty"`
+	// capacity units consumed by DynamoDB, if requested
+	ConsumedCapacity float64 `json:"consumed_capacity,omitempty"`
+	// tasks were left out and, because they had to be sorted in memory, there is no next page
+	Truncated bool `json:"truncated,omitempty"`
+	// a strongly consistent read was throttled and an eventually consistent one was used instead
+	EventuallyConsistent bool `json:"-"`
+}
+
+// StatusOptions control which tasks, and in which order, are returned by Status
+type StatusOptions struct {
+	// pagination: start after this task, the next field of the previous call
+	StartFrom task.Task
+	// filter out past tasks
+	FutureOnly bool
+	// sort tasks by trigger_at in this direction, if not empty
+	SortDirection string
+	// include the capacity units consumed by DynamoDB
+	ConsumedCapacity bool
+	// maximum number of tasks to return, capped by MaxStatusResults; 0 means up to MaxStatusResults
+	Limit int
+	// only tasks whose callback took longer than this, if positive; only applies when listing all tasks
+	SlowerThanMs int64
+	// only tasks in this state, if not empty; only applies when listing all tasks
+	State string
+	// only tasks created this way, e.g., api or recurring (any parent task), if not empty; does not apply when looking
+	// up a specific task
+	CreatedBy string
+	// only tasks with all of these labels; does not apply when looking up a specific task
+	Labels map[string]string
+	// only tasks whose name starts with this, if not empty; only applies when listing all tasks
+	NamePrefix string
+	// only tasks due at or before this unix timestamp, if positive; only applies when listing all tasks, and not
+	// along with FutureOnly
+	DueBy int64
+}
+
+// possible directions to sort the tasks returned by Status
+const (
+	SortAscending  = "asc"
+	SortDescending = "desc"
+)
+
+// IsValidSortDirection returns true iff direction is empty (unsorted) or one of the supported directions
+func IsValidSortDirection(direction string) bool {
+	return direction == "" || direction == SortAscending || direction == SortDescending
 }
 
 func New(logger *zap.Logger) *CallMe {
 	// set defaults
 	cm := &CallMe{
-		ListenIP:        defaultListenIP,
-		ListenPort:      defaultListenPort,
-		Debug:           false,
-		DynamoDBTable:   defaultDynamoDBTable,
-		DynamoDBRegion:  defaultDynamoDBRegion,
-		DynamoDBIndex:   defaultDynamoDBIndex,
-		ConnectTimeout:  defaultConnectTimeout,
-		ClientTimeout:   defaultClientTimeout,
-		MaxRetries:      defaultMaxRetires,
-		CatchupInterval: defaultCatchupInterval,
-		Logger:          logger,
+		ListenIP:                  defaultListenIP,
+		ListenPort:                defaultListenPort,
+		Debug:                     false,
+		DynamoDBTable:             defaultDynamoDBTable,
+		DynamoDBRegion:            defaultDynamoDBRegion,
+		DynamoDBIndex:             defaultDynamoDBIndex,
+		DynamoDBConfigTable:       defaultDynamoDBConfigTable,
+		DynamoDBArchiveTable:      defaultDynamoDBArchiveTable,
+		DynamoDBCountersTable:     defaultDynamoDBCountersTable,
+		ConnectTimeout:            defaultConnectTimeout,
+		ClientTimeout:             defaultClientTimeout,
+		MaxRetries:                defaultMaxRetires,
+		CatchupInterval:           defaultCatchupInterval,
+		StoreResponseBody:         true,
+		DeduplicationWindowMs:     defaultDeduplicationWindow,
+		MaxCSVUploadBytes:         defaultMaxCSVUploadBytes,
+		CallbackMaxIdleConns:      defaultCallbackMaxIdleConns,
+		CallbackIdleConnTimeoutMs: defaultCallbackIdleConnTimeout,
+		MaxPayloadBytes:           defaultMaxPayloadBytes,
+		DefaultCallbackMethod:     defaultCallbackMethod,
+		DefaultExpectedStatus:     defaultExpectedStatus,
+		MaxStatusResults:          defaultMaxStatusResults,
+		MaxLoopDriftMs:            defaultMaxLoopDriftMs,
+		LoopDriftAlertAfter:       defaultLoopDriftAlert,
+		EventBusBufferSize:        defaultEventBusBuffer,
+		StatusWebhookQueueDepth:   defaultStatusWebhookQueue,
+		StartupRetryAttempts:      defaultStartupRetries,
+		StartupRetryIntervalMs:    defaultStartupRetryMs,
+		ShutdownTimeoutSeconds:    defaultShutdownTimeout,
+		DedupFollowUps:            true,
+		DynamoDBMinCapacity:       defaultMinCapacity,
+		DynamoDBMaxCapacity:       defaultMaxCapacity,
+		DynamoDBTargetUtilization: defaultTargetUtilization,
+		Logger:                    logger,
 	}
 
 	// override configuration parameters with environment variables, if set
-	t := reflect.TypeOf(*cm)
-	v := reflect.ValueOf(cm).Elem()
-	for i := 0; i < t.NumField(); i++ {
-		// get the parameter name from the field tag
-		param := strings.ToUpper(t.Field(i).Tag.Get("callme"))
-		logger.Info("Reading configuration parameter", zap.String("parameter", param))
-		value := os.Getenv(param)
-		if value != "" {
-			logger.Info("Found value", zap.String("parameter", param), zap.String("value", value))
-			switch t.Field(i).Type.Kind() {
-			case reflect.String:
-				v.Field(i).SetString(value)
-			case reflect.Int:
-				n, err := strconv.Atoi(value)
-				if err != nil {
-					logger.Error(
-						"Failed to convert integer",
-						zap.String("param", param),
-						zap.String("value", value))
-					continue
-				}
-				v.Field(i).SetInt(int64(n))
-			case reflect.Bool:
-				if strings.ToLower(value) == "true" {
-					v.Field(i).SetBool(true)
-				}
-			}
-		}
+	cm.loadEnvironment()
+	// tell replicas apart
+	cm.identify()
+	// running against DynamoDB Local
+	if isLocalEndpoint(cm.DynamoDBEndpoint) {
+		cm.enterLocalMode()
+	}
+
+	// fall back to the built-in defaults rather than creating tasks that cannot be executed
+	if !task.IsValidCallbackMethod(cm.DefaultCallbackMethod) {
+		logger.Error("Unsupported default callback method", zap.String("method", cm.DefaultCallbackMethod))
+		cm.DefaultCallbackMethod = defaultCallbackMethod
 	}
 
 	// DynamoDB client
-	cm.ddb = connectToDynamoDB(cm.DynamoDBRegion, cm.DynamoDBEndpoint, cm.MaxRetries)
+	cm.ddb = connectToDynamoDB(cm.dynamoDBConfig(cm.DynamoDBRegion, true))
+	// replicas in other regions to fall back to
+	if len(cm.DynamoDBFailoverRegions) > 0 {
+		regions := append([]string{cm.DynamoDBRegion}, cm.DynamoDBFailoverRegions...)
+		clients := []dynamodbiface.DynamoDBAPI{cm.ddb}
+		for _, region := range cm.DynamoDBFailoverRegions {
+			clients = append(clients, connectToDynamoDB(cm.dynamoDBConfig(region, false)))
+		}
+		cm.ddb = newFailoverDynamoDB(regions, clients, logger)
+	}
+	// every DynamoDB operation would fail otherwise
+	err := cm.waitForDynamoDB()
+	if err != nil {
+		logger.Fatal("Giving up on DynamoDB", zap.Error(err))
+	}
+	// only used by tasks whose payload is stored encrypted
+	cm.kms = connectToKMS(cm.DynamoDBRegion)
+	// only used by tasks whose payload is referenced by an s3 URL
+	cm.s3 = connectToS3(cm.DynamoDBRegion)
 	// initialize the HTTP client
-	cm.httpClient = util.NewHTTPClient(cm.ConnectTimeout, cm.ClientTimeout)
+	cm.httpClient = util.NewHTTPClient(
+		cm.ConnectTimeout,
+		cm.ClientTimeout,
+		cm.ResponseBodyTimeout,
+		cm.CallbackMaxIdleConns,
+		cm.CallbackIdleConnTimeoutMs,
+		cm.CallbackMaxConnsPerHost,
+	)
+	// for endpoints that only speak HTTP/2
+	cm.http2Client = util.NewHTTP2Client(
+		cm.ConnectTimeout,
+		cm.ClientTimeout,
+		cm.ResponseBodyTimeout,
+		cm.CallbackMaxIdleConns,
+		cm.CallbackIdleConnTimeoutMs,
+		cm.CallbackMaxConnsPerHost,
+	)
+	cm.TaskCreatedChan = make(chan task.Task, cm.EventBusBufferSize)
+	// the handlers package registers the endpoints on it
+	cm.mux = http.NewServeMux()
+	// state changes are only pushed if there's somewhere to push them to
+	if cm.StatusWebhookEndpoint != "" {
+		cm.statusUpdates = make(chan statusUpdate, cm.StatusWebhookQueueDepth)
+		go cm.deliverStatusUpdates()
+	}
+	// likewise for newly created tasks
+	if cm.TaskCreationWebhook != "" {
+		cm.creations = make(chan task.Task, creationWebhookQueue)
+		go cm.deliverCreations()
+	}
+	// the status of specific tasks is always read from DynamoDB unless a TTL is set
+	if cm.StatusCacheTTL > 0 {
+		go cm.evictStatusCache()
+	}
+	// there's no limit on the number of callbacks running at the same time unless one is set
+	if cm.MaxConcurrentCallbacks > 0 {
+		cm.limiter = newCallbackLimiter(cm.MaxConcurrentCallbacks, time.Duration(cm.ConcurrencyRampSeconds)*time.Second)
+	}
+	// metrics are only pushed to StatsD if an address is set
+	if cm.StatsDAddr != "" {
+		cm.statsd = connectToStatsD(cm.StatsDAddr, cm.StatsDEnv, logger)
+	}
+	// responses of identical callbacks are only shared if explicitly enabled
+	if cm.DeduplicateCallbacks {
+		cm.responseCache = task.NewResponseCache(cm.DeduplicationWindowMs)
+		if cm.DeduplicationWindowMs > 0 {
+			go cm.evictResponseCache()
+		}
+	}
+	// requests are only recorded for replay if explicitly enabled
+	if cm.ReplayLogFile != "" {
+		cm.replayLog, err = openReplayLog(cm.ReplayLogFile)
+		if err != nil {
+			logger.Fatal("Failed to open the replay log", zap.Error(err), zap.String("file", cm.ReplayLogFile))
+		}
+	}
+	// handy for development, tables are usually created by other means
+	if cm.AutoCreateTable {
+		// only used to scale tables created with provisioned capacity
+		if cm.DynamoDBAutoScaling {
+			cm.autoScaler = connectToAutoScaling(cm.DynamoDBRegion)
+		}
+		cm.createTables()
+	}
+	// global list of days off
+	cm.loadHolidays()
+	cm.loadParked()
+	cm.loadMaintenanceWindows()
+	// no need to describe the table on every request
+	err = cm.RefreshTableMetadata()
+	if err != nil {
+		logger.Error("Failed to describe the tasks table", zap.Error(err))
+	} else if cm.DynamoDBStateIndex != "" && !cm.HasIndex(cm.DynamoDBStateIndex) {
+		cm.createStateIndex()
+	}
 
 	return cm
 }
 
-// Run continuously runs in the background and every minute executes the tasks scheduled for that minute
+// Run continuously runs in the background and, at the top of every minute, executes the tasks scheduled for that minute
 func (c *CallMe) Run() {
+	var previousMinute int64
 	for {
 		currentMinute := util.GetUnixMinute()
+		// when the next iteration should start
+		expected := nextMinuteBoundary(time.Now())
+		// the wall clock may have been set back, each minute is only handled once
+		if currentMinute <= previousMinute {
+			time.Sleep(time.Until(expected))
+			continue
+		}
+		previousMinute = currentMinute
 		c.Logger.Debug("Calling back", zap.Int64("time", currentMinute))
+		// the global list of holidays, parked tasks, and maintenance windows may have been changed by some other instance
+		c.loadHolidays()
+		c.loadParked()
+		c.loadMaintenanceWindows()
+		// the index on task_state may still be being built
+		if c.DynamoDBStateIndex != "" && !c.hasActiveIndex(c.DynamoDBStateIndex) {
+			_ = c.RefreshTableMetadata()
+		}
+
+		dispatched := c.dispatchMinute(currentMinute)
+		// does not need to hold back the next round
+		go c.refreshPendingTasks()
+
+		// until the next round, tasks created for this minute are dispatched as soon as they're created
+		c.dispatchCreated(currentMinute, dispatched, time.After(time.Until(expected)))
+		c.checkLoopDrift(expected, time.Now())
+	}
+}
+
+// execute the pending tasks scheduled for a given minute, unless paused, and return the ones dispatched
+func (c *CallMe) dispatchMinute(minute int64) map[string]bool {
+	dispatched := make(map[string]bool)
+	if c.Paused() {
+		c.Logger.Info("Paused, not calling back", zap.Int64("current_minute", minute))
+		return dispatched
+	}
 
-		input := &dynamodb.QueryInput{
-			TableName: aws.String(c.DynamoDBTable),
-			ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
-				":minute": {
-					S: aws.String(strconv.FormatInt(currentMinute, 10)),
-				},
+	input := &dynamodb.QueryInput{
+		TableName: aws.String(c.DynamoDBTable),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":minute": {
+				S: aws.String(strconv.FormatInt(minute, 10)),
 			},
-			KeyConditionExpression: aws.String("trigger_at = :minute"),
-		}
-		result, err := c.ddb.Query(input)
-		if err != nil {
-			c.Logger.Error(
-				"Failed to Query tasks for the current minute",
-				zap.Error(err),
-				zap.Int64("current_minute", currentMinute),
-			)
-		} else {
-			for _, item := range result.Items {
-				tsk := c.taskFromDynamoDB(item)
-				// TODO: worker pool
-				go tsk.Callback(c.httpClient, c.UpsertTask, c.Logger)
-			}
-		}
+		},
+		KeyConditionExpression: aws.String("trigger_at = :minute"),
+		// tasks may have already been executed, e.g., explicitly retried
+		FilterExpression: aws.String("task_state = :pending"),
+	}
+	input.ExpressionAttributeValues[":pending"] = &dynamodb.AttributeValue{S: aws.String(task.Pending)}
+	result, err := c.ddb.Query(input)
+	if err != nil {
+		c.Logger.Error(
+			"Failed to Query tasks for the current minute",
+			zap.Error(err),
+			zap.Int64("current_minute", minute),
+		)
+		return dispatched
+	}
 
-		time.Sleep(time.Minute)
+	overflow := 0
+	for _, item := range result.Items {
+		tsk := c.taskFromDynamoDB(item)
+		dispatched[inFlightKey(tsk)] = true
+		if !c.dispatchNow(tsk) {
+			overflow++
+		}
+	}
+	if overflow > 0 {
+		c.reportOverflow(minute, overflow)
 	}
+
+	return dispatched
+}
+
+// the start of the minute after t
+func nextMinuteBoundary(t time.Time) time.Time {
+	now := t.Unix()
+	return time.Unix(now-now%60+60, 0)
 }
 
 // Catchup finds all entries in the past that have not run and replays them
 // (if still within the maximum delay window). This could happen if the service is unavailable for a few minutes,
-// for example.
-func (c *CallMe) Catchup() {
-	c.Logger.Info("Starting the catch up process")
+// for example. Tasks closest to their maximum delay are replayed first.
+// Only one pass runs at a time. It returns once all tasks have been found, with the number of tasks being replayed.
+// Expired reservations (see ReserveTask) are removed along the way.
+// If name is not empty, only tasks with that name are replayed, which is much cheaper as there is no full table scan.
+func (c *CallMe) Catchup(name string) (int, error) {
+	if c.Paused() {
+		return 0, ErrPaused
+	}
+	if !atomic.CompareAndSwapInt32(&c.catchingUp, 0, 1) {
+		atomic.AddInt64(&c.catchupMetrics.skippedConcurrency, 1)
+		return 0, ErrCatchupInProgress
+	}
+	defer atomic.StoreInt32(&c.catchingUp, 0)
+
+	c.Logger.Info("Starting the catch up process", zap.String("task_name", name))
+	start := time.Now()
+	now := util.GetUnixMinute()
+	var scanned, executed, pastMaxDelay, running int64
 
 	lastEvaluatedKey := make(map[string]*dynamodb.AttributeValue, 0)
+	pending := make([]task.Task, 0)
+	expired := make([]task.Task, 0)
+	// replay whatever we found, even if the scan fails half way through
+	defer func() {
+		sortByUrgency(pending, util.GetUnixMinute())
+		c.dispatchInOrder(pending)
+		c.removeExpiredReservations(expired)
+	}()
 
 	for {
-		input := &dynamodb.ScanInput{
-			TableName:      aws.String(c.DynamoDBTable),
-			ConsistentRead: aws.Bool(false),
-		}
-		if len(lastEvaluatedKey) > 0 {
-			input.ExclusiveStartKey = lastEvaluatedKey
-		}
-		// filter out future tasks: add an attribute value for the current time and
-		// set a new condition expression that uses it
-		input.ExpressionAttributeValues = map[string]*dynamodb.AttributeValue{
-			":now": {
-				S: aws.String(strconv.FormatInt(util.GetUnixMinute(), 10)),
-			},
-			":pending": {
-				S: aws.String(task.Pending),
-			},
-		}
-		input.FilterExpression = aws.String("trigger_at <= :now AND task_state = :pending")
-
-		result, err := c.ddb.Scan(input)
+		var items []map[string]*dynamodb.AttributeValue
+		var err error
+		items, lastEvaluatedKey, err = c.findOverdueTasks(name, lastEvaluatedKey)
+		scanned += int64(len(items))
+		atomic.AddInt64(&c.catchupMetrics.scanned, int64(len(items)))
 		if err != nil {
-			c.Logger.Error("Failed Scan while catching up", zap.Error(err))
-			return
+			c.Logger.Error("Failed to find tasks while catching up", zap.Error(err))
+			return len(pending), errors.New("failed to find all pending tasks")
 		} else {
-			lastEvaluatedKey = result.LastEvaluatedKey
-			// unmarshall and execute each task
-			for _, i := range result.Items {
-				t := task.Task{}
-				err := dynamodbattribute.UnmarshalMap(i, &t)
-				if err != nil {
-					c.Logger.Error(
-						"Failed to UnmarshalMap while catching up on a pending task",
-						zap.Error(err),
-						zap.String("task_name", *i["task_name"].S),
-						zap.String("trigger_at", *i["trigger_at"].S),
-					)
-				} else {
+			// unmarshall and collect each task
+			for _, i := range items {
+				t, ok := c.unmarshalTask(i)
+				if !ok {
+					continue
+				}
+				switch {
+				case t.TaskState == task.Reserved:
+					expired = append(expired, t)
+				case c.isInFlight(t):
+					// e.g., found by the main loop a moment ago
+					running++
+					atomic.AddInt64(&c.catchupMetrics.skippedRunning, 1)
+				default:
 					c.Logger.Debug("Catching up on pending task",
 						zap.String("task", t.String()),
 					)
-					// TODO: worker pool
-					go t.Callback(c.httpClient, c.UpsertTask, c.Logger)
+					pending = append(pending, t)
+					// still handed over to the callback, which may postpone it if parked
+					if t.PastMaxDelay(now) {
+						pastMaxDelay++
+						atomic.AddInt64(&c.catchupMetrics.skippedPastDelay, 1)
+					} else {
+						executed++
+						atomic.AddInt64(&c.catchupMetrics.executed, 1)
+					}
 				}
 			}
 
 			// we're done here
 			if len(lastEvaluatedKey) == 0 {
-				c.Logger.Info("Catch up process finished")
-				return
+				duration := time.Since(start)
+				c.catchupMetrics.durations.observe(duration.Milliseconds(), CatchupDurationBuckets)
+				c.Logger.Info(
+					"Catchup completed",
+					zap.Int("tasks", len(pending)),
+					zap.Duration("duration", duration),
+					zap.Int64("scanned", scanned),
+					zap.Int64("executed", executed),
+					zap.Int64("skipped_past_max_delay", pastMaxDelay),
+					zap.Int64("skipped_already_running", running),
+				)
+				c.count("callme.catchup.recovered", int64(len(pending)))
+				return len(pending), nil
 			}
 		}
 	}
 }
 
+// one page of tasks to catch up on, and the key to the next one: overdue pending tasks and expired reservations
+// a full table scan finds all of them; if name is set, querying the inverted index finds only those with that name
+func (c *CallMe) findOverdueTasks(
+	name string,
+	startKey map[string]*dynamodb.AttributeValue,
+) ([]map[string]*dynamodb.AttributeValue, map[string]*dynamodb.AttributeValue, error) {
+	// filter out future tasks: add an attribute value for the current time and
+	// set a new condition expression that uses it
+	values := map[string]*dynamodb.AttributeValue{
+		":now": {
+			S: aws.String(strconv.FormatInt(util.GetUnixMinute(), 10)),
+		},
+		":pending": {
+			S: aws.String(task.Pending),
+		},
+		// reservations that were not confirmed in time are removed
+		":reserved": {
+			S: aws.String(task.Reserved),
+		},
+		":expiry": {
+			S: aws.String(strconv.FormatInt(time.Now().Unix(), 10)),
+		},
+	}
+	if len(startKey) == 0 {
+		startKey = nil
+	}
+
+	if name == "" {
+		input := &dynamodb.ScanInput{
+			TableName:                 aws.String(c.DynamoDBTable),
+			ConsistentRead:            aws.Bool(false),
+			ExclusiveStartKey:         startKey,
+			ExpressionAttributeValues: values,
+			FilterExpression: aws.String(
+				"(trigger_at <= :now AND task_state = :pending) OR (task_state = :reserved AND reserved_until < :expiry)",
+			),
+		}
+		result, err := c.ddb.Scan(input)
+		if err != nil {
+			return nil, nil, err
+		}
+		return result.Items, result.LastEvaluatedKey, nil
+	}
+
+	// trigger_at is the range key of the inverted index, so it can only be used in the key condition
+	values[":name"] = &dynamodb.AttributeValue{S: aws.String(name)}
+	input := &dynamodb.QueryInput{
+		TableName:                 aws.String(c.DynamoDBTable),
+		IndexName:                 aws.String(c.DynamoDBIndex),
+		ExclusiveStartKey:         startKey,
+		ExpressionAttributeValues: values,
+		KeyConditionExpression:    aws.String("task_name = :name AND trigger_at <= :now"),
+		FilterExpression: aws.String(
+			"task_state = :pending OR (task_state = :reserved AND reserved_until < :expiry)",
+		),
+	}
+	result, err := c.ddb.Query(input)
+	if err != nil {
+		return nil, nil, err
+	}
+	return result.Items, result.LastEvaluatedKey, nil
+}
+
+// sort tasks by how close they are to their maximum delay, i.e., (now - trigger_at) / (max_delay * 60), the most
+// urgent first, and the oldest first among those that are equally urgent
+func sortByUrgency(tasks []task.Task, now int64) {
+	urgency := func(t task.Task) float64 {
+		// by now trigger_at has been validated, it should be safe to ignore the error
+		triggerAt, _ := strconv.ParseInt(t.TriggerAt, 10, 64)
+		maxDelay := t.MaxDelay
+		if maxDelay <= 0 {
+			maxDelay = 1
+		}
+		return float64(now-triggerAt) / float64(maxDelay*60)
+	}
+
+	sort.SliceStable(tasks, func(i, j int) bool {
+		ui, uj := urgency(tasks[i]), urgency(tasks[j])
+		if ui == uj {
+			return tasks[i].TriggerAt < tasks[j].TriggerAt
+		}
+		return ui > uj
+	})
+}
+
+// execute a task in the background as soon as the number of callbacks running allows it
+func (c *CallMe) dispatch(tsk task.Task) {
+	done := c.queue(tsk)
+
+	go func() {
+		defer done()
+		if c.limiter != nil {
+			c.limiter.acquire(tsk.Weight)
+			defer c.limiter.release(tsk.Weight)
+		}
+
+		c.callback(tsk)
+	}()
+}
+
+// execute tasks in the order given, in the background; with a limit on the number of callbacks running, each task
+// waits for its turn before the next one is considered, instead of all of them racing for the next free slot
+func (c *CallMe) dispatchInOrder(tasks []task.Task) {
+	// all of them are waiting from now on, not only the one next in line
+	dequeue := make([]func(), len(tasks))
+	for i, tsk := range tasks {
+		dequeue[i] = c.queue(tsk)
+	}
+
+	go func() {
+		for i, tsk := range tasks {
+			if c.limiter == nil {
+				go func(tsk task.Task, done func()) {
+					defer done()
+					c.callback(tsk)
+				}(tsk, dequeue[i])
+				continue
+			}
+
+			c.limiter.acquire(tsk.Weight)
+			go func(tsk task.Task, done func()) {
+				defer done()
+				defer c.limiter.release(tsk.Weight)
+				c.callback(tsk)
+			}(tsk, dequeue[i])
+		}
+	}()
+}
+
+// execute a task with the current configuration
+func (c *CallMe) callback(tsk task.Task) {
+	// parked tasks are rolled over to the next minute
+	if c.IsParked(tsk.Name) {
+		c.postpone(tsk)
+		return
+	}
+	// callbacks to hosts under maintenance are either skipped or delayed
+	switch c.maintenanceAction(tsk) {
+	case MaintenanceSkip:
+		c.skip(tsk)
+		return
+	case MaintenanceDelay:
+		c.postpone(tsk)
+		return
+	}
+
+	c.configMutex.RLock()
+	storeResponseBody := c.StoreResponseBody
+	storeContentTypes := c.StoreResponseContentTypes
+	httpClient := c.httpClient
+	if c.CallbackHTTP2 || tsk.HTTP2 {
+		httpClient = c.http2Client
+	}
+	c.configMutex.RUnlock()
+
+	ctx, done := c.trackCallback(tsk)
+	defer done()
+	// shutting down, the task is left pending for some other instance
+	if ctx.Err() != nil {
+		c.Logger.Info("Shutting down, not calling back", zap.String("task", tsk.String()))
+		return
+	}
+	// the task may be deleted while running, in which case it must not be stored again
+	state := tsk.TaskState
+	updateTask := func(t task.Task) error {
+		if ctx.Err() != nil {
+			return nil
+		}
+		err := c.updateExecutedTask(t)
+		if err == nil && t.TaskState != state {
+			c.publishStatus(t, state)
+			state = t.TaskState
+		}
+		return err
+	}
+
+	tsk = c.claim(tsk)
+	createFollowUp := func(parent task.Task) func(task.Task) error {
+		return func(next task.Task) error {
+			return c.createFollowUp(parent, next)
+		}
+	}
+	// the payload is not needed to move the task to the next allowed day, and must not be stored along with it
+	holidays := c.Holidays()
+	if !tsk.PastMaxDelay(util.GetUnixMinute()) && tsk.MoveIfDayOff(holidays, updateTask, createFollowUp(tsk), c.Logger) {
+		return
+	}
+	tsk, err := c.decryptPayload(tsk)
+	if err == nil {
+		tsk, err = c.fetchPayload(ctx, tsk)
+	}
+	if err != nil {
+		tsk.TaskState = task.Failed
+		tsk.ResponseBody = err.Error()
+		tsk.ExecutedAt = strconv.FormatInt(time.Now().Unix(), 10)
+		err = updateTask(tsk)
+		if err != nil {
+			c.Logger.Error("Failed to update task", zap.Error(err), zap.String("task", tsk.String()))
+		}
+		return
+	}
+	tsk.Callback(ctx, httpClient, updateTask, createFollowUp(tsk), task.CallbackOptions{
+		StoreResponseBody: storeResponseBody,
+		StoreContentTypes: storeContentTypes,
+		Cache:             c.responseCache,
+		SucceededSince:    c.succeededSince,
+	}, c.Logger)
+}
+
+// check whether a task with the given name was successfully executed after some point in time
+func (c *CallMe) succeededSince(name string, since int64) (bool, error) {
+	input := &dynamodb.QueryInput{
+		TableName: aws.String(c.DynamoDBTable),
+		IndexName: aws.String(c.DynamoDBIndex),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":name":       {S: aws.String(name)},
+			":successful": {S: aws.String(task.Successful)},
+			":since":      {S: aws.String(strconv.FormatInt(since, 10))},
+		},
+		KeyConditionExpression: aws.String("task_name = :name"),
+		FilterExpression:       aws.String("task_state = :successful AND executed_at >= :since"),
+	}
+
+	for {
+		result, err := c.ddb.Query(input)
+		if err != nil {
+			return false, err
+		}
+		if len(result.Items) > 0 {
+			return true, nil
+		}
+		if len(result.LastEvaluatedKey) == 0 {
+			return false, nil
+		}
+		input.ExclusiveStartKey = result.LastEvaluatedKey
+	}
+}
+
+// generate an ID for this instance, unless one was configured, and attach it to all logs
+func (c *CallMe) identify() {
+	if c.InstanceID == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			hostname = "callme"
+		}
+		c.InstanceID = hostname + "-" + strconv.Itoa(os.Getpid())
+	}
+
+	c.Logger = c.Logger.With(zap.String("instance_id", c.InstanceID))
+}
+
+// periodically drop the responses of deduplicated callbacks that expired, which are otherwise only dropped when an
+// identical callback looks them up
+func (c *CallMe) evictResponseCache() {
+	ticker := time.NewTicker(time.Duration(c.DeduplicationWindowMs) * time.Millisecond)
+	defer ticker.Stop()
+
+	for now := range ticker.C {
+		c.responseCache.Evict(now)
+	}
+}
+
+// mark a task as being handled by this instance; it's stored along with the task once it starts running
+func (c *CallMe) claim(tsk task.Task) task.Task {
+	tsk.ClaimedBy = c.InstanceID
+	c.Logger.Debug("Claiming task", zap.String("task", tsk.String()))
+
+	return tsk
+}
+
+// record what changed when a task is explicitly updated
+func (c *CallMe) audit(action string, before task.Task, after task.Task) {
+	c.Logger.Info(
+		"Task updated",
+		zap.String("action", action),
+		zap.String("task", after.String()),
+		zap.String("created_by", after.CreatedBy),
+		zap.Any("diff", before.Diff(after)),
+	)
+}
+
 func (c *CallMe) CreateTask(tsk task.Task) error {
+	return c.createTask(tsk, true)
+}
+
+// store a new task, replacing any existing one with the same name and trigger_at unless idempotent is set and both
+// have the same UUID
+func (c *CallMe) createTask(tsk task.Task, idempotent bool) error {
 	c.Logger.Debug("Creating task", zap.String("task", tsk.String()))
 
-	return c.UpsertTask(tsk)
+	tsk, err := c.prepareForStorage(tsk)
+	if err != nil {
+		return err
+	}
+
+	err = c.putTask(tsk, idempotent)
+	if err == nil {
+		c.created(tsk)
+	}
+
+	return err
+}
+
+// set the defaults of a new task and encrypt its payload, if requested, before storing it
+func (c *CallMe) prepareForStorage(tsk task.Task) (task.Task, error) {
+	// tasks created internally (e.g., follow-up tasks) may not have been through validation
+	tsk.SetDefaults(c.TaskDefaults())
+	if tsk.CreatedBy == "" {
+		tsk.CreatedBy = task.CreatedByAPI
+	}
+	tsk, err := c.encryptPayload(tsk)
+	if err != nil {
+		return tsk, err
+	}
+	// decrypted, or fetched, right before calling back, and never stored
+	if tsk.EncryptedPayload != "" || tsk.PayloadRef != "" {
+		tsk.Payload = ""
+	}
+
+	return tsk, nil
+}
+
+// count and publish a newly stored task
+func (c *CallMe) created(tsk task.Task) {
+	c.count("callme.task.created", 1)
+	c.publishCreated(tsk)
+	c.publishCreation(tsk)
 }
 
 // Reschedule creates new entries for tasks that failed. It may be applied to a specific instance of a give task,
@@ -217,12 +935,14 @@ func (c *CallMe) CreateTask(tsk task.Task) error {
 // it defaults to scheduling the tasks to the next minute.
 // If the parameter all is set to true the tasks will be rescheduled regardless of whether or not the previous round
 // succeeded.
-func (c *CallMe) Reschedule(tsk task.Task, triggerAt string, all bool) ([]task.Task, error) {
+// Rescheduled tasks keep their UUID unless newUUID is set, in which case each one gets a new one. Either way, they are
+// stored as new entries, identified by name and the new trigger time, next to the original ones.
+func (c *CallMe) Reschedule(tsk task.Task, triggerAt string, all bool, newUUID bool) ([]task.Task, error) {
 	tasks := make([]task.Task, 0)
 
 	if tsk.TriggerAt != "" && tsk.Name != "" {
 		// single task at a specific time -- we can re-use statusByTaskKey
-		status, err := c.statusByTaskKey(tsk)
+		status, err := c.statusByTaskKey(c.DynamoDBTable, tsk, StatusOptions{})
 		if err != nil {
 			return nil, err
 		}
@@ -236,7 +956,7 @@ func (c *CallMe) Reschedule(tsk task.Task, triggerAt string, all bool) ([]task.T
 		next := task.Task{}
 		// collect all tasks
 		for {
-			result, err := c.statusByTaskName(tsk, next, false)
+			result, err := c.statusByTaskName(c.DynamoDBTable, tsk, StatusOptions{StartFrom: next})
 			if err != nil {
 				return nil, err
 			}
@@ -249,7 +969,7 @@ func (c *CallMe) Reschedule(tsk task.Task, triggerAt string, all bool) ([]task.T
 			}
 
 			// check to see if we're done here
-			if result.Next == (task.Task{}) {
+			if result.Next.Name == "" || result.Next.TriggerAt == "" {
 				break
 			} else {
 				next = result.Next
@@ -257,49 +977,145 @@ func (c *CallMe) Reschedule(tsk task.Task, triggerAt string, all bool) ([]task.T
 		}
 	}
 
-	// update the trigger_at timestamp and upsert it to keep the exact same parameters we had before
+	// update the trigger_at timestamp and upsert it to keep the exact same parameters we had before, pending again
 	for i := 0; i < len(tasks); i++ {
+		previous := tasks[i]
+		tasks[i] = pendingAgain(tasks[i])
 		tasks[i].TriggerAt = triggerAt
+		if newUUID {
+			tasks[i].UUID = task.NewUUID()
+		}
 		err := c.UpsertTask(tasks[i])
 		if err != nil {
 			return nil, err
 		}
+		c.audit("reschedule", previous, tasks[i])
 	}
 
 	return tasks, nil
 }
 
+// a copy of a task that was already executed, without its outcome, to be executed again
+func pendingAgain(tsk task.Task) task.Task {
+	tsk.TaskState = task.Pending
+	tsk.ResponseBody = ""
+	tsk.ResponseBodyHash = ""
+	tsk.ResponseStatus = 0
+	tsk.ExecutedAt = ""
+	tsk.ExecutionDurationMs = 0
+	tsk.ClaimedBy = ""
+
+	return tsk
+}
+
+// RetryTask immediately executes a task that failed, moving it to the next minute, at most MaxRetries times.
+// The retried task is returned.
+func (c *CallMe) RetryTask(tsk task.Task) (task.Task, error) {
+	status, err := c.statusByTaskKey(c.DynamoDBTable, tsk, StatusOptions{})
+	if err != nil {
+		return task.Task{}, err
+	}
+
+	failed := status.Tasks[0]
+	if failed.TaskState != task.Failed {
+		return task.Task{}, ErrTaskNotFailed
+	}
+	c.configMutex.RLock()
+	maxRetries := c.MaxRetries
+	c.configMutex.RUnlock()
+	if failed.RetryCount >= maxRetries {
+		return task.Task{}, ErrMaxRetriesReached
+	}
+
+	retried := pendingAgain(failed)
+	retried.TriggerAt = strconv.FormatInt(util.GetUnixMinute()+60, 10)
+	retried.RetryCount++
+	// stored as running, rather than pending, so that it's not picked up again at the next minute, or while catching
+	// up, if the dispatch below has to wait for a free slot until then
+	claimed := c.claim(retried)
+	claimed.TaskState = task.Running
+	err = c.UpsertTask(claimed)
+	if err != nil {
+		return task.Task{}, err
+	}
+	// only pending tasks are counted when stored, yet it will be counted out once completed
+	c.addToCounter(claimed.TriggerAt, 1)
+	c.audit("retry", failed, claimed)
+
+	// the task was moved, remove the failed entry so that it cannot be retried again
+	err = c.deleteTask(failed)
+	if err != nil {
+		c.Logger.Error("Failed to remove retried task", zap.Error(err), zap.String("task", failed.String()))
+	}
+
+	// no need to wait for the next minute
+	c.dispatch(retried)
+
+	return claimed, nil
+}
+
 // Status returns the status of a specific task at a specific schedule,
 // all entries of a given task (identified by its name),
-// or all tasks currently scheduled. It supports pagination via startFrom and the next field in the returned JSON.
-// It also allows to filter out all past entries if futureOnly is set to true.
-func (c *CallMe) Status(tsk task.Task, startFrom task.Task, futureOnly bool) (Status, error) {
+// or all tasks currently scheduled. See StatusOptions for pagination, filtering, and sorting.
+func (c *CallMe) Status(tsk task.Task, opts StatusOptions) (Status, error) {
+	return c.status(c.DynamoDBTable, tsk, opts)
+}
+
+// status of the tasks stored on a given table
+func (c *CallMe) status(table string, tsk task.Task, opts StatusOptions) (Status, error) {
 	// single task at a specific time -- we can collect the status with a simple call to GetItem
 	if tsk.TriggerAt != "" && tsk.Name != "" {
-		return c.statusByTaskKey(tsk)
+		return c.statusByTaskKey(table, tsk, opts)
 	}
 
 	// single task, but all entries -- we can use the inverted index and Query the table, avoiding a Scan
 	if tsk.Name != "" {
-		return c.statusByTaskName(tsk, startFrom, futureOnly)
+		return c.statusByTaskName(table, tsk, opts)
 	}
 
 	// we have nothing to help us identify a unique entry or the set of entries for a given task
 	// just return them all (paginated)
-	return c.statusAllTasks(startFrom, futureOnly)
+	return c.statusAllTasks(table, opts)
 }
 
-func (c *CallMe) statusByTaskKey(tsk task.Task) (Status, error) {
+// a task scheduled in the past is not found if futureOnly is set to true
+func (c *CallMe) statusByTaskKey(table string, tsk task.Task, opts StatusOptions) (Status, error) {
 	status := Status{Tasks: make([]task.Task, 0)}
 
+	// the trigger time is part of the key, no need to even look for past tasks
+	if opts.FutureOnly {
+		// the task may not exist at all, so trigger_at may not be valid either
+		triggerAt, err := strconv.ParseInt(tsk.TriggerAt, 10, 64)
+		if err != nil || triggerAt < util.GetUnixMinute() {
+			return Status{}, ErrTaskNotFound
+		}
+	}
+
+	// the capacity consumed is only known by actually reading the task
+	cacheKey := ""
+	if c.StatusCacheTTL > 0 && !opts.ConsumedCapacity {
+		cacheKey = statusCacheKey(table, tsk)
+		if cached, ok := c.statusCache.get(cacheKey, time.Now()); ok {
+			return cached, nil
+		}
+	}
+
 	input := &dynamodb.GetItemInput{
-		TableName: aws.String(c.DynamoDBTable),
+		TableName: aws.String(table),
 		Key: map[string]*dynamodb.AttributeValue{
 			"trigger_at": {S: aws.String(tsk.TriggerAt)},
 			"task_name":  {S: aws.String(tsk.Name)},
 		},
+		ReturnConsumedCapacity: returnConsumedCapacity(opts),
 	}
-	result, err := c.ddb.GetItem(input)
+	// the task may have just been created or updated
+	var result *dynamodb.GetItemOutput
+	consistent, err := c.withConsistencyFallback(func(consistent bool) error {
+		var err error
+		input.ConsistentRead = aws.Bool(consistent)
+		result, err = c.ddb.GetItem(input)
+		return err
+	})
 	if err != nil {
 		c.Logger.Error(
 			"Failed to get task status",
@@ -309,22 +1125,41 @@ func (c *CallMe) statusByTaskKey(tsk task.Task) (Status, error) {
 		return Status{}, errors.New("failed to retrieve the task's status")
 	}
 	if len(result.Item) == 0 {
-		return Status{}, errors.New("task not found")
+		return Status{}, ErrTaskNotFound
 	}
 
 	// we found it, let's add it to the list and return
 	status.Tasks = append(status.Tasks, c.taskFromDynamoDB(result.Item))
+	status.ConsumedCapacity = consumedCapacity(result.ConsumedCapacity)
+	status.EventuallyConsistent = !consistent
+	if cacheKey != "" {
+		c.statusCache.add(cacheKey, status, time.Now().Add(time.Duration(c.StatusCacheTTL)*time.Second))
+	}
 
 	return status, nil
 }
 
 // return the status of all entries for a given task, identified by name
+// concurrent identical calls share the same request to DynamoDB
+func (c *CallMe) statusByTaskName(table string, tsk task.Task, opts StatusOptions) (Status, error) {
+	key := fmt.Sprintf("%s|%s|%+v", table, tsk.Name, opts)
+	status, err, _ := c.statusGroup.Do(key, func() (interface{}, error) {
+		return c.queryByTaskName(table, tsk, opts)
+	})
+	// shared by every concurrent request for the same status
+	s := status.(Status)
+	s.Tasks = make([]task.Task, len(s.Tasks))
+	copy(s.Tasks, status.(Status).Tasks)
+
+	return s, err
+}
+
 // use the inverted index to call Query instead of doing a full table scan
-func (c *CallMe) statusByTaskName(tsk task.Task, startFrom task.Task, futureOnly bool) (Status, error) {
+func (c *CallMe) queryByTaskName(table string, tsk task.Task, opts StatusOptions) (Status, error) {
 	status := Status{Tasks: make([]task.Task, 0)}
 
 	input := &dynamodb.QueryInput{
-		TableName: aws.String(c.DynamoDBTable),
+		TableName: aws.String(table),
 		IndexName: aws.String(c.DynamoDBIndex),
 		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
 			":name": {
@@ -332,22 +1167,48 @@ func (c *CallMe) statusByTaskName(tsk task.Task, startFrom task.Task, futureOnly
 			},
 		},
 		KeyConditionExpression: aws.String("task_name = :name"),
+		ReturnConsumedCapacity: returnConsumedCapacity(opts),
+	}
+	if limit := c.statusLimit(opts); limit > 0 {
+		input.Limit = aws.Int64(int64(limit))
 	}
 
 	// filter out past tasks: add an attribute value for the current time and
 	// set a new condition expression that uses it
-	if futureOnly {
+	if opts.FutureOnly {
 		input.ExpressionAttributeValues[":now"] = &dynamodb.AttributeValue{
 			S: aws.String(strconv.FormatInt(time.Now().Unix(), 10)),
 		}
 		input.KeyConditionExpression = aws.String("task_name = :name AND trigger_at >= :now")
 	}
 
+	conditions := make([]string, 0)
+	if opts.CreatedBy != "" {
+		conditions = append(conditions, createdByCondition(opts.CreatedBy, input.ExpressionAttributeValues))
+	}
+	if len(opts.Labels) > 0 {
+		input.ExpressionAttributeNames = make(map[string]*string)
+		conditions = append(
+			conditions,
+			labelsCondition(opts.Labels, input.ExpressionAttributeNames, input.ExpressionAttributeValues),
+		)
+	}
+	if len(conditions) > 0 {
+		input.FilterExpression = aws.String(strings.Join(conditions, " AND "))
+	}
+
+	// trigger_at is the range key of the inverted index, DynamoDB can sort the results for us
+	if opts.SortDirection != "" {
+		input.ScanIndexForward = aws.Bool(opts.SortDirection == SortAscending)
+		status.SortedBy = "trigger_at"
+		status.SortDirection = opts.SortDirection
+	}
+
 	// we may be paginating this
-	if startFrom.TriggerAt != "" && startFrom.Name != "" {
+	if opts.StartFrom.TriggerAt != "" && opts.StartFrom.Name != "" {
 		input.ExclusiveStartKey = map[string]*dynamodb.AttributeValue{
-			"task_name":  {S: aws.String(startFrom.Name)},
-			"trigger_at": {S: aws.String(startFrom.TriggerAt)},
+			"task_name":  {S: aws.String(opts.StartFrom.Name)},
+			"trigger_at": {S: aws.String(opts.StartFrom.TriggerAt)},
 		}
 	}
 
@@ -357,7 +1218,7 @@ func (c *CallMe) statusByTaskName(tsk task.Task, startFrom task.Task, futureOnly
 			"Failed to Query the status of a task by name",
 			zap.Error(err),
 			zap.String("task_name", tsk.Name),
-			zap.Bool("future_only", futureOnly),
+			zap.Bool("future_only", opts.FutureOnly),
 		)
 		return status, errors.New("failed to retrieve the task's status")
 	}
@@ -366,6 +1227,7 @@ func (c *CallMe) statusByTaskName(tsk task.Task, startFrom task.Task, futureOnly
 		tsk := c.taskFromDynamoDB(item)
 		status.Tasks = append(status.Tasks, tsk)
 	}
+	status.ConsumedCapacity = consumedCapacity(result.ConsumedCapacity)
 
 	// include the last evaluated key for pagination
 	next := task.Task{}
@@ -380,31 +1242,109 @@ func (c *CallMe) statusByTaskName(tsk task.Task, startFrom task.Task, futureOnly
 }
 
 // scan the table
-func (c *CallMe) statusAllTasks(startFrom task.Task, futureOnly bool) (Status, error) {
+// there's no order on a Scan; if the tasks need to be sorted, all pages are collected and sorted in memory, in which
+// case there's no next page to return
+func (c *CallMe) statusAllTasks(table string, opts StatusOptions) (Status, error) {
+	if opts.SortDirection == "" {
+		return c.listTasks(table, opts)
+	}
+
+	status := Status{
+		Tasks:         make([]task.Task, 0),
+		SortedBy:      "trigger_at",
+		SortDirection: opts.SortDirection,
+	}
+	for {
+		page, err := c.listTasks(table, opts)
+		if err != nil {
+			return Status{}, err
+		}
+		status.Tasks = append(status.Tasks, page.Tasks...)
+		status.ConsumedCapacity += page.ConsumedCapacity
+
+		if page.Next.Name == "" || page.Next.TriggerAt == "" {
+			break
+		}
+		opts.StartFrom = page.Next
+	}
+	sortByTriggerAt(status.Tasks, opts.SortDirection)
+	// there's no way to continue from here, the best we can do is let the client know
+	if limit := c.statusLimit(opts); limit > 0 && len(status.Tasks) > limit {
+		status.Tasks = status.Tasks[:limit]
+		status.Truncated = true
+	}
+
+	return status, nil
+}
+
+// sort tasks by trigger_at in the given direction
+func sortByTriggerAt(tasks []task.Task, sortDirection string) {
+	sort.SliceStable(tasks, func(i, j int) bool {
+		// by now trigger_at has been validated, it should be safe to ignore the error
+		a, _ := strconv.ParseInt(tasks[i].TriggerAt, 10, 64)
+		b, _ := strconv.ParseInt(tasks[j].TriggerAt, 10, 64)
+		if sortDirection == SortDescending {
+			return a > b
+		}
+		return a < b
+	})
+}
+
+// scan a single page of the table
+func (c *CallMe) scanTasks(table string, opts StatusOptions) (Status, error) {
 	status := Status{}
 
 	// tasks in this table have not yet been executed (regardless of the trigger date)
 	input := &dynamodb.ScanInput{
-		TableName:      aws.String(c.DynamoDBTable),
-		ConsistentRead: aws.Bool(false),
+		TableName:              aws.String(table),
+		ConsistentRead:         aws.Bool(false),
+		ReturnConsumedCapacity: returnConsumedCapacity(opts),
+	}
+	// collecting all pages to sort them can only be limited after the fact
+	if limit := c.statusLimit(opts); limit > 0 && opts.SortDirection == "" {
+		input.Limit = aws.Int64(int64(limit))
 	}
 
-	// filter out past tasks: add an attribute value for the current time and
-	// set a new condition expression that uses it
-	if futureOnly {
-		input.ExpressionAttributeValues = map[string]*dynamodb.AttributeValue{
-			":now": {
-				S: aws.String(strconv.FormatInt(util.GetUnixMinute(), 10)),
-			},
-		}
-		input.FilterExpression = aws.String("trigger_at > :now")
+	// filter out past tasks, and fast ones: add an attribute value for each condition and
+	// set a new filter expression that uses them
+	values := make(map[string]*dynamodb.AttributeValue)
+	conditions := make([]string, 0)
+	if opts.FutureOnly {
+		values[":now"] = &dynamodb.AttributeValue{S: aws.String(strconv.FormatInt(util.GetUnixMinute(), 10))}
+		conditions = append(conditions, "trigger_at > :now")
+	} else if opts.DueBy > 0 {
+		values[":due_by"] = &dynamodb.AttributeValue{S: aws.String(strconv.FormatInt(opts.DueBy, 10))}
+		conditions = append(conditions, "trigger_at <= :due_by")
+	}
+	if opts.SlowerThanMs > 0 {
+		values[":threshold"] = &dynamodb.AttributeValue{N: aws.String(strconv.FormatInt(opts.SlowerThanMs, 10))}
+		conditions = append(conditions, "execution_duration_ms > :threshold")
+	}
+	if opts.State != "" {
+		values[":state"] = &dynamodb.AttributeValue{S: aws.String(opts.State)}
+		conditions = append(conditions, "task_state = :state")
+	}
+	if opts.CreatedBy != "" {
+		conditions = append(conditions, createdByCondition(opts.CreatedBy, values))
+	}
+	if len(opts.Labels) > 0 {
+		input.ExpressionAttributeNames = make(map[string]*string)
+		conditions = append(conditions, labelsCondition(opts.Labels, input.ExpressionAttributeNames, values))
+	}
+	if opts.NamePrefix != "" {
+		values[":name_prefix"] = &dynamodb.AttributeValue{S: aws.String(opts.NamePrefix)}
+		conditions = append(conditions, "begins_with(task_name, :name_prefix)")
+	}
+	if len(conditions) > 0 {
+		input.ExpressionAttributeValues = values
+		input.FilterExpression = aws.String(strings.Join(conditions, " AND "))
 	}
 
 	// we may be paginating this
-	if startFrom.TriggerAt != "" && startFrom.Name != "" {
+	if opts.StartFrom.TriggerAt != "" && opts.StartFrom.Name != "" {
 		input.ExclusiveStartKey = map[string]*dynamodb.AttributeValue{
-			"task_name":  {S: aws.String(startFrom.Name)},
-			"trigger_at": {S: aws.String(startFrom.TriggerAt)},
+			"task_name":  {S: aws.String(opts.StartFrom.Name)},
+			"trigger_at": {S: aws.String(opts.StartFrom.TriggerAt)},
 		}
 	}
 
@@ -415,18 +1355,13 @@ func (c *CallMe) statusAllTasks(startFrom task.Task, futureOnly bool) (Status, e
 		status.Tasks = make([]task.Task, 0)
 		// collect the
 		for _, i := range result.Items {
-			t := task.Task{}
-			err := dynamodbattribute.UnmarshalMap(i, &t)
-			if err != nil {
-				c.Logger.Error("Failed to UnmarshalMap on pending task", zap.Error(err))
-			} else {
-				c.Logger.Debug("Found pending task",
-					zap.String("hash", *i["trigger_at"].S),
-					zap.String("v", *i["task_name"].S),
-				)
+			t, ok := c.unmarshalTask(i)
+			if ok {
+				c.Logger.Debug("Found pending task", zap.String("task", t.String()))
 				status.Tasks = append(status.Tasks, t)
 			}
 		}
+		status.ConsumedCapacity = consumedCapacity(result.ConsumedCapacity)
 		// include the last evaluated key for pagination
 		next := task.Task{}
 		err := dynamodbattribute.UnmarshalMap(result.LastEvaluatedKey, &next)
@@ -440,19 +1375,149 @@ func (c *CallMe) statusAllTasks(startFrom task.Task, futureOnly bool) (Status, e
 	return status, nil
 }
 
+// maximum number of tasks to return: whatever was requested, but never more than MaxStatusResults; 0 means there is
+// no limit
+func (c *CallMe) statusLimit(opts StatusOptions) int {
+	c.configMutex.RLock()
+	limit := c.MaxStatusResults
+	c.configMutex.RUnlock()
+
+	if opts.Limit > 0 && (limit <= 0 || opts.Limit < limit) {
+		return opts.Limit
+	}
+
+	return limit
+}
+
+// ask DynamoDB to return the consumed capacity, if requested
+func returnConsumedCapacity(opts StatusOptions) *string {
+	if !opts.ConsumedCapacity {
+		return nil
+	}
+
+	return aws.String(dynamodb.ReturnConsumedCapacityTotal)
+}
+
+// total capacity units consumed, if returned by DynamoDB
+func consumedCapacity(capacity *dynamodb.ConsumedCapacity) float64 {
+	if capacity == nil {
+		return 0
+	}
+
+	return aws.Float64Value(capacity.CapacityUnits)
+}
+
+// condition on how tasks were created, e.g., recurring matches recurring:<task_name>@<trigger_at>; the values it uses
+// are added to values
+func createdByCondition(createdBy string, values map[string]*dynamodb.AttributeValue) string {
+	values[":created_by"] = &dynamodb.AttributeValue{S: aws.String(createdBy)}
+	values[":created_by_prefix"] = &dynamodb.AttributeValue{S: aws.String(createdBy + ":")}
+
+	return "(created_by = :created_by OR begins_with(created_by, :created_by_prefix))"
+}
+
+// condition on tasks having all of the given labels; label keys are arbitrary, so both they and their values are
+// added as placeholders to names and values
+func labelsCondition(
+	labels map[string]string,
+	names map[string]*string,
+	values map[string]*dynamodb.AttributeValue,
+) string {
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	names["#labels"] = aws.String("labels")
+	conditions := make([]string, 0, len(keys))
+	for i, k := range keys {
+		name := "#label" + strconv.Itoa(i)
+		value := ":label" + strconv.Itoa(i)
+		names[name] = aws.String(k)
+		values[value] = &dynamodb.AttributeValue{S: aws.String(labels[k])}
+		conditions = append(conditions, "#labels."+name+" = "+value)
+	}
+
+	return "(" + strings.Join(conditions, " AND ") + ")"
+}
+
 // UpsertTask adds or replaces a task in DynamoDB
 func (c *CallMe) UpsertTask(tsk task.Task) error {
+	return c.putTask(tsk, false)
+}
+
+// store the outcome of the execution of a task (its state, response, and who ran it) without replacing the whole
+// item, i.e., leaving its definition, and any attributes added by other means, alone; it fails with ErrTaskNotFound
+// if the task no longer exists, rather than bringing it back
+func (c *CallMe) updateTaskState(tsk task.Task) error {
+	defer c.statusCache.invalidate(c.DynamoDBTable, tsk)
+
+	update := "SET task_state = :state, response_status = :status, response_body = :body, " +
+		"response_body_hash = :hash, execution_duration_ms = :duration, claimed_by = :claimed_by"
+	values := map[string]*dynamodb.AttributeValue{
+		":state":      {S: aws.String(tsk.TaskState)},
+		":status":     {N: aws.String(strconv.Itoa(tsk.ResponseStatus))},
+		":body":       {S: aws.String(tsk.ResponseBody)},
+		":hash":       {S: aws.String(tsk.ResponseBodyHash)},
+		":duration":   {N: aws.String(strconv.FormatInt(tsk.ExecutionDurationMs, 10))},
+		":claimed_by": {S: aws.String(tsk.ClaimedBy)},
+	}
+	// a task that has not been executed (yet) must not look like one executed a long time ago, e.g., to the archival
+	if tsk.ExecutedAt == "" {
+		update += " REMOVE executed_at"
+	} else {
+		update += ", executed_at = :at"
+		values[":at"] = &dynamodb.AttributeValue{S: aws.String(tsk.ExecutedAt)}
+	}
+	_, err := c.ddb.UpdateItem(&dynamodb.UpdateItemInput{
+		TableName: aws.String(c.DynamoDBTable),
+		Key: map[string]*dynamodb.AttributeValue{
+			"trigger_at": {S: aws.String(tsk.TriggerAt)},
+			"task_name":  {S: aws.String(tsk.Name)},
+		},
+		UpdateExpression:          aws.String(update),
+		ConditionExpression:       aws.String("attribute_exists(trigger_at)"),
+		ExpressionAttributeValues: values,
+	})
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+		return ErrTaskNotFound
+	}
+	if err != nil {
+		c.Logger.Error("Failed to update task state", zap.Error(err), zap.String("task", tsk.String()))
+		return errors.New("failed to update the task's state")
+	}
+
+	return nil
+}
+
+// store a task; if idempotent is true and the task has a UUID, an existing task with the same key and UUID is kept
+// as is, e.g., a client retrying a request to create a task that has since been executed does not execute it again
+func (c *CallMe) putTask(tsk task.Task, idempotent bool) error {
 	item, err := dynamodbattribute.MarshalMap(tsk)
 	if err != nil {
 		c.Logger.Error("Failed to update task on DynamoDB: MapMarshal", zap.Error(err))
 		return errors.New("invalid JSON")
 	}
 
+	defer c.statusCache.invalidate(c.DynamoDBTable, tsk)
+
 	input := &dynamodb.PutItemInput{
 		TableName: aws.String(c.DynamoDBTable),
 		Item:      item,
+		// to tell a new task from one that is stored again
+		ReturnValues: aws.String(dynamodb.ReturnValueAllOld),
+	}
+	if idempotent && tsk.UUID != "" {
+		input.ConditionExpression = aws.String("attribute_not_exists(#uuid) OR #uuid <> :uuid")
+		input.ExpressionAttributeNames = map[string]*string{"#uuid": aws.String("uuid")}
+		input.ExpressionAttributeValues = map[string]*dynamodb.AttributeValue{":uuid": {S: aws.String(tsk.UUID)}}
+	}
+	output, err := c.ddb.PutItem(input)
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+		c.Logger.Debug("Task already exists", zap.String("task", tsk.String()), zap.String("uuid", tsk.UUID))
+		return nil
 	}
-	_, err = c.ddb.PutItem(input)
 	if err != nil {
 		msg := "Failed to store task"
 		c.Logger.Error(msg, zap.Error(err), zap.String("task", tsk.String()))
@@ -460,6 +1525,60 @@ func (c *CallMe) UpsertTask(tsk task.Task) error {
 	}
 
 	c.Logger.Debug("Successfully upserted task", zap.String("task", tsk.String()))
+	// a task stored again while still pending, or running, has already been counted
+	if state := stringAttribute(output.Attributes, "task_state"); state != task.Pending && state != task.Running {
+		c.countScheduled(tsk)
+	}
+	return nil
+}
+
+// unmarshal a stored task; items that cannot be unmarshalled, or lack the key attributes, are logged, counted, and
+// skipped (returning false) rather than taking down the whole request
+func (c *CallMe) unmarshalTask(item map[string]*dynamodb.AttributeValue) (task.Task, bool) {
+	t := task.Task{}
+	err := dynamodbattribute.UnmarshalMap(item, &t)
+	if err == nil && (t.Name == "" || t.TriggerAt == "") {
+		err = errors.New("missing key attributes")
+	}
+	if err != nil {
+		atomic.AddInt64(&c.malformedItems, 1)
+		c.Logger.Error(
+			"Skipping malformed task",
+			zap.Error(err),
+			zap.String("task_name", stringAttribute(item, "task_name")),
+			zap.String("trigger_at", stringAttribute(item, "trigger_at")),
+		)
+		return task.Task{}, false
+	}
+
+	return t, true
+}
+
+// value of a string attribute of an item, empty if it's missing or not a string
+func stringAttribute(item map[string]*dynamodb.AttributeValue, name string) string {
+	if value, ok := item[name]; ok && value != nil {
+		return aws.StringValue(value.S)
+	}
+
+	return ""
+}
+
+// remove a task from DynamoDB
+func (c *CallMe) deleteTask(tsk task.Task) error {
+	defer c.statusCache.invalidate(c.DynamoDBTable, tsk)
+
+	input := &dynamodb.DeleteItemInput{
+		TableName: aws.String(c.DynamoDBTable),
+		Key: map[string]*dynamodb.AttributeValue{
+			"trigger_at": {S: aws.String(tsk.TriggerAt)},
+			"task_name":  {S: aws.String(tsk.Name)},
+		},
+	}
+	_, err := c.ddb.DeleteItem(input)
+	if err != nil {
+		return errors.New("failed to delete task")
+	}
+
 	return nil
 }
 
@@ -475,12 +1594,6 @@ func (c *CallMe) taskFromDynamoDB(item map[string]*dynamodb.AttributeValue) task
 	return tsk
 }
 
-func connectToDynamoDB(region string, endpoint string, maxRetries int) *dynamodb.DynamoDB {
-	return dynamodb.New(session.Must(
-		session.NewSession(
-			aws.NewConfig().
-				WithRegion(region).
-				WithEndpoint(endpoint).
-				WithMaxRetries(maxRetries),
-		)))
+func connectToDynamoDB(config *aws.Config) *dynamodb.DynamoDB {
+	return dynamodb.New(session.Must(session.NewSession(config)))
 }
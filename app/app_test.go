@@ -0,0 +1,780 @@
+package app
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"github.com/marcoalmeida/callme/task"
+	"github.com/marcoalmeida/callme/util"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func Test_sortByUrgency(t *testing.T) {
+	now := int64(1800000000)
+	minutesAgo := func(m int) string {
+		return strconv.FormatInt(now-int64(m)*60, 10)
+	}
+
+	// (now - trigger_at) / (max_delay * 60)
+	tasks := []task.Task{
+		{Name: "t0", TriggerAt: minutesAgo(1), MaxDelay: 10},  // 0.1
+		{Name: "t1", TriggerAt: minutesAgo(9), MaxDelay: 10},  // 0.9
+		{Name: "t2", TriggerAt: minutesAgo(5), MaxDelay: 100}, // 0.05
+		{Name: "t3", TriggerAt: minutesAgo(3), MaxDelay: 4},   // 0.75
+		{Name: "t4", TriggerAt: minutesAgo(0), MaxDelay: 10},  // 0
+		{Name: "t5", TriggerAt: minutesAgo(20), MaxDelay: 60}, // 0.33
+		{Name: "t6", TriggerAt: minutesAgo(2), MaxDelay: 2},   // 1
+		{Name: "t7", TriggerAt: minutesAgo(6), MaxDelay: 10},  // 0.6
+		{Name: "t8", TriggerAt: minutesAgo(4), MaxDelay: 20},  // 0.2
+		{Name: "t9", TriggerAt: minutesAgo(1), MaxDelay: 2},   // 0.5
+	}
+	expected := []string{"t6", "t1", "t3", "t7", "t9", "t5", "t8", "t0", "t2", "t4"}
+
+	sortByUrgency(tasks, now)
+	for i, name := range expected {
+		if tasks[i].Name != name {
+			t.Error("Expected", name, "at position", i, "got", tasks[i].Name)
+		}
+	}
+}
+
+func Test_sortByTriggerAt(t *testing.T) {
+	tasks := []task.Task{
+		{Name: "t0", TriggerAt: "1800000120"},
+		{Name: "t1", TriggerAt: "999999999"},
+		{Name: "t2", TriggerAt: "1800000060"},
+	}
+
+	sortByTriggerAt(tasks, SortAscending)
+	for i, name := range []string{"t1", "t2", "t0"} {
+		if tasks[i].Name != name {
+			t.Error("Expected", name, "at position", i, "got", tasks[i].Name)
+		}
+	}
+
+	sortByTriggerAt(tasks, SortDescending)
+	for i, name := range []string{"t0", "t2", "t1"} {
+		if tasks[i].Name != name {
+			t.Error("Expected", name, "at position", i, "got", tasks[i].Name)
+		}
+	}
+}
+
+func Test_statusByTaskKey_futureOnly(t *testing.T) {
+	// past tasks are filtered out before DynamoDB is queried
+	cm := &CallMe{}
+	past := task.Task{Name: "t0", TriggerAt: strconv.FormatInt(util.GetUnixMinute()-60, 10)}
+
+	_, err := cm.statusByTaskKey("callme", past, StatusOptions{FutureOnly: true})
+	if err != ErrTaskNotFound {
+		t.Error("Expected", ErrTaskNotFound, "got", err)
+	}
+}
+
+func Test_nextMinuteBoundary(t *testing.T) {
+	tests := []struct {
+		at       time.Time
+		expected int64
+	}{
+		{time.Unix(1800000000, 0), 1800000060},
+		{time.Unix(1800000000, 1), 1800000060},
+		{time.Unix(1800000059, 999999999), 1800000060},
+	}
+
+	for _, test := range tests {
+		if next := nextMinuteBoundary(test.at); next.Unix() != test.expected || next.Nanosecond() != 0 {
+			t.Error("Expected", test.expected, "after", test.at, "got", next)
+		}
+	}
+}
+
+func Test_claim(t *testing.T) {
+	core, logs := observer.New(zap.DebugLevel)
+	cm := &CallMe{InstanceID: "i0", Logger: zap.New(core)}
+	cm.identify()
+
+	tsk := cm.claim(task.Task{Name: "t0", TriggerAt: "1800000000"})
+	if tsk.ClaimedBy != "i0" {
+		t.Error("Expected the task to be claimed by i0, got", tsk.ClaimedBy)
+	}
+
+	for _, entry := range logs.All() {
+		if entry.ContextMap()["instance_id"] != "i0" {
+			t.Error("Expected instance_id i0 on all logs, got", entry.ContextMap())
+		}
+	}
+	if logs.Len() == 0 {
+		t.Error("Expected the claim to be logged")
+	}
+}
+
+func Test_identify(t *testing.T) {
+	cm := &CallMe{Logger: zap.NewNop()}
+	cm.identify()
+	if cm.InstanceID == "" {
+		t.Error("Expected an instance ID to be generated")
+	}
+}
+
+// DynamoDB client that only knows how to Query, reporting the capacity consumed if asked to
+type queryClient struct {
+	dynamodbiface.DynamoDBAPI
+	input *dynamodb.QueryInput
+}
+
+func (d *queryClient) Query(input *dynamodb.QueryInput) (*dynamodb.QueryOutput, error) {
+	d.input = input
+	output := &dynamodb.QueryOutput{}
+	if aws.StringValue(input.ReturnConsumedCapacity) == dynamodb.ReturnConsumedCapacityTotal {
+		output.ConsumedCapacity = &dynamodb.ConsumedCapacity{CapacityUnits: aws.Float64(1.5)}
+	}
+
+	return output, nil
+}
+
+func TestStatus_consumedCapacity(t *testing.T) {
+	ddb := &queryClient{}
+	cm := &CallMe{Logger: zap.NewNop(), ddb: ddb}
+
+	status, err := cm.Status(task.Task{Name: "t0"}, StatusOptions{ConsumedCapacity: true})
+	if err != nil {
+		t.Fatal("Expected to succeed, failed with", err)
+	}
+	if aws.StringValue(ddb.input.ReturnConsumedCapacity) != dynamodb.ReturnConsumedCapacityTotal {
+		t.Error("Expected the consumed capacity to be requested, got", ddb.input.ReturnConsumedCapacity)
+	}
+	if status.ConsumedCapacity != 1.5 {
+		t.Error("Expected 1.5 capacity units, got", status.ConsumedCapacity)
+	}
+
+	// not requested
+	status, _ = cm.Status(task.Task{Name: "t0"}, StatusOptions{})
+	if ddb.input.ReturnConsumedCapacity != nil || status.ConsumedCapacity != 0 {
+		t.Error("Expected no consumed capacity, got", status.ConsumedCapacity)
+	}
+}
+
+// DynamoDB client that only knows how to Scan, always returning a full page
+type scanClient struct {
+	dynamodbiface.DynamoDBAPI
+	input *dynamodb.ScanInput
+}
+
+func (d *scanClient) Scan(input *dynamodb.ScanInput) (*dynamodb.ScanOutput, error) {
+	d.input = input
+	output := &dynamodb.ScanOutput{Items: make([]map[string]*dynamodb.AttributeValue, 0)}
+	for i := int64(0); i < aws.Int64Value(input.Limit); i++ {
+		output.Items = append(output.Items, map[string]*dynamodb.AttributeValue{
+			"task_name":  {S: aws.String("t" + strconv.FormatInt(i, 10))},
+			"trigger_at": {S: aws.String("1800000000")},
+		})
+	}
+	output.LastEvaluatedKey = output.Items[len(output.Items)-1]
+
+	return output, nil
+}
+
+func TestStatus_maxStatusResults(t *testing.T) {
+	ddb := &scanClient{}
+	cm := &CallMe{MaxStatusResults: 5, Logger: zap.NewNop(), ddb: ddb}
+
+	tests := []struct {
+		limit    int
+		expected int
+	}{
+		{0, 5},
+		{3, 3},
+		{10, 5},
+	}
+
+	for _, test := range tests {
+		status, err := cm.Status(task.Task{}, StatusOptions{Limit: test.limit})
+		if err != nil {
+			t.Fatal("Expected to succeed, failed with", err)
+		}
+		if aws.Int64Value(ddb.input.Limit) != int64(test.expected) || len(status.Tasks) != test.expected {
+			t.Error("Expected", test.expected, "tasks, got", len(status.Tasks))
+		}
+		if status.Next.Name != "t"+strconv.Itoa(test.expected-1) {
+			t.Error("Expected a cursor to the next page, got", status.Next)
+		}
+	}
+}
+
+func TestStatus_namePrefix(t *testing.T) {
+	ddb := &scanClient{}
+	cm := &CallMe{Logger: zap.NewNop(), ddb: ddb}
+
+	_, err := cm.Status(task.Task{}, StatusOptions{Limit: 1, NamePrefix: "team-a:"})
+	if err != nil {
+		t.Fatal("Expected to succeed, failed with", err)
+	}
+	if aws.StringValue(ddb.input.FilterExpression) != "begins_with(task_name, :name_prefix)" ||
+		aws.StringValue(ddb.input.ExpressionAttributeValues[":name_prefix"].S) != "team-a:" {
+		t.Error("Expected only tasks in team-a, got", ddb.input.FilterExpression, ddb.input.ExpressionAttributeValues)
+	}
+}
+
+// DynamoDB client whose Queries take a while, counting how many were made
+type slowQueryClient struct {
+	dynamodbiface.DynamoDBAPI
+	queries int64
+}
+
+func (d *slowQueryClient) Query(input *dynamodb.QueryInput) (*dynamodb.QueryOutput, error) {
+	atomic.AddInt64(&d.queries, 1)
+	time.Sleep(time.Millisecond)
+
+	return &dynamodb.QueryOutput{Items: []map[string]*dynamodb.AttributeValue{{
+		"task_name":  {S: aws.String("t0")},
+		"trigger_at": {S: aws.String("1800000000")},
+	}}}, nil
+}
+
+func TestStatusByTaskName_coalesced(t *testing.T) {
+	ddb := &slowQueryClient{}
+	cm := &CallMe{Logger: zap.NewNop(), ddb: ddb}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			status, err := cm.statusByTaskName("callme", task.Task{Name: "t0"}, StatusOptions{})
+			if err != nil || len(status.Tasks) != 1 {
+				t.Error("Expected a single task, got", status.Tasks, err)
+				return
+			}
+			// not shared with the other requests
+			status.Tasks[0].Name += "-0"
+			if status.Tasks[0].Name != "t0-0" {
+				t.Error("Expected t0-0, got", status.Tasks[0].Name)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// 50 concurrent identical requests, with and without coalescing
+func BenchmarkStatusByTaskName(b *testing.B) {
+	benchmarks := []struct {
+		name   string
+		status func(*CallMe, string, task.Task, StatusOptions) (Status, error)
+	}{
+		{"direct", (*CallMe).queryByTaskName},
+		{"coalesced", (*CallMe).statusByTaskName},
+	}
+
+	for _, bm := range benchmarks {
+		b.Run(bm.name, func(b *testing.B) {
+			ddb := &slowQueryClient{}
+			cm := &CallMe{Logger: zap.NewNop(), ddb: ddb}
+
+			for i := 0; i < b.N; i++ {
+				var wg sync.WaitGroup
+				for j := 0; j < 50; j++ {
+					wg.Add(1)
+					go func() {
+						defer wg.Done()
+						bm.status(cm, "callme", task.Task{Name: "t0"}, StatusOptions{})
+					}()
+				}
+				wg.Wait()
+			}
+			b.ReportMetric(float64(atomic.LoadInt64(&ddb.queries))/float64(b.N), "queries/op")
+		})
+	}
+}
+
+// DynamoDB client that rejects all conditional writes
+type conditionalWriteClient struct {
+	dynamodbiface.DynamoDBAPI
+	input *dynamodb.PutItemInput
+}
+
+func (d *conditionalWriteClient) PutItem(input *dynamodb.PutItemInput) (*dynamodb.PutItemOutput, error) {
+	d.input = input
+	if input.ConditionExpression != nil {
+		return nil, awserr.New(dynamodb.ErrCodeConditionalCheckFailedException, "conditional check failed", nil)
+	}
+
+	return &dynamodb.PutItemOutput{}, nil
+}
+
+func TestCreateTask_uuid(t *testing.T) {
+	ddb := &conditionalWriteClient{}
+	cm := &CallMe{Logger: zap.NewNop(), ddb: ddb}
+
+	// a task with the same UUID already exists, nothing to do
+	tsk := task.Task{Name: "t0", TriggerAt: "1800000000", UUID: "0123456789abcdef0123456789abcdef"}
+	err := cm.CreateTask(tsk)
+	if err != nil {
+		t.Error("Expected to succeed, failed with", err)
+	}
+	if ddb.input.ConditionExpression == nil {
+		t.Error("Expected a conditional write")
+	}
+
+	// no UUID, no condition
+	tsk.UUID = ""
+	err = cm.CreateTask(tsk)
+	if err != nil || ddb.input.ConditionExpression != nil {
+		t.Error("Expected an unconditional write, got", ddb.input.ConditionExpression, err)
+	}
+}
+
+func TestStatus_slowerThan(t *testing.T) {
+	ddb := &scanClient{}
+	cm := &CallMe{MaxStatusResults: 5, Logger: zap.NewNop(), ddb: ddb}
+
+	_, err := cm.Status(task.Task{}, StatusOptions{SlowerThanMs: 5000, FutureOnly: true})
+	if err != nil {
+		t.Fatal("Expected to succeed, failed with", err)
+	}
+	if aws.StringValue(ddb.input.FilterExpression) != "trigger_at > :now AND execution_duration_ms > :threshold" {
+		t.Error("Expected to filter by trigger_at and duration, got", aws.StringValue(ddb.input.FilterExpression))
+	}
+	if aws.StringValue(ddb.input.ExpressionAttributeValues[":threshold"].N) != "5000" {
+		t.Error("Expected a threshold of 5000, got", ddb.input.ExpressionAttributeValues[":threshold"])
+	}
+}
+
+func TestStatus_createdBy(t *testing.T) {
+	ddb := &scanClient{}
+	cm := &CallMe{MaxStatusResults: 5, Logger: zap.NewNop(), ddb: ddb}
+
+	_, err := cm.Status(task.Task{}, StatusOptions{State: task.Pending, CreatedBy: task.CreatedByRecurring})
+	if err != nil {
+		t.Fatal("Expected to succeed, failed with", err)
+	}
+	expected := "task_state = :state AND (created_by = :created_by OR begins_with(created_by, :created_by_prefix))"
+	if aws.StringValue(ddb.input.FilterExpression) != expected {
+		t.Error("Expected to filter by state and creator, got", aws.StringValue(ddb.input.FilterExpression))
+	}
+	if aws.StringValue(ddb.input.ExpressionAttributeValues[":created_by_prefix"].S) != "recurring:" {
+		t.Error("Expected to match any parent task, got", ddb.input.ExpressionAttributeValues[":created_by_prefix"])
+	}
+}
+
+func TestStatus_labels(t *testing.T) {
+	ddb := &scanClient{}
+	cm := &CallMe{MaxStatusResults: 5, Logger: zap.NewNop(), ddb: ddb}
+
+	_, err := cm.Status(task.Task{}, StatusOptions{Labels: map[string]string{"team": "billing", "env": "prod"}})
+	if err != nil {
+		t.Fatal("Expected to succeed, failed with", err)
+	}
+	// sorted by key
+	expected := "(#labels.#label0 = :label0 AND #labels.#label1 = :label1)"
+	if aws.StringValue(ddb.input.FilterExpression) != expected {
+		t.Error("Expected to filter by labels, got", aws.StringValue(ddb.input.FilterExpression))
+	}
+	if aws.StringValue(ddb.input.ExpressionAttributeNames["#label0"]) != "env" ||
+		aws.StringValue(ddb.input.ExpressionAttributeValues[":label0"].S) != "prod" {
+		t.Error("Expected env=prod, got", ddb.input.ExpressionAttributeNames, ddb.input.ExpressionAttributeValues)
+	}
+}
+
+func TestUnmarshalTask_labels(t *testing.T) {
+	cm := &CallMe{Logger: zap.NewNop()}
+	tsk := task.Task{Name: "t0", TriggerAt: "600", Labels: map[string]string{"team": "billing"}}
+
+	item, err := dynamodbattribute.MarshalMap(tsk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored, ok := cm.unmarshalTask(item)
+	if !ok || len(stored.Labels) != 1 || stored.Labels["team"] != "billing" {
+		t.Error("Expected labels to be round-tripped, got", stored.Labels)
+	}
+}
+
+// DynamoDB client that finds a failed task with a UUID and records the last task written
+type rescheduleClient struct {
+	dynamodbiface.DynamoDBAPI
+	put map[string]*dynamodb.AttributeValue
+}
+
+func (d *rescheduleClient) GetItem(input *dynamodb.GetItemInput) (*dynamodb.GetItemOutput, error) {
+	return &dynamodb.GetItemOutput{Item: map[string]*dynamodb.AttributeValue{
+		"task_name":  input.Key["task_name"],
+		"trigger_at": input.Key["trigger_at"],
+		"task_state": {S: aws.String(task.Failed)},
+		"uuid":       {S: aws.String("0123456789abcdef0123456789abcdef")},
+	}}, nil
+}
+
+func (d *rescheduleClient) PutItem(input *dynamodb.PutItemInput) (*dynamodb.PutItemOutput, error) {
+	d.put = input.Item
+	return &dynamodb.PutItemOutput{}, nil
+}
+
+func TestReschedule_uuid(t *testing.T) {
+	for _, newUUID := range []bool{false, true} {
+		ddb := &rescheduleClient{}
+		cm := &CallMe{Logger: zap.NewNop(), ddb: ddb}
+
+		tasks, err := cm.Reschedule(task.Task{Name: "t0", TriggerAt: "600"}, "660", false, newUUID)
+		if err != nil || len(tasks) != 1 {
+			t.Fatal("Expected 1 task to be rescheduled, got", tasks, err)
+		}
+
+		// a new entry either way
+		if aws.StringValue(ddb.put["trigger_at"].S) != "660" || aws.StringValue(ddb.put["task_name"].S) != "t0" {
+			t.Error("Expected t0@660 to be stored, got", ddb.put)
+		}
+		// picked up again once due
+		if state := aws.StringValue(ddb.put["task_state"].S); state != task.Pending {
+			t.Error("Expected the rescheduled task to be pending, got", state)
+		}
+		uuid := aws.StringValue(ddb.put["uuid"].S)
+		if uuid != tasks[0].UUID {
+			t.Error("Expected the stored UUID to be returned, got", tasks[0].UUID, "and", uuid)
+		}
+		preserved := uuid == "0123456789abcdef0123456789abcdef"
+		if preserved == newUUID {
+			t.Error("Expected a new UUID:", newUUID, "got", uuid)
+		}
+	}
+}
+
+// DynamoDB client that finds overdue tasks, one per page, optionally waiting to be released before each page
+type catchupClient struct {
+	dynamodbiface.DynamoDBAPI
+	pages   int
+	entered chan bool
+	release chan bool
+}
+
+func (d *catchupClient) Scan(input *dynamodb.ScanInput) (*dynamodb.ScanOutput, error) {
+	if d.release != nil {
+		d.entered <- true
+		<-d.release
+	}
+
+	page := 0
+	if input.ExclusiveStartKey != nil {
+		page, _ = strconv.Atoi(aws.StringValue(input.ExclusiveStartKey["trigger_at"].S))
+	}
+
+	// long past max_delay, replaying them does nothing
+	output := &dynamodb.ScanOutput{
+		Items: []map[string]*dynamodb.AttributeValue{
+			{
+				"task_name":  {S: aws.String("t" + strconv.Itoa(page))},
+				"trigger_at": {S: aws.String(strconv.Itoa(page))},
+			},
+		},
+	}
+	if page < d.pages-1 {
+		output.LastEvaluatedKey = map[string]*dynamodb.AttributeValue{
+			"task_name":  {S: aws.String("t" + strconv.Itoa(page))},
+			"trigger_at": {S: aws.String(strconv.Itoa(page + 1))},
+		}
+	}
+
+	return output, nil
+}
+
+func TestCatchup(t *testing.T) {
+	cm := &CallMe{Logger: zap.NewNop(), ddb: &catchupClient{pages: 3}}
+
+	n, err := cm.Catchup("")
+	if err != nil || n != 3 {
+		t.Error("Expected 3 tasks to be replayed, got", n, err)
+	}
+
+	// only one pass at a time
+	ddb := &catchupClient{pages: 1, entered: make(chan bool), release: make(chan bool)}
+	cm = &CallMe{Logger: zap.NewNop(), ddb: ddb}
+	done := make(chan int)
+	go func() {
+		n, _ := cm.Catchup("")
+		done <- n
+	}()
+	<-ddb.entered
+
+	_, err = cm.Catchup("")
+	if err != ErrCatchupInProgress {
+		t.Error("Expected", ErrCatchupInProgress, "got", err)
+	}
+
+	ddb.release <- true
+	if n := <-done; n != 1 {
+		t.Error("Expected 1 task to be replayed, got", n)
+	}
+}
+
+func TestCatchup_stats(t *testing.T) {
+	cm := &CallMe{Logger: zap.NewNop(), ddb: &catchupClient{pages: 3}}
+	// t1 is still running
+	_, done := cm.trackCallback(task.Task{Name: "t1", TriggerAt: "1"})
+	defer done()
+
+	_, err := cm.Catchup("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// not a complete pass, but a skipped one
+	cm.catchingUp = 1
+	_, err = cm.Catchup("")
+	if err != ErrCatchupInProgress {
+		t.Fatal("Expected", ErrCatchupInProgress, "got", err)
+	}
+
+	stats := cm.CatchupStats()
+	if stats.Scanned != 3 || stats.Executed != 0 || stats.Passes != 1 {
+		t.Error("Expected 3 items scanned, none executed, in 1 pass, got", stats)
+	}
+	expected := map[string]int64{
+		CatchupSkipPastMaxDelay:   2,
+		CatchupSkipAlreadyRunning: 1,
+		CatchupSkipConcurrentLock: 1,
+	}
+	for reason, n := range expected {
+		if stats.Skipped[reason] != n {
+			t.Error("Expected", n, "skipped because", reason, "got", stats.Skipped[reason])
+		}
+	}
+}
+
+// DynamoDB client that finds the same overdue tasks, on a single page, and records the order in which they start
+type catchupOrderClient struct {
+	dynamodbiface.DynamoDBAPI
+	items    []map[string]*dynamodb.AttributeValue
+	mutex    sync.Mutex
+	started  []string
+	finished chan bool
+}
+
+func (d *catchupOrderClient) Scan(input *dynamodb.ScanInput) (*dynamodb.ScanOutput, error) {
+	return &dynamodb.ScanOutput{Items: d.items}, nil
+}
+
+func (d *catchupOrderClient) UpdateItem(input *dynamodb.UpdateItemInput) (*dynamodb.UpdateItemOutput, error) {
+	switch aws.StringValue(input.ExpressionAttributeValues[":state"].S) {
+	case task.Running:
+		d.mutex.Lock()
+		d.started = append(d.started, aws.StringValue(input.Key["task_name"].S))
+		d.mutex.Unlock()
+	case task.Successful, task.Failed:
+		d.finished <- true
+	}
+
+	return &dynamodb.UpdateItemOutput{}, nil
+}
+
+func TestCatchup_oldestFirst(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ts.Close()
+
+	now := util.GetUnixMinute()
+	item := func(name string, minutesAgo int64) map[string]*dynamodb.AttributeValue {
+		return map[string]*dynamodb.AttributeValue{
+			"task_name":            {S: aws.String(name)},
+			"trigger_at":           {S: aws.String(strconv.FormatInt(now-minutesAgo*60, 10))},
+			"task_state":           {S: aws.String(task.Pending)},
+			"callback":             {S: aws.String(ts.URL)},
+			"callback_method":      {S: aws.String("GET")},
+			"expected_http_status": {N: aws.String("200")},
+			"retry":                {N: aws.String("1")},
+			"max_delay":            {N: aws.String("10")},
+		}
+	}
+	// newest first, as a scan could return them
+	ddb := &catchupOrderClient{
+		items:    []map[string]*dynamodb.AttributeValue{item("t0", 1), item("t1", 3), item("t2", 5), item("t3", 2)},
+		finished: make(chan bool),
+	}
+	// a single callback at a time, so that the order in which they run is the order in which they were enqueued
+	cm := &CallMe{Logger: zap.NewNop(), ddb: ddb, httpClient: ts.Client(), limiter: newCallbackLimiter(1, 0)}
+
+	n, err := cm.Catchup("")
+	if err != nil || n != len(ddb.items) {
+		t.Fatal("Expected", len(ddb.items), "tasks to be replayed, got", n, err)
+	}
+	for range ddb.items {
+		select {
+		case <-ddb.finished:
+		case <-time.After(5 * time.Second):
+			t.Fatal("Expected all tasks to be replayed")
+		}
+	}
+
+	expected := []string{"t2", "t1", "t3", "t0"}
+	for i, name := range expected {
+		if ddb.started[i] != name {
+			t.Error("Expected", expected, "got", ddb.started)
+			break
+		}
+	}
+}
+
+func TestCatchup_waitingForSlot(t *testing.T) {
+	var requests int64
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&requests, 1)
+	}))
+	defer ts.Close()
+
+	ddb := newMemoryClient()
+	cm := &CallMe{Logger: zap.NewNop(), ddb: ddb, httpClient: ts.Client(), limiter: newCallbackLimiter(1, 0)}
+	overdue := task.Task{
+		Name:             "t0",
+		TriggerAt:        strconv.FormatInt(util.GetUnixMinute()-60, 10),
+		CallbackEndpoint: ts.URL,
+		MaxDelay:         10,
+	}
+	overdue.SetDefaults("", 0)
+	if err := cm.UpsertTask(overdue); err != nil {
+		t.Fatal(err)
+	}
+
+	// no free slot, the task is still pending when the next pass finds it
+	cm.limiter.acquire(1)
+	if n, err := cm.Catchup(""); err != nil || n != 1 {
+		t.Fatal("Expected 1 task to be replayed, got", n, err)
+	}
+	if n, err := cm.Catchup(""); err != nil || n != 0 {
+		t.Error("Expected the task waiting for a slot not to be replayed again, got", n, err)
+	}
+
+	cm.limiter.release(1)
+	for i := 0; i < 100 && stringAttribute(ddb.item("t0", overdue.TriggerAt), "task_state") != task.Successful; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	// give a second callback, if any, the chance to run
+	time.Sleep(50 * time.Millisecond)
+	if n := atomic.LoadInt64(&requests); n != 1 {
+		t.Error("Expected a single request, got", n)
+	}
+}
+
+// DynamoDB client that finds a single overdue task when querying the inverted index
+type catchupQueryClient struct {
+	dynamodbiface.DynamoDBAPI
+	input *dynamodb.QueryInput
+}
+
+func (d *catchupQueryClient) Query(input *dynamodb.QueryInput) (*dynamodb.QueryOutput, error) {
+	d.input = input
+
+	return &dynamodb.QueryOutput{
+		Items: []map[string]*dynamodb.AttributeValue{
+			{
+				"task_name":  input.ExpressionAttributeValues[":name"],
+				"trigger_at": {S: aws.String("0")},
+			},
+		},
+	}, nil
+}
+
+func TestCatchup_taskName(t *testing.T) {
+	ddb := &catchupQueryClient{}
+	cm := &CallMe{DynamoDBIndex: "inverted_index", Logger: zap.NewNop(), ddb: ddb}
+
+	// the embedded interface is nil, scanning the table would panic
+	n, err := cm.Catchup("t0")
+	if err != nil || n != 1 {
+		t.Error("Expected 1 task to be replayed, got", n, err)
+	}
+	if aws.StringValue(ddb.input.IndexName) != "inverted_index" ||
+		aws.StringValue(ddb.input.KeyConditionExpression) != "task_name = :name AND trigger_at <= :now" ||
+		aws.StringValue(ddb.input.ExpressionAttributeValues[":name"].S) != "t0" {
+		t.Error("Expected to query the inverted index for t0, got", ddb.input)
+	}
+}
+
+// DynamoDB client that finds a valid task along with malformed ones
+type malformedClient struct {
+	dynamodbiface.DynamoDBAPI
+}
+
+func (d *malformedClient) Scan(input *dynamodb.ScanInput) (*dynamodb.ScanOutput, error) {
+	return &dynamodb.ScanOutput{
+		Items: []map[string]*dynamodb.AttributeValue{
+			// missing the key attributes
+			{"task_state": {S: aws.String(task.Pending)}},
+			// wrong type
+			{"task_name": {N: aws.String("1")}, "trigger_at": nil},
+			{"task_name": {S: aws.String("t0")}, "trigger_at": {S: aws.String("0")}},
+		},
+	}, nil
+}
+
+func TestUnmarshalTask_malformed(t *testing.T) {
+	cm := &CallMe{Logger: zap.NewNop(), ddb: &malformedClient{}}
+
+	status, err := cm.Status(task.Task{}, StatusOptions{})
+	if err != nil || len(status.Tasks) != 1 || status.Tasks[0].Name != "t0" {
+		t.Error("Expected only t0 to be found, got", status.Tasks, err)
+	}
+	if cm.MalformedItems() != 2 {
+		t.Error("Expected 2 malformed items, got", cm.MalformedItems())
+	}
+
+	n, err := cm.Catchup("")
+	if err != nil || n != 1 {
+		t.Error("Expected 1 task to be replayed, got", n, err)
+	}
+	if cm.MalformedItems() != 4 {
+		t.Error("Expected 4 malformed items, got", cm.MalformedItems())
+	}
+}
+
+func TestRetryTask(t *testing.T) {
+	var requests int64
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&requests, 1)
+	}))
+	defer ts.Close()
+
+	ddb := newMemoryClient()
+	c := &CallMe{
+		Logger:     zap.NewNop(),
+		ddb:        ddb,
+		httpClient: ts.Client(),
+		InstanceID: "i0",
+		MaxRetries: 1,
+		limiter:    newCallbackLimiter(1, 0),
+	}
+	failed := task.Task{Name: "t0", TriggerAt: "1800000000", CallbackEndpoint: ts.URL}
+	failed.SetDefaults("", 0)
+	failed.TaskState = task.Failed
+	if err := c.UpsertTask(failed); err != nil {
+		t.Fatal(err)
+	}
+
+	// no free slot, the retry has to wait
+	c.limiter.acquire(1)
+	retried, err := c.RetryTask(failed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	item := ddb.item("t0", retried.TriggerAt)
+	if stringAttribute(item, "task_state") != task.Running || stringAttribute(item, "claimed_by") != "i0" {
+		t.Error("Expected the retried task to be running on this instance, not pending, got", item)
+	}
+
+	c.limiter.release(1)
+	for i := 0; i < 100 && stringAttribute(ddb.item("t0", retried.TriggerAt), "task_state") != task.Successful; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if state := stringAttribute(ddb.item("t0", retried.TriggerAt), "task_state"); state != task.Successful {
+		t.Error("Expected the retried task to succeed, got", state)
+	}
+	if n := atomic.LoadInt64(&requests); n != 1 {
+		t.Error("Expected a single request, got", n)
+	}
+}
@@ -0,0 +1,133 @@
+package app
+
+import (
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/marcoalmeida/callme/task"
+	"github.com/marcoalmeida/callme/util"
+	"go.uber.org/zap"
+)
+
+// maximum number of put or delete requests DynamoDB accepts on a single call to BatchWriteItem
+const maxBatchWriteItems = 25
+
+// Archive continuously runs in the background and once a day moves all tasks that were executed more than
+// ArchiveAfterDays ago from the main table to the archive table. It returns immediately if archival is disabled.
+func (c *CallMe) Archive() {
+	if c.ArchiveAfterDays <= 0 {
+		c.Logger.Info("Task archival is disabled")
+		return
+	}
+
+	for {
+		c.archiveExecutedTasks()
+		time.Sleep(24 * time.Hour)
+	}
+}
+
+// ArchiveStatus is the equivalent of Status for archived tasks.
+func (c *CallMe) ArchiveStatus(tsk task.Task, opts StatusOptions) (Status, error) {
+	return c.status(c.DynamoDBArchiveTable, tsk, opts)
+}
+
+func (c *CallMe) archiveExecutedTasks() {
+	cutoff := time.Now().Unix() - int64(c.ArchiveAfterDays)*86400
+	c.Logger.Info("Starting the archival process", zap.Int64("executed_before", cutoff))
+
+	archived := 0
+	lastEvaluatedKey := make(map[string]*dynamodb.AttributeValue, 0)
+	for {
+		input := &dynamodb.ScanInput{
+			TableName:      aws.String(c.DynamoDBTable),
+			ConsistentRead: aws.Bool(false),
+			ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+				":cutoff": {
+					S: aws.String(strconv.FormatInt(cutoff, 10)),
+				},
+			},
+			// tasks that have not been executed have either no executed_at or an empty one, which sorts first
+			FilterExpression: aws.String("size(executed_at) > 0 AND executed_at < :cutoff"),
+		}
+		if len(lastEvaluatedKey) > 0 {
+			input.ExclusiveStartKey = lastEvaluatedKey
+		}
+
+		result, err := c.ddb.Scan(input)
+		if err != nil {
+			c.Logger.Error("Failed Scan while archiving", zap.Error(err))
+			return
+		}
+
+		// copy each batch to the archive before deleting it from the main table; if deleting fails the same
+		// tasks will just be copied again on the next pass
+		for start := 0; start < len(result.Items); start += maxBatchWriteItems {
+			end := start + maxBatchWriteItems
+			if end > len(result.Items) {
+				end = len(result.Items)
+			}
+
+			puts := make([]*dynamodb.WriteRequest, 0, end-start)
+			deletes := make([]*dynamodb.WriteRequest, 0, end-start)
+			for _, item := range result.Items[start:end] {
+				puts = append(puts, &dynamodb.WriteRequest{
+					PutRequest: &dynamodb.PutRequest{Item: item},
+				})
+				deletes = append(deletes, &dynamodb.WriteRequest{
+					DeleteRequest: &dynamodb.DeleteRequest{
+						Key: map[string]*dynamodb.AttributeValue{
+							"trigger_at": item["trigger_at"],
+							"task_name":  item["task_name"],
+						},
+					},
+				})
+			}
+
+			err = c.batchWrite(c.DynamoDBArchiveTable, puts)
+			if err != nil {
+				c.Logger.Error("Failed to copy tasks to the archive", zap.Error(err))
+				return
+			}
+			err = c.batchWrite(c.DynamoDBTable, deletes)
+			if err != nil {
+				c.Logger.Error("Failed to delete archived tasks", zap.Error(err))
+				return
+			}
+			archived += len(puts)
+		}
+
+		lastEvaluatedKey = result.LastEvaluatedKey
+		if len(lastEvaluatedKey) == 0 {
+			break
+		}
+	}
+
+	c.Logger.Info("Archival process finished", zap.Int("archived", archived))
+}
+
+// send a batch of (at most maxBatchWriteItems) write requests to a table, retrying the ones DynamoDB did not process
+func (c *CallMe) batchWrite(table string, requests []*dynamodb.WriteRequest) error {
+	pending := map[string][]*dynamodb.WriteRequest{table: requests}
+
+	c.configMutex.RLock()
+	maxRetries := c.MaxRetries
+	c.configMutex.RUnlock()
+
+	for i := 0; i <= maxRetries; i++ {
+		result, err := c.ddb.BatchWriteItem(&dynamodb.BatchWriteItemInput{RequestItems: pending})
+		if err != nil {
+			return err
+		}
+		if len(result.UnprocessedItems) == 0 {
+			return nil
+		}
+
+		pending = result.UnprocessedItems
+		util.Backoff(i, c.Logger)
+	}
+
+	return errors.New("failed to process all items in the batch")
+}
@@ -0,0 +1,105 @@
+package app
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/marcoalmeida/callme/task"
+	"go.uber.org/zap"
+)
+
+// DynamoDB client keeping the tasks in memory, and the archive apart, that leaves the first request of every batch
+// unprocessed the first time it's sent
+type archiveClient struct {
+	*memoryClient
+	mutex    sync.Mutex
+	archived []map[string]*dynamodb.AttributeValue
+	retried  int
+}
+
+func (d *archiveClient) BatchWriteItem(input *dynamodb.BatchWriteItemInput) (*dynamodb.BatchWriteItemOutput, error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	output := &dynamodb.BatchWriteItemOutput{UnprocessedItems: map[string][]*dynamodb.WriteRequest{}}
+	for table, requests := range input.RequestItems {
+		if len(requests) > maxBatchWriteItems {
+			panic("too many requests")
+		}
+		if len(requests) > 1 {
+			output.UnprocessedItems[table] = requests[:1]
+			requests = requests[1:]
+		} else {
+			d.retried++
+		}
+		for _, request := range requests {
+			if request.PutRequest != nil {
+				d.archived = append(d.archived, request.PutRequest.Item)
+				continue
+			}
+			_, _ = d.memoryClient.DeleteItem(&dynamodb.DeleteItemInput{Key: request.DeleteRequest.Key})
+		}
+	}
+
+	return output, nil
+}
+
+func TestArchiveExecutedTasks(t *testing.T) {
+	ddb := &archiveClient{memoryClient: newMemoryClient()}
+	c := &CallMe{
+		Logger:               zap.NewNop(),
+		ddb:                  ddb,
+		ArchiveAfterDays:     1,
+		MaxRetries:           1,
+		DynamoDBArchiveTable: "callme-archive",
+	}
+
+	longAgo := strconv.FormatInt(time.Now().Unix()-7*86400, 10)
+	recently := strconv.FormatInt(time.Now().Unix()-60, 10)
+	for _, tsk := range []task.Task{
+		{Name: "old0", TriggerAt: longAgo, TaskState: task.Successful, ExecutedAt: longAgo},
+		{Name: "old1", TriggerAt: longAgo, TaskState: task.Failed, ExecutedAt: longAgo},
+		{Name: "recent", TriggerAt: recently, TaskState: task.Successful, ExecutedAt: recently},
+		// never executed, however long ago they were due
+		{Name: "pending", TriggerAt: longAgo, TaskState: task.Pending},
+		{Name: "running", TriggerAt: longAgo, TaskState: task.Pending},
+	} {
+		if err := c.UpsertTask(tsk); err != nil {
+			t.Fatal(err)
+		}
+	}
+	err := c.updateTaskState(task.Task{Name: "running", TriggerAt: longAgo, TaskState: task.Running})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c.archiveExecutedTasks()
+
+	if len(ddb.archived) != 2 {
+		t.Fatal("Expected 2 archived tasks, got", len(ddb.archived))
+	}
+	for _, item := range ddb.archived {
+		if name := aws.StringValue(item["task_name"].S); name != "old0" && name != "old1" {
+			t.Error("Expected only tasks executed long ago to be archived, got", name)
+		}
+	}
+	if ddb.item("old0", longAgo) != nil || ddb.item("old1", longAgo) != nil {
+		t.Error("Expected the archived tasks to be removed")
+	}
+	for _, name := range []string{"pending", "running"} {
+		if ddb.item(name, longAgo) == nil {
+			t.Error("Expected", name, "not to be archived")
+		}
+	}
+	if ddb.item("recent", recently) == nil {
+		t.Error("Expected the recently executed task not to be archived")
+	}
+	// both the copy and the removal of the first task
+	if ddb.retried != 2 {
+		t.Error("Expected the unprocessed requests to be retried, got", ddb.retried)
+	}
+}
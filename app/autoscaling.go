@@ -0,0 +1,112 @@
+package app
+
+import (
+	"errors"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/applicationautoscaling"
+	"github.com/aws/aws-sdk-go/service/applicationautoscaling/applicationautoscalingiface"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"go.uber.org/zap"
+)
+
+// DynamoDB only tracks utilization targets within this range (percent)
+const (
+	minTargetUtilization = 20
+	maxTargetUtilization = 90
+)
+
+func connectToAutoScaling(region string) applicationautoscalingiface.ApplicationAutoScalingAPI {
+	return applicationautoscaling.New(session.Must(session.NewSession(aws.NewConfig().WithRegion(region))))
+}
+
+// whether the tasks table is created with provisioned capacity, scaled by Application Auto Scaling, rather than
+// on-demand; DynamoDB Local does not support it
+func (c *CallMe) autoScaling() bool {
+	if !c.DynamoDBAutoScaling || c.local {
+		return false
+	}
+
+	err := c.validateAutoScaling()
+	if err != nil {
+		c.Logger.Error("Invalid auto-scaling configuration, creating an on-demand table instead", zap.Error(err))
+		return false
+	}
+
+	return true
+}
+
+func (c *CallMe) validateAutoScaling() error {
+	if c.DynamoDBMinCapacity < 1 || c.DynamoDBMaxCapacity < c.DynamoDBMinCapacity {
+		return errors.New("capacity must be at least 1, and the maximum no lower than the minimum")
+	}
+	if c.DynamoDBTargetUtilization < minTargetUtilization || c.DynamoDBTargetUtilization > maxTargetUtilization {
+		return errors.New("target utilization must be between 20 and 90")
+	}
+
+	return nil
+}
+
+// start with the minimum capacity, on the table and all of its indexes
+func (c *CallMe) provisionCapacity(input *dynamodb.CreateTableInput) {
+	throughput := &dynamodb.ProvisionedThroughput{
+		ReadCapacityUnits:  aws.Int64(int64(c.DynamoDBMinCapacity)),
+		WriteCapacityUnits: aws.Int64(int64(c.DynamoDBMinCapacity)),
+	}
+
+	input.BillingMode = aws.String(dynamodb.BillingModeProvisioned)
+	input.ProvisionedThroughput = throughput
+	for _, index := range input.GlobalSecondaryIndexes {
+		index.ProvisionedThroughput = throughput
+	}
+}
+
+// scale the read and write capacity of a table, and that of its indexes, between DynamoDBMinCapacity and
+// DynamoDBMaxCapacity to keep the utilization at DynamoDBTargetUtilization; the table must be active
+func (c *CallMe) enableAutoScaling(table string, indexes []string) error {
+	err := c.ddb.WaitUntilTableExists(&dynamodb.DescribeTableInput{TableName: aws.String(table)})
+	if err != nil {
+		return err
+	}
+
+	resources := map[string]string{"table/" + table: "table"}
+	for _, index := range indexes {
+		resources["table/"+table+"/index/"+index] = "index"
+	}
+	for resource, kind := range resources {
+		for _, capacity := range []string{"Read", "Write"} {
+			dimension := "dynamodb:" + kind + ":" + capacity + "CapacityUnits"
+			_, err := c.autoScaler.RegisterScalableTarget(&applicationautoscaling.RegisterScalableTargetInput{
+				ServiceNamespace:  aws.String(applicationautoscaling.ServiceNamespaceDynamodb),
+				ResourceId:        aws.String(resource),
+				ScalableDimension: aws.String(dimension),
+				MinCapacity:       aws.Int64(int64(c.DynamoDBMinCapacity)),
+				MaxCapacity:       aws.Int64(int64(c.DynamoDBMaxCapacity)),
+			})
+			if err != nil {
+				return err
+			}
+
+			_, err = c.autoScaler.PutScalingPolicy(&applicationautoscaling.PutScalingPolicyInput{
+				PolicyName:        aws.String(resource + "-" + capacity),
+				PolicyType:        aws.String(applicationautoscaling.PolicyTypeTargetTrackingScaling),
+				ServiceNamespace:  aws.String(applicationautoscaling.ServiceNamespaceDynamodb),
+				ResourceId:        aws.String(resource),
+				ScalableDimension: aws.String(dimension),
+				TargetTrackingScalingPolicyConfiguration: &applicationautoscaling.TargetTrackingScalingPolicyConfiguration{
+					TargetValue: aws.Float64(c.DynamoDBTargetUtilization),
+					PredefinedMetricSpecification: &applicationautoscaling.PredefinedMetricSpecification{
+						PredefinedMetricType: aws.String("DynamoDB" + capacity + "CapacityUtilization"),
+					},
+				},
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	c.Logger.Info("Enabled auto-scaling", zap.String("table", table), zap.Strings("indexes", indexes))
+	return nil
+}
@@ -0,0 +1,116 @@
+package app
+
+import (
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/applicationautoscaling"
+	"github.com/aws/aws-sdk-go/service/applicationautoscaling/applicationautoscalingiface"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"go.uber.org/zap"
+)
+
+// DynamoDB client that records the tables created, all of which become active right away
+type provisionedTableClient struct {
+	createTableClient
+	inputs []*dynamodb.CreateTableInput
+}
+
+func (d *provisionedTableClient) CreateTable(input *dynamodb.CreateTableInput) (*dynamodb.CreateTableOutput, error) {
+	d.inputs = append(d.inputs, input)
+	return d.createTableClient.CreateTable(input)
+}
+
+func (d *provisionedTableClient) WaitUntilTableExists(input *dynamodb.DescribeTableInput) error {
+	return nil
+}
+
+// Application Auto Scaling client that records the scalable targets and policies, as resource:dimension
+type autoScalingClient struct {
+	applicationautoscalingiface.ApplicationAutoScalingAPI
+	targets  []string
+	policies []string
+}
+
+func (a *autoScalingClient) RegisterScalableTarget(
+	input *applicationautoscaling.RegisterScalableTargetInput,
+) (*applicationautoscaling.RegisterScalableTargetOutput, error) {
+	a.targets = append(a.targets, aws.StringValue(input.ResourceId)+":"+aws.StringValue(input.ScalableDimension))
+	return &applicationautoscaling.RegisterScalableTargetOutput{}, nil
+}
+
+func (a *autoScalingClient) PutScalingPolicy(
+	input *applicationautoscaling.PutScalingPolicyInput,
+) (*applicationautoscaling.PutScalingPolicyOutput, error) {
+	if aws.Float64Value(input.TargetTrackingScalingPolicyConfiguration.TargetValue) != 70 {
+		panic("unexpected target utilization")
+	}
+	a.policies = append(a.policies, aws.StringValue(input.ResourceId)+":"+aws.StringValue(input.ScalableDimension))
+	return &applicationautoscaling.PutScalingPolicyOutput{}, nil
+}
+
+func TestCreateTables_autoScaling(t *testing.T) {
+	ddb := &provisionedTableClient{createTableClient: createTableClient{existing: map[string]bool{}}}
+	scaler := &autoScalingClient{}
+	cm := &CallMe{
+		DynamoDBTable:             "callme-tasks",
+		DynamoDBIndex:             "inverted_index",
+		DynamoDBConfigTable:       "callme-config",
+		DynamoDBAutoScaling:       true,
+		DynamoDBMinCapacity:       5,
+		DynamoDBMaxCapacity:       50,
+		DynamoDBTargetUtilization: 70,
+		Logger:                    zap.NewNop(),
+		ddb:                       ddb,
+		autoScaler:                scaler,
+	}
+
+	cm.createTables()
+	for _, input := range ddb.inputs {
+		provisioned := aws.StringValue(input.BillingMode) == dynamodb.BillingModeProvisioned
+		if provisioned != (aws.StringValue(input.TableName) == "callme-tasks") {
+			t.Error("Expected only the tasks table to be provisioned, got", input)
+		}
+	}
+	if aws.Int64Value(ddb.inputs[0].GlobalSecondaryIndexes[0].ProvisionedThroughput.ReadCapacityUnits) != 5 {
+		t.Error("Expected the index to start with the minimum capacity, got", ddb.inputs[0].GlobalSecondaryIndexes[0])
+	}
+
+	expected := []string{
+		"table/callme-tasks/index/inverted_index:dynamodb:index:ReadCapacityUnits",
+		"table/callme-tasks/index/inverted_index:dynamodb:index:WriteCapacityUnits",
+		"table/callme-tasks:dynamodb:table:ReadCapacityUnits",
+		"table/callme-tasks:dynamodb:table:WriteCapacityUnits",
+	}
+	sort.Strings(scaler.targets)
+	sort.Strings(scaler.policies)
+	if strings.Join(scaler.targets, ",") != strings.Join(expected, ",") {
+		t.Error("Expected scalable targets", expected, "got", scaler.targets)
+	}
+	if strings.Join(scaler.policies, ",") != strings.Join(expected, ",") {
+		t.Error("Expected scaling policies", expected, "got", scaler.policies)
+	}
+}
+
+func TestCreateTables_invalidAutoScaling(t *testing.T) {
+	ddb := &provisionedTableClient{createTableClient: createTableClient{existing: map[string]bool{}}}
+	scaler := &autoScalingClient{}
+	cm := &CallMe{
+		DynamoDBTable:             "callme-tasks",
+		DynamoDBAutoScaling:       true,
+		DynamoDBMinCapacity:       10,
+		DynamoDBMaxCapacity:       5,
+		DynamoDBTargetUtilization: 70,
+		Logger:                    zap.NewNop(),
+		ddb:                       ddb,
+		autoScaler:                scaler,
+	}
+
+	// falls back to an on-demand table
+	cm.createTables()
+	if aws.StringValue(ddb.inputs[0].BillingMode) != dynamodb.BillingModePayPerRequest || len(scaler.targets) != 0 {
+		t.Error("Expected an on-demand table, got", ddb.inputs[0], scaler.targets)
+	}
+}
@@ -0,0 +1,69 @@
+package app
+
+import (
+	"sync/atomic"
+
+	"github.com/marcoalmeida/callme/task"
+	"go.uber.org/zap"
+)
+
+// start executing a task right away if there's room for one more callback; otherwise, i.e., the limit on the number
+// of callbacks running has been reached, it either waits for its turn or, with RescheduleOverflow, is moved to the
+// next minute. It returns false if there was no room.
+func (c *CallMe) dispatchNow(tsk task.Task) bool {
+	if c.limiter == nil {
+		go c.callback(tsk)
+		return true
+	}
+
+	if c.limiter.tryAcquire(tsk.Weight) {
+		go func() {
+			defer c.limiter.release(tsk.Weight)
+			c.callback(tsk)
+		}()
+		return true
+	}
+
+	atomic.AddInt64(&c.poolOverflows, 1)
+
+	c.configMutex.RLock()
+	reschedule := c.RescheduleOverflow
+	c.configMutex.RUnlock()
+	if reschedule {
+		go c.postpone(tsk)
+	} else {
+		c.dispatch(tsk)
+	}
+
+	return false
+}
+
+// warn that some of the tasks for a given minute could not start on time
+func (c *CallMe) reportOverflow(minute int64, overflow int) {
+	c.configMutex.RLock()
+	reschedule := c.RescheduleOverflow
+	c.configMutex.RUnlock()
+
+	c.Logger.Warn(
+		"Too many callbacks running, some tasks could not start on time",
+		zap.Int64("current_minute", minute),
+		zap.Int("overflow", overflow),
+		zap.Bool("rescheduled", reschedule),
+	)
+	c.count("callme.pool.overflow", int64(overflow))
+}
+
+// PoolWeightUsed returns the number of callback slots, see MaxConcurrentCallbacks, taken by the callbacks running; it's
+// always 0 if the number of callbacks is not limited
+func (c *CallMe) PoolWeightUsed() int {
+	if c.limiter == nil {
+		return 0
+	}
+
+	return c.limiter.used()
+}
+
+// PoolOverflows returns the number of tasks that could not start on time because too many callbacks were running
+func (c *CallMe) PoolOverflows() int64 {
+	return atomic.LoadInt64(&c.poolOverflows)
+}
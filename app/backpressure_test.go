@@ -0,0 +1,43 @@
+package app
+
+import (
+	"testing"
+	"time"
+
+	"github.com/marcoalmeida/callme/task"
+	"go.uber.org/zap"
+)
+
+func TestDispatchNow_overflow(t *testing.T) {
+	ddb := newMemoryClient()
+	c := &CallMe{
+		Logger:             zap.NewNop(),
+		ddb:                ddb,
+		limiter:            newCallbackLimiter(1, 0),
+		RescheduleOverflow: true,
+	}
+	// the only slot is taken
+	c.limiter.acquire(1)
+
+	tsk := task.Task{Name: "t0", TriggerAt: "4102444800", TaskState: task.Pending}
+	if err := c.UpsertTask(tsk); err != nil {
+		t.Fatal(err)
+	}
+	if c.dispatchNow(tsk) {
+		t.Fatal("Expected the pool to be full")
+	}
+	if c.PoolOverflows() != 1 {
+		t.Error("Expected 1 overflow, got", c.PoolOverflows())
+	}
+
+	// moved to the next minute, rather than waiting
+	for i := 0; i < 100 && ddb.item("t0", tsk.TriggerAt) != nil; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if ddb.item("t0", "4102444860") == nil {
+		t.Error("Expected the task to be rescheduled to 4102444860")
+	}
+	if ddb.item("t0", tsk.TriggerAt) != nil {
+		t.Error("Expected the original task to be removed")
+	}
+}
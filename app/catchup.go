@@ -0,0 +1,157 @@
+package app
+
+import (
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"go.uber.org/zap"
+)
+
+const (
+	catchupLeaseConfigKey = "catchup_lease"
+	// a pass should take far less than this; the lease of an instance that dies while catching up expires eventually
+	catchupLeaseTTL = 30 * time.Minute
+)
+
+// reasons why tasks found while catching up are not executed: the task can no longer be executed, it's being
+// executed already, or (counting passes, not tasks) another pass was in progress
+const (
+	CatchupSkipPastMaxDelay   = "past_max_delay"
+	CatchupSkipAlreadyRunning = "already_running"
+	CatchupSkipConcurrentLock = "concurrent_lock"
+)
+
+// running totals of all catch up passes
+type catchupMetrics struct {
+	// duration of complete passes
+	durations durationHistogram
+	// items read from the table, tasks executed, and those skipped for each reason
+	scanned            int64
+	executed           int64
+	skippedPastDelay   int64
+	skippedRunning     int64
+	skippedConcurrency int64
+}
+
+// CatchupStats describes all catch up passes so far
+type CatchupStats struct {
+	// cumulative count of complete passes per duration bucket (see CatchupDurationBuckets, the last one being +Inf),
+	// along with their total duration and number
+	DurationBuckets []int64
+	DurationSumMs   int64
+	Passes          int64
+	Scanned         int64
+	Executed        int64
+	// by reason, e.g., CatchupSkipPastMaxDelay
+	Skipped map[string]int64
+}
+
+// CatchupStats returns the totals of all catch up passes so far
+func (c *CallMe) CatchupStats() CatchupStats {
+	stats := CatchupStats{
+		Scanned:  atomic.LoadInt64(&c.catchupMetrics.scanned),
+		Executed: atomic.LoadInt64(&c.catchupMetrics.executed),
+		Skipped: map[string]int64{
+			CatchupSkipPastMaxDelay:   atomic.LoadInt64(&c.catchupMetrics.skippedPastDelay),
+			CatchupSkipAlreadyRunning: atomic.LoadInt64(&c.catchupMetrics.skippedRunning),
+			CatchupSkipConcurrentLock: atomic.LoadInt64(&c.catchupMetrics.skippedConcurrency),
+		},
+	}
+	stats.DurationBuckets, stats.DurationSumMs, stats.Passes = c.catchupMetrics.durations.snapshot()
+
+	return stats
+}
+
+// CatchupPeriodically continuously runs in the background and, every CatchupInterval minutes, catches up on tasks that
+// were never executed. It returns if the interval is not positive, after a single pass.
+func (c *CallMe) CatchupPeriodically() {
+	for {
+		c.periodicCatchup()
+
+		c.configMutex.RLock()
+		interval := c.CatchupInterval
+		c.configMutex.RUnlock()
+		if interval <= 0 {
+			c.Logger.Info("Periodic catch up is disabled")
+			return
+		}
+
+		time.Sleep(time.Duration(interval) * time.Minute)
+	}
+}
+
+// a catch up pass, unless the previous one is still running on this instance or, with CatchupLease, any other
+func (c *CallMe) periodicCatchup() {
+	if c.CatchupLease {
+		acquired, err := c.acquireCatchupLease()
+		if err != nil {
+			c.Logger.Error("Failed to acquire the catch up lease", zap.Error(err))
+			return
+		}
+		if !acquired {
+			c.Logger.Info("Skipping catch up, another instance is already catching up")
+			atomic.AddInt64(&c.catchupMetrics.skippedConcurrency, 1)
+			return
+		}
+		defer c.releaseCatchupLease()
+	}
+
+	_, err := c.Catchup("")
+	if err == ErrCatchupInProgress {
+		c.Logger.Info("Skipping catch up, the previous pass is still running")
+		return
+	}
+	if err == ErrPaused {
+		c.Logger.Info("Skipping catch up, paused")
+		return
+	}
+	// housekeeping that, like catching up, only needs to be done by one instance at a time
+	c.scrubResponseBodies()
+}
+
+// take the cluster-wide catch up lease, unless some other instance holds it and it has not expired yet
+func (c *CallMe) acquireCatchupLease() (bool, error) {
+	now := time.Now()
+	_, err := c.ddb.PutItem(&dynamodb.PutItemInput{
+		TableName: aws.String(c.DynamoDBConfigTable),
+		Item: map[string]*dynamodb.AttributeValue{
+			"config_key": {S: aws.String(catchupLeaseConfigKey)},
+			"holder":     {S: aws.String(c.InstanceID)},
+			"expires_at": {N: aws.String(strconv.FormatInt(now.Add(catchupLeaseTTL).Unix(), 10))},
+		},
+		ConditionExpression: aws.String("attribute_not_exists(config_key) OR expires_at < :now OR holder = :holder"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":now":    {N: aws.String(strconv.FormatInt(now.Unix(), 10))},
+			":holder": {S: aws.String(c.InstanceID)},
+		},
+	})
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// give up the catch up lease, if this instance still holds it
+func (c *CallMe) releaseCatchupLease() {
+	_, err := c.ddb.DeleteItem(&dynamodb.DeleteItemInput{
+		TableName: aws.String(c.DynamoDBConfigTable),
+		Key: map[string]*dynamodb.AttributeValue{
+			"config_key": {S: aws.String(catchupLeaseConfigKey)},
+		},
+		ConditionExpression: aws.String("holder = :holder"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":holder": {S: aws.String(c.InstanceID)},
+		},
+	})
+	if err != nil {
+		c.Logger.Error("Failed to release the catch up lease", zap.Error(err))
+	}
+}
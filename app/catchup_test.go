@@ -0,0 +1,104 @@
+package app
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"go.uber.org/zap"
+)
+
+func TestPeriodicCatchup_overlapping(t *testing.T) {
+	ddb := &catchupClient{pages: 1, entered: make(chan bool), release: make(chan bool)}
+	cm := &CallMe{Logger: zap.NewNop(), ddb: ddb}
+
+	first := make(chan bool)
+	go func() {
+		cm.periodicCatchup()
+		first <- true
+	}()
+	<-ddb.entered
+
+	// the next tick does not even scan
+	second := make(chan bool)
+	go func() {
+		cm.periodicCatchup()
+		second <- true
+	}()
+	select {
+	case <-second:
+	case <-time.After(time.Second):
+		t.Error("Expected the second pass to be skipped")
+	}
+
+	ddb.release <- true
+	<-first
+}
+
+// DynamoDB client holding the catch up lease, with just enough support for the conditions used on it
+type leaseClient struct {
+	dynamodbiface.DynamoDBAPI
+	mutex sync.Mutex
+	item  map[string]*dynamodb.AttributeValue
+}
+
+func (d *leaseClient) PutItem(input *dynamodb.PutItemInput) (*dynamodb.PutItemOutput, error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if d.item != nil {
+		expiresAt, _ := strconv.ParseInt(aws.StringValue(d.item["expires_at"].N), 10, 64)
+		now, _ := strconv.ParseInt(aws.StringValue(input.ExpressionAttributeValues[":now"].N), 10, 64)
+		holder := aws.StringValue(d.item["holder"].S)
+		if expiresAt >= now && holder != aws.StringValue(input.ExpressionAttributeValues[":holder"].S) {
+			return nil, awserr.New(dynamodb.ErrCodeConditionalCheckFailedException, "conditional check failed", nil)
+		}
+	}
+
+	d.item = input.Item
+	return &dynamodb.PutItemOutput{}, nil
+}
+
+func (d *leaseClient) DeleteItem(input *dynamodb.DeleteItemInput) (*dynamodb.DeleteItemOutput, error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if d.item == nil ||
+		aws.StringValue(d.item["holder"].S) != aws.StringValue(input.ExpressionAttributeValues[":holder"].S) {
+		return nil, awserr.New(dynamodb.ErrCodeConditionalCheckFailedException, "conditional check failed", nil)
+	}
+
+	d.item = nil
+	return &dynamodb.DeleteItemOutput{}, nil
+}
+
+func TestCatchupLease(t *testing.T) {
+	ddb := &leaseClient{}
+	a := &CallMe{Logger: zap.NewNop(), ddb: ddb, InstanceID: "a"}
+	b := &CallMe{Logger: zap.NewNop(), ddb: ddb, InstanceID: "b"}
+
+	acquire := func(c *CallMe, expected bool) {
+		acquired, err := c.acquireCatchupLease()
+		if err != nil || acquired != expected {
+			t.Error(c.InstanceID, "expected to acquire the lease:", expected, "got", acquired, err)
+		}
+	}
+
+	acquire(a, true)
+	acquire(b, false)
+	// releasing a lease held by someone else does nothing
+	b.releaseCatchupLease()
+	acquire(b, false)
+
+	a.releaseCatchupLease()
+	acquire(b, true)
+
+	// the lease of an instance that went away expires
+	ddb.item["expires_at"] = &dynamodb.AttributeValue{N: aws.String(strconv.FormatInt(time.Now().Unix()-1, 10))}
+	acquire(a, true)
+}
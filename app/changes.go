@@ -0,0 +1,58 @@
+package app
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// wakes up whoever is waiting for the state of the tasks with a given name to change; only changes made by this
+// instance are seen
+type stateChanges struct {
+	mutex   sync.Mutex
+	waiting map[string]chan struct{}
+}
+
+// channel that is closed on the next change to the tasks with a given name
+func (s *stateChanges) wait(name string) <-chan struct{} {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.waiting == nil {
+		s.waiting = make(map[string]chan struct{})
+	}
+	ch, ok := s.waiting[name]
+	if !ok {
+		ch = make(chan struct{})
+		s.waiting[name] = ch
+	}
+
+	return ch
+}
+
+func (s *stateChanges) notify(name string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if ch, ok := s.waiting[name]; ok {
+		close(ch)
+		delete(s.waiting, name)
+	}
+}
+
+// WaitForChange blocks until the state of a task with the given name changes, returning true, or until the timeout
+// elapses or ctx is canceled, returning false. Only changes made by this instance, i.e., callbacks it executes, are
+// taken into account.
+func (c *CallMe) WaitForChange(ctx context.Context, name string, timeout time.Duration) bool {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case <-c.stateChanges.wait(name):
+		return true
+	case <-timer.C:
+		return false
+	case <-ctx.Done():
+		return false
+	}
+}
@@ -0,0 +1,39 @@
+package app
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/marcoalmeida/callme/task"
+	"go.uber.org/zap"
+)
+
+func TestWaitForChange(t *testing.T) {
+	c := &CallMe{Logger: zap.NewNop(), ddb: &countersClient{}}
+
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		// changes to other tasks do not count
+		_ = c.updateExecutedTask(task.Task{Name: "t1", TriggerAt: "60", TaskState: task.Running})
+		time.Sleep(50 * time.Millisecond)
+		_ = c.updateExecutedTask(task.Task{Name: "t0", TriggerAt: "60", TaskState: task.Running})
+	}()
+
+	start := time.Now()
+	if !c.WaitForChange(context.Background(), "t0", 5*time.Second) {
+		t.Error("Expected a change")
+	}
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond || elapsed > time.Second {
+		t.Error("Expected to return as soon as t0 changed, took", elapsed)
+	}
+
+	// nothing changes
+	start = time.Now()
+	if c.WaitForChange(context.Background(), "t0", 100*time.Millisecond) {
+		t.Error("Expected no changes")
+	}
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+		t.Error("Expected to wait for the timeout, took", elapsed)
+	}
+}
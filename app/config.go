@@ -0,0 +1,282 @@
+package app
+
+import (
+	"crypto/subtle"
+	"errors"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/marcoalmeida/callme/task"
+	"go.uber.org/zap"
+)
+
+// key of the item, on the configuration table, holding the global list of holidays
+const holidaysConfigKey = "holidays"
+
+// Holidays returns the global list of holidays (ISO 8601 dates) all calendar-aware tasks skip
+func (c *CallMe) Holidays() []string {
+	c.holidaysMutex.RLock()
+	defer c.holidaysMutex.RUnlock()
+
+	return c.holidays
+}
+
+// SetHolidays replaces the global list of holidays
+func (c *CallMe) SetHolidays(holidays []string) error {
+	item := map[string]*dynamodb.AttributeValue{
+		"config_key": {S: aws.String(holidaysConfigKey)},
+	}
+	// DynamoDB does not accept empty sets
+	if len(holidays) > 0 {
+		item["config_value"] = &dynamodb.AttributeValue{SS: aws.StringSlice(holidays)}
+	}
+
+	input := &dynamodb.PutItemInput{
+		TableName: aws.String(c.DynamoDBConfigTable),
+		Item:      item,
+	}
+	_, err := c.ddb.PutItem(input)
+	if err != nil {
+		c.Logger.Error("Failed to store holidays", zap.Error(err))
+		return errors.New("failed to store holidays")
+	}
+
+	c.holidaysMutex.Lock()
+	c.holidays = holidays
+	c.holidaysMutex.Unlock()
+
+	return nil
+}
+
+// refresh the local copy of the global list of holidays; on failure the previous one is kept
+func (c *CallMe) loadHolidays() {
+	input := &dynamodb.GetItemInput{
+		TableName: aws.String(c.DynamoDBConfigTable),
+		Key: map[string]*dynamodb.AttributeValue{
+			"config_key": {S: aws.String(holidaysConfigKey)},
+		},
+	}
+	result, err := c.ddb.GetItem(input)
+	if err != nil {
+		if !c.missingConfigTable(err) {
+			c.Logger.Error("Failed to load holidays", zap.Error(err))
+		}
+		return
+	}
+
+	holidays := make([]string, 0)
+	if value, ok := result.Item["config_value"]; ok {
+		holidays = aws.StringValueSlice(value.SS)
+	}
+
+	c.holidaysMutex.Lock()
+	c.holidays = holidays
+	c.holidaysMutex.Unlock()
+}
+
+// whether an error reading from the configuration table is because it does not exist, e.g., it was not created along
+// with the tasks table, which is only logged the first time, rather than on every reload
+func (c *CallMe) missingConfigTable(err error) bool {
+	aerr, ok := err.(awserr.Error)
+	if !ok || aerr.Code() != dynamodb.ErrCodeResourceNotFoundException {
+		return false
+	}
+
+	if atomic.CompareAndSwapInt32(&c.configTableMissing, 0, 1) {
+		c.Logger.Warn(
+			"The configuration table does not exist, holidays, parked tasks, and maintenance windows are not loaded",
+			zap.String("table", c.DynamoDBConfigTable),
+		)
+	}
+	return true
+}
+
+// placeholder for the value of configuration parameters that should not be disclosed
+const redacted = "<redacted>"
+
+// Config returns the effective configuration, indexed by parameter name, with all secrets redacted
+func (c *CallMe) Config() map[string]interface{} {
+	c.configMutex.RLock()
+	defer c.configMutex.RUnlock()
+
+	config := make(map[string]interface{})
+
+	t := reflect.TypeOf(c).Elem()
+	v := reflect.ValueOf(c).Elem()
+	for i := 0; i < t.NumField(); i++ {
+		param := t.Field(i).Tag.Get("callme")
+		if param == "" {
+			continue
+		}
+		if isSecret(t.Field(i)) {
+			config[param] = redacted
+		} else {
+			config[param] = v.Field(i).Interface()
+		}
+	}
+	// not a configuration parameter, but also changes the way tasks are executed
+	config["parked_tasks"] = c.Parked()
+	config["maintenance_windows"] = c.MaintenanceWindows()
+
+	return config
+}
+
+// override configuration parameters with environment variables, if set
+func (c *CallMe) loadEnvironment() {
+	t := reflect.TypeOf(c).Elem()
+	v := reflect.ValueOf(c).Elem()
+	for i := 0; i < t.NumField(); i++ {
+		// get the parameter name from the field tag
+		param := strings.ToUpper(t.Field(i).Tag.Get("callme"))
+		if param == "" {
+			continue
+		}
+		c.Logger.Info("Reading configuration parameter", zap.String("parameter", param))
+		value := os.Getenv(param)
+		if value != "" {
+			logged := value
+			if isSecret(t.Field(i)) {
+				logged = redacted
+			}
+			c.Logger.Info("Found value", zap.String("parameter", param), zap.String("value", logged))
+			switch t.Field(i).Type.Kind() {
+			case reflect.String:
+				v.Field(i).SetString(value)
+			case reflect.Int:
+				n, err := strconv.Atoi(value)
+				if err != nil {
+					c.Logger.Error(
+						"Failed to convert integer",
+						zap.String("param", param),
+						zap.String("value", logged))
+					continue
+				}
+				v.Field(i).SetInt(int64(n))
+			case reflect.Float64:
+				f, err := strconv.ParseFloat(value, 64)
+				if err != nil {
+					c.Logger.Error(
+						"Failed to convert number",
+						zap.String("param", param),
+						zap.String("value", logged))
+					continue
+				}
+				v.Field(i).SetFloat(f)
+			case reflect.Bool:
+				v.Field(i).SetBool(strings.ToLower(value) == "true")
+			case reflect.Slice:
+				// comma separated list of strings
+				values := make([]string, 0)
+				for _, s := range strings.Split(value, ",") {
+					if s = strings.TrimSpace(s); s != "" {
+						values = append(values, s)
+					}
+				}
+				v.Field(i).Set(reflect.ValueOf(values))
+			}
+		}
+	}
+}
+
+// Reload re-reads all environment variables and applies the new values of the configuration parameters that can be
+// changed at runtime; changes to the others are ignored. Nothing is changed if any of the new values is invalid.
+// The new effective configuration is returned.
+func (c *CallMe) Reload() (map[string]interface{}, error) {
+	t := reflect.TypeOf(c).Elem()
+	current := reflect.ValueOf(c).Elem()
+
+	// start from the current configuration, as not all parameters may be set
+	next := &CallMe{Logger: c.Logger}
+	updated := reflect.ValueOf(next).Elem()
+	c.configMutex.RLock()
+	for i := 0; i < t.NumField(); i++ {
+		if t.Field(i).Tag.Get("callme") != "" {
+			updated.Field(i).Set(current.Field(i))
+		}
+	}
+	c.configMutex.RUnlock()
+
+	next.loadEnvironment()
+	err := next.validateConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	c.configMutex.Lock()
+	for i := 0; i < t.NumField(); i++ {
+		param := t.Field(i).Tag.Get("callme")
+		if param == "" || reflect.DeepEqual(current.Field(i).Interface(), updated.Field(i).Interface()) {
+			continue
+		}
+		if t.Field(i).Tag.Get("static") == "true" {
+			c.Logger.Warn("Ignoring configuration parameter that cannot be changed at runtime", zap.String("parameter", param))
+			continue
+		}
+		current.Field(i).Set(updated.Field(i))
+		c.Logger.Info("Configuration parameter changed", zap.String("parameter", param))
+	}
+	c.configMutex.Unlock()
+
+	return c.Config(), nil
+}
+
+// TaskDefaults returns the callback method and expected HTTP status of tasks that do not set them
+func (c *CallMe) TaskDefaults() (string, int) {
+	c.configMutex.RLock()
+	defer c.configMutex.RUnlock()
+
+	return c.DefaultCallbackMethod, c.DefaultExpectedStatus
+}
+
+// UploadLimits returns the maximum size of a task's payload and of a CSV file with tasks
+func (c *CallMe) UploadLimits() (int, int) {
+	c.configMutex.RLock()
+	defer c.configMutex.RUnlock()
+
+	return c.MaxPayloadBytes, c.MaxCSVUploadBytes
+}
+
+// Authorize checks whether a token grants access to the administrative endpoints
+func (c *CallMe) Authorize(token string) error {
+	c.configMutex.RLock()
+	defer c.configMutex.RUnlock()
+
+	// no token, no administrative endpoints
+	if c.AdminToken == "" {
+		return ErrAdminDisabled
+	}
+	if subtle.ConstantTimeCompare([]byte(token), []byte(c.AdminToken)) != 1 {
+		return ErrUnauthorized
+	}
+
+	return nil
+}
+
+// make sure the configuration parameters that can be changed at runtime are valid
+func (c *CallMe) validateConfig() error {
+	if !task.IsValidCallbackMethod(c.DefaultCallbackMethod) {
+		return errors.New("unsupported default callback method: " + c.DefaultCallbackMethod)
+	}
+	if c.DefaultExpectedStatus < 100 || c.DefaultExpectedStatus > 599 {
+		return errors.New("invalid default expected status: " + strconv.Itoa(c.DefaultExpectedStatus))
+	}
+	if c.MaxRetries < 0 {
+		return errors.New("max_retries cannot be negative")
+	}
+	if c.MaxPayloadBytes <= 0 || c.MaxCSVUploadBytes <= 0 || c.MaxStatusResults <= 0 {
+		return errors.New("size limits must be positive")
+	}
+
+	return nil
+}
+
+// configuration parameters tagged with secret:"true" are never disclosed
+func isSecret(field reflect.StructField) bool {
+	return field.Tag.Get("secret") == "true"
+}
@@ -0,0 +1,100 @@
+package app
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestConfig(t *testing.T) {
+	t.Setenv("MAX_RETRIES", "7")
+	t.Setenv("STORE_RESPONSE_BODY", "false")
+	t.Setenv("ADMIN_TOKEN", "s3cret")
+	t.Setenv("DYNAMODB_FAILOVER_REGIONS", "us-west-2, eu-west-1,")
+
+	cm := &CallMe{MaxRetries: defaultMaxRetires, StoreResponseBody: true, Logger: zap.NewNop()}
+	cm.loadEnvironment()
+	config := cm.Config()
+
+	if config["max_retries"] != 7 {
+		t.Error("Expected max_retries to be 7, got", config["max_retries"])
+	}
+	if config["store_response_body"] != false {
+		t.Error("Expected store_response_body to be false, got", config["store_response_body"])
+	}
+	if config["admin_token"] != redacted {
+		t.Error("Expected admin_token to be redacted, got", config["admin_token"])
+	}
+	if !reflect.DeepEqual(config["dynamodb_failover_regions"], []string{"us-west-2", "eu-west-1"}) {
+		t.Error("Expected 2 failover regions, got", config["dynamodb_failover_regions"])
+	}
+	if _, ok := config["Logger"]; ok {
+		t.Error("Expected only configuration parameters")
+	}
+}
+
+func TestReload(t *testing.T) {
+	cm := &CallMe{
+		DynamoDBTable:         "callme",
+		MaxRetries:            3,
+		MaxPayloadBytes:       16,
+		MaxCSVUploadBytes:     16,
+		DefaultCallbackMethod: "GET",
+		DefaultExpectedStatus: 200,
+		MaxStatusResults:      100,
+		Logger:                zap.NewNop(),
+	}
+
+	t.Setenv("MAX_RETRIES", "9")
+	t.Setenv("DYNAMODB_TABLE", "other")
+	config, err := cm.Reload()
+	if err != nil {
+		t.Fatal("Expected to succeed, failed with", err)
+	}
+	if cm.MaxRetries != 9 || config["max_retries"] != 9 {
+		t.Error("Expected max_retries to be reloaded, got", cm.MaxRetries)
+	}
+	if cm.DynamoDBTable != "callme" {
+		t.Error("Expected dynamodb_table to be ignored, got", cm.DynamoDBTable)
+	}
+
+	// nothing changes if any of the new values is invalid
+	t.Setenv("MAX_RETRIES", "5")
+	t.Setenv("DEFAULT_CALLBACK_METHOD", "CONNECT")
+	_, err = cm.Reload()
+	if err == nil {
+		t.Error("Expected to fail with an invalid default callback method")
+	}
+	if cm.MaxRetries != 9 {
+		t.Error("Expected max_retries to remain 9, got", cm.MaxRetries)
+	}
+}
+
+// DynamoDB client without a configuration table
+type noConfigTableClient struct {
+	dynamodbiface.DynamoDBAPI
+}
+
+func (d *noConfigTableClient) GetItem(input *dynamodb.GetItemInput) (*dynamodb.GetItemOutput, error) {
+	return nil, awserr.New(dynamodb.ErrCodeResourceNotFoundException, "table not found", nil)
+}
+
+func TestLoadConfig_missingTable(t *testing.T) {
+	core, logs := observer.New(zap.WarnLevel)
+	cm := &CallMe{Logger: zap.New(core), ddb: &noConfigTableClient{}, DynamoDBConfigTable: "callme-config"}
+
+	// reloaded every minute
+	for i := 0; i < 3; i++ {
+		cm.loadHolidays()
+		cm.loadParked()
+		cm.loadMaintenanceWindows()
+	}
+	if logs.Len() != 1 || logs.All()[0].Level != zap.WarnLevel {
+		t.Error("Expected a single warning, got", logs.All())
+	}
+}
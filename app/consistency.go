@@ -0,0 +1,35 @@
+package app
+
+import (
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"go.uber.org/zap"
+)
+
+// errors returned by DynamoDB when requests are throttled
+func isThroughputError(err error) bool {
+	aerr, ok := err.(awserr.Error)
+	if !ok {
+		return false
+	}
+
+	switch aerr.Code() {
+	case dynamodb.ErrCodeProvisionedThroughputExceededException, dynamodb.ErrCodeRequestLimitExceeded,
+		"ThrottlingException":
+		return true
+	}
+
+	return false
+}
+
+// run a strongly consistent read and, if it's throttled, try once more with an eventually consistent one, which
+// consumes half the capacity; it returns whether the read that was used is strongly consistent
+func (c *CallMe) withConsistencyFallback(fn func(consistent bool) error) (bool, error) {
+	err := fn(true)
+	if !isThroughputError(err) {
+		return true, err
+	}
+
+	c.Logger.Warn("Strongly consistent read throttled, falling back to an eventually consistent one", zap.Error(err))
+	return false, fn(false)
+}
@@ -0,0 +1,68 @@
+package app
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"github.com/marcoalmeida/callme/task"
+	"go.uber.org/zap"
+)
+
+// DynamoDB client that fails strongly and/or eventually consistent reads with the given errors
+type throttledClient struct {
+	dynamodbiface.DynamoDBAPI
+	strongErr   error
+	eventualErr error
+	reads       []bool
+}
+
+func (d *throttledClient) GetItem(input *dynamodb.GetItemInput) (*dynamodb.GetItemOutput, error) {
+	consistent := aws.BoolValue(input.ConsistentRead)
+	d.reads = append(d.reads, consistent)
+
+	if consistent && d.strongErr != nil {
+		return nil, d.strongErr
+	}
+	if !consistent && d.eventualErr != nil {
+		return nil, d.eventualErr
+	}
+
+	return &dynamodb.GetItemOutput{Item: map[string]*dynamodb.AttributeValue{
+		"task_name":  input.Key["task_name"],
+		"trigger_at": input.Key["trigger_at"],
+	}}, nil
+}
+
+func TestStatusByTaskKey_consistencyFallback(t *testing.T) {
+	throttled := awserr.New(dynamodb.ErrCodeProvisionedThroughputExceededException, "throttled", nil)
+	tests := []struct {
+		ddb        *throttledClient
+		fails      bool
+		eventually bool
+		reads      int
+	}{
+		{&throttledClient{}, false, false, 1},
+		{&throttledClient{strongErr: throttled}, false, true, 2},
+		{&throttledClient{strongErr: throttled, eventualErr: throttled}, true, false, 2},
+		// only throttling is worth retrying
+		{&throttledClient{strongErr: errors.New("boom")}, true, false, 1},
+	}
+
+	for i, test := range tests {
+		c := &CallMe{Logger: zap.NewNop(), ddb: test.ddb, DynamoDBTable: "tasks"}
+		status, err := c.statusByTaskKey("tasks", task.Task{Name: "t0", TriggerAt: "60"}, StatusOptions{})
+		if (err != nil) != test.fails {
+			t.Error(i, "Unexpected error", err)
+		}
+		if err == nil && status.EventuallyConsistent != test.eventually {
+			t.Error(i, "Expected eventually consistent", test.eventually, "got", status.EventuallyConsistent)
+		}
+		if len(test.ddb.reads) != test.reads || !test.ddb.reads[0] {
+			t.Error(i, "Expected", test.reads, "reads, the first strongly consistent, got", test.ddb.reads)
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package app
+
+import (
+	"strconv"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/marcoalmeida/callme/task"
+	"go.uber.org/zap"
+)
+
+const (
+	// widest range of minutes a histogram can cover
+	maxHistogramMinutes = 1440
+	// BatchGetItem reads at most 100 items per call
+	maxBatchGetKeys = 100
+)
+
+// MinuteCount is the number of tasks scheduled for a given minute that have not completed yet
+type MinuteCount struct {
+	Minute string `json:"minute"`
+	Count  int64  `json:"count"`
+}
+
+// atomically add delta to the number of tasks scheduled for a given minute; counters are best effort, failures are
+// logged and otherwise ignored
+func (c *CallMe) addToCounter(triggerAt string, delta int64) {
+	if !c.CountTasks {
+		return
+	}
+
+	_, err := c.ddb.UpdateItem(&dynamodb.UpdateItemInput{
+		TableName: aws.String(c.DynamoDBCountersTable),
+		Key: map[string]*dynamodb.AttributeValue{
+			"trigger_at": {S: aws.String(triggerAt)},
+		},
+		// count is a reserved word
+		UpdateExpression:         aws.String("ADD #count :delta"),
+		ExpressionAttributeNames: map[string]*string{"#count": aws.String("count")},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":delta": {N: aws.String(strconv.FormatInt(delta, 10))},
+		},
+	})
+	if err != nil {
+		c.Logger.Error("Failed to update task counter", zap.Error(err), zap.String("trigger_at", triggerAt))
+	}
+}
+
+// count a task once it is scheduled
+func (c *CallMe) countScheduled(tsk task.Task) {
+	if tsk.TaskState == task.Pending {
+		c.addToCounter(tsk.TriggerAt, 1)
+	}
+}
+
+// stop counting a task once it completes, is skipped, or moved elsewhere
+func (c *CallMe) countCompleted(tsk task.Task) {
+	c.addToCounter(tsk.TriggerAt, -1)
+}
+
+// TaskHistogram returns the number of tasks scheduled for each minute between from and to (Unix timestamps,
+// inclusive) that have not completed yet
+func (c *CallMe) TaskHistogram(from int64, to int64) ([]MinuteCount, error) {
+	if !c.CountTasks {
+		return nil, ErrCountersDisabled
+	}
+
+	from -= from % 60
+	to -= to % 60
+	if to < from || (to-from)/60 >= maxHistogramMinutes {
+		return nil, ErrInvalidRange
+	}
+
+	histogram := make([]MinuteCount, 0, (to-from)/60+1)
+	index := make(map[string]int)
+	for minute := from; minute <= to; minute += 60 {
+		m := strconv.FormatInt(minute, 10)
+		index[m] = len(histogram)
+		histogram = append(histogram, MinuteCount{Minute: m})
+	}
+
+	keys := make([]map[string]*dynamodb.AttributeValue, 0, len(histogram))
+	for _, m := range histogram {
+		keys = append(keys, map[string]*dynamodb.AttributeValue{"trigger_at": {S: aws.String(m.Minute)}})
+	}
+
+	for len(keys) > 0 {
+		n := len(keys)
+		if n > maxBatchGetKeys {
+			n = maxBatchGetKeys
+		}
+
+		output, err := c.ddb.BatchGetItem(&dynamodb.BatchGetItemInput{
+			RequestItems: map[string]*dynamodb.KeysAndAttributes{
+				c.DynamoDBCountersTable: {Keys: keys[:n]},
+			},
+		})
+		if err != nil {
+			c.Logger.Error("Failed to read task counters", zap.Error(err))
+			return nil, err
+		}
+		keys = keys[n:]
+
+		for _, item := range output.Responses[c.DynamoDBCountersTable] {
+			i, ok := index[stringAttribute(item, "trigger_at")]
+			if !ok || item["count"] == nil {
+				continue
+			}
+			histogram[i].Count, _ = strconv.ParseInt(aws.StringValue(item["count"].N), 10, 64)
+		}
+
+		// throttled keys are tried again
+		if unprocessed, ok := output.UnprocessedKeys[c.DynamoDBCountersTable]; ok {
+			keys = append(keys, unprocessed.Keys...)
+		}
+	}
+
+	return histogram, nil
+}
@@ -0,0 +1,207 @@
+package app
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"github.com/marcoalmeida/callme/task"
+	"go.uber.org/zap"
+)
+
+// DynamoDB client that keeps the counters in memory and ignores writes to the tasks table
+type countersClient struct {
+	dynamodbiface.DynamoDBAPI
+	counters map[string]int64
+	batches  int
+}
+
+func (d *countersClient) PutItem(input *dynamodb.PutItemInput) (*dynamodb.PutItemOutput, error) {
+	return &dynamodb.PutItemOutput{}, nil
+}
+
+func (d *countersClient) UpdateItem(input *dynamodb.UpdateItemInput) (*dynamodb.UpdateItemOutput, error) {
+	// the state of a task, not a counter
+	if input.ExpressionAttributeValues[":delta"] == nil {
+		return &dynamodb.UpdateItemOutput{}, nil
+	}
+	delta, _ := strconv.ParseInt(aws.StringValue(input.ExpressionAttributeValues[":delta"].N), 10, 64)
+	d.counters[aws.StringValue(input.Key["trigger_at"].S)] += delta
+
+	return &dynamodb.UpdateItemOutput{}, nil
+}
+
+func (d *countersClient) BatchGetItem(input *dynamodb.BatchGetItemInput) (*dynamodb.BatchGetItemOutput, error) {
+	d.batches++
+
+	output := &dynamodb.BatchGetItemOutput{Responses: map[string][]map[string]*dynamodb.AttributeValue{}}
+	for table, keys := range input.RequestItems {
+		if len(keys.Keys) > maxBatchGetKeys {
+			panic("too many keys")
+		}
+		for _, key := range keys.Keys {
+			minute := aws.StringValue(key["trigger_at"].S)
+			if count, ok := d.counters[minute]; ok {
+				output.Responses[table] = append(output.Responses[table], map[string]*dynamodb.AttributeValue{
+					"trigger_at": {S: aws.String(minute)},
+					"count":      {N: aws.String(strconv.FormatInt(count, 10))},
+				})
+			}
+		}
+	}
+
+	return output, nil
+}
+
+// DynamoDB client that keeps both the tasks and the counters in memory
+type countingMemoryClient struct {
+	*memoryClient
+	mutex    sync.Mutex
+	counters map[string]int64
+}
+
+func (d *countingMemoryClient) UpdateItem(input *dynamodb.UpdateItemInput) (*dynamodb.UpdateItemOutput, error) {
+	if input.ExpressionAttributeValues[":delta"] == nil {
+		return d.memoryClient.UpdateItem(input)
+	}
+	delta, _ := strconv.ParseInt(aws.StringValue(input.ExpressionAttributeValues[":delta"].N), 10, 64)
+	d.mutex.Lock()
+	d.counters[aws.StringValue(input.Key["trigger_at"].S)] += delta
+	d.mutex.Unlock()
+
+	return &dynamodb.UpdateItemOutput{}, nil
+}
+
+func (d *countingMemoryClient) count(minute string) int64 {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	return d.counters[minute]
+}
+
+func TestTaskHistogram_retry(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ts.Close()
+
+	ddb := &countingMemoryClient{memoryClient: newMemoryClient(), counters: map[string]int64{}}
+	c := &CallMe{
+		Logger:                zap.NewNop(),
+		ddb:                   ddb,
+		httpClient:            ts.Client(),
+		MaxRetries:            1,
+		CountTasks:            true,
+		DynamoDBCountersTable: defaultDynamoDBCountersTable,
+	}
+
+	// stored again while pending, counted once
+	pending := task.Task{Name: "t0", TriggerAt: "600", TaskState: task.Pending}
+	for i := 0; i < 2; i++ {
+		if err := c.UpsertTask(pending); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := ddb.count("600"); n != 1 {
+		t.Error("Expected 1 task at 600, got", n)
+	}
+
+	failed := task.Task{Name: "t1", TriggerAt: "600", CallbackEndpoint: ts.URL}
+	failed.SetDefaults("", 0)
+	failed.TaskState = task.Failed
+	if err := c.UpsertTask(failed); err != nil {
+		t.Fatal(err)
+	}
+	retried, err := c.RetryTask(failed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := ddb.count(retried.TriggerAt); n != 1 {
+		t.Error("Expected the retried task to be counted, got", n)
+	}
+	for i := 0; i < 100 && stringAttribute(ddb.item("t1", retried.TriggerAt), "task_state") != task.Successful; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	// counted out right after the state is stored
+	time.Sleep(50 * time.Millisecond)
+	if n := ddb.count(retried.TriggerAt); n != 0 {
+		t.Error("Expected no tasks left once the retried one completed, got", n)
+	}
+}
+
+func TestTaskHistogram(t *testing.T) {
+	ddb := &countersClient{counters: map[string]int64{}}
+	c := &CallMe{
+		Logger:                zap.NewNop(),
+		ddb:                   ddb,
+		CountTasks:            true,
+		DynamoDBCountersTable: defaultDynamoDBCountersTable,
+	}
+
+	for _, tsk := range []task.Task{
+		{Name: "t0", TriggerAt: "600", TaskState: task.Pending},
+		{Name: "t1", TriggerAt: "600", TaskState: task.Pending},
+		{Name: "t2", TriggerAt: "720", TaskState: task.Pending},
+	} {
+		err := c.UpsertTask(tsk)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// running does not change anything, completing does
+	err := c.updateExecutedTask(task.Task{Name: "t0", TriggerAt: "600", TaskState: task.Running})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = c.updateExecutedTask(task.Task{Name: "t0", TriggerAt: "600", TaskState: task.Successful})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	histogram, err := c.TaskHistogram(610, 720)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []MinuteCount{{"600", 1}, {"660", 0}, {"720", 1}}
+	if len(histogram) != len(expected) {
+		t.Fatal("Expected", expected, "got", histogram)
+	}
+	for i := range expected {
+		if histogram[i] != expected[i] {
+			t.Error("Expected", expected[i], "got", histogram[i])
+		}
+	}
+
+	// a day is split across several batches
+	ddb.batches = 0
+	histogram, err = c.TaskHistogram(0, (maxHistogramMinutes-1)*60)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(histogram) != maxHistogramMinutes {
+		t.Error("Expected", maxHistogramMinutes, "minutes, got", len(histogram))
+	}
+	if expected := (maxHistogramMinutes + maxBatchGetKeys - 1) / maxBatchGetKeys; ddb.batches != expected {
+		t.Error("Expected", expected, "batches, got", ddb.batches)
+	}
+
+	_, err = c.TaskHistogram(0, maxHistogramMinutes*60)
+	if err != ErrInvalidRange {
+		t.Error("Expected", ErrInvalidRange, "got", err)
+	}
+	_, err = c.TaskHistogram(120, 60)
+	if err != ErrInvalidRange {
+		t.Error("Expected", ErrInvalidRange, "got", err)
+	}
+
+	c.CountTasks = false
+	_, err = c.TaskHistogram(0, 60)
+	if err != ErrCountersDisabled {
+		t.Error("Expected", ErrCountersDisabled, "got", err)
+	}
+}
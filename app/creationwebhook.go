@@ -0,0 +1,63 @@
+package app
+
+import (
+	"encoding/json"
+	"sync/atomic"
+
+	"github.com/marcoalmeida/callme/task"
+	"go.uber.org/zap"
+)
+
+const (
+	// tasks waiting to be pushed to TaskCreationWebhook
+	creationWebhookQueue = 1000
+	// attempts to push each one, backing off in between
+	creationWebhookAttempts = 3
+)
+
+// queue a newly created task to be pushed to the creation webhook; tasks are dropped, and counted, rather than holding
+// up the request that created them if the queue is full
+func (c *CallMe) publishCreation(tsk task.Task) {
+	if c.creations == nil {
+		return
+	}
+
+	select {
+	case c.creations <- tsk:
+	default:
+		atomic.AddInt64(&c.creationsDropped, 1)
+		c.Logger.Error("Dropping created task notification, the queue is full", zap.String("task", tsk.String()))
+	}
+}
+
+// push queued tasks to the creation webhook, one at a time, until the queue is closed
+func (c *CallMe) deliverCreations() {
+	for tsk := range c.creations {
+		c.deliverCreation(tsk)
+	}
+}
+
+// push a newly created task, without its payload, which may be sensitive and is of no use to track how tasks are
+// created; it's dropped, and counted, if it cannot be delivered
+func (c *CallMe) deliverCreation(tsk task.Task) {
+	tsk.Payload = ""
+	tsk.EncryptedPayload = ""
+	tsk.EncryptedDataKey = ""
+	body, err := json.Marshal(tsk)
+	if err != nil {
+		c.Logger.Error("Failed to marshal created task", zap.Error(err))
+		return
+	}
+
+	delivered := c.postSigned(c.TaskCreationWebhook, c.TaskCreationWebhookSecret, body, creationWebhookAttempts,
+		c.Logger.With(zap.String("webhook", "creation"), zap.String("task", tsk.String())),
+	)
+	if !delivered {
+		atomic.AddInt64(&c.creationsDropped, 1)
+	}
+}
+
+// CreationWebhookDropped returns the number of created tasks that could not be pushed to TaskCreationWebhook
+func (c *CallMe) CreationWebhookDropped() int64 {
+	return atomic.LoadInt64(&c.creationsDropped)
+}
@@ -0,0 +1,82 @@
+package app
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/marcoalmeida/callme/task"
+	"go.uber.org/zap"
+)
+
+func TestCreationWebhook(t *testing.T) {
+	received := make(chan task.Task, 1)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		if r.Header.Get(statusSignatureHeader) != signStatusUpdate(body, "s3cr3t") {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		var tsk task.Task
+		json.Unmarshal(body, &tsk)
+		received <- tsk
+	}))
+	defer ts.Close()
+
+	c := &CallMe{
+		Logger:                    zap.NewNop(),
+		ddb:                       &rescheduleClient{},
+		httpClient:                ts.Client(),
+		TaskCreationWebhook:       ts.URL,
+		TaskCreationWebhookSecret: "s3cr3t",
+		creations:                 make(chan task.Task, 1),
+	}
+	go c.deliverCreations()
+	defer close(c.creations)
+
+	err := c.CreateTask(task.Task{Name: "t0", TriggerAt: "600", CallbackEndpoint: ts.URL, Payload: "secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case tsk := <-received:
+		if tsk.Name != "t0" || tsk.TriggerAt != "600" || tsk.Payload != "" {
+			t.Error("Expected t0@600 without its payload, got", tsk)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected the created task to be pushed")
+	}
+}
+
+func TestCreationWebhook_dropped(t *testing.T) {
+	failures := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		failures++
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer ts.Close()
+
+	c := &CallMe{
+		Logger:              zap.NewNop(),
+		httpClient:          ts.Client(),
+		TaskCreationWebhook: ts.URL,
+		creations:           make(chan task.Task, 1),
+	}
+
+	// nothing is delivering them yet, and publishing never blocks
+	tsk := task.Task{Name: "t0", TriggerAt: "600"}
+	c.publishCreation(tsk)
+	c.publishCreation(tsk)
+	if c.CreationWebhookDropped() != 1 {
+		t.Error("Expected 1 task to be dropped with a full queue, got", c.CreationWebhookDropped())
+	}
+
+	// retried, then dropped
+	c.deliverCreation(<-c.creations)
+	if failures != creationWebhookAttempts || c.CreationWebhookDropped() != 2 {
+		t.Error("Expected", creationWebhookAttempts, "attempts and 2 dropped tasks, got", failures, c.CreationWebhookDropped())
+	}
+}
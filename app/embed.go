@@ -0,0 +1,39 @@
+package app
+
+import (
+	"context"
+	"net/http"
+)
+
+// ServeHTTP makes CallMe an http.Handler, so that it can be embedded in existing servers, e.g.,
+// mux.Handle("/callme/", http.StripPrefix("/callme", cm)); requests are served by the instance's own mux, on which
+// the handlers package registers all endpoints (handlers.Register).
+func (c *CallMe) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	c.mux.ServeHTTP(w, r)
+}
+
+// Mux returns the mux requests to this instance are served by
+func (c *CallMe) Mux() *http.ServeMux {
+	return c.mux
+}
+
+// Start runs the main loop, periodic catch up, and archival (if enabled) in the background. Once ctx is done no more
+// callbacks are started; Shutdown still needs to be called to wait for those in progress.
+func (c *CallMe) Start(ctx context.Context) {
+	go c.CatchupPeriodically()
+	go c.Run()
+	go c.Archive()
+
+	go func() {
+		<-ctx.Done()
+		c.Logger.Info("Context done, no more callbacks will be started")
+		c.stop()
+	}()
+}
+
+// stop starting new callbacks
+func (c *CallMe) stop() {
+	c.inFlightMutex.Lock()
+	c.stopping = true
+	c.inFlightMutex.Unlock()
+}
@@ -0,0 +1,44 @@
+package app
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/marcoalmeida/callme/task"
+	"go.uber.org/zap"
+)
+
+func TestServeHTTP(t *testing.T) {
+	cm := &CallMe{Logger: zap.NewNop(), mux: http.NewServeMux()}
+	cm.Mux().HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, "ok")
+	})
+
+	mux := http.NewServeMux()
+	mux.Handle("/callme/", http.StripPrefix("/callme", cm))
+
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/callme/health", nil))
+	if rec.Code != http.StatusOK || rec.Body.String() != "ok" {
+		t.Errorf("Expected 200 ok, got %d %q", rec.Code, rec.Body.String())
+	}
+
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/callme/nothing", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("Expected 404, got %d", rec.Code)
+	}
+}
+
+func Test_stop(t *testing.T) {
+	cm := &CallMe{Logger: zap.NewNop()}
+	cm.stop()
+
+	ctx, done := cm.trackCallback(task.Task{Name: "stopped", TriggerAt: "1"})
+	defer done()
+	if ctx.Err() == nil {
+		t.Error("Expected callbacks not to start once stopped")
+	}
+}
@@ -0,0 +1,188 @@
+package app
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/kms"
+	"github.com/aws/aws-sdk-go/service/kms/kmsiface"
+	"github.com/marcoalmeida/callme/task"
+	"go.uber.org/zap"
+)
+
+const (
+	// AES-256
+	dataKeyBytes = 32
+	// how long decrypted data keys are kept in memory, saving a call to KMS for every callback
+	dataKeyTTL = 5 * time.Minute
+)
+
+// decrypted data keys, by the hash of their encrypted form
+type dataKeyCache struct {
+	keys  map[string]cachedDataKey
+	mutex sync.Mutex
+}
+
+type cachedDataKey struct {
+	key     []byte
+	expires time.Time
+}
+
+func (d *dataKeyCache) get(id string, now time.Time) ([]byte, bool) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	cached, ok := d.keys[id]
+	if !ok || now.After(cached.expires) {
+		delete(d.keys, id)
+		return nil, false
+	}
+
+	return cached.key, true
+}
+
+func (d *dataKeyCache) add(id string, key []byte, now time.Time) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if d.keys == nil {
+		d.keys = make(map[string]cachedDataKey)
+	}
+	// expired keys are dropped as they're added, so the cache only holds those used within the last few minutes
+	for k, cached := range d.keys {
+		if now.After(cached.expires) {
+			delete(d.keys, k)
+		}
+	}
+	d.keys[id] = cachedDataKey{key: key, expires: now.Add(dataKeyTTL)}
+}
+
+func connectToKMS(region string) kmsiface.KMSAPI {
+	return kms.New(session.Must(session.NewSession(aws.NewConfig().WithRegion(region))))
+}
+
+// replace the payload of a task, and those of its follow-up tasks, with its encrypted form if requested: the payload is
+// encrypted (AES-256-GCM) with a random data key, which is in turn encrypted with the task's KMS key
+func (c *CallMe) encryptPayload(tsk task.Task) (task.Task, error) {
+	if tsk.OnSuccess != nil {
+		next, err := c.encryptPayload(*tsk.OnSuccess)
+		if err != nil {
+			return tsk, err
+		}
+		tsk.OnSuccess = &next
+	}
+
+	// already encrypted, e.g., a follow-up task
+	if !tsk.EncryptPayload || tsk.EncryptedPayload != "" {
+		return tsk, nil
+	}
+
+	key := make([]byte, dataKeyBytes)
+	_, err := io.ReadFull(rand.Reader, key)
+	if err != nil {
+		return tsk, err
+	}
+
+	output, err := c.kms.Encrypt(&kms.EncryptInput{
+		KeyId:     aws.String(tsk.PayloadKMSKeyID),
+		Plaintext: key,
+	})
+	if err != nil {
+		c.Logger.Error("Failed to encrypt data key", zap.Error(err), zap.String("task", tsk.String()))
+		return tsk, errors.New("failed to encrypt the payload")
+	}
+
+	gcm, err := newGCM(key)
+	if err != nil {
+		return tsk, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	_, err = io.ReadFull(rand.Reader, nonce)
+	if err != nil {
+		return tsk, err
+	}
+
+	tsk.EncryptedPayload = base64.StdEncoding.EncodeToString(gcm.Seal(nonce, nonce, []byte(tsk.Payload), nil))
+	tsk.EncryptedDataKey = base64.StdEncoding.EncodeToString(output.CiphertextBlob)
+	tsk.Payload = ""
+	c.dataKeys.add(dataKeyID(tsk.EncryptedDataKey), key, time.Now())
+
+	return tsk, nil
+}
+
+// restore the payload of a task whose payload is stored encrypted
+func (c *CallMe) decryptPayload(tsk task.Task) (task.Task, error) {
+	if tsk.EncryptedPayload == "" {
+		return tsk, nil
+	}
+
+	key, err := c.dataKey(tsk.EncryptedDataKey)
+	if err != nil {
+		c.Logger.Error("Failed to decrypt data key", zap.Error(err), zap.String("task", tsk.String()))
+		return tsk, errors.New("failed to decrypt the payload")
+	}
+
+	ciphertext, err := base64.StdEncoding.DecodeString(tsk.EncryptedPayload)
+	if err != nil {
+		return tsk, errors.New("invalid encrypted payload: " + err.Error())
+	}
+	gcm, err := newGCM(key)
+	if err != nil {
+		return tsk, err
+	}
+	if len(ciphertext) < gcm.NonceSize() {
+		return tsk, errors.New("invalid encrypted payload: too short")
+	}
+	payload, err := gcm.Open(nil, ciphertext[:gcm.NonceSize()], ciphertext[gcm.NonceSize():], nil)
+	if err != nil {
+		return tsk, errors.New("failed to decrypt the payload: " + err.Error())
+	}
+	tsk.Payload = string(payload)
+
+	return tsk, nil
+}
+
+// decrypt a data key (base64), using the cache if possible
+func (c *CallMe) dataKey(encrypted string) ([]byte, error) {
+	id := dataKeyID(encrypted)
+	if key, ok := c.dataKeys.get(id, time.Now()); ok {
+		return key, nil
+	}
+
+	blob, err := base64.StdEncoding.DecodeString(encrypted)
+	if err != nil {
+		return nil, err
+	}
+	output, err := c.kms.Decrypt(&kms.DecryptInput{CiphertextBlob: blob})
+	if err != nil {
+		return nil, err
+	}
+	c.dataKeys.add(id, output.Plaintext, time.Now())
+
+	return output.Plaintext, nil
+}
+
+// data keys are cached by the hash of their encrypted form
+func dataKeyID(encrypted string) string {
+	sum := sha256.Sum256([]byte(encrypted))
+	return hex.EncodeToString(sum[:])
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
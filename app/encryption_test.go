@@ -0,0 +1,160 @@
+package app
+
+import (
+	"bytes"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/kms"
+	"github.com/aws/aws-sdk-go/service/kms/kmsiface"
+	"github.com/marcoalmeida/callme/task"
+	"github.com/marcoalmeida/callme/util"
+	"go.uber.org/zap"
+)
+
+// KMS client that "encrypts" data keys by reversing them
+type reversingKMS struct {
+	kmsiface.KMSAPI
+	decrypted int
+}
+
+func reverse(b []byte) []byte {
+	r := make([]byte, len(b))
+	for i := range b {
+		r[len(b)-1-i] = b[i]
+	}
+	return r
+}
+
+func (k *reversingKMS) Encrypt(input *kms.EncryptInput) (*kms.EncryptOutput, error) {
+	return &kms.EncryptOutput{CiphertextBlob: reverse(input.Plaintext), KeyId: input.KeyId}, nil
+}
+
+func (k *reversingKMS) Decrypt(input *kms.DecryptInput) (*kms.DecryptOutput, error) {
+	k.decrypted++
+	return &kms.DecryptOutput{Plaintext: reverse(input.CiphertextBlob)}, nil
+}
+
+func TestEncryptPayload(t *testing.T) {
+	kmsClient := &reversingKMS{}
+	c := &CallMe{Logger: zap.NewNop(), kms: kmsClient}
+
+	tsk := task.Task{
+		Name:            "t0",
+		TriggerAt:       "600",
+		Payload:         "secret",
+		EncryptPayload:  true,
+		PayloadKMSKeyID: "alias/callme",
+		OnSuccess:       &task.Task{Name: "t1", TriggerAt: "+1m", Payload: "another secret", EncryptPayload: true},
+	}
+	encrypted, err := c.encryptPayload(tsk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if encrypted.Payload != "" || encrypted.EncryptedPayload == "" || encrypted.EncryptedDataKey == "" {
+		t.Fatal("Expected only the encrypted payload and data key to be kept, got", encrypted)
+	}
+	if encrypted.OnSuccess.Payload != "" || encrypted.OnSuccess.EncryptedPayload == "" {
+		t.Error("Expected the payload of the follow-up task to be encrypted, got", encrypted.OnSuccess)
+	}
+
+	// encrypting again has no effect
+	again, err := c.encryptPayload(encrypted)
+	if err != nil || again.EncryptedPayload != encrypted.EncryptedPayload {
+		t.Error("Expected the payload to be encrypted only once, got", again, err)
+	}
+
+	// the data key is only decrypted by KMS once it's no longer cached
+	c.dataKeys = dataKeyCache{}
+	for i := 0; i < 2; i++ {
+		decrypted, err := c.decryptPayload(encrypted)
+		if err != nil || decrypted.Payload != "secret" {
+			t.Error("Expected secret, got", decrypted.Payload, err)
+		}
+	}
+	if kmsClient.decrypted != 1 {
+		t.Error("Expected 1 call to KMS Decrypt, got", kmsClient.decrypted)
+	}
+
+	// tampered payloads fail to decrypt
+	tampered := encrypted
+	tampered.EncryptedPayload = "AAAA" + tampered.EncryptedPayload[4:]
+	_, err = c.decryptPayload(tampered)
+	if err == nil {
+		t.Error("Expected to fail decrypting a tampered payload")
+	}
+
+	// plaintext payloads are left alone
+	plain, err := c.decryptPayload(task.Task{Payload: "public"})
+	if err != nil || plain.Payload != "public" {
+		t.Error("Expected public, got", plain.Payload, err)
+	}
+}
+
+func TestCallback_encryptedDayOff(t *testing.T) {
+	kmsClient := &reversingKMS{}
+	ddb := newMemoryClient()
+	c := &CallMe{Logger: zap.NewNop(), ddb: ddb, kms: kmsClient}
+
+	now := time.Unix(util.GetUnixMinute(), 0).UTC()
+	tsk := task.Task{
+		Name:             "t0",
+		TriggerAt:        strconv.FormatInt(now.Unix(), 10),
+		CallbackEndpoint: "http://example.com",
+		Payload:          "secret",
+		EncryptPayload:   true,
+		PayloadKMSKeyID:  "alias/callme",
+		SkipHolidays:     []string{now.Format(util.DateLayout)},
+	}
+	tsk.SetDefaults("", 0)
+	if err := c.CreateTask(tsk); err != nil {
+		t.Fatal(err)
+	}
+	stored, ok := c.unmarshalTask(ddb.item("t0", tsk.TriggerAt))
+	if !ok {
+		t.Fatal("Expected the task to be stored")
+	}
+
+	c.dataKeys = dataKeyCache{}
+	c.callback(stored)
+	moved := ddb.item("t0", strconv.FormatInt(now.AddDate(0, 0, 1).Unix(), 10))
+	if moved == nil {
+		t.Fatal("Expected the task to be moved to the next day")
+	}
+	if _, ok := moved["payload"]; ok || stringAttribute(moved, "encrypted_payload") == "" {
+		t.Error("Expected only the encrypted payload to be stored, got", moved)
+	}
+	if kmsClient.decrypted != 0 {
+		t.Error("Expected the payload not to be decrypted, got", kmsClient.decrypted, "calls to KMS Decrypt")
+	}
+}
+
+func TestCreateTask_payloadRef(t *testing.T) {
+	ddb := newMemoryClient()
+	c := &CallMe{Logger: zap.NewNop(), ddb: ddb}
+
+	// e.g., a task moved after its payload was fetched
+	tsk := task.Task{Name: "t0", TriggerAt: "1800000000", PayloadRef: "s3://bucket/key", Payload: "large"}
+	if err := c.CreateTask(tsk); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := ddb.item("t0", "1800000000")["payload"]; ok {
+		t.Error("Expected the fetched payload not to be stored")
+	}
+}
+
+func TestDataKeyCache(t *testing.T) {
+	var cache dataKeyCache
+	now := time.Date(2024, 3, 15, 22, 30, 0, 0, time.UTC)
+	cache.add("k", []byte("key"), now)
+
+	key, ok := cache.get("k", now.Add(dataKeyTTL))
+	if !ok || !bytes.Equal(key, []byte("key")) {
+		t.Error("Expected the key to be cached, got", key, ok)
+	}
+	_, ok = cache.get("k", now.Add(dataKeyTTL+1))
+	if ok {
+		t.Error("Expected the key to expire")
+	}
+}
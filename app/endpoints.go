@@ -0,0 +1,49 @@
+package app
+
+import (
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/endpoints"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+// configuration of the DynamoDB client for a region; DYNAMODB_ENDPOINT, and local mode, only apply to the primary
+// region, whereas DYNAMODB_ENDPOINT_TEMPLATE and DYNAMODB_FIPS apply to all of them
+func (c *CallMe) dynamoDBConfig(region string, primary bool) *aws.Config {
+	config := aws.NewConfig().
+		WithRegion(region).
+		WithMaxRetries(c.MaxRetries)
+	if c.DynamoDBFIPS {
+		config = config.WithUseFIPSEndpoint(true)
+	}
+	if c.DynamoDBEndpointTemplate != "" {
+		config = config.WithEndpointResolver(templateResolver(c.DynamoDBEndpointTemplate))
+	}
+
+	if primary {
+		config = config.WithEndpoint(c.DynamoDBEndpoint)
+		if c.local {
+			config = localConfig(config)
+		}
+	}
+
+	return config
+}
+
+// resolve the DynamoDB endpoint by replacing {region} in template, e.g., https://dynamodb.{region}.example.com; the
+// endpoints of all other services are resolved as usual
+func templateResolver(template string) endpoints.Resolver {
+	return endpoints.ResolverFunc(
+		func(service, region string, opts ...func(*endpoints.Options)) (endpoints.ResolvedEndpoint, error) {
+			if service != dynamodb.EndpointsID {
+				return endpoints.DefaultResolver().EndpointFor(service, region, opts...)
+			}
+
+			return endpoints.ResolvedEndpoint{
+				URL:           strings.Replace(template, "{region}", region, -1),
+				SigningRegion: region,
+			}, nil
+		},
+	)
+}
@@ -0,0 +1,36 @@
+package app
+
+import "testing"
+
+func TestDynamoDBConfig(t *testing.T) {
+	tests := []struct {
+		c        *CallMe
+		region   string
+		primary  bool
+		expected string
+	}{
+		{&CallMe{}, "us-east-1", true, "https://dynamodb.us-east-1.amazonaws.com"},
+		{&CallMe{DynamoDBFIPS: true}, "us-east-1", true, "https://dynamodb-fips.us-east-1.amazonaws.com"},
+		{
+			&CallMe{DynamoDBEndpointTemplate: "https://dynamodb.{region}.example.com"},
+			"us-gov-west-1",
+			false,
+			"https://dynamodb.us-gov-west-1.example.com",
+		},
+		// an explicit endpoint takes precedence, but only on the primary region
+		{
+			&CallMe{DynamoDBEndpointTemplate: "https://dynamodb.{region}.example.com", DynamoDBEndpoint: "http://ddb:8000"},
+			"us-east-1",
+			true,
+			"http://ddb:8000",
+		},
+		{&CallMe{DynamoDBEndpoint: "http://ddb:8000"}, "us-west-2", false, "https://dynamodb.us-west-2.amazonaws.com"},
+	}
+
+	for _, test := range tests {
+		ddb := connectToDynamoDB(test.c.dynamoDBConfig(test.region, test.primary))
+		if ddb.Endpoint != test.expected {
+			t.Error("Expected endpoint", test.expected, "got", ddb.Endpoint)
+		}
+	}
+}
@@ -0,0 +1,41 @@
+package app
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/marcoalmeida/callme/task"
+	"go.uber.org/zap"
+)
+
+// publish a newly created task so that, if it's due this minute, the main loop dispatches it right away; otherwise it
+// would only be found by the next catch up pass. Events are dropped if the buffer is full.
+func (c *CallMe) publishCreated(tsk task.Task) {
+	select {
+	case c.TaskCreatedChan <- tsk:
+	default:
+		c.Logger.Debug("Dropping task creation event, the buffer is full", zap.String("task", tsk.String()))
+	}
+}
+
+// dispatch the pending tasks created for a given minute, unless they have already been dispatched, until next fires;
+// tasks created for any other minute, or while paused, are left for the main loop, or a catch up pass, to find
+func (c *CallMe) dispatchCreated(minute int64, dispatched map[string]bool, next <-chan time.Time) {
+	current := strconv.FormatInt(minute, 10)
+
+	for {
+		select {
+		case tsk := <-c.TaskCreatedChan:
+			key := inFlightKey(tsk)
+			if tsk.TriggerAt != current || tsk.TaskState != task.Pending || dispatched[key] || c.Paused() {
+				continue
+			}
+			dispatched[key] = true
+
+			c.Logger.Debug("Dispatching newly created task", zap.String("task", tsk.String()))
+			c.dispatch(tsk)
+		case <-next:
+			return
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package app
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/marcoalmeida/callme/task"
+	"github.com/marcoalmeida/callme/util"
+	"go.uber.org/zap"
+)
+
+func TestDispatchCreated(t *testing.T) {
+	var mutex sync.Mutex
+	calls := make(map[string]int)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		calls[r.URL.Path]++
+		mutex.Unlock()
+	}))
+	defer ts.Close()
+
+	c := &CallMe{
+		Logger:          zap.NewNop(),
+		ddb:             &countersClient{},
+		httpClient:      ts.Client(),
+		TaskCreatedChan: make(chan task.Task, 10),
+	}
+
+	now := util.GetUnixMinute()
+	newTask := func(name string, minute int64) task.Task {
+		tsk := task.Task{Name: name, TriggerAt: strconv.FormatInt(minute, 10), CallbackEndpoint: ts.URL + "/" + name}
+		tsk.SetDefaults("", 0)
+		return tsk
+	}
+	// found by the main loop already
+	dispatched := map[string]bool{inFlightKey(newTask("polled", now)): true}
+
+	for _, tsk := range []task.Task{newTask("t0", now), newTask("polled", now), newTask("later", now+60)} {
+		err := c.CreateTask(tsk)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	c.dispatchCreated(now, dispatched, time.After(100*time.Millisecond))
+
+	// give the callbacks a chance to run
+	time.Sleep(100 * time.Millisecond)
+	mutex.Lock()
+	defer mutex.Unlock()
+	if calls["/t0"] != 1 {
+		t.Error("Expected the task due this minute to be dispatched once, got", calls["/t0"])
+	}
+	if calls["/polled"] != 0 || calls["/later"] != 0 {
+		t.Error("Expected no other tasks to be dispatched, got", calls)
+	}
+
+	// creating tasks never blocks, even if nobody is listening
+	c.TaskCreatedChan = make(chan task.Task)
+	err := c.CreateTask(newTask("t1", now))
+	if err != nil {
+		t.Fatal(err)
+	}
+}
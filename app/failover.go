@@ -0,0 +1,165 @@
+package app
+
+import (
+	"sync/atomic"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"go.uber.org/zap"
+)
+
+// DynamoDB client that sends requests to the active region and, if it cannot be reached, moves on to the next one.
+// All regions are expected to hold replicas of the same tables, i.e., DynamoDB Global Tables.
+// Only the operations used by CallMe are wrapped, anything else goes to the primary region.
+type failoverDynamoDB struct {
+	dynamodbiface.DynamoDBAPI
+	regions []string
+	clients []dynamodbiface.DynamoDBAPI
+	// index of the region requests are currently sent to
+	active int32
+	logger *zap.Logger
+}
+
+// a client per region, the first one being the primary
+func newFailoverDynamoDB(regions []string, clients []dynamodbiface.DynamoDBAPI, logger *zap.Logger) *failoverDynamoDB {
+	return &failoverDynamoDB{
+		DynamoDBAPI: clients[0],
+		regions:     regions,
+		clients:     clients,
+		logger:      logger,
+	}
+}
+
+// ActiveRegion returns the region requests are currently sent to
+func (f *failoverDynamoDB) ActiveRegion() string {
+	return f.regions[atomic.LoadInt32(&f.active)]
+}
+
+// run fn against the active region and, if it cannot be reached, against each of the others in turn until one
+// succeeds, which becomes the active one
+func (f *failoverDynamoDB) withFailover(fn func(dynamodbiface.DynamoDBAPI) error) error {
+	active := int(atomic.LoadInt32(&f.active))
+
+	var err error
+	for i := 0; i < len(f.clients); i++ {
+		next := (active + i) % len(f.clients)
+		err = fn(f.clients[next])
+		if !isEndpointError(err) {
+			if next != active && atomic.CompareAndSwapInt32(&f.active, int32(active), int32(next)) {
+				f.logger.Warn(
+					"Failed over to another DynamoDB region",
+					zap.String("from", f.regions[active]),
+					zap.String("to", f.regions[next]),
+				)
+			}
+			return err
+		}
+		f.logger.Error("DynamoDB region unavailable", zap.String("region", f.regions[next]), zap.Error(err))
+	}
+
+	return err
+}
+
+// errors that mean the region could not be reached, as opposed to the request itself failing
+func isEndpointError(err error) bool {
+	aerr, ok := err.(awserr.Error)
+	if !ok {
+		return false
+	}
+
+	switch aerr.Code() {
+	case request.ErrCodeRequestError, request.ErrCodeResponseTimeout, "ServiceUnavailable", "InternalFailure":
+		return true
+	}
+
+	return false
+}
+
+func (f *failoverDynamoDB) GetItem(input *dynamodb.GetItemInput) (output *dynamodb.GetItemOutput, err error) {
+	err = f.withFailover(func(client dynamodbiface.DynamoDBAPI) error {
+		output, err = client.GetItem(input)
+		return err
+	})
+	return output, err
+}
+
+func (f *failoverDynamoDB) PutItem(input *dynamodb.PutItemInput) (output *dynamodb.PutItemOutput, err error) {
+	err = f.withFailover(func(client dynamodbiface.DynamoDBAPI) error {
+		output, err = client.PutItem(input)
+		return err
+	})
+	return output, err
+}
+
+func (f *failoverDynamoDB) UpdateItem(input *dynamodb.UpdateItemInput) (output *dynamodb.UpdateItemOutput, err error) {
+	err = f.withFailover(func(client dynamodbiface.DynamoDBAPI) error {
+		output, err = client.UpdateItem(input)
+		return err
+	})
+	return output, err
+}
+
+func (f *failoverDynamoDB) DeleteItem(input *dynamodb.DeleteItemInput) (output *dynamodb.DeleteItemOutput, err error) {
+	err = f.withFailover(func(client dynamodbiface.DynamoDBAPI) error {
+		output, err = client.DeleteItem(input)
+		return err
+	})
+	return output, err
+}
+
+func (f *failoverDynamoDB) Query(input *dynamodb.QueryInput) (output *dynamodb.QueryOutput, err error) {
+	err = f.withFailover(func(client dynamodbiface.DynamoDBAPI) error {
+		output, err = client.Query(input)
+		return err
+	})
+	return output, err
+}
+
+func (f *failoverDynamoDB) Scan(input *dynamodb.ScanInput) (output *dynamodb.ScanOutput, err error) {
+	err = f.withFailover(func(client dynamodbiface.DynamoDBAPI) error {
+		output, err = client.Scan(input)
+		return err
+	})
+	return output, err
+}
+
+func (f *failoverDynamoDB) BatchGetItem(
+	input *dynamodb.BatchGetItemInput,
+) (output *dynamodb.BatchGetItemOutput, err error) {
+	err = f.withFailover(func(client dynamodbiface.DynamoDBAPI) error {
+		output, err = client.BatchGetItem(input)
+		return err
+	})
+	return output, err
+}
+
+func (f *failoverDynamoDB) BatchWriteItem(
+	input *dynamodb.BatchWriteItemInput,
+) (output *dynamodb.BatchWriteItemOutput, err error) {
+	err = f.withFailover(func(client dynamodbiface.DynamoDBAPI) error {
+		output, err = client.BatchWriteItem(input)
+		return err
+	})
+	return output, err
+}
+
+func (f *failoverDynamoDB) DescribeTable(
+	input *dynamodb.DescribeTableInput,
+) (output *dynamodb.DescribeTableOutput, err error) {
+	err = f.withFailover(func(client dynamodbiface.DynamoDBAPI) error {
+		output, err = client.DescribeTable(input)
+		return err
+	})
+	return output, err
+}
+
+// ActiveRegion returns the DynamoDB region requests are currently sent to
+func (c *CallMe) ActiveRegion() string {
+	if f, ok := c.ddb.(*failoverDynamoDB); ok {
+		return f.ActiveRegion()
+	}
+
+	return c.DynamoDBRegion
+}
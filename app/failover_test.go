@@ -0,0 +1,82 @@
+package app
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"go.uber.org/zap"
+)
+
+// DynamoDB client that fails every GetItem with the same error, and counts them
+type regionClient struct {
+	dynamodbiface.DynamoDBAPI
+	err   error
+	calls int
+}
+
+func (d *regionClient) GetItem(input *dynamodb.GetItemInput) (*dynamodb.GetItemOutput, error) {
+	d.calls++
+	if d.err != nil {
+		return nil, d.err
+	}
+
+	return &dynamodb.GetItemOutput{}, nil
+}
+
+func TestFailoverDynamoDB(t *testing.T) {
+	primary := &regionClient{err: awserr.New(request.ErrCodeRequestError, "connection refused", nil)}
+	secondary := &regionClient{err: awserr.New(request.ErrCodeRequestError, "connection refused", nil)}
+	tertiary := &regionClient{}
+	ddb := newFailoverDynamoDB(
+		[]string{"us-east-1", "us-west-2", "eu-west-1"},
+		[]dynamodbiface.DynamoDBAPI{primary, secondary, tertiary},
+		zap.NewNop(),
+	)
+	cm := &CallMe{DynamoDBRegion: "us-east-1", ddb: ddb}
+
+	_, err := cm.ddb.GetItem(&dynamodb.GetItemInput{})
+	if err != nil {
+		t.Fatal("Expected to fail over, failed with", err)
+	}
+	if cm.ActiveRegion() != "eu-west-1" {
+		t.Error("Expected eu-west-1 to be the active region, got", cm.ActiveRegion())
+	}
+
+	// stick to the active region
+	_, err = cm.ddb.GetItem(&dynamodb.GetItemInput{})
+	if err != nil || primary.calls != 1 || secondary.calls != 1 || tertiary.calls != 2 {
+		t.Error("Expected requests to go to eu-west-1, got", primary.calls, secondary.calls, tertiary.calls, err)
+	}
+
+	// other errors are returned as is
+	tertiary.err = awserr.New(dynamodb.ErrCodeConditionalCheckFailedException, "conditional check failed", nil)
+	_, err = cm.ddb.GetItem(&dynamodb.GetItemInput{})
+	if err != tertiary.err || tertiary.calls != 3 || primary.calls != 1 {
+		t.Error("Expected the request not to be retried elsewhere, got", err)
+	}
+
+	// nowhere to go
+	for _, client := range []*regionClient{primary, secondary, tertiary} {
+		client.err = awserr.New(request.ErrCodeRequestError, "connection refused", nil)
+	}
+	_, err = cm.ddb.GetItem(&dynamodb.GetItemInput{})
+	if err == nil || cm.ActiveRegion() != "eu-west-1" {
+		t.Error("Expected to fail and keep the active region, got", cm.ActiveRegion(), err)
+	}
+}
+
+func Test_isEndpointError(t *testing.T) {
+	if !isEndpointError(awserr.New(request.ErrCodeRequestError, "", nil)) {
+		t.Error("Expected a request error to be an endpoint error")
+	}
+	if isEndpointError(awserr.New(dynamodb.ErrCodeResourceNotFoundException, "", nil)) {
+		t.Error("Expected a missing table not to be an endpoint error")
+	}
+	if isEndpointError(errors.New("boom")) || isEndpointError(nil) {
+		t.Error("Expected only AWS errors to be endpoint errors")
+	}
+}
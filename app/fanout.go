@@ -0,0 +1,62 @@
+package app
+
+import (
+	"errors"
+
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/marcoalmeida/callme/task"
+	"go.uber.org/zap"
+)
+
+// MaxFanOut is the maximum number of tasks CreateTasks writes at once, which DynamoDB does on a single call
+const MaxFanOut = maxBatchWriteItems
+
+// CreateTasks creates up to MaxFanOut tasks at once, e.g., one per recipient of a campaign, with a single write to
+// DynamoDB. All of them are encrypted (if requested), and marshalled, before any is written; DynamoDB may still fail
+// to write some of them, in which case an error is returned, as if none had been created. Unlike CreateTask, existing
+// tasks are always replaced, as there's no way to check their UUID in a batch.
+func (c *CallMe) CreateTasks(tasks []task.Task) error {
+	if len(tasks) == 0 {
+		return nil
+	}
+	if len(tasks) > MaxFanOut {
+		return errors.New("too many tasks to create at once")
+	}
+	c.Logger.Debug("Creating tasks", zap.Int("tasks", len(tasks)))
+
+	requests := make([]*dynamodb.WriteRequest, 0, len(tasks))
+	for i := range tasks {
+		tsk, err := c.prepareForStorage(tasks[i])
+		if err != nil {
+			return err
+		}
+		tasks[i] = tsk
+
+		item, err := dynamodbattribute.MarshalMap(tsk)
+		if err != nil {
+			c.Logger.Error("Failed to marshal task", zap.Error(err), zap.String("task", tsk.String()))
+			return errors.New("invalid JSON")
+		}
+		requests = append(requests, &dynamodb.WriteRequest{PutRequest: &dynamodb.PutRequest{Item: item}})
+	}
+
+	err := c.batchWrite(c.DynamoDBTable, requests)
+	for _, tsk := range tasks {
+		c.statusCache.invalidate(c.DynamoDBTable, tsk)
+	}
+	if err != nil {
+		c.Logger.Error("Failed to store tasks", zap.Error(err), zap.Int("tasks", len(tasks)))
+		return errors.New("failed to store tasks")
+	}
+
+	c.count("callme.task.created", int64(len(tasks)))
+	for _, tsk := range tasks {
+		c.countScheduled(tsk)
+		c.publishCreated(tsk)
+		c.publishCreation(tsk)
+	}
+	c.Logger.Debug("Successfully created tasks", zap.Int("tasks", len(tasks)))
+
+	return nil
+}
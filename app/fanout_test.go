@@ -0,0 +1,96 @@
+package app
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"github.com/marcoalmeida/callme/task"
+	"go.uber.org/zap"
+)
+
+// DynamoDB client that keeps the items written in batches, processing at most limit of them per call
+type fanOutClient struct {
+	dynamodbiface.DynamoDBAPI
+	limit   int
+	calls   int
+	written []map[string]*dynamodb.AttributeValue
+}
+
+func (d *fanOutClient) BatchWriteItem(input *dynamodb.BatchWriteItemInput) (*dynamodb.BatchWriteItemOutput, error) {
+	d.calls++
+	output := &dynamodb.BatchWriteItemOutput{}
+	for table, requests := range input.RequestItems {
+		for i, request := range requests {
+			if i >= d.limit {
+				output.UnprocessedItems = map[string][]*dynamodb.WriteRequest{table: requests[i:]}
+				break
+			}
+			d.written = append(d.written, request.PutRequest.Item)
+		}
+	}
+
+	return output, nil
+}
+
+func TestCreateTasks(t *testing.T) {
+	ddb := &fanOutClient{limit: 2}
+	c := &CallMe{Logger: zap.NewNop(), ddb: ddb, DynamoDBTable: "tasks", MaxRetries: 3}
+
+	tasks := make([]task.Task, 0)
+	for i := 0; i < 3; i++ {
+		tasks = append(tasks, task.Task{
+			Name:             "campaign-" + strconv.Itoa(i),
+			TriggerAt:        "1800000000",
+			CallbackEndpoint: "http://example.com",
+			Payload:          "user=" + strconv.Itoa(i),
+		})
+	}
+	err := c.CreateTasks(tasks)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// some items were left unprocessed the first time around
+	if ddb.calls != 2 || len(ddb.written) != 3 {
+		t.Fatalf("Expected 3 tasks written in 2 calls, got %d in %d", len(ddb.written), ddb.calls)
+	}
+	for i, item := range ddb.written {
+		if aws.StringValue(item["payload"].S) != "user="+strconv.Itoa(i) {
+			t.Error("Expected each task to keep its own payload, got", item["payload"])
+		}
+		if aws.StringValue(item["task_state"].S) != task.Pending || aws.StringValue(item["created_by"].S) != "api" {
+			t.Error("Expected defaults to be set, got", item)
+		}
+	}
+}
+
+func TestCreateTasks_payloadRef(t *testing.T) {
+	ddb := &fanOutClient{limit: MaxFanOut}
+	c := &CallMe{Logger: zap.NewNop(), ddb: ddb}
+
+	// same as CreateTask, the payload is fetched right before calling back
+	err := c.CreateTasks([]task.Task{{
+		Name:             "t0",
+		TriggerAt:        "1800000000",
+		CallbackEndpoint: "http://example.com",
+		Payload:          "user=0",
+		PayloadRef:       "s3://bucket/key",
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ddb.written) != 1 || ddb.written[0]["payload"] != nil {
+		t.Error("Expected a single task without its payload, got", ddb.written)
+	}
+}
+
+func TestCreateTasks_tooMany(t *testing.T) {
+	c := &CallMe{Logger: zap.NewNop(), ddb: &fanOutClient{limit: MaxFanOut}}
+	tasks := make([]task.Task, MaxFanOut+1)
+	if c.CreateTasks(tasks) == nil {
+		t.Error("Expected to fail with more than", MaxFanOut, "tasks")
+	}
+}
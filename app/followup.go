@@ -0,0 +1,73 @@
+package app
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/marcoalmeida/callme/task"
+	"go.uber.org/zap"
+)
+
+// create the follow-up of a task that succeeded; with DedupFollowUps, the UUID of the follow-up is first recorded on
+// the parent task, and it is only created if it wasn't there yet, i.e., even if the parent is executed more than
+// once (e.g., by two instances) a single chain is started
+func (c *CallMe) createFollowUp(parent task.Task, next task.Task) error {
+	c.configMutex.RLock()
+	dedup := c.DedupFollowUps
+	c.configMutex.RUnlock()
+	if !dedup {
+		return c.CreateTask(next)
+	}
+
+	_, err := c.ddb.UpdateItem(&dynamodb.UpdateItemInput{
+		TableName: aws.String(c.DynamoDBTable),
+		Key: map[string]*dynamodb.AttributeValue{
+			"trigger_at": {S: aws.String(parent.TriggerAt)},
+			"task_name":  {S: aws.String(parent.Name)},
+		},
+		UpdateExpression:    aws.String("SET follow_up_uuid = :uuid"),
+		ConditionExpression: aws.String("attribute_exists(trigger_at) AND attribute_not_exists(follow_up_uuid)"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":uuid": {S: aws.String(next.UUID)},
+		},
+	})
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+		// either it was already created, or the parent task has since been deleted
+		c.Logger.Info(
+			"Not creating follow-up task, already created",
+			zap.String("task", parent.String()),
+			zap.String("uuid", next.UUID),
+		)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	err = c.CreateTask(next)
+	if err != nil {
+		// let the next execution of the parent, if any, try again
+		c.forgetFollowUp(parent, next)
+	}
+
+	return err
+}
+
+// clear the UUID of the follow-up recorded on a task, if it's still the given one
+func (c *CallMe) forgetFollowUp(parent task.Task, next task.Task) {
+	_, err := c.ddb.UpdateItem(&dynamodb.UpdateItemInput{
+		TableName: aws.String(c.DynamoDBTable),
+		Key: map[string]*dynamodb.AttributeValue{
+			"trigger_at": {S: aws.String(parent.TriggerAt)},
+			"task_name":  {S: aws.String(parent.Name)},
+		},
+		UpdateExpression:    aws.String("REMOVE follow_up_uuid"),
+		ConditionExpression: aws.String("follow_up_uuid = :uuid"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":uuid": {S: aws.String(next.UUID)},
+		},
+	})
+	if err != nil {
+		c.Logger.Error("Failed to clear the follow-up of a task", zap.Error(err), zap.String("task", parent.String()))
+	}
+}
@@ -0,0 +1,84 @@
+package app
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"github.com/marcoalmeida/callme/task"
+	"github.com/marcoalmeida/callme/util"
+	"go.uber.org/zap"
+)
+
+// DynamoDB client that keeps the follow-up recorded on each task, and counts the tasks created with each name
+type followUpClient struct {
+	dynamodbiface.DynamoDBAPI
+	mutex     sync.Mutex
+	followUps map[string]string
+	created   map[string]int
+}
+
+func (d *followUpClient) PutItem(input *dynamodb.PutItemInput) (*dynamodb.PutItemOutput, error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	d.created[aws.StringValue(input.Item["task_name"].S)]++
+
+	return &dynamodb.PutItemOutput{}, nil
+}
+
+func (d *followUpClient) UpdateItem(input *dynamodb.UpdateItemInput) (*dynamodb.UpdateItemOutput, error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	key := aws.StringValue(input.Key["task_name"].S) + "@" + aws.StringValue(input.Key["trigger_at"].S)
+	if strings.Contains(aws.StringValue(input.UpdateExpression), "follow_up_uuid") {
+		if _, ok := d.followUps[key]; ok {
+			return nil, awserr.New(dynamodb.ErrCodeConditionalCheckFailedException, "", nil)
+		}
+		d.followUps[key] = aws.StringValue(input.ExpressionAttributeValues[":uuid"].S)
+	}
+
+	return &dynamodb.UpdateItemOutput{}, nil
+}
+
+func TestCallback_followUpOnce(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	ddb := &followUpClient{followUps: make(map[string]string), created: make(map[string]int)}
+	c := &CallMe{Logger: zap.NewNop(), ddb: ddb, httpClient: http.DefaultClient, DedupFollowUps: true}
+
+	parent := task.Task{
+		Name:             "t0",
+		TriggerAt:        strconv.FormatInt(util.GetUnixMinute(), 10),
+		CallbackEndpoint: server.URL,
+		TaskState:        task.Pending,
+		OnSuccess:        &task.Task{Name: "t1", TriggerAt: "+1m", CallbackEndpoint: server.URL},
+	}
+	parent.SetDefaults("", 0)
+
+	// e.g., by two instances
+	c.callback(parent)
+	c.callback(parent)
+
+	if ddb.created["t1"] != 1 {
+		t.Error("Expected exactly one follow-up task, got", ddb.created["t1"])
+	}
+	if ddb.followUps["t0@"+parent.TriggerAt] != parent.FollowUpUUID() {
+		t.Error("Expected the follow-up to be recorded on the parent, got", ddb.followUps)
+	}
+
+	// without de-duplication, every execution creates one
+	c.DedupFollowUps = false
+	c.callback(parent)
+	if ddb.created["t1"] != 2 {
+		t.Error("Expected another follow-up task, got", ddb.created["t1"])
+	}
+}
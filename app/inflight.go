@@ -0,0 +1,134 @@
+package app
+
+import (
+	"context"
+	"errors"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/marcoalmeida/callme/task"
+	"go.uber.org/zap"
+)
+
+// tasks are uniquely identified by name and trigger time
+func inFlightKey(tsk task.Task) string {
+	return tsk.Name + "@" + tsk.TriggerAt
+}
+
+// register a callback in progress; the context is canceled by cancelCallback, and done must be called once the
+// callback returns; once shutting down, the context is canceled right away
+func (c *CallMe) trackCallback(tsk task.Task) (context.Context, func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	key := inFlightKey(tsk)
+
+	c.inFlightMutex.Lock()
+	if c.stopping {
+		c.inFlightMutex.Unlock()
+		cancel()
+		return ctx, func() {}
+	}
+	if c.inFlight == nil {
+		c.inFlight = make(map[string]context.CancelFunc)
+	}
+	c.inFlight[key] = cancel
+	c.inFlightMutex.Unlock()
+
+	return ctx, func() {
+		c.inFlightMutex.Lock()
+		delete(c.inFlight, key)
+		c.inFlightMutex.Unlock()
+		cancel()
+	}
+}
+
+// register a task waiting for its turn to be executed, e.g., for a free callback slot, so that it's not dispatched
+// again in the meantime; the function returned must be called once it's done
+func (c *CallMe) queue(tsk task.Task) func() {
+	key := inFlightKey(tsk)
+
+	c.inFlightMutex.Lock()
+	if c.queued == nil {
+		c.queued = make(map[string]bool)
+	}
+	c.queued[key] = true
+	c.inFlightMutex.Unlock()
+
+	return func() {
+		c.inFlightMutex.Lock()
+		delete(c.queued, key)
+		c.inFlightMutex.Unlock()
+	}
+}
+
+// whether a task is being executed by this instance, or waiting to be
+func (c *CallMe) isInFlight(tsk task.Task) bool {
+	c.inFlightMutex.Lock()
+	defer c.inFlightMutex.Unlock()
+
+	key := inFlightKey(tsk)
+	_, ok := c.inFlight[key]
+	return ok || c.queued[key]
+}
+
+// number of callbacks in progress
+func (c *CallMe) inFlightCount() int {
+	c.inFlightMutex.Lock()
+	defer c.inFlightMutex.Unlock()
+
+	return len(c.inFlight)
+}
+
+// cancel all callbacks in progress
+func (c *CallMe) cancelAllCallbacks() {
+	c.inFlightMutex.Lock()
+	defer c.inFlightMutex.Unlock()
+
+	for _, cancel := range c.inFlight {
+		cancel()
+	}
+}
+
+// cancel the callback in progress for a task, if there is one
+func (c *CallMe) cancelCallback(tsk task.Task) bool {
+	c.inFlightMutex.Lock()
+	cancel, ok := c.inFlight[inFlightKey(tsk)]
+	c.inFlightMutex.Unlock()
+
+	if ok {
+		c.Logger.Debug("Canceling callback in progress", zap.String("task", tsk.String()))
+		cancel()
+	}
+
+	return ok
+}
+
+// DeleteTask removes a task, identified by name and trigger_at. If it's being executed, the callback is canceled and
+// its outcome discarded. It fails with ErrTaskNotFound if there is no such task.
+func (c *CallMe) DeleteTask(tsk task.Task) error {
+	// before deleting, otherwise the callback could store its outcome after the task is gone
+	c.cancelCallback(tsk)
+	defer c.statusCache.invalidate(c.DynamoDBTable, tsk)
+
+	output, err := c.ddb.DeleteItem(&dynamodb.DeleteItemInput{
+		TableName: aws.String(c.DynamoDBTable),
+		Key: map[string]*dynamodb.AttributeValue{
+			"trigger_at": {S: aws.String(tsk.TriggerAt)},
+			"task_name":  {S: aws.String(tsk.Name)},
+		},
+		ReturnValues: aws.String(dynamodb.ReturnValueAllOld),
+	})
+	if err != nil {
+		c.Logger.Error("Failed to delete task", zap.Error(err), zap.String("task", tsk.String()))
+		return errors.New("failed to delete task")
+	}
+	if len(output.Attributes) == 0 {
+		return ErrTaskNotFound
+	}
+
+	deleted, ok := c.unmarshalTask(output.Attributes)
+	if ok && (deleted.TaskState == task.Pending || deleted.TaskState == task.Running) {
+		c.countCompleted(deleted)
+	}
+
+	return nil
+}
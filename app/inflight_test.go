@@ -0,0 +1,131 @@
+package app
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/marcoalmeida/callme/task"
+	"github.com/marcoalmeida/callme/util"
+	"go.uber.org/zap"
+)
+
+func TestUpdateTaskState(t *testing.T) {
+	ddb := newMemoryClient()
+	c := &CallMe{Logger: zap.NewNop(), ddb: ddb}
+	tsk := task.Task{Name: "t0", TriggerAt: "600", TaskState: task.Pending, Payload: "secret"}
+
+	// not part of the task definition, e.g., added by some other tool
+	item, err := dynamodbattribute.MarshalMap(tsk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	item["owner"] = &dynamodb.AttributeValue{S: aws.String("billing")}
+	_, err = ddb.PutItem(&dynamodb.PutItemInput{Item: item})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tsk.TaskState = task.Running
+	tsk.Payload = ""
+	err = c.updateTaskState(tsk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	item = ddb.item("t0", "600")
+	if stringAttribute(item, "task_state") != task.Running {
+		t.Error("Expected the task to be running, got", item["task_state"])
+	}
+	if stringAttribute(item, "owner") != "billing" || stringAttribute(item, "payload") != "secret" {
+		t.Error("Expected all other attributes to be left alone, got", item)
+	}
+	// not executed yet, which archival relies on
+	if _, ok := item["executed_at"]; ok {
+		t.Error("Expected no executed_at, got", item["executed_at"])
+	}
+
+	tsk.TaskState = task.Successful
+	tsk.ExecutedAt = "660"
+	err = c.updateTaskState(tsk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if executedAt := stringAttribute(ddb.item("t0", "600"), "executed_at"); executedAt != "660" {
+		t.Error("Expected the task to be executed at 660, got", executedAt)
+	}
+
+	// the task is not brought back once deleted
+	err = c.DeleteTask(tsk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = c.updateTaskState(tsk)
+	if err != ErrTaskNotFound || ddb.len() != 0 {
+		t.Error("Expected", ErrTaskNotFound, "got", err, ddb.len())
+	}
+}
+
+func TestDeleteTask_inFlight(t *testing.T) {
+	started := make(chan bool)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started <- true
+		// a long callback, only interrupted by the client going away
+		select {
+		case <-r.Context().Done():
+		case <-time.After(5 * time.Second):
+		}
+	}))
+	defer ts.Close()
+
+	ddb := newMemoryClient()
+	c := &CallMe{Logger: zap.NewNop(), ddb: ddb, httpClient: ts.Client()}
+
+	tsk := task.Task{
+		Name:             "t0",
+		TriggerAt:        strconv.FormatInt(util.GetUnixMinute(), 10),
+		CallbackEndpoint: ts.URL,
+		TaskState:        task.Pending,
+	}
+	tsk.SetDefaults("", 0)
+	err := c.UpsertTask(tsk)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan bool)
+	go func() {
+		c.callback(tsk)
+		done <- true
+	}()
+
+	<-started
+	puts := ddb.puts
+	err = c.DeleteTask(tsk)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-done:
+	case <-time.After(3 * time.Second):
+		t.Fatal("Expected the callback to be canceled")
+	}
+
+	if ddb.puts != puts {
+		t.Error("Expected no writes after deleting the task, got", ddb.puts-puts)
+	}
+	if item := ddb.item("t0", tsk.TriggerAt); item != nil {
+		t.Error("Expected the task to remain deleted, got", item)
+	}
+
+	// nothing left to delete
+	err = c.DeleteTask(tsk)
+	if err != ErrTaskNotFound {
+		t.Error("Expected", ErrTaskNotFound, "got", err)
+	}
+}
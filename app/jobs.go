@@ -0,0 +1,132 @@
+package app
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/marcoalmeida/callme/task"
+	"go.uber.org/zap"
+)
+
+const (
+	// background jobs running at the same time; more are rejected rather than queued
+	maxRunningJobs = 4
+	// how long a job can still be polled after it finished
+	jobRetention = time.Hour
+)
+
+// states of a job
+const (
+	JobRunning = "running"
+	JobDone    = "done"
+	JobFailed  = "failed"
+)
+
+var (
+	ErrTooManyJobs = errors.New("too many jobs running, try again later")
+	ErrJobNotFound = errors.New("job not found")
+)
+
+// Job is some work, e.g., rescheduling tasks, carried out in the background rather than while the client waits
+type Job struct {
+	ID         string      `json:"job_id"`
+	State      string      `json:"state"`
+	StartedAt  int64       `json:"started_at"`
+	FinishedAt int64       `json:"finished_at,omitempty"`
+	Tasks      []task.Task `json:"tasks,omitempty"`
+	Error      string      `json:"error,omitempty"`
+}
+
+// jobs running, and those that finished within jobRetention, by ID
+type jobRegistry struct {
+	jobs    map[string]*Job
+	running int
+	mutex   sync.Mutex
+}
+
+// register a new job, unless too many are already running
+func (r *jobRegistry) start(now time.Time) (Job, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.running >= maxRunningJobs {
+		return Job{}, ErrTooManyJobs
+	}
+	if r.jobs == nil {
+		r.jobs = make(map[string]*Job)
+	}
+	// finished jobs are dropped as new ones start
+	for id, job := range r.jobs {
+		if job.State != JobRunning && now.Unix()-job.FinishedAt > int64(jobRetention/time.Second) {
+			delete(r.jobs, id)
+		}
+	}
+
+	job := &Job{ID: task.NewUUID(), State: JobRunning, StartedAt: now.Unix()}
+	r.jobs[job.ID] = job
+	r.running++
+
+	return *job, nil
+}
+
+// record the outcome of a job
+func (r *jobRegistry) finish(id string, tasks []task.Task, err error, now time.Time) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	job := r.jobs[id]
+	job.State = JobDone
+	job.Tasks = tasks
+	if err != nil {
+		job.State = JobFailed
+		job.Error = err.Error()
+	}
+	job.FinishedAt = now.Unix()
+	r.running--
+}
+
+func (r *jobRegistry) get(id string) (Job, bool) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	job, ok := r.jobs[id]
+	if !ok {
+		return Job{}, false
+	}
+	// callers are free to modify the tasks they get
+	copied := *job
+	copied.Tasks = append([]task.Task(nil), job.Tasks...)
+
+	return copied, true
+}
+
+// RescheduleAsync is the same as Reschedule, but returns right away with a job, see Job, that can be polled until the
+// tasks are rescheduled. Only a few jobs run at the same time, ErrTooManyJobs is returned if there is no room for
+// another one.
+func (c *CallMe) RescheduleAsync(tsk task.Task, triggerAt string, all bool, newUUID bool) (Job, error) {
+	job, err := c.jobs.start(time.Now())
+	if err != nil {
+		return Job{}, err
+	}
+
+	go func() {
+		tasks, err := c.Reschedule(tsk, triggerAt, all, newUUID)
+		if err != nil {
+			c.Logger.Error("Failed to reschedule tasks", zap.Error(err), zap.String("job_id", job.ID))
+		}
+		c.jobs.finish(job.ID, tasks, err, time.Now())
+	}()
+
+	return job, nil
+}
+
+// Job returns a job started in the background, as long as it's still running or finished within the last hour
+func (c *CallMe) Job(id string) (Job, error) {
+	job, ok := c.jobs.get(id)
+	if !ok {
+		return Job{}, ErrJobNotFound
+	}
+
+	return job, nil
+}
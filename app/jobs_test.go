@@ -0,0 +1,89 @@
+package app
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/marcoalmeida/callme/task"
+	"go.uber.org/zap"
+)
+
+// same as rescheduleClient, but storing tasks waits to be released
+type slowRescheduleClient struct {
+	rescheduleClient
+	release chan bool
+}
+
+func (d *slowRescheduleClient) PutItem(input *dynamodb.PutItemInput) (*dynamodb.PutItemOutput, error) {
+	<-d.release
+	return d.rescheduleClient.PutItem(input)
+}
+
+func TestRescheduleAsync(t *testing.T) {
+	ddb := &slowRescheduleClient{release: make(chan bool)}
+	cm := &CallMe{Logger: zap.NewNop(), ddb: ddb}
+
+	job, err := cm.RescheduleAsync(task.Task{Name: "t0", TriggerAt: "600"}, "660", false, false)
+	if err != nil {
+		t.Fatal("Expected to start a job, failed with", err)
+	}
+	// returns before any task is rescheduled
+	if job.State != JobRunning || ddb.put != nil {
+		t.Error("Expected a running job, got", job)
+	}
+	polled, err := cm.Job(job.ID)
+	if err != nil || polled.State != JobRunning {
+		t.Error("Expected to poll the running job, got", polled, err)
+	}
+
+	ddb.release <- true
+	deadline := time.Now().Add(time.Second)
+	for polled.State == JobRunning {
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the job to finish")
+		}
+		time.Sleep(10 * time.Millisecond)
+		polled, _ = cm.Job(job.ID)
+	}
+	if polled.State != JobDone || len(polled.Tasks) != 1 || polled.Tasks[0].TriggerAt != "660" || polled.FinishedAt == 0 {
+		t.Error("Expected t0 to be rescheduled to 660, got", polled)
+	}
+
+	if _, err := cm.Job("unknown"); err != ErrJobNotFound {
+		t.Error("Expected", ErrJobNotFound, "got", err)
+	}
+}
+
+func Test_jobRegistry(t *testing.T) {
+	r := jobRegistry{}
+	now := time.Now()
+
+	ids := make([]string, 0)
+	for i := 0; i < maxRunningJobs; i++ {
+		job, err := r.start(now)
+		if err != nil {
+			t.Fatal("Expected to start job", i, "failed with", err)
+		}
+		ids = append(ids, job.ID)
+	}
+	if _, err := r.start(now); err != ErrTooManyJobs {
+		t.Error("Expected", ErrTooManyJobs, "got", err)
+	}
+
+	// room for another one once a job finishes, which is kept for a while
+	r.finish(ids[0], nil, nil, now)
+	if _, err := r.start(now.Add(jobRetention)); err != nil {
+		t.Error("Expected to start another job, failed with", err)
+	}
+	if _, ok := r.get(ids[0]); !ok {
+		t.Error("Expected the finished job to be kept")
+	}
+	r.finish(ids[1], nil, nil, now)
+	if _, err := r.start(now.Add(jobRetention + time.Second)); err != nil {
+		t.Error("Expected to start another job, failed with", err)
+	}
+	if _, ok := r.get(ids[0]); ok {
+		t.Error("Expected the finished job to be dropped after", jobRetention)
+	}
+}
@@ -0,0 +1,102 @@
+package app
+
+import (
+	"sync"
+	"time"
+)
+
+// callbackLimiter bounds the number of callbacks running at the same time, each one taking as many slots as the weight
+// of its task. The bound starts at 1 and grows linearly up to max over the ramp period so that, e.g., catching up after
+// an outage does not overwhelm the callback endpoints.
+type callbackLimiter struct {
+	max     int
+	ramp    time.Duration
+	start   time.Time
+	now     func() time.Time
+	running int
+	cond    *sync.Cond
+}
+
+func newCallbackLimiter(max int, ramp time.Duration) *callbackLimiter {
+	return &callbackLimiter{
+		max:   max,
+		ramp:  ramp,
+		start: time.Now(),
+		now:   time.Now,
+		cond:  sync.NewCond(&sync.Mutex{}),
+	}
+}
+
+// maximum number of callbacks allowed to run at this point in time
+func (l *callbackLimiter) limit() int {
+	elapsed := l.now().Sub(l.start)
+	if l.ramp <= 0 || elapsed >= l.ramp {
+		return l.max
+	}
+
+	limit := int(int64(l.max) * int64(elapsed) / int64(l.ramp))
+	if limit < 1 {
+		return 1
+	}
+	return limit
+}
+
+// number of slots taken by a callback with the given weight: at least 1, and never more than max
+func (l *callbackLimiter) slots(weight int) int {
+	if weight < 1 {
+		return 1
+	}
+	if weight > l.max {
+		return l.max
+	}
+	return weight
+}
+
+// whether a callback taking this many slots can start now; one that takes more than the current limit (e.g., while
+// ramping up) still runs once no other callbacks are
+func (l *callbackLimiter) fits(slots int) bool {
+	return l.running == 0 || l.running+slots <= l.limit()
+}
+
+// wait for a callback with the given weight to be allowed to run
+func (l *callbackLimiter) acquire(weight int) {
+	l.cond.L.Lock()
+	defer l.cond.L.Unlock()
+
+	// the limit only grows while ramping up, callbacks finishing are enough to have it checked again
+	slots := l.slots(weight)
+	for !l.fits(slots) {
+		l.cond.Wait()
+	}
+	l.running += slots
+}
+
+// same as acquire, but without waiting: it returns false if the callback is not allowed to run right now
+func (l *callbackLimiter) tryAcquire(weight int) bool {
+	l.cond.L.Lock()
+	defer l.cond.L.Unlock()
+
+	slots := l.slots(weight)
+	if !l.fits(slots) {
+		return false
+	}
+	l.running += slots
+	return true
+}
+
+// let the next callbacks run, after one with the given weight finished
+func (l *callbackLimiter) release(weight int) {
+	l.cond.L.Lock()
+	l.running -= l.slots(weight)
+	l.cond.L.Unlock()
+
+	l.cond.Broadcast()
+}
+
+// number of slots taken by the callbacks running
+func (l *callbackLimiter) used() int {
+	l.cond.L.Lock()
+	defer l.cond.L.Unlock()
+
+	return l.running
+}
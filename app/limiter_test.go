@@ -0,0 +1,97 @@
+package app
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_callbackLimiter_ramp(t *testing.T) {
+	l := newCallbackLimiter(100, 10*time.Minute)
+	now := l.start
+	l.now = func() time.Time { return now }
+
+	previous := 0
+	for _, elapsed := range []time.Duration{0, time.Minute, 5 * time.Minute, 9 * time.Minute, 10 * time.Minute} {
+		now = l.start.Add(elapsed)
+		limit := l.limit()
+		if limit < previous || limit < 1 || limit > 100 {
+			t.Error("Expected the limit to increase from 1 towards 100, got", limit, "after", elapsed)
+		}
+		previous = limit
+	}
+	if previous != 100 {
+		t.Error("Expected the limit to reach 100 at the end of the ramp, got", previous)
+	}
+
+	now = l.start.Add(5 * time.Minute)
+	if l.limit() != 50 {
+		t.Error("Expected a limit of 50 half way through the ramp, got", l.limit())
+	}
+}
+
+func Test_callbackLimiter_acquire(t *testing.T) {
+	l := newCallbackLimiter(1, 0)
+	l.acquire(1)
+
+	acquired := make(chan bool)
+	go func() {
+		l.acquire(1)
+		acquired <- true
+	}()
+
+	select {
+	case <-acquired:
+		t.Fatal("Expected to wait for a callback to finish")
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	l.release(1)
+	select {
+	case <-acquired:
+	case <-time.After(time.Second):
+		t.Error("Expected to run once the previous callback finished")
+	}
+}
+
+func Test_callbackLimiter_weight(t *testing.T) {
+	l := newCallbackLimiter(10, 0)
+
+	// a pool of 10 fits at most 2 callbacks with weight 5
+	if !l.tryAcquire(5) || !l.tryAcquire(5) {
+		t.Fatal("Expected 2 callbacks with weight 5 to run")
+	}
+	if l.used() != 10 {
+		t.Error("Expected 10 slots to be used, got", l.used())
+	}
+	if l.tryAcquire(5) || l.tryAcquire(1) {
+		t.Error("Expected no more callbacks to run")
+	}
+
+	acquired := make(chan bool)
+	go func() {
+		l.acquire(5)
+		acquired <- true
+	}()
+	select {
+	case <-acquired:
+		t.Fatal("Expected to wait for 5 slots")
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	l.release(5)
+	select {
+	case <-acquired:
+	case <-time.After(time.Second):
+		t.Fatal("Expected to run once 5 slots were released")
+	}
+	if l.used() != 10 {
+		t.Error("Expected 10 slots to be used, got", l.used())
+	}
+
+	// weights are at least 1 and at most the size of the pool
+	l.release(5)
+	l.release(5)
+	if l.slots(0) != 1 || l.slots(20) != 10 || !l.tryAcquire(20) || l.used() != 10 {
+		t.Error("Expected weights to be capped, got", l.slots(0), l.slots(20), l.used())
+	}
+}
@@ -0,0 +1,164 @@
+package app
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/url"
+	"os"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"go.uber.org/zap"
+)
+
+// special value of DYNAMODB_ENDPOINT for DynamoDB Local listening on the default port
+const (
+	localEndpointAlias = "local"
+	localEndpoint      = "http://localhost:8000"
+)
+
+// local development mode: DynamoDB Local is running on this host
+func isLocalEndpoint(endpoint string) bool {
+	if endpoint == localEndpointAlias {
+		return true
+	}
+
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return false
+	}
+
+	return u.Hostname() == "localhost" || u.Hostname() == "127.0.0.1"
+}
+
+// set everything up for local development: debug logs and tables created on startup, unless explicitly disabled
+func (c *CallMe) enterLocalMode() {
+	c.local = true
+	if c.DynamoDBEndpoint == localEndpointAlias {
+		c.DynamoDBEndpoint = localEndpoint
+	}
+	if os.Getenv("DEBUG") == "" {
+		c.Debug = true
+	}
+	if os.Getenv("AUTO_CREATE_TABLE") == "" {
+		c.AutoCreateTable = true
+	}
+
+	c.Logger.Warn(
+		"*** LOCAL DEVELOPMENT MODE *** using DynamoDB Local with dummy credentials and no TLS verification, "+
+			"do not use in production",
+		zap.String("dynamodb_endpoint", c.DynamoDBEndpoint),
+	)
+}
+
+// AWS configuration for DynamoDB Local: it accepts any credentials and may use a self-signed certificate
+func localConfig(config *aws.Config) *aws.Config {
+	return config.
+		WithCredentials(credentials.NewStaticCredentials("local", "local", "")).
+		WithHTTPClient(&http.Client{
+			Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}},
+		})
+}
+
+// create the tables that do not exist yet
+func (c *CallMe) createTables() {
+	tables := []string{c.DynamoDBTable}
+	if c.ArchiveAfterDays > 0 {
+		tables = append(tables, c.DynamoDBArchiveTable)
+	}
+	for _, table := range tables {
+		input := &dynamodb.CreateTableInput{
+			TableName: aws.String(table),
+			AttributeDefinitions: []*dynamodb.AttributeDefinition{
+				{AttributeName: aws.String("trigger_at"), AttributeType: aws.String(dynamodb.ScalarAttributeTypeS)},
+				{AttributeName: aws.String("task_name"), AttributeType: aws.String(dynamodb.ScalarAttributeTypeS)},
+			},
+			KeySchema: []*dynamodb.KeySchemaElement{
+				{AttributeName: aws.String("trigger_at"), KeyType: aws.String(dynamodb.KeyTypeHash)},
+				{AttributeName: aws.String("task_name"), KeyType: aws.String(dynamodb.KeyTypeRange)},
+			},
+			GlobalSecondaryIndexes: []*dynamodb.GlobalSecondaryIndex{
+				{
+					IndexName: aws.String(c.DynamoDBIndex),
+					KeySchema: []*dynamodb.KeySchemaElement{
+						{AttributeName: aws.String("task_name"), KeyType: aws.String(dynamodb.KeyTypeHash)},
+						{AttributeName: aws.String("trigger_at"), KeyType: aws.String(dynamodb.KeyTypeRange)},
+					},
+					Projection: &dynamodb.Projection{ProjectionType: aws.String(dynamodb.ProjectionTypeAll)},
+				},
+			},
+			BillingMode: aws.String(dynamodb.BillingModePayPerRequest),
+		}
+		// the optional index on task_state is only used on the tasks table
+		if c.DynamoDBStateIndex != "" && table == c.DynamoDBTable {
+			input.AttributeDefinitions = append(input.AttributeDefinitions, &dynamodb.AttributeDefinition{
+				AttributeName: aws.String("task_state"),
+				AttributeType: aws.String(dynamodb.ScalarAttributeTypeS),
+			})
+			input.GlobalSecondaryIndexes = append(input.GlobalSecondaryIndexes, &dynamodb.GlobalSecondaryIndex{
+				IndexName:  aws.String(c.DynamoDBStateIndex),
+				KeySchema:  stateIndexKeySchema,
+				Projection: &dynamodb.Projection{ProjectionType: aws.String(dynamodb.ProjectionTypeAll)},
+			})
+		}
+		// so is auto-scaling, the archive is rarely read or written to
+		autoScaling := table == c.DynamoDBTable && c.autoScaling()
+		if autoScaling {
+			c.provisionCapacity(input)
+		}
+		if c.createTable(input) && autoScaling {
+			indexes := make([]string, 0, len(input.GlobalSecondaryIndexes))
+			for _, index := range input.GlobalSecondaryIndexes {
+				indexes = append(indexes, aws.StringValue(index.IndexName))
+			}
+			err := c.enableAutoScaling(table, indexes)
+			if err != nil {
+				c.Logger.Error("Failed to enable auto-scaling", zap.Error(err), zap.String("table", table))
+			}
+		}
+	}
+
+	if c.CountTasks {
+		c.createTable(&dynamodb.CreateTableInput{
+			TableName: aws.String(c.DynamoDBCountersTable),
+			AttributeDefinitions: []*dynamodb.AttributeDefinition{
+				{AttributeName: aws.String("trigger_at"), AttributeType: aws.String(dynamodb.ScalarAttributeTypeS)},
+			},
+			KeySchema: []*dynamodb.KeySchemaElement{
+				{AttributeName: aws.String("trigger_at"), KeyType: aws.String(dynamodb.KeyTypeHash)},
+			},
+			BillingMode: aws.String(dynamodb.BillingModePayPerRequest),
+		})
+	}
+
+	c.createTable(&dynamodb.CreateTableInput{
+		TableName: aws.String(c.DynamoDBConfigTable),
+		AttributeDefinitions: []*dynamodb.AttributeDefinition{
+			{AttributeName: aws.String("config_key"), AttributeType: aws.String(dynamodb.ScalarAttributeTypeS)},
+		},
+		KeySchema: []*dynamodb.KeySchemaElement{
+			{AttributeName: aws.String("config_key"), KeyType: aws.String(dynamodb.KeyTypeHash)},
+		},
+		BillingMode: aws.String(dynamodb.BillingModePayPerRequest),
+	})
+}
+
+// create a table, unless it already exists; returns true iff it was created
+func (c *CallMe) createTable(input *dynamodb.CreateTableInput) bool {
+	table := aws.StringValue(input.TableName)
+
+	_, err := c.ddb.CreateTable(input)
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == dynamodb.ErrCodeResourceInUseException {
+		c.Logger.Debug("Table already exists", zap.String("table", table))
+		return false
+	}
+	if err != nil {
+		c.Logger.Error("Failed to create table", zap.Error(err), zap.String("table", table))
+		return false
+	}
+
+	c.Logger.Info("Created table", zap.String("table", table))
+	return true
+}
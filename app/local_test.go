@@ -0,0 +1,72 @@
+package app
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"go.uber.org/zap"
+)
+
+func Test_isLocalEndpoint(t *testing.T) {
+	for endpoint, expected := range map[string]bool{
+		"local":                    true,
+		"http://localhost:8000":    true,
+		"https://127.0.0.1:8443":   true,
+		"":                         false,
+		"https://dynamodb.aws.com": false,
+	} {
+		if isLocalEndpoint(endpoint) != expected {
+			t.Error("Expected", endpoint, "to be local:", expected)
+		}
+	}
+}
+
+func Test_enterLocalMode(t *testing.T) {
+	t.Setenv("DEBUG", "")
+	t.Setenv("AUTO_CREATE_TABLE", "false")
+
+	cm := &CallMe{DynamoDBEndpoint: "local", Logger: zap.NewNop()}
+	cm.enterLocalMode()
+	if !cm.local || cm.DynamoDBEndpoint != localEndpoint || !cm.Debug {
+		t.Error("Expected local mode with debug enabled, got", cm.local, cm.DynamoDBEndpoint, cm.Debug)
+	}
+	// explicitly disabled
+	if cm.AutoCreateTable {
+		t.Error("Expected AUTO_CREATE_TABLE to be respected")
+	}
+}
+
+// DynamoDB client that keeps track of the tables created, some of which already exist
+type createTableClient struct {
+	dynamodbiface.DynamoDBAPI
+	existing map[string]bool
+	created  []string
+}
+
+func (d *createTableClient) CreateTable(input *dynamodb.CreateTableInput) (*dynamodb.CreateTableOutput, error) {
+	if d.existing[aws.StringValue(input.TableName)] {
+		return nil, awserr.New(dynamodb.ErrCodeResourceInUseException, "table already exists", nil)
+	}
+	d.created = append(d.created, aws.StringValue(input.TableName))
+
+	return &dynamodb.CreateTableOutput{}, nil
+}
+
+func TestCreateTables(t *testing.T) {
+	ddb := &createTableClient{existing: map[string]bool{"callme-config": true}}
+	cm := &CallMe{
+		DynamoDBTable:        "callme-tasks",
+		DynamoDBConfigTable:  "callme-config",
+		DynamoDBArchiveTable: "callme-archive",
+		Logger:               zap.NewNop(),
+		ddb:                  ddb,
+	}
+
+	cm.createTables()
+	if len(ddb.created) != 1 || ddb.created[0] != "callme-tasks" {
+		t.Error("Expected only the tasks table to be created, got", ddb.created)
+	}
+}
@@ -0,0 +1,236 @@
+package app
+
+import (
+	"encoding/json"
+	"errors"
+	"net/url"
+	"regexp"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/marcoalmeida/callme/task"
+	"github.com/marcoalmeida/callme/util"
+	"go.uber.org/zap"
+)
+
+// key of the item, on the configuration table, holding the maintenance windows
+const maintenanceConfigKey = "maintenance_windows"
+
+// how far back to look for the start or end of a maintenance window; a week covers all weekly schedules
+const maintenanceLookback = 7 * 24 * time.Hour
+
+// actions that can be taken on tasks whose callback host is under maintenance
+const (
+	MaintenanceSkip  = "skip"
+	MaintenanceDelay = "delay"
+)
+
+// MaintenanceWindow describes a recurring period of time during which callbacks to some hosts should not be made
+type MaintenanceWindow struct {
+	// regular expression matched against the hostname of the callback endpoint
+	HostPattern string `json:"host_pattern"`
+	// cron expressions (UTC) for when the window opens and closes
+	StartCron string `json:"start_cron"`
+	EndCron   string `json:"end_cron"`
+	// skip or delay
+	Action string `json:"action"`
+}
+
+// maintenance window ready to be checked against tasks
+type maintenanceWindow struct {
+	MaintenanceWindow
+	host   *regexp.Regexp
+	start  util.Cron
+	end    util.Cron
+	active bool
+}
+
+// parse a maintenance window, making sure it is valid
+func compileMaintenanceWindow(w MaintenanceWindow) (maintenanceWindow, error) {
+	var err error
+	compiled := maintenanceWindow{MaintenanceWindow: w}
+
+	if w.Action != MaintenanceSkip && w.Action != MaintenanceDelay {
+		return compiled, errors.New("invalid maintenance action: " + w.Action)
+	}
+	compiled.host, err = regexp.Compile(w.HostPattern)
+	if err != nil {
+		return compiled, errors.New("invalid host pattern: " + w.HostPattern)
+	}
+	compiled.start, err = util.ParseCron(w.StartCron)
+	if err != nil {
+		return compiled, err
+	}
+	compiled.end, err = util.ParseCron(w.EndCron)
+	if err != nil {
+		return compiled, err
+	}
+
+	return compiled, nil
+}
+
+// a window is open if it was more recently opened than closed
+func (w *maintenanceWindow) isActive(at time.Time) bool {
+	start, ok := w.start.Previous(at, maintenanceLookback)
+	if !ok {
+		return false
+	}
+	end, ok := w.end.Previous(at, maintenanceLookback)
+
+	return !ok || start.After(end)
+}
+
+// ValidateMaintenanceWindows returns an error describing the first invalid maintenance window, if any
+func ValidateMaintenanceWindows(windows []MaintenanceWindow) error {
+	for _, w := range windows {
+		_, err := compileMaintenanceWindow(w)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// MaintenanceWindows returns the configured maintenance windows
+func (c *CallMe) MaintenanceWindows() []MaintenanceWindow {
+	c.maintenanceMutex.RLock()
+	defer c.maintenanceMutex.RUnlock()
+
+	windows := make([]MaintenanceWindow, 0, len(c.maintenanceWindows))
+	for _, w := range c.maintenanceWindows {
+		windows = append(windows, w.MaintenanceWindow)
+	}
+
+	return windows
+}
+
+// SetMaintenanceWindows validates and replaces all maintenance windows
+func (c *CallMe) SetMaintenanceWindows(windows []MaintenanceWindow) error {
+	compiled, err := c.compileMaintenanceWindows(windows)
+	if err != nil {
+		return err
+	}
+
+	value, err := json.Marshal(windows)
+	if err != nil {
+		return errors.New("failed to encode maintenance windows")
+	}
+	input := &dynamodb.PutItemInput{
+		TableName: aws.String(c.DynamoDBConfigTable),
+		Item: map[string]*dynamodb.AttributeValue{
+			"config_key":   {S: aws.String(maintenanceConfigKey)},
+			"config_value": {S: aws.String(string(value))},
+		},
+	}
+	_, err = c.ddb.PutItem(input)
+	if err != nil {
+		c.Logger.Error("Failed to store maintenance windows", zap.Error(err))
+		return errors.New("failed to store maintenance windows")
+	}
+
+	c.maintenanceMutex.Lock()
+	c.maintenanceWindows = compiled
+	c.maintenanceMutex.Unlock()
+
+	return nil
+}
+
+// compile all windows and find out which ones are currently open
+func (c *CallMe) compileMaintenanceWindows(windows []MaintenanceWindow) ([]maintenanceWindow, error) {
+	now := time.Unix(util.GetUnixMinute(), 0)
+
+	compiled := make([]maintenanceWindow, 0, len(windows))
+	for _, w := range windows {
+		mw, err := compileMaintenanceWindow(w)
+		if err != nil {
+			return nil, err
+		}
+		mw.active = mw.isActive(now)
+		compiled = append(compiled, mw)
+	}
+
+	return compiled, nil
+}
+
+// refresh the local copy of the maintenance windows, and which ones are open; on failure the previous one is kept
+func (c *CallMe) loadMaintenanceWindows() {
+	input := &dynamodb.GetItemInput{
+		TableName: aws.String(c.DynamoDBConfigTable),
+		Key: map[string]*dynamodb.AttributeValue{
+			"config_key": {S: aws.String(maintenanceConfigKey)},
+		},
+	}
+	result, err := c.ddb.GetItem(input)
+	if err != nil {
+		if !c.missingConfigTable(err) {
+			c.Logger.Error("Failed to load maintenance windows", zap.Error(err))
+		}
+		return
+	}
+
+	windows := make([]MaintenanceWindow, 0)
+	if value, ok := result.Item["config_value"]; ok {
+		err = json.Unmarshal([]byte(aws.StringValue(value.S)), &windows)
+		if err != nil {
+			c.Logger.Error("Failed to decode maintenance windows", zap.Error(err))
+			return
+		}
+	}
+
+	compiled, err := c.compileMaintenanceWindows(windows)
+	if err != nil {
+		c.Logger.Error("Ignoring invalid maintenance windows", zap.Error(err))
+		return
+	}
+
+	c.maintenanceMutex.Lock()
+	c.maintenanceWindows = compiled
+	c.maintenanceMutex.Unlock()
+}
+
+// return the action of the first open maintenance window that covers every host the task may call back, if any
+func (c *CallMe) maintenanceAction(tsk task.Task) string {
+	endpoints := tsk.CallbackPool
+	if len(endpoints) == 0 {
+		endpoints = []string{tsk.CallbackEndpoint}
+	}
+
+	c.maintenanceMutex.RLock()
+	defer c.maintenanceMutex.RUnlock()
+
+	for _, w := range c.maintenanceWindows {
+		if !w.active {
+			continue
+		}
+		covered := true
+		for _, endpoint := range endpoints {
+			u, err := url.Parse(endpoint)
+			if err != nil || !w.host.MatchString(u.Hostname()) {
+				covered = false
+				break
+			}
+		}
+		if covered {
+			return w.Action
+		}
+	}
+
+	return ""
+}
+
+// mark a task as skipped without calling back
+func (c *CallMe) skip(tsk task.Task) {
+	c.Logger.Debug("Skipping task under maintenance", zap.String("task", tsk.String()))
+
+	tsk.TaskState = task.Skipped
+	err := c.updateExecutedTask(tsk)
+	if err == ErrTaskNotFound {
+		c.Logger.Debug("Task deleted before being skipped", zap.String("task", tsk.String()))
+		return
+	}
+	if err != nil {
+		c.Logger.Error("Failed to skip task", zap.Error(err), zap.String("task", tsk.String()))
+	}
+}
@@ -0,0 +1,111 @@
+package app
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/marcoalmeida/callme/task"
+	"github.com/marcoalmeida/callme/util"
+	"go.uber.org/zap"
+)
+
+func TestValidateMaintenanceWindows(t *testing.T) {
+	valid := MaintenanceWindow{HostPattern: `^db\.`, StartCron: "0 22 * * *", EndCron: "0 6 * * *", Action: MaintenanceSkip}
+	if err := ValidateMaintenanceWindows([]MaintenanceWindow{valid}); err != nil {
+		t.Error("Expected window to be valid, got", err)
+	}
+
+	invalid := []MaintenanceWindow{
+		{HostPattern: "(", StartCron: "0 22 * * *", EndCron: "0 6 * * *", Action: MaintenanceSkip},
+		{HostPattern: ".*", StartCron: "0 22 * *", EndCron: "0 6 * * *", Action: MaintenanceSkip},
+		{HostPattern: ".*", StartCron: "0 22 * * *", EndCron: "0 6 * * *", Action: "drop"},
+	}
+	for _, w := range invalid {
+		if err := ValidateMaintenanceWindows([]MaintenanceWindow{w}); err == nil {
+			t.Error("Expected window to be invalid", w)
+		}
+	}
+}
+
+func TestMaintenanceWindow_isActive(t *testing.T) {
+	w, _ := compileMaintenanceWindow(
+		MaintenanceWindow{HostPattern: ".*", StartCron: "0 22 * * *", EndCron: "0 6 * * *", Action: MaintenanceSkip},
+	)
+
+	tests := []struct {
+		at       time.Time
+		expected bool
+	}{
+		{time.Date(2024, 3, 15, 21, 59, 0, 0, time.UTC), false},
+		{time.Date(2024, 3, 15, 22, 0, 0, 0, time.UTC), true},
+		{time.Date(2024, 3, 16, 3, 0, 0, 0, time.UTC), true},
+		{time.Date(2024, 3, 16, 6, 0, 0, 0, time.UTC), false},
+	}
+
+	for _, test := range tests {
+		if w.isActive(test.at) != test.expected {
+			t.Error("Expected window to be active", test.expected, "at", test.at)
+		}
+	}
+}
+
+func TestMaintenance(t *testing.T) {
+	ddb := newMemoryClient()
+	cm := &CallMe{Logger: zap.NewNop(), ddb: ddb}
+
+	// always open
+	err := cm.SetMaintenanceWindows([]MaintenanceWindow{
+		{HostPattern: `^db\.`, StartCron: "* * * * *", EndCron: "0 0 1 1 *", Action: MaintenanceSkip},
+		{HostPattern: `^api\.`, StartCron: "* * * * *", EndCron: "0 0 1 1 *", Action: MaintenanceDelay},
+	})
+	if err != nil {
+		t.Fatal("Expected maintenance windows to be set, got", err)
+	}
+
+	tests := []struct {
+		tsk      task.Task
+		expected string
+	}{
+		{task.Task{CallbackEndpoint: "http://db.example.com/cb"}, MaintenanceSkip},
+		{task.Task{CallbackEndpoint: "http://api.example.com:8080/cb"}, MaintenanceDelay},
+		{task.Task{CallbackEndpoint: "http://web.example.com/cb"}, ""},
+		// only if there is no way around the window
+		{task.Task{CallbackPool: []string{"http://db.example.com/cb", "http://db.example.org/cb"}}, MaintenanceSkip},
+		{task.Task{CallbackPool: []string{"http://db.example.com/cb", "http://web.example.com/cb"}}, ""},
+	}
+	for _, test := range tests {
+		if action := cm.maintenanceAction(test.tsk); action != test.expected {
+			t.Error("Expected", test.expected, "got", action, "for", test.tsk)
+		}
+	}
+
+	// skipped tasks are updated in place, delayed ones are moved to the next minute
+	now := strconv.FormatInt(util.GetUnixMinute(), 10)
+	next := strconv.FormatInt(util.GetUnixMinute()+60, 10)
+	skipped := task.Task{Name: "t0", TriggerAt: now, CallbackEndpoint: "http://db.example.com/"}
+	delayed := task.Task{Name: "t1", TriggerAt: now, CallbackEndpoint: "http://api.example.com/"}
+	for _, tsk := range []task.Task{skipped, delayed} {
+		if err := cm.UpsertTask(tsk); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cm.callback(skipped)
+	if state := stringAttribute(ddb.item("t0", now), "task_state"); state != task.Skipped {
+		t.Error("Expected the task to be skipped, got", state)
+	}
+	cm.callback(delayed)
+	if ddb.item("t1", now) != nil || ddb.item("t1", next) == nil {
+		t.Error("Expected the task to be moved to the next minute")
+	}
+
+	// a task deleted in the meantime is not brought back
+	if err := cm.DeleteTask(skipped); err != nil {
+		t.Fatal(err)
+	}
+	cm.callback(skipped)
+	if item := ddb.item("t0", now); item != nil {
+		t.Error("Expected the deleted task to stay deleted, got", item)
+	}
+}
@@ -0,0 +1,241 @@
+package app
+
+import (
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"github.com/marcoalmeida/callme/task"
+)
+
+// DynamoDB client holding a single table of tasks in memory, keyed by task_name and trigger_at, with just enough
+// support for the conditions, updates, and filters the app uses; tests that need to inspect the requests themselves,
+// or inject failures, have their own clients
+type memoryClient struct {
+	dynamodbiface.DynamoDBAPI
+	mutex sync.Mutex
+	items map[string]map[string]*dynamodb.AttributeValue
+	puts  int
+	reads int
+}
+
+func newMemoryClient() *memoryClient {
+	return &memoryClient{items: make(map[string]map[string]*dynamodb.AttributeValue)}
+}
+
+func memoryKey(item map[string]*dynamodb.AttributeValue) string {
+	return stringAttribute(item, "task_name") + "@" + stringAttribute(item, "trigger_at")
+}
+
+// a copy of the stored item with the given task name and trigger_at, nil if there is none
+func (d *memoryClient) item(name string, triggerAt string) map[string]*dynamodb.AttributeValue {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	stored, ok := d.items[name+"@"+triggerAt]
+	if !ok {
+		return nil
+	}
+	item := make(map[string]*dynamodb.AttributeValue, len(stored))
+	for k, v := range stored {
+		item[k] = v
+	}
+
+	return item
+}
+
+// number of gets, queries, and scans so far
+func (d *memoryClient) readCount() int {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	return d.reads
+}
+
+func (d *memoryClient) len() int {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	return len(d.items)
+}
+
+func conditionalCheckFailed() error {
+	return awserr.New(dynamodb.ErrCodeConditionalCheckFailedException, "conditional check failed", nil)
+}
+
+func (d *memoryClient) PutItem(input *dynamodb.PutItemInput) (*dynamodb.PutItemOutput, error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	key := memoryKey(input.Item)
+	existing, exists := d.items[key]
+	values := input.ExpressionAttributeValues
+
+	failed := false
+	switch aws.StringValue(input.ConditionExpression) {
+	case "attribute_not_exists(task_name)":
+		failed = exists
+	case "task_state = :reserved AND reserved_until >= :now":
+		failed = !exists ||
+			stringAttribute(existing, "task_state") != task.Reserved ||
+			stringAttribute(existing, "reserved_until") < aws.StringValue(values[":now"].S)
+	case "attribute_not_exists(#uuid) OR #uuid <> :uuid":
+		failed = exists && stringAttribute(existing, "uuid") == aws.StringValue(values[":uuid"].S)
+	}
+	if failed {
+		return nil, conditionalCheckFailed()
+	}
+
+	d.puts++
+	d.items[key] = input.Item
+
+	output := &dynamodb.PutItemOutput{}
+	if aws.StringValue(input.ReturnValues) == dynamodb.ReturnValueAllOld {
+		output.Attributes = existing
+	}
+	return output, nil
+}
+
+func (d *memoryClient) GetItem(input *dynamodb.GetItemInput) (*dynamodb.GetItemOutput, error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	d.reads++
+	return &dynamodb.GetItemOutput{Item: d.items[memoryKey(input.Key)]}, nil
+}
+
+// only SET and REMOVE expressions, in that order, are applied; every condition the app uses requires the item to
+// exist, without one the item is created
+func (d *memoryClient) UpdateItem(input *dynamodb.UpdateItemInput) (*dynamodb.UpdateItemOutput, error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	key := memoryKey(input.Key)
+	stored, ok := d.items[key]
+	if !ok && input.ConditionExpression != nil {
+		return nil, conditionalCheckFailed()
+	}
+	if !ok {
+		stored = input.Key
+	}
+	update := aws.StringValue(input.UpdateExpression)
+	if !strings.HasPrefix(update, "SET ") {
+		return &dynamodb.UpdateItemOutput{}, nil
+	}
+	// items already returned are left alone
+	item := make(map[string]*dynamodb.AttributeValue, len(stored))
+	for k, v := range stored {
+		item[k] = v
+	}
+	update = strings.TrimPrefix(update, "SET ")
+	if i := strings.Index(update, " REMOVE "); i >= 0 {
+		for _, attribute := range strings.Split(update[i+len(" REMOVE "):], ",") {
+			delete(item, strings.TrimSpace(attribute))
+		}
+		update = update[:i]
+	}
+	for _, assignment := range strings.Split(update, ",") {
+		parts := strings.Split(assignment, "=")
+		item[strings.TrimSpace(parts[0])] = input.ExpressionAttributeValues[strings.TrimSpace(parts[1])]
+	}
+	d.items[key] = item
+
+	return &dynamodb.UpdateItemOutput{}, nil
+}
+
+func (d *memoryClient) DeleteItem(input *dynamodb.DeleteItemInput) (*dynamodb.DeleteItemOutput, error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	key := memoryKey(input.Key)
+	old := d.items[key]
+	delete(d.items, key)
+
+	return &dynamodb.DeleteItemOutput{Attributes: old}, nil
+}
+
+// whether an item matches the values of a query or scan, by the placeholders the app uses for them
+func memoryMatches(item, values map[string]*dynamodb.AttributeValue, filter string) bool {
+	equal := map[string]string{
+		":name":     "task_name",
+		":state":    "task_state",
+		":minute":   "trigger_at",
+		":running":  "task_state",
+		":instance": "claimed_by",
+	}
+	for placeholder, attribute := range equal {
+		if value, ok := values[placeholder]; ok && stringAttribute(item, attribute) != aws.StringValue(value.S) {
+			return false
+		}
+	}
+	if value, ok := values[":due_by"]; ok && stringAttribute(item, "trigger_at") > aws.StringValue(value.S) {
+		return false
+	}
+	if value, ok := values[":cutoff"]; ok {
+		// an empty string sorts first, only a missing attribute never matches
+		executedAt, exists := item["executed_at"]
+		if !exists || aws.StringValue(executedAt.S) >= aws.StringValue(value.S) {
+			return false
+		}
+		if aws.StringValue(executedAt.S) == "" && strings.Contains(filter, "size(executed_at) > 0") {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (d *memoryClient) Query(input *dynamodb.QueryInput) (*dynamodb.QueryOutput, error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	d.reads++
+	// in key order, for pages of at most Limit items to follow each other
+	keys := make([]string, 0, len(d.items))
+	for key := range d.items {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	start := ""
+	if input.ExclusiveStartKey != nil {
+		start = memoryKey(input.ExclusiveStartKey)
+	}
+
+	output := &dynamodb.QueryOutput{}
+	for _, key := range keys {
+		item := d.items[key]
+		if key <= start || !memoryMatches(item, input.ExpressionAttributeValues, aws.StringValue(input.FilterExpression)) {
+			continue
+		}
+		if input.Limit != nil && int64(len(output.Items)) == aws.Int64Value(input.Limit) {
+			last := output.Items[len(output.Items)-1]
+			output.LastEvaluatedKey = map[string]*dynamodb.AttributeValue{
+				"task_name":  last["task_name"],
+				"trigger_at": last["trigger_at"],
+			}
+			break
+		}
+		output.Items = append(output.Items, item)
+	}
+
+	return output, nil
+}
+
+func (d *memoryClient) Scan(input *dynamodb.ScanInput) (*dynamodb.ScanOutput, error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	d.reads++
+	output := &dynamodb.ScanOutput{}
+	for _, item := range d.items {
+		if memoryMatches(item, input.ExpressionAttributeValues, aws.StringValue(input.FilterExpression)) {
+			output.Items = append(output.Items, item)
+		}
+	}
+
+	return output, nil
+}
@@ -0,0 +1,72 @@
+package app
+
+import (
+	"errors"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"go.uber.org/zap"
+)
+
+// TableMetadata is the relevant subset of the description of the tasks table
+type TableMetadata struct {
+	Name      string   `json:"name"`
+	Status    string   `json:"status"`
+	ItemCount int64    `json:"item_count"`
+	Indexes   []string `json:"indexes"`
+	// status of each index, e.g., CREATING or ACTIVE
+	IndexStatus map[string]string `json:"index_status"`
+}
+
+// TableMetadata returns the cached description of the tasks table
+func (c *CallMe) TableMetadata() TableMetadata {
+	c.metadataMutex.RLock()
+	defer c.metadataMutex.RUnlock()
+
+	return c.tableMetadata
+}
+
+// RefreshTableMetadata describes the tasks table and replaces the cached metadata
+func (c *CallMe) RefreshTableMetadata() error {
+	result, err := c.ddb.DescribeTable(&dynamodb.DescribeTableInput{TableName: aws.String(c.DynamoDBTable)})
+	if err != nil {
+		c.Logger.Error("Failed to describe table", zap.Error(err), zap.String("table", c.DynamoDBTable))
+		return errors.New("failed to describe table")
+	}
+
+	metadata := TableMetadata{
+		Name:        aws.StringValue(result.Table.TableName),
+		Status:      aws.StringValue(result.Table.TableStatus),
+		ItemCount:   aws.Int64Value(result.Table.ItemCount),
+		Indexes:     make([]string, 0, len(result.Table.GlobalSecondaryIndexes)),
+		IndexStatus: make(map[string]string),
+	}
+	for _, index := range result.Table.GlobalSecondaryIndexes {
+		metadata.Indexes = append(metadata.Indexes, aws.StringValue(index.IndexName))
+		metadata.IndexStatus[aws.StringValue(index.IndexName)] = aws.StringValue(index.IndexStatus)
+	}
+
+	c.metadataMutex.Lock()
+	c.tableMetadata = metadata
+	c.metadataMutex.Unlock()
+
+	return nil
+}
+
+// HasIndex returns true iff the tasks table has a global secondary index with the given name, as of the last time
+// its metadata was refreshed
+func (c *CallMe) HasIndex(name string) bool {
+	for _, index := range c.TableMetadata().Indexes {
+		if index == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// returns true iff the tasks table has a global secondary index with the given name that can be queried, as of the
+// last time its metadata was refreshed
+func (c *CallMe) hasActiveIndex(name string) bool {
+	return c.TableMetadata().IndexStatus[name] == dynamodb.IndexStatusActive
+}
@@ -0,0 +1,52 @@
+package app
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"go.uber.org/zap"
+)
+
+// DynamoDB client that only knows how to describe a table
+type describeTableClient struct {
+	dynamodbiface.DynamoDBAPI
+	calls int
+}
+
+func (d *describeTableClient) DescribeTable(input *dynamodb.DescribeTableInput) (*dynamodb.DescribeTableOutput, error) {
+	d.calls++
+	return &dynamodb.DescribeTableOutput{
+		Table: &dynamodb.TableDescription{
+			TableName:   input.TableName,
+			TableStatus: aws.String(dynamodb.TableStatusActive),
+			GlobalSecondaryIndexes: []*dynamodb.GlobalSecondaryIndexDescription{
+				{IndexName: aws.String("inverted_index")},
+			},
+		},
+	}, nil
+}
+
+func TestTableMetadata(t *testing.T) {
+	ddb := &describeTableClient{}
+	cm := &CallMe{DynamoDBTable: "callme", Logger: zap.NewNop(), ddb: ddb}
+
+	err := cm.RefreshTableMetadata()
+	if err != nil {
+		t.Fatal("Expected to succeed, failed with", err)
+	}
+	for i := 0; i < 3; i++ {
+		if cm.TableMetadata().Name != "callme" || !cm.HasIndex("inverted_index") {
+			t.Error("Expected the cached metadata, got", cm.TableMetadata())
+		}
+	}
+	if ddb.calls != 1 {
+		t.Error("Expected DescribeTable to be called once, got", ddb.calls)
+	}
+
+	err = cm.RefreshTableMetadata()
+	if err != nil || ddb.calls != 2 {
+		t.Error("Expected DescribeTable to be called again on refresh, got", ddb.calls, err)
+	}
+}
@@ -0,0 +1,159 @@
+package app
+
+import (
+	"sync/atomic"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/marcoalmeida/callme/task"
+	"github.com/marcoalmeida/callme/util"
+	"go.uber.org/zap"
+)
+
+// metrics pushed to StatsD
+type statsdClient interface {
+	Count(name string, value int64, tags ...string)
+	Histogram(name string, value float64, tags ...string)
+}
+
+// StatsD client tagging all metrics with the environment, if any; on failure metrics are not sent
+func connectToStatsD(addr string, env string, logger *zap.Logger) statsdClient {
+	tags := make([]string, 0)
+	if env != "" {
+		tags = append(tags, "env:"+env)
+	}
+
+	client, err := util.NewStatsD(addr, tags...)
+	if err != nil {
+		logger.Error("Failed to connect to StatsD, metrics will not be sent", zap.Error(err), zap.String("addr", addr))
+		return nil
+	}
+
+	return client
+}
+
+// add to a StatsD counter, if enabled
+func (c *CallMe) count(name string, value int64, tags ...string) {
+	if c.statsd != nil {
+		c.statsd.Count(name, value, tags...)
+	}
+}
+
+// record a value in a StatsD histogram, if enabled
+func (c *CallMe) histogram(name string, value float64, tags ...string) {
+	if c.statsd != nil {
+		c.statsd.Histogram(name, value, tags...)
+	}
+}
+
+// upper bounds (inclusive), in milliseconds, of the buckets of the callback and catch up duration histograms; both
+// must have as many buckets as durationHistogram
+var (
+	CallbackDurationBuckets = []int64{100, 500, 1000, 5000, 30000}
+	CatchupDurationBuckets  = []int64{1000, 5000, 15000, 60000, 300000}
+)
+
+// cumulative histogram of durations, in milliseconds
+type durationHistogram struct {
+	// one counter per bucket, plus the implicit +Inf one
+	buckets [6]int64
+	sum     int64
+	count   int64
+}
+
+// record a duration in the bucket with the given upper bounds
+func (h *durationHistogram) observe(ms int64, bounds []int64) {
+	for i, le := range bounds {
+		if ms <= le {
+			atomic.AddInt64(&h.buckets[i], 1)
+		}
+	}
+	atomic.AddInt64(&h.buckets[len(bounds)], 1)
+	atomic.AddInt64(&h.sum, ms)
+	atomic.AddInt64(&h.count, 1)
+}
+
+// the cumulative count per bucket (the last one being +Inf) along with the total duration and number of observations
+func (h *durationHistogram) snapshot() ([]int64, int64, int64) {
+	buckets := make([]int64, len(h.buckets))
+	for i := range buckets {
+		buckets[i] = atomic.LoadInt64(&h.buckets[i])
+	}
+
+	return buckets, atomic.LoadInt64(&h.sum), atomic.LoadInt64(&h.count)
+}
+
+// CallbackDurations returns the cumulative count of callbacks per bucket (the last one being +Inf) along with the
+// total duration and number of callbacks observed
+func (c *CallMe) CallbackDurations() ([]int64, int64, int64) {
+	return c.callbackDurations.snapshot()
+}
+
+// store the state of a task and, once it's been executed, keep track of how long the callback took; those waiting for the task
+// to change state are woken up
+func (c *CallMe) updateExecutedTask(tsk task.Task) error {
+	if tsk.TaskState == task.Successful || tsk.TaskState == task.Failed {
+		c.callbackDurations.observe(tsk.ExecutionDurationMs, CallbackDurationBuckets)
+		c.count("callme.task.executed", 1, "state:"+tsk.TaskState)
+		c.histogram("callme.callback.duration", float64(tsk.ExecutionDurationMs))
+	}
+
+	err := c.updateTaskState(tsk)
+	if err != nil {
+		return err
+	}
+	c.stateChanges.notify(tsk.Name)
+	if tsk.TaskState == task.Successful || tsk.TaskState == task.Failed || tsk.TaskState == task.Skipped {
+		c.countCompleted(tsk)
+	}
+
+	return nil
+}
+
+// MalformedItems returns the number of stored items that could not be read as tasks, and were skipped
+func (c *CallMe) MalformedItems() int64 {
+	return atomic.LoadInt64(&c.malformedItems)
+}
+
+// PendingTasks returns the number of tasks waiting to be executed, as of the last time it was counted (once a minute)
+func (c *CallMe) PendingTasks() int64 {
+	return atomic.LoadInt64(&c.pendingTasks)
+}
+
+// update the number of pending tasks; on failure the previous one is kept
+func (c *CallMe) refreshPendingTasks() {
+	n, err := c.countTasks(task.Pending)
+	if err != nil {
+		c.Logger.Error("Failed to count pending tasks", zap.Error(err))
+		return
+	}
+
+	atomic.StoreInt64(&c.pendingTasks, n)
+}
+
+// count all tasks in a given state
+// it's a full table scan, but only the number of matching items is returned
+func (c *CallMe) countTasks(state string) (int64, error) {
+	input := &dynamodb.ScanInput{
+		TableName: aws.String(c.DynamoDBTable),
+		Select:    aws.String(dynamodb.SelectCount),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":state": {S: aws.String(state)},
+		},
+		FilterExpression: aws.String("task_state = :state"),
+	}
+
+	var count int64
+	for {
+		result, err := c.ddb.Scan(input)
+		if err != nil {
+			return 0, err
+		}
+		count += aws.Int64Value(result.Count)
+
+		if len(result.LastEvaluatedKey) == 0 {
+			return count, nil
+		}
+		input.ExclusiveStartKey = result.LastEvaluatedKey
+	}
+}
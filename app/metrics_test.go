@@ -0,0 +1,142 @@
+package app
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"github.com/marcoalmeida/callme/task"
+	"go.uber.org/zap"
+)
+
+// DynamoDB client that returns a fixed count on each page of a Scan
+type countClient struct {
+	dynamodbiface.DynamoDBAPI
+	pages []int64
+}
+
+func (d *countClient) Scan(input *dynamodb.ScanInput) (*dynamodb.ScanOutput, error) {
+	page := 0
+	if input.ExclusiveStartKey != nil {
+		page, _ = strconv.Atoi(aws.StringValue(input.ExclusiveStartKey["page"].N))
+	}
+
+	output := &dynamodb.ScanOutput{Count: aws.Int64(d.pages[page])}
+	if page < len(d.pages)-1 {
+		output.LastEvaluatedKey = map[string]*dynamodb.AttributeValue{
+			"page": {N: aws.String(strconv.Itoa(page + 1))},
+		}
+	}
+
+	return output, nil
+}
+
+func TestPendingTasks(t *testing.T) {
+	cm := &CallMe{Logger: zap.NewNop(), ddb: &countClient{pages: []int64{3, 0, 4}}}
+
+	cm.refreshPendingTasks()
+	if cm.PendingTasks() != 7 {
+		t.Error("Expected 7 pending tasks, got", cm.PendingTasks())
+	}
+}
+
+func TestCallbackDurations(t *testing.T) {
+	cm := &CallMe{Logger: zap.NewNop(), ddb: newMemoryClient()}
+
+	// only executed tasks are observed
+	for i, tsk := range []task.Task{
+		{TaskState: task.Running},
+		{TaskState: task.Successful, ExecutionDurationMs: 50},
+		{TaskState: task.Failed, ExecutionDurationMs: 700},
+		{TaskState: task.Successful, ExecutionDurationMs: 60000},
+	} {
+		tsk.Name = "t" + strconv.Itoa(i)
+		tsk.TriggerAt = "600"
+		if err := cm.UpsertTask(task.Task{Name: tsk.Name, TriggerAt: tsk.TriggerAt}); err != nil {
+			t.Fatal(err)
+		}
+		err := cm.updateExecutedTask(tsk)
+		if err != nil {
+			t.Fatal("Expected to succeed, failed with", err)
+		}
+	}
+
+	buckets, sum, count := cm.CallbackDurations()
+	expected := []int64{1, 1, 2, 2, 2, 3}
+	for i := range expected {
+		if buckets[i] != expected[i] {
+			t.Error("Expected", expected, "got", buckets)
+			break
+		}
+	}
+	if sum != 60750 || count != 3 {
+		t.Error("Expected a sum of 60750 over 3 callbacks, got", sum, count)
+	}
+}
+
+// StatsD client that keeps track of every metric sent
+type fakeStatsD struct {
+	mutex   sync.Mutex
+	metrics []string
+}
+
+func (s *fakeStatsD) Count(name string, value int64, tags ...string) {
+	s.record(name, strconv.FormatInt(value, 10), tags)
+}
+
+func (s *fakeStatsD) Histogram(name string, value float64, tags ...string) {
+	s.record(name, strconv.FormatFloat(value, 'f', -1, 64), tags)
+}
+
+func (s *fakeStatsD) record(name string, value string, tags []string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.metrics = append(s.metrics, strings.Join(append([]string{name, value}, tags...), " "))
+}
+
+func TestStatsD(t *testing.T) {
+	statsd := &fakeStatsD{}
+	ddb := newMemoryClient()
+	cm := &CallMe{Logger: zap.NewNop(), ddb: ddb, statsd: statsd}
+
+	tsk := task.Task{Name: "t0", TriggerAt: "1800000000"}
+	err := cm.CreateTask(tsk)
+	if err != nil {
+		t.Fatal("Expected to succeed, failed with", err)
+	}
+	tsk.TaskState = task.Failed
+	tsk.ExecutionDurationMs = 42
+	err = cm.updateExecutedTask(tsk)
+	if err != nil {
+		t.Fatal("Expected to succeed, failed with", err)
+	}
+
+	cm.ddb = &catchupClient{pages: 2}
+	_, err = cm.Catchup("")
+	if err != nil {
+		t.Fatal("Expected to succeed, failed with", err)
+	}
+
+	expected := []string{
+		"callme.task.created 1",
+		"callme.task.executed 1 state:failed",
+		"callme.callback.duration 42",
+		"callme.catchup.recovered 2",
+	}
+	if strings.Join(statsd.metrics, "\n") != strings.Join(expected, "\n") {
+		t.Error("Expected", expected, "got", statsd.metrics)
+	}
+
+	// disabled
+	cm = &CallMe{Logger: zap.NewNop(), ddb: ddb}
+	tsk.TaskState = task.Successful
+	err = cm.updateExecutedTask(tsk)
+	if err != nil {
+		t.Error("Expected to succeed without StatsD, failed with", err)
+	}
+}
@@ -0,0 +1,48 @@
+package app
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/marcoalmeida/callme/task"
+)
+
+// OverdueTask is a pending task whose trigger_at is already past, along with how long ago it was due
+type OverdueTask struct {
+	task.Task
+	OverdueSeconds int64 `json:"overdue_seconds"`
+}
+
+// Overdue lists pending tasks that should have run by now
+type Overdue struct {
+	Tasks []OverdueTask `json:"tasks"`
+	// tasks were left out, see Status.Truncated
+	Truncated bool `json:"truncated,omitempty"`
+}
+
+// Overdue returns the pending tasks whose trigger_at is already past, e.g., because no instance was running, oldest
+// first. All pages are collected, so only Limit, NamePrefix, CreatedBy, and Labels apply out of opts.
+func (c *CallMe) Overdue(opts StatusOptions) (Overdue, error) {
+	now := time.Now().Unix()
+	status, err := c.statusAllTasks(c.DynamoDBTable, StatusOptions{
+		SortDirection: SortAscending,
+		Limit:         opts.Limit,
+		State:         task.Pending,
+		DueBy:         now,
+		CreatedBy:     opts.CreatedBy,
+		Labels:        opts.Labels,
+		NamePrefix:    opts.NamePrefix,
+	})
+	if err != nil {
+		return Overdue{}, err
+	}
+
+	overdue := Overdue{Tasks: make([]OverdueTask, 0, len(status.Tasks)), Truncated: status.Truncated}
+	for _, tsk := range status.Tasks {
+		// by now trigger_at has been validated, it should be safe to ignore the error
+		triggerAt, _ := strconv.ParseInt(tsk.TriggerAt, 10, 64)
+		overdue.Tasks = append(overdue.Tasks, OverdueTask{Task: tsk, OverdueSeconds: now - triggerAt})
+	}
+
+	return overdue, nil
+}
@@ -0,0 +1,50 @@
+package app
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/marcoalmeida/callme/task"
+	"go.uber.org/zap"
+)
+
+func TestOverdue(t *testing.T) {
+	now := time.Now().Unix()
+	c := &CallMe{Logger: zap.NewNop(), ddb: newMemoryClient()}
+	add := func(name string, triggerAt int64, state string) {
+		err := c.UpsertTask(task.Task{
+			Name:             name,
+			TriggerAt:        strconv.FormatInt(triggerAt, 10),
+			CallbackEndpoint: "http://example.com",
+			TaskState:        state,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	add("recent", now-60, task.Pending)
+	add("future", now+3600, task.Pending)
+	add("oldest", now-3600, task.Pending)
+	add("done", now-7200, task.Successful)
+	add("later", now+60, task.Pending)
+
+	overdue, err := c.Overdue(StatusOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(overdue.Tasks) != 2 {
+		t.Fatalf("Expected 2 overdue tasks, got %+v", overdue.Tasks)
+	}
+	if overdue.Tasks[0].Name != "oldest" || overdue.Tasks[1].Name != "recent" {
+		t.Errorf("Expected the oldest task first, got %s, %s", overdue.Tasks[0].Name, overdue.Tasks[1].Name)
+	}
+	// a second may have gone by
+	if d := overdue.Tasks[0].OverdueSeconds; d < 3600 || d > 3601 {
+		t.Error("Expected oldest to be overdue by 3600 seconds, got", d)
+	}
+	if d := overdue.Tasks[1].OverdueSeconds; d < 60 || d > 61 {
+		t.Error("Expected recent to be overdue by 60 seconds, got", d)
+	}
+}
@@ -0,0 +1,136 @@
+package app
+
+import (
+	"errors"
+	"sort"
+	"strconv"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/marcoalmeida/callme/task"
+	"github.com/marcoalmeida/callme/util"
+	"go.uber.org/zap"
+)
+
+// key of the item, on the configuration table, holding the names of the parked tasks
+const parkedConfigKey = "parked"
+
+// Park suspends all tasks with a given name: instead of being executed they are postponed, one minute at a time, until
+// the task is unparked
+func (c *CallMe) Park(name string) error {
+	return c.updateParked(name, "ADD")
+}
+
+// Unpark resumes the execution of the tasks with a given name
+func (c *CallMe) Unpark(name string) error {
+	return c.updateParked(name, "DELETE")
+}
+
+// IsParked returns true iff the tasks with a given name are parked
+func (c *CallMe) IsParked(name string) bool {
+	c.parkedMutex.RLock()
+	defer c.parkedMutex.RUnlock()
+
+	return c.parked[name]
+}
+
+// Parked returns the names of all parked tasks
+func (c *CallMe) Parked() []string {
+	c.parkedMutex.RLock()
+	defer c.parkedMutex.RUnlock()
+
+	names := make([]string, 0, len(c.parked))
+	for name := range c.parked {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// add a name to, or delete it from, the set of parked tasks; updating the set in place is safe across instances
+func (c *CallMe) updateParked(name string, action string) error {
+	input := &dynamodb.UpdateItemInput{
+		TableName: aws.String(c.DynamoDBConfigTable),
+		Key: map[string]*dynamodb.AttributeValue{
+			"config_key": {S: aws.String(parkedConfigKey)},
+		},
+		UpdateExpression: aws.String(action + " config_value :name"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":name": {SS: aws.StringSlice([]string{name})},
+		},
+	}
+	_, err := c.ddb.UpdateItem(input)
+	if err != nil {
+		c.Logger.Error("Failed to update parked tasks", zap.Error(err), zap.String("task_name", name))
+		return errors.New("failed to update parked tasks")
+	}
+
+	c.parkedMutex.Lock()
+	if c.parked == nil {
+		c.parked = make(map[string]bool)
+	}
+	if action == "ADD" {
+		c.parked[name] = true
+	} else {
+		delete(c.parked, name)
+	}
+	c.parkedMutex.Unlock()
+
+	return nil
+}
+
+// refresh the local copy of the parked tasks; on failure the previous one is kept
+func (c *CallMe) loadParked() {
+	input := &dynamodb.GetItemInput{
+		TableName: aws.String(c.DynamoDBConfigTable),
+		Key: map[string]*dynamodb.AttributeValue{
+			"config_key": {S: aws.String(parkedConfigKey)},
+		},
+	}
+	result, err := c.ddb.GetItem(input)
+	if err != nil {
+		if !c.missingConfigTable(err) {
+			c.Logger.Error("Failed to load parked tasks", zap.Error(err))
+		}
+		return
+	}
+
+	parked := make(map[string]bool)
+	if value, ok := result.Item["config_value"]; ok {
+		for _, name := range aws.StringValueSlice(value.SS) {
+			parked[name] = true
+		}
+	}
+
+	c.parkedMutex.Lock()
+	c.parked = parked
+	c.parkedMutex.Unlock()
+}
+
+// move a task to the next minute
+func (c *CallMe) postpone(tsk task.Task) {
+	next := tsk
+	// by now trigger_at has been validated, it should be safe to ignore the error
+	triggerAt, _ := strconv.ParseInt(tsk.TriggerAt, 10, 64)
+	// tasks found while catching up may be long overdue, they can only be picked up again if moved to the future
+	if now := util.GetUnixMinute(); triggerAt < now {
+		triggerAt = now
+	}
+	next.TriggerAt = strconv.FormatInt(triggerAt+60, 10)
+
+	c.Logger.Debug("Postponing task", zap.String("task", tsk.String()), zap.String("next", next.TriggerAt))
+
+	err := c.UpsertTask(next)
+	if err != nil {
+		c.Logger.Error("Failed to postpone task", zap.Error(err), zap.String("task", tsk.String()))
+		return
+	}
+	// the task was moved, it should not be picked up again when catching up
+	err = c.deleteTask(tsk)
+	if err != nil {
+		c.Logger.Error("Failed to remove postponed task", zap.Error(err), zap.String("task", tsk.String()))
+		return
+	}
+	c.countCompleted(tsk)
+}
@@ -0,0 +1,39 @@
+package app
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/marcoalmeida/callme/task"
+	"github.com/marcoalmeida/callme/util"
+	"go.uber.org/zap"
+)
+
+func TestPark(t *testing.T) {
+	ddb := newMemoryClient()
+	cm := &CallMe{Logger: zap.NewNop(), ddb: ddb}
+
+	err := cm.Park("t0")
+	if err != nil || !cm.IsParked("t0") || cm.IsParked("t1") {
+		t.Fatal("Expected t0, and only t0, to be parked", cm.Parked(), err)
+	}
+
+	// parked tasks are moved to the next minute instead of being executed, even if long overdue
+	now := util.GetUnixMinute()
+	overdue := task.Task{Name: "t0", TriggerAt: strconv.FormatInt(now-3600, 10), TaskState: task.Pending}
+	if err := cm.UpsertTask(overdue); err != nil {
+		t.Fatal(err)
+	}
+	cm.callback(overdue)
+	if ddb.item("t0", strconv.FormatInt(now+60, 10)) == nil {
+		t.Error("Expected the task to be moved to the next minute")
+	}
+	if ddb.item("t0", overdue.TriggerAt) != nil {
+		t.Error("Expected the original task to be removed")
+	}
+
+	err = cm.Unpark("t0")
+	if err != nil || cm.IsParked("t0") {
+		t.Error("Expected t0 not to be parked", cm.Parked(), err)
+	}
+}
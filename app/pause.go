@@ -0,0 +1,39 @@
+package app
+
+import (
+	"sync/atomic"
+)
+
+// Pause stops executing tasks, e.g., before maintenance: neither the main loop nor catch up passes start any more
+// callbacks, while those in progress run to completion. Tasks due in the meantime are left pending. It returns false
+// if already paused.
+func (c *CallMe) Pause() bool {
+	if !atomic.CompareAndSwapInt32(&c.paused, 0, 1) {
+		return false
+	}
+	c.Logger.Warn("Paused, no more tasks will be executed until resumed")
+
+	return true
+}
+
+// Resume starts executing tasks again, catching up on those that were due while paused. It returns false if not
+// paused.
+func (c *CallMe) Resume() bool {
+	if !atomic.CompareAndSwapInt32(&c.paused, 1, 0) {
+		return false
+	}
+	c.Logger.Info("Resumed")
+	go c.periodicCatchup()
+
+	return true
+}
+
+// Paused returns true iff tasks are not being executed, see Pause
+func (c *CallMe) Paused() bool {
+	return atomic.LoadInt32(&c.paused) == 1
+}
+
+// InFlight returns the number of callbacks in progress
+func (c *CallMe) InFlight() int {
+	return c.inFlightCount()
+}
@@ -0,0 +1,56 @@
+package app
+
+import (
+	"testing"
+	"time"
+
+	"github.com/marcoalmeida/callme/task"
+	"go.uber.org/zap"
+)
+
+func TestPause(t *testing.T) {
+	ddb := newMemoryClient()
+	// callbacks return right away, the task is left pending
+	c := &CallMe{Logger: zap.NewNop(), ddb: ddb, stopping: true}
+	if err := c.UpsertTask(task.Task{Name: "t0", TriggerAt: "1700000040", TaskState: task.Pending}); err != nil {
+		t.Fatal(err)
+	}
+
+	if !c.Pause() || !c.Paused() {
+		t.Fatal("Expected to be paused")
+	}
+	if c.Pause() {
+		t.Error("Expected not to pause twice")
+	}
+
+	// nothing is looked for, let alone enqueued
+	if dispatched := c.dispatchMinute(1700000040); len(dispatched) != 0 {
+		t.Error("Expected no tasks to be dispatched while paused, got", dispatched)
+	}
+	if _, err := c.Catchup(""); err != ErrPaused {
+		t.Error("Expected", ErrPaused, "got", err)
+	}
+	if reads := ddb.readCount(); reads != 0 {
+		t.Error("Expected no queries or scans while paused, got", reads)
+	}
+
+	if !c.Resume() || c.Paused() {
+		t.Fatal("Expected to be resumed")
+	}
+	if c.Resume() {
+		t.Error("Expected not to resume twice")
+	}
+
+	// tasks due while paused are caught up on
+	deadline := time.Now().Add(time.Second)
+	for ddb.readCount() == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("Expected a catch up pass after resuming")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	// and the main loop executes them again
+	if dispatched := c.dispatchMinute(1700000040); !dispatched["t0@1700000040"] {
+		t.Error("Expected t0 to be dispatched after resuming, got", dispatched)
+	}
+}
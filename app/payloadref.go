@@ -0,0 +1,143 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/marcoalmeida/callme/task"
+	"go.uber.org/zap"
+)
+
+// how long referenced payloads are kept in memory, saving a request for every callback that shares them
+const payloadTTL = 5 * time.Minute
+
+// referenced payloads, by URL
+type payloadCache struct {
+	payloads map[string]cachedPayload
+	mutex    sync.Mutex
+}
+
+type cachedPayload struct {
+	payload string
+	expires time.Time
+}
+
+func (p *payloadCache) get(ref string, now time.Time) (string, bool) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	cached, ok := p.payloads[ref]
+	if !ok || now.After(cached.expires) {
+		delete(p.payloads, ref)
+		return "", false
+	}
+
+	return cached.payload, true
+}
+
+func (p *payloadCache) add(ref string, payload string, now time.Time) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.payloads == nil {
+		p.payloads = make(map[string]cachedPayload)
+	}
+	// expired payloads are dropped as they're added, so the cache only holds those used within the last few minutes
+	for k, cached := range p.payloads {
+		if now.After(cached.expires) {
+			delete(p.payloads, k)
+		}
+	}
+	p.payloads[ref] = cachedPayload{payload: payload, expires: now.Add(payloadTTL)}
+}
+
+func connectToS3(region string) s3iface.S3API {
+	return s3.New(session.Must(session.NewSession(aws.NewConfig().WithRegion(region))))
+}
+
+// set the payload of a task whose payload is referenced by URL, using the cache if possible; payloads larger than
+// MaxPayloadBytes are rejected, just like inline ones
+func (c *CallMe) fetchPayload(ctx context.Context, tsk task.Task) (task.Task, error) {
+	if tsk.PayloadRef == "" {
+		return tsk, nil
+	}
+
+	if payload, ok := c.payloads.get(tsk.PayloadRef, time.Now()); ok {
+		tsk.Payload = payload
+		return tsk, nil
+	}
+
+	body, err := c.openPayloadRef(ctx, tsk.PayloadRef)
+	if err != nil {
+		c.Logger.Error("Failed to fetch payload", zap.Error(err), zap.String("task", tsk.String()))
+		return tsk, errors.New("failed to fetch the payload: " + err.Error())
+	}
+	defer body.Close()
+
+	maxPayloadBytes, _ := c.UploadLimits()
+	var reader io.Reader = body
+	if maxPayloadBytes > 0 {
+		// one more byte than allowed, to tell a payload that is too large apart from one that's just the right size
+		reader = io.LimitReader(body, int64(maxPayloadBytes)+1)
+	}
+	payload, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return tsk, errors.New("failed to fetch the payload: " + err.Error())
+	}
+	if maxPayloadBytes > 0 && len(payload) > maxPayloadBytes {
+		return tsk, fmt.Errorf("payload too large, the maximum is %d bytes", maxPayloadBytes)
+	}
+
+	tsk.Payload = string(payload)
+	c.payloads.add(tsk.PayloadRef, tsk.Payload, time.Now())
+
+	return tsk, nil
+}
+
+// body of the object referenced by an http(s) or s3 URL
+func (c *CallMe) openPayloadRef(ctx context.Context, ref string) (io.ReadCloser, error) {
+	u, err := url.Parse(ref)
+	if err != nil {
+		return nil, err
+	}
+
+	switch u.Scheme {
+	case "http", "https":
+		req, err := http.NewRequestWithContext(ctx, "GET", ref, nil)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := c.httpClient.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode < 200 || resp.StatusCode > 299 {
+			resp.Body.Close()
+			return nil, fmt.Errorf("unexpected HTTP status %d", resp.StatusCode)
+		}
+		return resp.Body, nil
+	case "s3":
+		output, err := c.s3.GetObjectWithContext(ctx, &s3.GetObjectInput{
+			Bucket: aws.String(u.Host),
+			Key:    aws.String(strings.TrimPrefix(u.Path, "/")),
+		})
+		if err != nil {
+			return nil, err
+		}
+		return output.Body, nil
+	default:
+		return nil, errors.New("unsupported scheme: " + u.Scheme)
+	}
+}
@@ -0,0 +1,83 @@
+package app
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+
+	"github.com/marcoalmeida/callme/task"
+	"github.com/marcoalmeida/callme/util"
+	"go.uber.org/zap"
+)
+
+func TestCallback_payloadRef(t *testing.T) {
+	var fetched int32
+	source := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&fetched, 1)
+		w.Write([]byte("shared payload"))
+	}))
+	defer source.Close()
+
+	received := make(chan string, 2)
+	callback := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		received <- string(body)
+	}))
+	defer callback.Close()
+
+	ddb := newMemoryClient()
+	c := &CallMe{Logger: zap.NewNop(), ddb: ddb, httpClient: http.DefaultClient}
+
+	for i := 0; i < 2; i++ {
+		tsk := task.Task{
+			Name:             "t" + strconv.Itoa(i),
+			TriggerAt:        strconv.FormatInt(util.GetUnixMinute(), 10),
+			CallbackEndpoint: callback.URL,
+			CallbackMethod:   "POST",
+			PayloadRef:       source.URL + "/payload.json",
+			TaskState:        task.Pending,
+		}
+		tsk.SetDefaults("", 0)
+		err := c.UpsertTask(tsk)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c.callback(tsk)
+
+		body := <-received
+		if body != "shared payload" {
+			t.Error("Expected the referenced payload to reach the callback, got", body)
+		}
+		if payload := stringAttribute(ddb.item(tsk.Name, tsk.TriggerAt), "payload"); payload != "" {
+			t.Error("Expected the referenced payload not to be stored, got", payload)
+		}
+	}
+
+	// the second callback uses the cached payload
+	if atomic.LoadInt32(&fetched) != 1 {
+		t.Error("Expected the payload to be fetched once, got", fetched)
+	}
+}
+
+func TestFetchPayload_tooLarge(t *testing.T) {
+	source := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("0123456789"))
+	}))
+	defer source.Close()
+
+	c := &CallMe{Logger: zap.NewNop(), httpClient: http.DefaultClient, MaxPayloadBytes: 9}
+	_, err := c.fetchPayload(context.Background(), task.Task{PayloadRef: source.URL})
+	if err == nil {
+		t.Error("Expected to fail fetching a payload larger than MaxPayloadBytes")
+	}
+
+	c.MaxPayloadBytes = 10
+	tsk, err := c.fetchPayload(context.Background(), task.Task{PayloadRef: source.URL})
+	if err != nil || tsk.Payload != "0123456789" {
+		t.Error("Expected 0123456789, got", tsk.Payload, err)
+	}
+}
@@ -0,0 +1,109 @@
+package app
+
+import (
+	"github.com/marcoalmeida/callme/task"
+	"go.uber.org/zap"
+)
+
+// outcomes of ReconcileTask
+const (
+	ReconcileCreated   = "created"
+	ReconcileUpdated   = "updated"
+	ReconcileUnchanged = "unchanged"
+)
+
+// fields that are not part of the definition of a task, but set as it's stored or executed
+var reconcileIgnoredFields = map[string]bool{
+	"task_state":            true,
+	"response_body":         true,
+	"response_body_hash":    true,
+	"response_status":       true,
+	"executed_at":           true,
+	"handled_by":            true,
+	"retry_count":           true,
+	"claimed_by":            true,
+	"execution_duration_ms": true,
+	"reserved_until":        true,
+	"created_by":            true,
+	"encrypted_payload":     true,
+	"encrypted_data_key":    true,
+}
+
+// ReconcileTask makes tsk the only pending task with its name, which works as a key chosen by the client, e.g., to
+// declare tasks from version control and apply them repeatedly. If there already is a task with the same name,
+// trigger_at, and definition, whatever its state, it's left alone, e.g., applying the same declaration after the
+// task ran does not run it again; otherwise tsk is stored. Any other pending tasks with that name are deleted. It
+// returns whether the task was created, updated, or left unchanged.
+func (c *CallMe) ReconcileTask(tsk task.Task) (string, error) {
+	c.Logger.Debug("Reconciling task", zap.String("task", tsk.String()))
+
+	tsk.SetDefaults(c.TaskDefaults())
+	if tsk.CreatedBy == "" {
+		tsk.CreatedBy = task.CreatedByAPI
+	}
+
+	// the task with the same key, if any, and the other pending ones, on however many pages they are
+	var existing *task.Task
+	pending := make([]task.Task, 0)
+	next := task.Task{}
+	for {
+		status, err := c.statusByTaskName(c.DynamoDBTable, tsk, StatusOptions{StartFrom: next})
+		if err != nil {
+			return "", err
+		}
+
+		for _, t := range status.Tasks {
+			t := t
+			switch {
+			case t.TriggerAt == tsk.TriggerAt:
+				existing = &t
+			case t.TaskState == task.Pending:
+				pending = append(pending, t)
+			}
+		}
+
+		if status.Next.Name == "" || status.Next.TriggerAt == "" {
+			break
+		}
+		next = status.Next
+	}
+	unchanged := existing != nil && sameDefinition(*existing, tsk)
+	if unchanged && len(pending) == 0 {
+		return ReconcileUnchanged, nil
+	}
+
+	if !unchanged {
+		// the UUID, if any, is the same on every call, it cannot be relied upon to tell whether the task changed
+		err := c.createTask(tsk, false)
+		if err != nil {
+			return "", err
+		}
+		if existing == nil && len(pending) == 0 {
+			return ReconcileCreated, nil
+		}
+		if existing != nil {
+			c.audit("reconcile", *existing, tsk)
+		}
+	}
+
+	for _, previous := range pending {
+		c.audit("reconcile", previous, tsk)
+		err := c.DeleteTask(previous)
+		if err != nil && err != ErrTaskNotFound {
+			return "", err
+		}
+	}
+
+	return ReconcileUpdated, nil
+}
+
+// both tasks have the same definition, regardless of their state or outcome
+func sameDefinition(stored task.Task, tsk task.Task) bool {
+	for field := range stored.Diff(tsk) {
+		if !reconcileIgnoredFields[field] {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,116 @@
+package app
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/marcoalmeida/callme/task"
+	"github.com/marcoalmeida/callme/util"
+	"go.uber.org/zap"
+)
+
+func TestReconcileTask(t *testing.T) {
+	ddb := newMemoryClient()
+	c := &CallMe{Logger: zap.NewNop(), ddb: ddb}
+	reconcile := func(tsk task.Task, expected string) {
+		t.Helper()
+		outcome, err := c.ReconcileTask(tsk)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if outcome != expected {
+			t.Errorf("Expected the task to be %s, got %s", expected, outcome)
+		}
+		if ddb.len() != 1 {
+			t.Fatalf("Expected a single task, got %d", ddb.len())
+		}
+	}
+
+	tsk := task.Task{
+		Name:             "nightly-report",
+		TriggerAt:        "1800000000",
+		CallbackEndpoint: "http://example.com",
+		Payload:          "v1",
+	}
+	reconcile(tsk, ReconcileCreated)
+	// repeating the same request changes nothing
+	reconcile(tsk, ReconcileUnchanged)
+	reconcile(tsk, ReconcileUnchanged)
+	if ddb.puts != 1 {
+		t.Error("Expected identical requests not to write anything, got", ddb.puts, "writes")
+	}
+
+	// updated in place
+	tsk.Payload = "v2"
+	reconcile(tsk, ReconcileUpdated)
+	if payload := stringAttribute(ddb.item("nightly-report", "1800000000"), "payload"); payload != "v2" {
+		t.Error("Expected the payload to be updated, got", payload)
+	}
+
+	// moved to a new trigger_at, the previous one is gone
+	tsk.TriggerAt = "1800000600"
+	reconcile(tsk, ReconcileUpdated)
+	if ddb.item("nightly-report", "1800000600") == nil {
+		t.Error("Expected the task to be moved, got", ddb.items)
+	}
+	reconcile(tsk, ReconcileUnchanged)
+}
+
+func TestReconcileTask_pages(t *testing.T) {
+	ddb := newMemoryClient()
+	c := &CallMe{Logger: zap.NewNop(), ddb: ddb, MaxStatusResults: 2}
+
+	// more pending tasks than fit on a page, the last one already as declared
+	tsk := task.Task{Name: "t0", TriggerAt: "1800000000", CallbackEndpoint: "http://example.com"}
+	if _, err := c.ReconcileTask(tsk); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 4; i++ {
+		previous := task.Task{Name: "t0", TriggerAt: strconv.Itoa(1700000000 + i*60), TaskState: task.Pending}
+		if err := c.UpsertTask(previous); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	outcome, err := c.ReconcileTask(tsk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if outcome != ReconcileUpdated || ddb.len() != 1 || ddb.item("t0", tsk.TriggerAt) == nil {
+		t.Error("Expected every other pending task to be deleted, got", outcome, ddb.len())
+	}
+}
+
+func TestReconcileTask_afterRun(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ts.Close()
+
+	ddb := newMemoryClient()
+	c := &CallMe{Logger: zap.NewNop(), ddb: ddb, httpClient: ts.Client()}
+	tsk := task.Task{
+		Name:             "nightly-report",
+		TriggerAt:        strconv.FormatInt(util.GetUnixMinute(), 10),
+		CallbackEndpoint: ts.URL,
+	}
+	outcome, err := c.ReconcileTask(tsk)
+	if err != nil || outcome != ReconcileCreated {
+		t.Fatal("Expected the task to be created, got", outcome, err)
+	}
+
+	stored, _ := c.unmarshalTask(ddb.item(tsk.Name, tsk.TriggerAt))
+	c.callback(stored)
+	if state := stringAttribute(ddb.item(tsk.Name, tsk.TriggerAt), "task_state"); state != task.Successful {
+		t.Fatal("Expected the task to have run, got", state)
+	}
+
+	// the same declaration does not bring it back to pending
+	outcome, err = c.ReconcileTask(tsk)
+	if err != nil || outcome != ReconcileUnchanged {
+		t.Error("Expected the task to be unchanged, got", outcome, err)
+	}
+	if state := stringAttribute(ddb.item(tsk.Name, tsk.TriggerAt), "task_state"); state != task.Successful {
+		t.Error("Expected the task to still be successful, got", state)
+	}
+}
@@ -0,0 +1,133 @@
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// headers whose values are never written to the replay log
+var replayRedactedHeaders = []string{"Authorization", "Cookie", "Proxy-Authorization"}
+
+// ReplayEntry is a mutating request, as written to ReplayLogFile, along with the status code it was answered with
+type ReplayEntry struct {
+	Method    string      `json:"method"`
+	Path      string      `json:"path"`
+	Headers   http.Header `json:"headers"`
+	Body      string      `json:"body"`
+	Status    int         `json:"status"`
+	Timestamp time.Time   `json:"timestamp"`
+}
+
+// appends one JSON line per request to ReplayLogFile, if set
+type replayLog struct {
+	mutex sync.Mutex
+	file  *os.File
+}
+
+func openReplayLog(path string) (*replayLog, error) {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	return &replayLog{file: f}, nil
+}
+
+// ReplayLogging returns true iff mutating requests are being written to ReplayLogFile
+func (c *CallMe) ReplayLogging() bool {
+	return c.replayLog != nil
+}
+
+// RecordRequest writes a mutating request, and the status code it was answered with, to ReplayLogFile, for it to be
+// replayed later on by callme-replay; credentials, and payloads meant to be encrypted, are redacted. It's a no-op
+// unless ReplayLogFile is set.
+func (c *CallMe) RecordRequest(r *http.Request, body []byte, status int) {
+	if c.replayLog == nil {
+		return
+	}
+
+	headers := r.Header.Clone()
+	for _, h := range replayRedactedHeaders {
+		if headers.Get(h) != "" {
+			headers.Set(h, redacted)
+		}
+	}
+	line, err := json.Marshal(ReplayEntry{
+		Method:    r.Method,
+		Path:      r.URL.RequestURI(),
+		Headers:   headers,
+		Body:      string(redactPayloads(body)),
+		Status:    status,
+		Timestamp: time.Now().UTC(),
+	})
+	if err != nil {
+		c.Logger.Error("Failed to marshal request for the replay log", zap.Error(err))
+		return
+	}
+
+	c.replayLog.mutex.Lock()
+	defer c.replayLog.mutex.Unlock()
+	_, err = c.replayLog.file.Write(append(line, '\n'))
+	if err != nil {
+		c.Logger.Error("Failed to write to the replay log", zap.Error(err))
+	}
+}
+
+// the body of a request, with the payloads of tasks that ask for encryption redacted; bodies that are not JSON but
+// mention encrypt_payload, e.g., CSV uploads, are redacted as a whole
+func redactPayloads(body []byte) []byte {
+	var v interface{}
+	err := json.Unmarshal(body, &v)
+	if err != nil {
+		if bytes.Contains(body, []byte("encrypt_payload")) {
+			return []byte(redacted)
+		}
+		return body
+	}
+	if !redactEncrypted(v) {
+		return body
+	}
+
+	var b bytes.Buffer
+	enc := json.NewEncoder(&b)
+	enc.SetEscapeHTML(false)
+	err = enc.Encode(v)
+	if err != nil {
+		return []byte(redacted)
+	}
+
+	return bytes.TrimSuffix(b.Bytes(), []byte("\n"))
+}
+
+// redact, in place, the payloads of the tasks in v, and their follow-ups, that ask for encryption, along with those
+// of fan-out requests whose template does; returns true iff anything was redacted
+func redactEncrypted(v interface{}) bool {
+	changed := false
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for _, child := range v {
+			changed = redactEncrypted(child) || changed
+		}
+		if v["encrypt_payload"] == true && v["payload"] != nil {
+			v["payload"] = redacted
+			changed = true
+		}
+		if template, ok := v["template"].(map[string]interface{}); ok && template["encrypt_payload"] == true &&
+			v["payloads"] != nil {
+			v["payloads"] = redacted
+			changed = true
+		}
+	case []interface{}:
+		for _, child := range v {
+			changed = redactEncrypted(child) || changed
+		}
+	}
+
+	return changed
+}
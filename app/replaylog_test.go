@@ -0,0 +1,109 @@
+package app
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+func TestRecordRequest(t *testing.T) {
+	dir, err := ioutil.TempDir("", "replay")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "replay.log")
+
+	log, err := openReplayLog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cm := &CallMe{Logger: zap.NewNop(), replayLog: log}
+	if !cm.ReplayLogging() {
+		t.Fatal("Expected requests to be recorded")
+	}
+
+	body := `{"callback_endpoint": "http://example.com"}`
+	r := httptest.NewRequest(http.MethodPut, "/task/reminder@1?pretty", strings.NewReader(body))
+	r.Header.Set("Authorization", "Bearer secret")
+	r.Header.Set("X-Callme-Namespace", "team-a")
+	cm.RecordRequest(r, []byte(body), http.StatusCreated)
+	cm.RecordRequest(httptest.NewRequest(http.MethodDelete, "/task/reminder@1", nil), nil, http.StatusOK)
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var entries []ReplayEntry
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var entry ReplayEntry
+		err := json.Unmarshal(scanner.Bytes(), &entry)
+		if err != nil {
+			t.Fatal(err)
+		}
+		entries = append(entries, entry)
+	}
+
+	if len(entries) != 2 {
+		t.Fatalf("Expected 2 entries, got %d", len(entries))
+	}
+	first := entries[0]
+	if first.Method != http.MethodPut || first.Path != "/task/reminder@1?pretty" || first.Body != body ||
+		first.Status != http.StatusCreated {
+		t.Errorf("Unexpected entry: %+v", first)
+	}
+	if first.Headers.Get("Authorization") != redacted {
+		t.Error("Expected the Authorization header to be redacted, got", first.Headers.Get("Authorization"))
+	}
+	if first.Headers.Get("X-Callme-Namespace") != "team-a" {
+		t.Error("Expected other headers to be kept, got", first.Headers)
+	}
+	if entries[1].Method != http.MethodDelete || entries[1].Status != http.StatusOK {
+		t.Errorf("Unexpected entry: %+v", entries[1])
+	}
+}
+
+func TestRecordRequest_disabled(t *testing.T) {
+	cm := &CallMe{Logger: zap.NewNop()}
+	if cm.ReplayLogging() {
+		t.Error("Expected requests not to be recorded")
+	}
+	// no-op
+	cm.RecordRequest(httptest.NewRequest(http.MethodPost, "/retry/reminder@1", nil), nil, http.StatusOK)
+}
+
+func TestRedactPayloads(t *testing.T) {
+	tests := []struct {
+		body     string
+		expected string
+	}{
+		{`{"payload":"public"}`, `{"payload":"public"}`},
+		{`{"encrypt_payload":true,"payload":"secret"}`, `{"encrypt_payload":true,"payload":"<redacted>"}`},
+		{
+			`{"payload":"public","on_success":{"encrypt_payload":true,"payload":"secret"}}`,
+			`{"on_success":{"encrypt_payload":true,"payload":"<redacted>"},"payload":"public"}`,
+		},
+		{
+			`{"template":{"encrypt_payload":true},"payloads":["a","b"]}`,
+			`{"payloads":"<redacted>","template":{"encrypt_payload":true}}`,
+		},
+		{"task_name,payload,encrypt_payload\nt0,secret,true\n", redacted},
+		{"task_name,payload\nt0,public\n", "task_name,payload\nt0,public\n"},
+	}
+
+	for _, test := range tests {
+		if body := string(redactPayloads([]byte(test.body))); body != test.expected {
+			t.Errorf("Expected %s, got %s", test.expected, body)
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package app
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/marcoalmeida/callme/task"
+	"github.com/marcoalmeida/callme/util"
+	"go.uber.org/zap"
+)
+
+// how long a reservation lasts before it has to be confirmed
+const reservationTTL = 5 * time.Minute
+
+// ReserveTask creates a placeholder for a task, identified by its name and trigger_at, that is never executed unless
+// confirmed (with ConfirmTask) within the next 5 minutes
+func (c *CallMe) ReserveTask(tsk task.Task) (task.Task, error) {
+	reserved := task.Task{
+		Name:          tsk.Name,
+		TriggerAt:     tsk.TriggerAt,
+		TaskState:     task.Reserved,
+		ReservedUntil: strconv.FormatInt(time.Now().Add(reservationTTL).Unix(), 10),
+	}
+
+	err := c.putTaskIf(reserved, "attribute_not_exists(task_name)", nil)
+	if err != nil {
+		return task.Task{}, err
+	}
+
+	return reserved, nil
+}
+
+// ConfirmTask replaces a reserved task with its full definition, making it pending; it fails with ErrTaskNotReserved
+// if there is no such reservation, or it has expired
+func (c *CallMe) ConfirmTask(tsk task.Task) (task.Task, error) {
+	tsk.ReservedUntil = ""
+	tsk, err := c.prepareForStorage(tsk)
+	if err != nil {
+		return task.Task{}, err
+	}
+
+	err = c.putTaskIf(
+		tsk,
+		"task_state = :reserved AND reserved_until >= :now",
+		map[string]*dynamodb.AttributeValue{
+			":reserved": {S: aws.String(task.Reserved)},
+			":now":      {S: aws.String(strconv.FormatInt(time.Now().Unix(), 10))},
+		},
+	)
+	if err == ErrTaskExists {
+		return task.Task{}, ErrTaskNotReserved
+	}
+	if err != nil {
+		return task.Task{}, err
+	}
+	c.countScheduled(tsk)
+	c.created(tsk)
+
+	// the reservation may have outlived the trigger time, in which case there's no point waiting for a catch up pass
+	triggerAt, _ := strconv.ParseInt(tsk.TriggerAt, 10, 64)
+	if triggerAt < util.GetUnixMinute() {
+		c.dispatch(tsk)
+	}
+
+	return tsk, nil
+}
+
+// store a task if the condition holds; returns ErrTaskExists otherwise
+func (c *CallMe) putTaskIf(tsk task.Task, condition string, values map[string]*dynamodb.AttributeValue) error {
+	item, err := dynamodbattribute.MarshalMap(tsk)
+	if err != nil {
+		c.Logger.Error("Failed to update task on DynamoDB: MapMarshal", zap.Error(err))
+		return errors.New("invalid JSON")
+	}
+
+	defer c.statusCache.invalidate(c.DynamoDBTable, tsk)
+
+	input := &dynamodb.PutItemInput{
+		TableName:                 aws.String(c.DynamoDBTable),
+		Item:                      item,
+		ConditionExpression:       aws.String(condition),
+		ExpressionAttributeValues: values,
+	}
+	_, err = c.ddb.PutItem(input)
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+		return ErrTaskExists
+	}
+	if err != nil {
+		msg := "Failed to store task"
+		c.Logger.Error(msg, zap.Error(err), zap.String("task", tsk.String()))
+		return errors.New(strings.ToLower(msg))
+	}
+
+	return nil
+}
+
+// remove reservations that were not confirmed in time
+func (c *CallMe) removeExpiredReservations(tasks []task.Task) {
+	for _, tsk := range tasks {
+		c.Logger.Debug("Removing expired reservation", zap.String("task", tsk.String()))
+		err := c.deleteTask(tsk)
+		if err != nil {
+			c.Logger.Error("Failed to remove expired reservation", zap.Error(err), zap.String("task", tsk.String()))
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package app
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/marcoalmeida/callme/task"
+	"go.uber.org/zap"
+)
+
+func TestReserveTask(t *testing.T) {
+	ddb := newMemoryClient()
+	cm := &CallMe{Logger: zap.NewNop(), ddb: ddb}
+
+	tsk := task.Task{Name: "t0", TriggerAt: "1800000000"}
+	reserved, err := cm.ReserveTask(tsk)
+	if err != nil || reserved.TaskState != task.Reserved || reserved.ReservedUntil == "" {
+		t.Fatal("Expected the task to be reserved, got", reserved, err)
+	}
+
+	// no double booking
+	_, err = cm.ReserveTask(tsk)
+	if err != ErrTaskExists {
+		t.Error("Expected", ErrTaskExists, "got", err)
+	}
+
+	tsk.CallbackEndpoint = "http://example.com"
+	confirmed, err := cm.ConfirmTask(tsk)
+	if err != nil || confirmed.TaskState != task.Pending || confirmed.ReservedUntil != "" {
+		t.Error("Expected the task to be pending, got", confirmed, err)
+	}
+	if stringAttribute(ddb.item("t0", "1800000000"), "task_state") != task.Pending {
+		t.Error("Expected the stored task to be pending, got", ddb.item("t0", "1800000000"))
+	}
+
+	// only reservations can be confirmed
+	_, err = cm.ConfirmTask(tsk)
+	if err != ErrTaskNotReserved {
+		t.Error("Expected", ErrTaskNotReserved, "got", err)
+	}
+	_, err = cm.ConfirmTask(task.Task{Name: "t1", TriggerAt: "1800000000"})
+	if err != ErrTaskNotReserved {
+		t.Error("Expected", ErrTaskNotReserved, "got", err)
+	}
+}
+
+func TestCatchup_expiredReservations(t *testing.T) {
+	ddb := newMemoryClient()
+	cm := &CallMe{Logger: zap.NewNop(), ddb: ddb}
+
+	expired := strconv.FormatInt(time.Now().Add(-time.Minute).Unix(), 10)
+	ddb.items["t0@1800000000"] = map[string]*dynamodb.AttributeValue{
+		"task_name":      {S: aws.String("t0")},
+		"trigger_at":     {S: aws.String("1800000000")},
+		"task_state":     {S: aws.String(task.Reserved)},
+		"reserved_until": {S: aws.String(expired)},
+	}
+
+	n, err := cm.Catchup("")
+	if err != nil || n != 0 {
+		t.Error("Expected nothing to be replayed, got", n, err)
+	}
+	if ddb.len() != 0 {
+		t.Error("Expected the expired reservation to be removed, got", ddb.items)
+	}
+}
+
+func TestConfirmTask_encrypted(t *testing.T) {
+	ddb := newMemoryClient()
+	cm := &CallMe{Logger: zap.NewNop(), ddb: ddb, kms: &reversingKMS{}}
+
+	tsk := task.Task{Name: "t0", TriggerAt: "1800000000"}
+	if _, err := cm.ReserveTask(tsk); err != nil {
+		t.Fatal(err)
+	}
+	tsk.CallbackEndpoint = "http://example.com"
+	tsk.Payload = "secret"
+	tsk.EncryptPayload = true
+	tsk.PayloadKMSKeyID = "alias/callme"
+	if _, err := cm.ConfirmTask(tsk); err != nil {
+		t.Fatal(err)
+	}
+
+	item := ddb.item("t0", "1800000000")
+	if _, ok := item["payload"]; ok || stringAttribute(item, "encrypted_payload") == "" {
+		t.Error("Expected only the encrypted payload to be stored, got", item)
+	}
+	if stringAttribute(item, "created_by") != task.CreatedByAPI {
+		t.Error("Expected the task to be created by the API, got", stringAttribute(item, "created_by"))
+	}
+}
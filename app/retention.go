@@ -0,0 +1,79 @@
+package app
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"go.uber.org/zap"
+)
+
+// clear the response bodies of tasks executed more than ResponseRetentionMinutes ago, if set; everything else about
+// the task, including its state and the response status, is kept
+func (c *CallMe) scrubResponseBodies() {
+	c.configMutex.RLock()
+	retention := c.ResponseRetentionMinutes
+	c.configMutex.RUnlock()
+	if retention <= 0 {
+		return
+	}
+
+	cutoff := time.Now().Unix() - int64(retention)*60
+	c.Logger.Info("Clearing old response bodies", zap.Int64("executed_before", cutoff))
+
+	scrubbed := 0
+	lastEvaluatedKey := make(map[string]*dynamodb.AttributeValue, 0)
+	for {
+		input := &dynamodb.ScanInput{
+			TableName:      aws.String(c.DynamoDBTable),
+			ConsistentRead: aws.Bool(false),
+			ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+				":cutoff": {S: aws.String(strconv.FormatInt(cutoff, 10))},
+				":empty":  {S: aws.String("")},
+			},
+			// tasks that have not been executed have either no executed_at or an empty one, which sorts first
+			FilterExpression:     aws.String("size(executed_at) > 0 AND executed_at < :cutoff AND response_body <> :empty"),
+			ProjectionExpression: aws.String("trigger_at, task_name"),
+		}
+		if len(lastEvaluatedKey) > 0 {
+			input.ExclusiveStartKey = lastEvaluatedKey
+		}
+
+		result, err := c.ddb.Scan(input)
+		if err != nil {
+			c.Logger.Error("Failed Scan while clearing response bodies", zap.Error(err))
+			return
+		}
+
+		for _, item := range result.Items {
+			_, err := c.ddb.UpdateItem(&dynamodb.UpdateItemInput{
+				TableName: aws.String(c.DynamoDBTable),
+				Key: map[string]*dynamodb.AttributeValue{
+					"trigger_at": item["trigger_at"],
+					"task_name":  item["task_name"],
+				},
+				UpdateExpression: aws.String("SET response_body = :empty"),
+				// the task may have been deleted, or archived, in the meantime
+				ConditionExpression:       aws.String("attribute_exists(task_name)"),
+				ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{":empty": {S: aws.String("")}},
+			})
+			if err != nil {
+				if aerr, ok := err.(awserr.Error); ok && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+					continue
+				}
+				c.Logger.Error("Failed to clear response body", zap.Error(err))
+				return
+			}
+			scrubbed++
+		}
+
+		lastEvaluatedKey = result.LastEvaluatedKey
+		if len(lastEvaluatedKey) == 0 {
+			break
+		}
+	}
+
+	c.Logger.Info("Cleared old response bodies", zap.Int("cleared", scrubbed))
+}
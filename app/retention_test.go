@@ -0,0 +1,57 @@
+package app
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/marcoalmeida/callme/task"
+	"go.uber.org/zap"
+)
+
+func TestScrubResponseBodies(t *testing.T) {
+	now := time.Now().Unix()
+	old := task.Task{
+		Name:           "old",
+		TriggerAt:      "600",
+		TaskState:      task.Successful,
+		ResponseStatus: 200,
+		ResponseBody:   "sensitive",
+		ExecutedAt:     strconv.FormatInt(now-7200, 10),
+	}
+	recent := old
+	recent.Name = "recent"
+	recent.ExecutedAt = strconv.FormatInt(now-60, 10)
+
+	ddb := newMemoryClient()
+	c := &CallMe{Logger: zap.NewNop(), ddb: ddb, ResponseRetentionMinutes: 60}
+	for _, tsk := range []task.Task{old, recent} {
+		if err := c.UpsertTask(tsk); err != nil {
+			t.Fatal(err)
+		}
+	}
+	c.scrubResponseBodies()
+
+	item := ddb.item("old", "600")
+	if body := stringAttribute(item, "response_body"); body != "" {
+		t.Error("Expected the old response body to be cleared, got", body)
+	}
+	if stringAttribute(item, "task_state") != task.Successful || aws.StringValue(item["response_status"].N) != "200" ||
+		stringAttribute(item, "executed_at") != old.ExecutedAt {
+		t.Error("Expected everything else to be kept, got", item)
+	}
+	if body := stringAttribute(ddb.item("recent", "600"), "response_body"); body != "sensitive" {
+		t.Error("Expected the recent response body to be kept, got", body)
+	}
+
+	// disabled by default
+	if err := c.UpsertTask(old); err != nil {
+		t.Fatal(err)
+	}
+	c.ResponseRetentionMinutes = 0
+	c.scrubResponseBodies()
+	if body := stringAttribute(ddb.item("old", "600"), "response_body"); body != "sensitive" {
+		t.Error("Expected nothing to be cleared, got", body)
+	}
+}
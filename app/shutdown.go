@@ -0,0 +1,107 @@
+package app
+
+import (
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/marcoalmeida/callme/task"
+	"go.uber.org/zap"
+)
+
+const (
+	// how often to check whether all callbacks in progress have returned
+	shutdownPollInterval = 100 * time.Millisecond
+	// how long callbacks are given to return once canceled
+	shutdownCancelGrace = 5 * time.Second
+)
+
+// Shutdown stops starting new callbacks and waits up to ShutdownTimeoutSeconds for those in progress to complete.
+// Callbacks still running by then are canceled, and the tasks left running by this instance are set back to
+// pending, for the catch-up pass of some other instance to pick them up.
+func (c *CallMe) Shutdown() {
+	c.configMutex.RLock()
+	timeout := time.Duration(c.ShutdownTimeoutSeconds) * time.Second
+	c.configMutex.RUnlock()
+
+	c.stop()
+
+	c.Logger.Info("Shutting down, waiting for callbacks in progress", zap.Int("in_flight", c.inFlightCount()))
+	if c.waitForCallbacks(timeout) {
+		c.Logger.Info("All callbacks completed")
+		return
+	}
+
+	c.Logger.Error("Timed out waiting for callbacks, canceling them", zap.Int("in_flight", c.inFlightCount()))
+	c.cancelAllCallbacks()
+	// their outcome is discarded once canceled, but one may be being stored right now
+	c.waitForCallbacks(shutdownCancelGrace)
+
+	released, err := c.releaseRunningTasks()
+	if err != nil {
+		c.Logger.Error("Failed to set running tasks back to pending", zap.Error(err))
+	}
+	c.Logger.Info("Set running tasks back to pending", zap.Int("released", released))
+}
+
+// wait up to timeout for all callbacks in progress to return; returns false if some are still running
+func (c *CallMe) waitForCallbacks(timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for c.inFlightCount() > 0 {
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(shutdownPollInterval)
+	}
+
+	return true
+}
+
+// set all tasks left running by this instance back to pending
+func (c *CallMe) releaseRunningTasks() (int, error) {
+	released := 0
+	lastEvaluatedKey := make(map[string]*dynamodb.AttributeValue, 0)
+	for {
+		input := &dynamodb.ScanInput{
+			TableName:      aws.String(c.DynamoDBTable),
+			ConsistentRead: aws.Bool(true),
+			ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+				":running":  {S: aws.String(task.Running)},
+				":instance": {S: aws.String(c.InstanceID)},
+			},
+			FilterExpression: aws.String("task_state = :running AND claimed_by = :instance"),
+		}
+		if len(lastEvaluatedKey) > 0 {
+			input.ExclusiveStartKey = lastEvaluatedKey
+		}
+
+		result, err := c.ddb.Scan(input)
+		if err != nil {
+			return released, err
+		}
+
+		for _, item := range result.Items {
+			tsk, ok := c.unmarshalTask(item)
+			if !ok {
+				continue
+			}
+			tsk.TaskState = task.Pending
+			tsk.ClaimedBy = ""
+			err := c.updateTaskState(tsk)
+			if err == ErrTaskNotFound {
+				// deleted in the meantime
+				continue
+			}
+			if err != nil {
+				return released, err
+			}
+			c.Logger.Info("Released running task", zap.String("task", tsk.String()))
+			released++
+		}
+
+		lastEvaluatedKey = result.LastEvaluatedKey
+		if len(lastEvaluatedKey) == 0 {
+			return released, nil
+		}
+	}
+}
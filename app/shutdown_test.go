@@ -0,0 +1,74 @@
+package app
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/marcoalmeida/callme/task"
+	"github.com/marcoalmeida/callme/util"
+	"go.uber.org/zap"
+)
+
+func TestShutdown(t *testing.T) {
+	started := make(chan bool)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started <- true
+		// a callback that takes longer than the shutdown timeout
+		select {
+		case <-r.Context().Done():
+		case <-time.After(5 * time.Second):
+		}
+	}))
+	defer ts.Close()
+
+	ddb := newMemoryClient()
+	c := &CallMe{Logger: zap.NewNop(), ddb: ddb, httpClient: ts.Client(), InstanceID: "i0"}
+	newTask := func(name string) task.Task {
+		tsk := task.Task{
+			Name:             name,
+			TriggerAt:        strconv.FormatInt(util.GetUnixMinute(), 10),
+			CallbackEndpoint: ts.URL,
+			TaskState:        task.Pending,
+		}
+		tsk.SetDefaults("", 0)
+		return tsk
+	}
+
+	t0 := newTask("t0")
+	err := c.UpsertTask(t0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan bool)
+	go func() {
+		c.callback(t0)
+		done <- true
+	}()
+	<-started
+	if stringAttribute(ddb.item("t0", t0.TriggerAt), "task_state") != task.Running {
+		t.Fatal("Expected t0 to be running, got", stringAttribute(ddb.item("t0", t0.TriggerAt), "task_state"))
+	}
+
+	c.Shutdown()
+	select {
+	case <-done:
+	case <-time.After(3 * time.Second):
+		t.Fatal("Expected the callback to be canceled")
+	}
+	if stringAttribute(ddb.item("t0", t0.TriggerAt), "task_state") != task.Pending {
+		t.Error("Expected t0 to be set back to pending, got", stringAttribute(ddb.item("t0", t0.TriggerAt), "task_state"))
+	}
+	if stringAttribute(ddb.item("t0", t0.TriggerAt), "claimed_by") != "" {
+		t.Error("Expected t0 not to be claimed by any instance")
+	}
+
+	// no more callbacks once shutting down
+	t1 := newTask("t1")
+	c.callback(t1)
+	if item := ddb.item("t1", t1.TriggerAt); item != nil {
+		t.Error("Expected t1 to be left alone, got", item)
+	}
+}
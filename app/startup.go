@@ -0,0 +1,37 @@
+package app
+
+import (
+	"errors"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"go.uber.org/zap"
+)
+
+// make sure DynamoDB can be reached before going any further, e.g., DynamoDB Local may still be starting; up to
+// StartupRetryAttempts attempts are made, StartupRetryIntervalMs apart
+func (c *CallMe) waitForDynamoDB() error {
+	attempts := c.StartupRetryAttempts
+	if attempts < 1 {
+		attempts = 1
+	}
+
+	for i := 0; i < attempts; i++ {
+		_, err := c.ddb.ListTables(&dynamodb.ListTablesInput{Limit: aws.Int64(1)})
+		if err == nil {
+			return nil
+		}
+		c.Logger.Error(
+			"Failed to connect to DynamoDB",
+			zap.Error(err),
+			zap.Int("attempt", i+1),
+			zap.Int("max_attempts", attempts),
+		)
+		if i < attempts-1 {
+			time.Sleep(time.Duration(c.StartupRetryIntervalMs) * time.Millisecond)
+		}
+	}
+
+	return errors.New("DynamoDB is unreachable")
+}
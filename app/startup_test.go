@@ -0,0 +1,64 @@
+package app
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+// DynamoDB endpoint that is unavailable for the first few requests, e.g., still starting
+func delayedDynamoDB(unavailable int32) (*httptest.Server, *int32) {
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&requests, 1) <= unavailable {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Content-Type", "application/x-amz-json-1.0")
+		w.Write([]byte(`{"TableNames": ["callme-tasks"]}`))
+	}))
+
+	return server, &requests
+}
+
+func TestWaitForDynamoDB(t *testing.T) {
+	tests := []struct {
+		unavailable int32
+		attempts    int
+		succeed     bool
+	}{
+		{0, 10, true},
+		{3, 10, true},
+		{3, 3, false},
+	}
+
+	for _, test := range tests {
+		server, requests := delayedDynamoDB(test.unavailable)
+
+		c := &CallMe{
+			Logger:                 zap.NewNop(),
+			DynamoDBEndpoint:       server.URL,
+			DynamoDBRegion:         "us-east-1",
+			StartupRetryAttempts:   test.attempts,
+			StartupRetryIntervalMs: 10,
+			local:                  true,
+		}
+		c.ddb = connectToDynamoDB(c.dynamoDBConfig(c.DynamoDBRegion, true))
+
+		err := c.waitForDynamoDB()
+		if test.succeed && err != nil {
+			t.Error("Expected to succeed, failed with", err)
+		}
+		if !test.succeed && err == nil {
+			t.Error("Expected to fail after", test.attempts, "attempts")
+		}
+		if !test.succeed && atomic.LoadInt32(requests) != int32(test.attempts) {
+			t.Error("Expected", test.attempts, "attempts, got", atomic.LoadInt32(requests))
+		}
+
+		server.Close()
+	}
+}
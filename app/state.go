@@ -0,0 +1,156 @@
+package app
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/marcoalmeida/callme/task"
+	"github.com/marcoalmeida/callme/util"
+	"go.uber.org/zap"
+)
+
+// key schema of the optional index on task_state
+var stateIndexKeySchema = []*dynamodb.KeySchemaElement{
+	{AttributeName: aws.String("task_state"), KeyType: aws.String(dynamodb.KeyTypeHash)},
+	{AttributeName: aws.String("trigger_at"), KeyType: aws.String(dynamodb.KeyTypeRange)},
+}
+
+// add the index on task_state to the tasks table; it's built in the background, until then tasks are scanned
+// only works on tables with on-demand capacity, otherwise the index needs to be created by other means
+func (c *CallMe) createStateIndex() {
+	input := &dynamodb.UpdateTableInput{
+		TableName: aws.String(c.DynamoDBTable),
+		AttributeDefinitions: []*dynamodb.AttributeDefinition{
+			{AttributeName: aws.String("task_state"), AttributeType: aws.String(dynamodb.ScalarAttributeTypeS)},
+			{AttributeName: aws.String("trigger_at"), AttributeType: aws.String(dynamodb.ScalarAttributeTypeS)},
+		},
+		GlobalSecondaryIndexUpdates: []*dynamodb.GlobalSecondaryIndexUpdate{
+			{
+				Create: &dynamodb.CreateGlobalSecondaryIndexAction{
+					IndexName:  aws.String(c.DynamoDBStateIndex),
+					KeySchema:  stateIndexKeySchema,
+					Projection: &dynamodb.Projection{ProjectionType: aws.String(dynamodb.ProjectionTypeAll)},
+				},
+			},
+		},
+	}
+	_, err := c.ddb.UpdateTable(input)
+	if err != nil {
+		c.Logger.Error(
+			"Failed to create the index on task_state",
+			zap.Error(err),
+			zap.String("index", c.DynamoDBStateIndex),
+		)
+		return
+	}
+
+	c.Logger.Info("Creating the index on task_state", zap.String("index", c.DynamoDBStateIndex))
+	err = c.RefreshTableMetadata()
+	if err != nil {
+		c.Logger.Error("Failed to describe the tasks table", zap.Error(err))
+	}
+}
+
+// one page of all tasks; tasks in a given state are found with the index on task_state, if it's available
+func (c *CallMe) listTasks(table string, opts StatusOptions) (Status, error) {
+	// the archive table has no such index
+	indexed := table == c.DynamoDBTable && c.DynamoDBStateIndex != "" && c.hasActiveIndex(c.DynamoDBStateIndex)
+	if opts.State != "" && indexed {
+		return c.queryByState(table, opts)
+	}
+
+	return c.scanTasks(table, opts)
+}
+
+// one page of the tasks in a given state, using the index on task_state
+func (c *CallMe) queryByState(table string, opts StatusOptions) (Status, error) {
+	status := Status{}
+
+	input := &dynamodb.QueryInput{
+		TableName: aws.String(table),
+		IndexName: aws.String(c.DynamoDBStateIndex),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":state": {S: aws.String(opts.State)},
+		},
+		KeyConditionExpression: aws.String("task_state = :state"),
+		ReturnConsumedCapacity: returnConsumedCapacity(opts),
+	}
+	// collecting all pages to sort them can only be limited after the fact
+	if limit := c.statusLimit(opts); limit > 0 && opts.SortDirection == "" {
+		input.Limit = aws.Int64(int64(limit))
+	}
+
+	// trigger_at is the range key of the index, it can only be used in the key condition
+	if opts.FutureOnly {
+		input.ExpressionAttributeValues[":now"] = &dynamodb.AttributeValue{
+			S: aws.String(strconv.FormatInt(util.GetUnixMinute(), 10)),
+		}
+		input.KeyConditionExpression = aws.String("task_state = :state AND trigger_at > :now")
+	} else if opts.DueBy > 0 {
+		input.ExpressionAttributeValues[":due_by"] = &dynamodb.AttributeValue{
+			S: aws.String(strconv.FormatInt(opts.DueBy, 10)),
+		}
+		input.KeyConditionExpression = aws.String("task_state = :state AND trigger_at <= :due_by")
+	}
+	conditions := make([]string, 0)
+	if opts.SlowerThanMs > 0 {
+		input.ExpressionAttributeValues[":threshold"] = &dynamodb.AttributeValue{
+			N: aws.String(strconv.FormatInt(opts.SlowerThanMs, 10)),
+		}
+		conditions = append(conditions, "execution_duration_ms > :threshold")
+	}
+	if opts.CreatedBy != "" {
+		conditions = append(conditions, createdByCondition(opts.CreatedBy, input.ExpressionAttributeValues))
+	}
+	if len(opts.Labels) > 0 {
+		input.ExpressionAttributeNames = make(map[string]*string)
+		conditions = append(
+			conditions,
+			labelsCondition(opts.Labels, input.ExpressionAttributeNames, input.ExpressionAttributeValues),
+		)
+	}
+	if opts.NamePrefix != "" {
+		input.ExpressionAttributeValues[":name_prefix"] = &dynamodb.AttributeValue{S: aws.String(opts.NamePrefix)}
+		conditions = append(conditions, "begins_with(task_name, :name_prefix)")
+	}
+	if len(conditions) > 0 {
+		input.FilterExpression = aws.String(strings.Join(conditions, " AND "))
+	}
+
+	// we may be paginating this; the key of an index includes its own attributes as well as the table's
+	if opts.StartFrom.TriggerAt != "" && opts.StartFrom.Name != "" {
+		input.ExclusiveStartKey = map[string]*dynamodb.AttributeValue{
+			"task_name":  {S: aws.String(opts.StartFrom.Name)},
+			"trigger_at": {S: aws.String(opts.StartFrom.TriggerAt)},
+			"task_state": {S: aws.String(opts.State)},
+		}
+	}
+
+	result, err := c.ddb.Query(input)
+	if err != nil {
+		c.Logger.Error("Failed to query the index on task_state", zap.Error(err))
+		return status, err
+	}
+
+	status.Tasks = make([]task.Task, 0)
+	for _, i := range result.Items {
+		t, ok := c.unmarshalTask(i)
+		if ok {
+			status.Tasks = append(status.Tasks, t)
+		}
+	}
+	status.ConsumedCapacity = consumedCapacity(result.ConsumedCapacity)
+	// include the last evaluated key for pagination
+	next := task.Task{}
+	err = dynamodbattribute.UnmarshalMap(result.LastEvaluatedKey, &next)
+	if err != nil {
+		c.Logger.Error("Failed to UnmarshalMap last evaluated key", zap.Error(err))
+	} else {
+		status.Next = next
+	}
+
+	return status, nil
+}
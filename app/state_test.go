@@ -0,0 +1,104 @@
+package app
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"github.com/marcoalmeida/callme/task"
+	"go.uber.org/zap"
+)
+
+// DynamoDB client that finds the same failed task either by querying or scanning, keeping track of which one was used
+type stateClient struct {
+	dynamodbiface.DynamoDBAPI
+	indexStatus string
+	query       *dynamodb.QueryInput
+	scan        *dynamodb.ScanInput
+	update      *dynamodb.UpdateTableInput
+}
+
+var failedItem = map[string]*dynamodb.AttributeValue{
+	"task_name":  {S: aws.String("t0")},
+	"trigger_at": {S: aws.String("1800000000")},
+	"task_state": {S: aws.String(task.Failed)},
+}
+
+func (d *stateClient) Query(input *dynamodb.QueryInput) (*dynamodb.QueryOutput, error) {
+	d.query = input
+	return &dynamodb.QueryOutput{Items: []map[string]*dynamodb.AttributeValue{failedItem}}, nil
+}
+
+func (d *stateClient) Scan(input *dynamodb.ScanInput) (*dynamodb.ScanOutput, error) {
+	d.scan = input
+	return &dynamodb.ScanOutput{Items: []map[string]*dynamodb.AttributeValue{failedItem}}, nil
+}
+
+func (d *stateClient) DescribeTable(input *dynamodb.DescribeTableInput) (*dynamodb.DescribeTableOutput, error) {
+	table := &dynamodb.TableDescription{TableName: input.TableName}
+	if d.indexStatus != "" {
+		table.GlobalSecondaryIndexes = []*dynamodb.GlobalSecondaryIndexDescription{
+			{IndexName: aws.String("state_index"), IndexStatus: aws.String(d.indexStatus)},
+		}
+	}
+
+	return &dynamodb.DescribeTableOutput{Table: table}, nil
+}
+
+func (d *stateClient) UpdateTable(input *dynamodb.UpdateTableInput) (*dynamodb.UpdateTableOutput, error) {
+	d.update = input
+	d.indexStatus = dynamodb.IndexStatusCreating
+	return &dynamodb.UpdateTableOutput{}, nil
+}
+
+func TestStatus_stateIndex(t *testing.T) {
+	ddb := &stateClient{indexStatus: dynamodb.IndexStatusActive}
+	cm := &CallMe{DynamoDBTable: "callme", DynamoDBStateIndex: "state_index", Logger: zap.NewNop(), ddb: ddb}
+	_ = cm.RefreshTableMetadata()
+
+	status, err := cm.Status(task.Task{}, StatusOptions{State: task.Failed})
+	if err != nil || len(status.Tasks) != 1 {
+		t.Fatal("Expected 1 failed task, got", status.Tasks, err)
+	}
+	if ddb.scan != nil || aws.StringValue(ddb.query.IndexName) != "state_index" {
+		t.Error("Expected to query the index on task_state")
+	}
+	if aws.StringValue(ddb.query.ExpressionAttributeValues[":state"].S) != task.Failed {
+		t.Error("Expected to query failed tasks, got", ddb.query.ExpressionAttributeValues)
+	}
+}
+
+func TestStatus_stateScan(t *testing.T) {
+	// no index, the index is not ready yet, or it's not enabled
+	for _, cm := range []*CallMe{
+		{DynamoDBTable: "callme", DynamoDBStateIndex: "state_index", ddb: &stateClient{}},
+		{DynamoDBTable: "callme", DynamoDBStateIndex: "state_index", ddb: &stateClient{indexStatus: "CREATING"}},
+		{DynamoDBTable: "callme", ddb: &stateClient{indexStatus: dynamodb.IndexStatusActive}},
+	} {
+		cm.Logger = zap.NewNop()
+		_ = cm.RefreshTableMetadata()
+		ddb := cm.ddb.(*stateClient)
+
+		status, err := cm.Status(task.Task{}, StatusOptions{State: task.Failed})
+		if err != nil || len(status.Tasks) != 1 {
+			t.Fatal("Expected 1 failed task, got", status.Tasks, err)
+		}
+		if ddb.query != nil || aws.StringValue(ddb.scan.FilterExpression) != "task_state = :state" {
+			t.Error("Expected to scan the table filtering by state, got", ddb.scan)
+		}
+	}
+}
+
+func TestCreateStateIndex(t *testing.T) {
+	ddb := &stateClient{}
+	cm := &CallMe{DynamoDBTable: "callme", DynamoDBStateIndex: "state_index", Logger: zap.NewNop(), ddb: ddb}
+
+	cm.createStateIndex()
+	if ddb.update == nil || aws.StringValue(ddb.update.GlobalSecondaryIndexUpdates[0].Create.IndexName) != "state_index" {
+		t.Fatal("Expected the index to be created, got", ddb.update)
+	}
+	if !cm.HasIndex("state_index") || cm.hasActiveIndex("state_index") {
+		t.Error("Expected the index to be listed, but not yet active, got", cm.TableMetadata())
+	}
+}
@@ -0,0 +1,77 @@
+package app
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/marcoalmeida/callme/task"
+)
+
+// status of specific tasks, by table and key, kept for StatusCacheTTL seconds so that polling the same task over and
+// over does not read it from DynamoDB every time
+type statusCache struct {
+	entries sync.Map
+	hits    int64
+	misses  int64
+}
+
+type cachedStatus struct {
+	result Status
+	expiry time.Time
+}
+
+func statusCacheKey(table string, tsk task.Task) string {
+	return table + "|" + tsk.Name + "@" + tsk.TriggerAt
+}
+
+func (s *statusCache) get(key string, now time.Time) (Status, bool) {
+	entry, ok := s.entries.Load(key)
+	if !ok || now.After(entry.(cachedStatus).expiry) {
+		atomic.AddInt64(&s.misses, 1)
+		return Status{}, false
+	}
+	atomic.AddInt64(&s.hits, 1)
+
+	// callers are free to modify the tasks they get
+	status := entry.(cachedStatus).result
+	status.Tasks = append([]task.Task(nil), status.Tasks...)
+
+	return status, true
+}
+
+func (s *statusCache) add(key string, status Status, expiry time.Time) {
+	status.Tasks = append([]task.Task(nil), status.Tasks...)
+	s.entries.Store(key, cachedStatus{result: status, expiry: expiry})
+}
+
+// forget the status of a task that changed
+func (s *statusCache) invalidate(table string, tsk task.Task) {
+	s.entries.Delete(statusCacheKey(table, tsk))
+}
+
+// drop the entries that expired
+func (s *statusCache) evict(now time.Time) {
+	s.entries.Range(func(key, entry interface{}) bool {
+		if now.After(entry.(cachedStatus).expiry) {
+			s.entries.Delete(key)
+		}
+		return true
+	})
+}
+
+// periodically drop the statuses that expired, so that those of tasks no longer polled do not pile up
+func (c *CallMe) evictStatusCache() {
+	ticker := time.NewTicker(time.Duration(c.StatusCacheTTL) * time.Second)
+	defer ticker.Stop()
+
+	for now := range ticker.C {
+		c.statusCache.evict(now)
+	}
+}
+
+// StatusCacheStats returns the number of lookups of specific tasks answered from the cache, and those that had to
+// read them from DynamoDB, while StatusCacheTTL is set
+func (c *CallMe) StatusCacheStats() (int64, int64) {
+	return atomic.LoadInt64(&c.statusCache.hits), atomic.LoadInt64(&c.statusCache.misses)
+}
@@ -0,0 +1,132 @@
+package app
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/marcoalmeida/callme/task"
+	"go.uber.org/zap"
+)
+
+func TestStatus_cache(t *testing.T) {
+	ddb := newMemoryClient()
+	c := &CallMe{Logger: zap.NewNop(), ddb: ddb, DynamoDBTable: "tasks", StatusCacheTTL: 60}
+	tsk := task.Task{Name: "t0", TriggerAt: "1800000000", TaskState: task.Pending}
+	if err := c.UpsertTask(tsk); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 3; i++ {
+		status, err := c.Status(task.Task{Name: "t0", TriggerAt: "1800000000"}, StatusOptions{})
+		if err != nil || len(status.Tasks) != 1 || status.Tasks[0].TaskState != task.Pending {
+			t.Fatal("Expected the pending task, got", status, err)
+		}
+		// not shared with the cache
+		status.Tasks[0].TaskState = task.Failed
+	}
+	if ddb.reads != 1 {
+		t.Error("Expected the task to be read once, got", ddb.reads)
+	}
+	if hits, misses := c.StatusCacheStats(); hits != 2 || misses != 1 {
+		t.Error("Expected 2 hits and 1 miss, got", hits, misses)
+	}
+
+	// updating the task invalidates its status
+	tsk.TaskState = task.Successful
+	if err := c.UpsertTask(tsk); err != nil {
+		t.Fatal(err)
+	}
+	status, _ := c.Status(task.Task{Name: "t0", TriggerAt: "1800000000"}, StatusOptions{})
+	if ddb.reads != 2 || status.Tasks[0].TaskState != task.Successful {
+		t.Error("Expected the task to be read again, got", ddb.reads, status.Tasks)
+	}
+
+	// the capacity consumed requires reading it
+	_, _ = c.Status(task.Task{Name: "t0", TriggerAt: "1800000000"}, StatusOptions{ConsumedCapacity: true})
+	if ddb.reads != 3 {
+		t.Error("Expected the task to be read to report capacity, got", ddb.reads)
+	}
+
+	// expired entries are dropped
+	c.statusCache.evict(time.Now().Add(time.Minute + time.Second))
+	if _, ok := c.statusCache.entries.Load(statusCacheKey("tasks", tsk)); ok {
+		t.Error("Expected the expired status to be evicted")
+	}
+}
+
+func TestStatus_cacheDisabled(t *testing.T) {
+	ddb := newMemoryClient()
+	ddb.items["t0@1800000000"] = map[string]*dynamodb.AttributeValue{
+		"task_name":  {S: aws.String("t0")},
+		"trigger_at": {S: aws.String("1800000000")},
+	}
+	c := &CallMe{Logger: zap.NewNop(), ddb: ddb}
+
+	for i := 0; i < 2; i++ {
+		_, _ = c.Status(task.Task{Name: "t0", TriggerAt: "1800000000"}, StatusOptions{})
+	}
+	if ddb.reads != 2 {
+		t.Error("Expected every lookup to read the task, got", ddb.reads)
+	}
+}
+
+func TestStatus_cacheRetryAndConfirm(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ts.Close()
+
+	ddb := newMemoryClient()
+	c := &CallMe{
+		Logger:         zap.NewNop(),
+		ddb:            ddb,
+		httpClient:     ts.Client(),
+		DynamoDBTable:  "tasks",
+		StatusCacheTTL: 60,
+		MaxRetries:     1,
+	}
+	status := func(tsk task.Task) []task.Task {
+		t.Helper()
+		s, err := c.Status(task.Task{Name: tsk.Name, TriggerAt: tsk.TriggerAt}, StatusOptions{})
+		if err == ErrTaskNotFound {
+			return nil
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s.Tasks
+	}
+
+	// the failed entry is gone once retried
+	failed := task.Task{Name: "t0", TriggerAt: "1800000000", CallbackEndpoint: ts.URL, TaskState: task.Failed}
+	if err := c.UpsertTask(failed); err != nil {
+		t.Fatal(err)
+	}
+	if tasks := status(failed); len(tasks) != 1 || tasks[0].TaskState != task.Failed {
+		t.Fatal("Expected the failed task, got", tasks)
+	}
+	if _, err := c.RetryTask(failed); err != nil {
+		t.Fatal(err)
+	}
+	if tasks := status(failed); len(tasks) != 0 {
+		t.Error("Expected the failed task to be gone, got", tasks)
+	}
+
+	// confirmed reservations are no longer reported as reserved
+	reserved, err := c.ReserveTask(task.Task{Name: "t1", TriggerAt: "1800000000"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tasks := status(reserved); len(tasks) != 1 || tasks[0].TaskState != task.Reserved {
+		t.Fatal("Expected the reserved task, got", tasks)
+	}
+	_, err = c.ConfirmTask(task.Task{Name: "t1", TriggerAt: "1800000000", CallbackEndpoint: ts.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tasks := status(reserved); len(tasks) != 1 || tasks[0].TaskState != task.Pending {
+		t.Error("Expected the confirmed task to be pending, got", tasks)
+	}
+}
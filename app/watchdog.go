@@ -0,0 +1,82 @@
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// notification sent to NotificationWebhook
+type notification struct {
+	InstanceID string `json:"instance_id"`
+	Message    string `json:"message"`
+}
+
+// compare the time the main loop woke up with the time it was expected to; falling behind means some minutes are
+// never checked for tasks to run
+func (c *CallMe) checkLoopDrift(expected time.Time, now time.Time) {
+	c.configMutex.RLock()
+	maxDriftMs := c.MaxLoopDriftMs
+	alertAfter := c.LoopDriftAlertAfter
+	c.configMutex.RUnlock()
+
+	drift := now.Sub(expected).Milliseconds()
+	if drift <= int64(maxDriftMs) {
+		c.driftyIterations = 0
+		return
+	}
+
+	atomic.AddInt64(&c.loopDrifts, 1)
+	c.driftyIterations++
+	c.Logger.Error(
+		"The main loop is falling behind",
+		zap.Int64("drift_ms", drift),
+		zap.Int("consecutive", c.driftyIterations),
+	)
+
+	// once per streak
+	if alertAfter > 0 && c.driftyIterations == alertAfter {
+		go c.notify(fmt.Sprintf(
+			"The main loop fell behind on %d consecutive iterations, by %dms on the last one",
+			c.driftyIterations,
+			drift,
+		))
+	}
+}
+
+// LoopDrifts returns the number of iterations of the main loop that fell behind by more than MaxLoopDriftMs
+func (c *CallMe) LoopDrifts() int64 {
+	return atomic.LoadInt64(&c.loopDrifts)
+}
+
+// post a message to the notification webhook, if there is one
+func (c *CallMe) notify(msg string) {
+	c.configMutex.RLock()
+	webhook := c.NotificationWebhook
+	c.configMutex.RUnlock()
+
+	if webhook == "" {
+		return
+	}
+
+	body, err := json.Marshal(notification{InstanceID: c.InstanceID, Message: msg})
+	if err != nil {
+		c.Logger.Error("Failed to marshal notification", zap.Error(err))
+		return
+	}
+
+	resp, err := c.httpClient.Post(webhook, "application/json", bytes.NewReader(body))
+	if err != nil {
+		c.Logger.Error("Failed to send notification", zap.Error(err))
+		return
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		c.Logger.Error("Notification webhook failed", zap.Int("http_status", resp.StatusCode))
+	}
+}
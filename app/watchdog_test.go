@@ -0,0 +1,70 @@
+package app
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+func TestCheckLoopDrift(t *testing.T) {
+	notifications := make(chan notification, 10)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var n notification
+		_ = json.NewDecoder(r.Body).Decode(&n)
+		notifications <- n
+	}))
+	defer ts.Close()
+
+	c := &CallMe{
+		Logger:              zap.NewNop(),
+		httpClient:          ts.Client(),
+		InstanceID:          "i0",
+		MaxLoopDriftMs:      defaultMaxLoopDriftMs,
+		LoopDriftAlertAfter: 2,
+		NotificationWebhook: ts.URL,
+	}
+	expected := time.Now()
+
+	// within the limit
+	c.checkLoopDrift(expected, expected.Add(time.Second))
+	if c.LoopDrifts() != 0 {
+		t.Error("Expected no drift, got", c.LoopDrifts())
+	}
+
+	// a single late iteration is not enough to alert
+	c.checkLoopDrift(expected, expected.Add(10*time.Second))
+	c.checkLoopDrift(expected, expected)
+	c.checkLoopDrift(expected, expected.Add(10*time.Second))
+	if c.LoopDrifts() != 2 {
+		t.Error("Expected 2 drifts, got", c.LoopDrifts())
+	}
+	select {
+	case n := <-notifications:
+		t.Error("Expected no notification, got", n)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	// second in a row, and only once per streak
+	c.checkLoopDrift(expected, expected.Add(10*time.Second))
+	c.checkLoopDrift(expected, expected.Add(10*time.Second))
+	select {
+	case n := <-notifications:
+		if n.InstanceID != "i0" || n.Message == "" {
+			t.Error("Unexpected notification", n)
+		}
+	case <-time.After(time.Second):
+		t.Error("Expected a notification")
+	}
+	select {
+	case n := <-notifications:
+		t.Error("Expected a single notification, got", n)
+	case <-time.After(100 * time.Millisecond):
+	}
+	if c.LoopDrifts() != 4 {
+		t.Error("Expected 4 drifts, got", c.LoopDrifts())
+	}
+}
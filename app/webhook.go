@@ -0,0 +1,112 @@
+package app
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/marcoalmeida/callme/task"
+	"github.com/marcoalmeida/callme/util"
+	"go.uber.org/zap"
+)
+
+// header carrying the HMAC-SHA256 of the body of status updates, keyed with StatusWebhookSecret (hex)
+const statusSignatureHeader = "X-Callme-Signature"
+
+// change in the state of a task, pushed to StatusWebhookEndpoint
+type statusUpdate struct {
+	TaskID    string `json:"task_id"`
+	OldState  string `json:"old_state"`
+	NewState  string `json:"new_state"`
+	Timestamp string `json:"timestamp"`
+}
+
+// queue a change in the state of a task to be pushed to the status webhook; updates are dropped rather than holding up
+// the callback if the queue is full
+func (c *CallMe) publishStatus(tsk task.Task, oldState string) {
+	if c.statusUpdates == nil {
+		return
+	}
+
+	update := statusUpdate{
+		TaskID:    inFlightKey(tsk),
+		OldState:  oldState,
+		NewState:  tsk.TaskState,
+		Timestamp: time.Now().UTC().Format(time.RFC3339),
+	}
+	select {
+	case c.statusUpdates <- update:
+	default:
+		c.Logger.Error("Dropping status update, the queue is full", zap.String("task", tsk.String()))
+	}
+}
+
+// push queued status updates to the status webhook, one at a time, until the queue is closed
+func (c *CallMe) deliverStatusUpdates() {
+	for update := range c.statusUpdates {
+		c.deliverStatusUpdate(update)
+	}
+}
+
+// push a status update, retrying up to MaxRetries times on errors and server side failures
+func (c *CallMe) deliverStatusUpdate(update statusUpdate) {
+	body, err := json.Marshal(update)
+	if err != nil {
+		c.Logger.Error("Failed to marshal status update", zap.Error(err))
+		return
+	}
+
+	c.configMutex.RLock()
+	maxRetries := c.MaxRetries
+	c.configMutex.RUnlock()
+
+	c.postSigned(c.StatusWebhookEndpoint, c.StatusWebhookSecret, body, maxRetries, c.Logger.With(
+		zap.String("webhook", "status"),
+		zap.String("task_id", update.TaskID),
+	))
+}
+
+// POST a JSON body, signed with secret, to a webhook, making up to attempts attempts on errors and server side
+// failures; returns true iff it was delivered
+func (c *CallMe) postSigned(endpoint string, secret string, body []byte, attempts int, logger *zap.Logger) bool {
+	for i := 0; i < attempts; i++ {
+		req, err := http.NewRequest("POST", endpoint, bytes.NewReader(body))
+		if err != nil {
+			logger.Error("Failed to create webhook request", zap.Error(err))
+			return false
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(statusSignatureHeader, signStatusUpdate(body, secret))
+
+		resp, err := c.httpClient.Do(req)
+		if err == nil {
+			resp.Body.Close()
+			if resp.StatusCode >= 200 && resp.StatusCode <= 299 {
+				return true
+			}
+			// client side errors won't go away by retrying
+			if resp.StatusCode >= 400 && resp.StatusCode <= 499 {
+				logger.Error("Webhook rejected request", zap.Int("http_status", resp.StatusCode))
+				return false
+			}
+		}
+		logger.Error("Failed to deliver to webhook", zap.Error(err), zap.Int("attempt", i))
+		if i < attempts-1 {
+			util.Backoff(i, logger)
+		}
+	}
+
+	return false
+}
+
+// HMAC-SHA256 of the body of a webhook request, hex encoded
+func signStatusUpdate(body []byte, secret string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
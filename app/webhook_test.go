@@ -0,0 +1,73 @@
+package app
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/marcoalmeida/callme/task"
+	"go.uber.org/zap"
+)
+
+func TestStatusWebhook(t *testing.T) {
+	attempts := 0
+	received := make(chan statusUpdate, 1)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		// fail the first attempt
+		if attempts == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		body, _ := ioutil.ReadAll(r.Body)
+		if r.Header.Get(statusSignatureHeader) != signStatusUpdate(body, "s3cr3t") {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		var update statusUpdate
+		json.Unmarshal(body, &update)
+		received <- update
+	}))
+	defer ts.Close()
+
+	c := &CallMe{
+		Logger:                zap.NewNop(),
+		httpClient:            ts.Client(),
+		MaxRetries:            2,
+		StatusWebhookEndpoint: ts.URL,
+		StatusWebhookSecret:   "s3cr3t",
+		statusUpdates:         make(chan statusUpdate, 1),
+	}
+	go c.deliverStatusUpdates()
+	defer close(c.statusUpdates)
+
+	c.publishStatus(task.Task{Name: "t0", TriggerAt: "600", TaskState: task.Successful}, task.Running)
+	select {
+	case update := <-received:
+		if update.TaskID != "t0@600" || update.OldState != task.Running || update.NewState != task.Successful {
+			t.Error("Expected t0@600 to go from running to successful, got", update)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected a status update")
+	}
+}
+
+func TestPublishStatus_fullQueue(t *testing.T) {
+	c := &CallMe{Logger: zap.NewNop(), statusUpdates: make(chan statusUpdate, 1)}
+
+	// nothing is delivering updates, and publishing never blocks
+	tsk := task.Task{Name: "t0", TriggerAt: "600", TaskState: task.Running}
+	c.publishStatus(tsk, task.Pending)
+	c.publishStatus(tsk, task.Pending)
+	if len(c.statusUpdates) != 1 {
+		t.Error("Expected 1 queued update, got", len(c.statusUpdates))
+	}
+
+	// disabled without a webhook
+	c.statusUpdates = nil
+	c.publishStatus(tsk, task.Pending)
+}
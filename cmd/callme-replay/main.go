@@ -0,0 +1,100 @@
+// callme-replay replays the requests recorded in a replay log (REPLAY_LOG) against a callme server, in the same
+// order, and reports those answered with a status code other than the one originally returned
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/marcoalmeida/callme/app"
+)
+
+// value of the headers redacted from the replay log
+const redacted = "<redacted>"
+
+func main() {
+	target := flag.String("target", "http://localhost:8080", "URL of the server to replay the requests against")
+	token := flag.String("token", "", "admin token sent instead of the one redacted from the log, if any")
+	timeout := flag.Duration("timeout", 10*time.Second, "timeout of each request")
+	flag.Parse()
+	if flag.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "Usage: callme-replay [-target URL] [-token TOKEN] <replay log>")
+		os.Exit(2)
+	}
+
+	f, err := os.Open(flag.Arg(0))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Failed to open the replay log:", err)
+		os.Exit(2)
+	}
+	defer f.Close()
+
+	client := &http.Client{Timeout: *timeout}
+	replayed, diverged := 0, 0
+	scanner := bufio.NewScanner(f)
+	// request bodies, e.g., CSV imports, may be larger than the default limit
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	for scanner.Scan() {
+		var entry app.ReplayEntry
+		err := json.Unmarshal(scanner.Bytes(), &entry)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Skipping malformed entry:", err)
+			continue
+		}
+
+		status, err := replay(client, strings.TrimSuffix(*target, "/"), *token, entry)
+		replayed++
+		if err != nil {
+			diverged++
+			fmt.Printf("%s %s: expected %d, failed: %s\n", entry.Method, entry.Path, entry.Status, err)
+			continue
+		}
+		if status != entry.Status {
+			diverged++
+			fmt.Printf("%s %s: expected %d, got %d\n", entry.Method, entry.Path, entry.Status, status)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		fmt.Fprintln(os.Stderr, "Failed to read the replay log:", err)
+		os.Exit(2)
+	}
+
+	fmt.Printf("Replayed %d requests, %d diverged\n", replayed, diverged)
+	if diverged > 0 {
+		os.Exit(1)
+	}
+}
+
+// send a recorded request to target and return the status code of the response
+func replay(client *http.Client, target string, token string, entry app.ReplayEntry) (int, error) {
+	req, err := http.NewRequest(entry.Method, target+entry.Path, strings.NewReader(entry.Body))
+	if err != nil {
+		return 0, err
+	}
+	for k, values := range entry.Headers {
+		for _, v := range values {
+			// credentials were redacted when recorded
+			if v != redacted {
+				req.Header.Add(k, v)
+			}
+		}
+	}
+	req.Header.Del("Content-Length")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+
+	return resp.StatusCode, nil
+}
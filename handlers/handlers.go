@@ -1,13 +1,21 @@
 package handlers
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
-	"regexp"
+	"reflect"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/marcoalmeida/callme/app"
 	"github.com/marcoalmeida/callme/task"
@@ -15,11 +23,25 @@ import (
 	"go.uber.org/zap"
 )
 
+// longest a request to /status/ can wait for changes
+const maxStatusWait = time.Minute
+
+// tasks created with this header are stored as <namespace>:<task_name>, and only visible to requests that include it
+// with the same value; teams sharing a table can then use the same task names
+const (
+	namespaceHeader       = "X-Callme-Namespace"
+	namespaceSeparator    = ":"
+	maxNamespaceLength    = 64
+	invalidNamespaceError = "invalid namespace, expected up to 64 letters, digits, '-', or '_'"
+)
+
 // ResponseBody contains the necessary data to send an HTTP response back to the client. It should
 // be an interface that needs to be JSON-serialized before sending.
 type Response struct {
 	status int
 	data   interface{}
+	// optional headers
+	header http.Header
 }
 
 // Message provides a simple way of defining a response message that can easily be attached to ResponseBody
@@ -28,34 +50,231 @@ type message struct {
 	Error   string `json:"error,omitempty"`
 }
 
+// all the reasons a task definition is invalid, by field
+type validationErrors struct {
+	Error  string                `json:"error"`
+	Errors task.ValidationErrors `json:"errors"`
+}
+
+// global list of days off for calendar-aware tasks
+type holidays struct {
+	Holidays []string `json:"holidays"`
+}
+
+// recurring periods of time during which callbacks to some hosts are skipped or delayed
+type maintenanceWindows struct {
+	Windows []app.MaintenanceWindow `json:"maintenance_windows"`
+}
+
+// uniform shape of all responses, if requested with envelope=true
+type envelope struct {
+	Data  interface{}  `json:"data"`
+	Error string       `json:"error,omitempty"`
+	Meta  envelopeMeta `json:"meta"`
+}
+
+type envelopeMeta struct {
+	// value of start_from to fetch the next page
+	NextCursor string `json:"next_cursor,omitempty"`
+	// number of items in data, if it's a list
+	Count *int `json:"count,omitempty"`
+}
+
+// one task per payload, otherwise identical to the template
+type fanOutRequest struct {
+	Template task.Task `json:"template"`
+	Payloads []string  `json:"payloads"`
+}
+
+// identifiers of the tasks created out of a fan-out request, in the same order as their payloads
+type fanOut struct {
+	Message string   `json:"message"`
+	TaskIDs []string `json:"task_ids"`
+}
+
+// identifier of a reserved task, to be used to confirm it
+type reservation struct {
+	TaskID        string `json:"task_id"`
+	ReservedUntil string `json:"reserved_until"`
+}
+
+// response to the creation of a task; id, the components of task_id, is only included if requested
+type creation struct {
+	Message string          `json:"message"`
+	TaskID  string          `json:"task_id"`
+	ID      *taskIdentifier `json:"id,omitempty"`
+}
+
+// components of a task identifier
+type taskIdentifier struct {
+	TaskName  string `json:"task_name"`
+	UUID      string `json:"uuid,omitempty"`
+	TriggerAt string `json:"trigger_at"`
+}
+
+// state of the running instance
+type health struct {
+	Status         string `json:"status"`
+	DynamoDBRegion string `json:"dynamodb_region"`
+}
+
+// absolute time a trigger_at would be scheduled for
+type resolution struct {
+	TriggerAt string `json:"trigger_at"`
+	ISO8601   string `json:"iso8601"`
+}
+
+// whether tasks are being executed, see /admin/pause
+type readiness struct {
+	Status   string `json:"status"`
+	InFlight int    `json:"in_flight"`
+}
+
+// number of tasks found by a catch up pass, being replayed
+type flushResponse struct {
+	Enqueued int `json:"enqueued"`
+}
+
+// summary of a bulk task creation from a CSV file
+type csvResponse struct {
+	Created int           `json:"created"`
+	Errors  []csvRowError `json:"errors"`
+}
+
+// error found on a specific row of a CSV file; rows are numbered from 1, which is the header
+type csvRowError struct {
+	Row   int    `json:"row"`
+	Error string `json:"error"`
+}
+
+// a valid task read from a CSV file, along with the row it was found on
+type csvTask struct {
+	row  int
+	task task.Task
+}
+
+// webhook notification sent by Prometheus Alertmanager; alerts are kept as received, to be used as the payload
+type alertmanagerNotification struct {
+	Alerts []json.RawMessage `json:"alerts"`
+}
+
+// the fields of an Alertmanager alert that tasks are created from
+type alertmanagerAlert struct {
+	Status      string            `json:"status"`
+	Labels      map[string]string `json:"labels"`
+	Annotations map[string]string `json:"annotations"`
+	Fingerprint string            `json:"fingerprint"`
+}
+
+// error found on a specific alert of an Alertmanager notification; alerts are numbered from 1
+type alertError struct {
+	Alert int    `json:"alert"`
+	Error string `json:"error"`
+}
+
+// summary of the tasks created and canceled from an Alertmanager notification
+type alertmanagerResponse struct {
+	Created  int          `json:"created"`
+	Canceled int          `json:"canceled"`
+	Errors   []alertError `json:"errors"`
+}
+
 // Handler is used to set up all of the handlers in the basic environment on which we're service traffic
 type Handler struct {
 	App         *app.CallMe
 	handlerFunc func(e *app.CallMe, r *http.Request) *Response
 }
 
-// Register registers all handlers
+// pending tasks, for auto-scaling
+type pendingCount struct {
+	PendingTasks int64 `json:"pending_tasks"`
+}
+
+// Register registers all handlers on the instance's own mux, so that it can be used as an http.Handler
 func Register(app *app.CallMe) {
-	http.Handle("/task/", Handler{App: app, handlerFunc: taskHandler})
-	http.Handle("/reschedule/", Handler{App: app, handlerFunc: rescheduleHandler})
-	http.Handle("/status/", Handler{App: app, handlerFunc: statusHandler})
+	RegisterPrefix(app, app.Mux(), "")
+}
+
+// RegisterPrefix registers all handlers on mux under a given path prefix, e.g., /callme, so that it can be mounted
+// behind a reverse proxy without rewriting URLs; the prefix is removed before the request reaches the handlers
+func RegisterPrefix(app *app.CallMe, mux *http.ServeMux, prefix string) {
+	prefix = strings.TrimSuffix(prefix, "/")
+	handle := func(pattern string, h http.Handler) {
+		if prefix == "" {
+			mux.Handle(pattern, h)
+			return
+		}
+		mux.Handle(prefix+pattern, http.StripPrefix(prefix, h))
+	}
+
+	handle("/task/", Handler{App: app, handlerFunc: taskHandler})
+	handle("/reschedule/", Handler{App: app, handlerFunc: rescheduleHandler})
+	handle("/jobs/", Handler{App: app, handlerFunc: jobHandler})
+	handle("/status/", Handler{App: app, handlerFunc: statusHandler})
+	handle("/archive/", Handler{App: app, handlerFunc: archiveHandler})
+	handle("/tasks/csv", Handler{App: app, handlerFunc: tasksCSVHandler})
+	handle("/tasks/alertmanager", Handler{App: app, handlerFunc: alertmanagerHandler})
+	handle("/holidays", Handler{App: app, handlerFunc: holidaysHandler})
+	handle("/maintenance-windows", Handler{App: app, handlerFunc: maintenanceWindowsHandler})
+	handle("/health", Handler{App: app, handlerFunc: healthHandler})
+	handle("/readyz", Handler{App: app, handlerFunc: readyzHandler})
+	handle("/resolve", Handler{App: app, handlerFunc: resolveHandler})
+	handle("/config", Handler{App: app, handlerFunc: configHandler})
+	handle("/admin/reload", Handler{App: app, handlerFunc: reloadHandler})
+	handle("/admin/flush", Handler{App: app, handlerFunc: flushHandler})
+	handle("/admin/pause", Handler{App: app, handlerFunc: pauseHandler})
+	handle("/admin/resume", Handler{App: app, handlerFunc: resumeHandler})
+	handle("/admin/metadata", Handler{App: app, handlerFunc: metadataHandler})
+	handle("/metrics/pending-count", Handler{App: app, handlerFunc: pendingCountHandler})
+	handle("/stats/histogram", Handler{App: app, handlerFunc: histogramHandler})
+	// Prometheus expects plain text, not JSON
+	handle("/metrics", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		metricsHandler(app, w)
+	}))
 }
 
 // ServeHTTP implements http.Handler and sends the actual response back to the client.
 func (h Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	var err error
 	pretty := false
+	wrap := false
+
+	// the body of mutating requests is kept for the replay log, if enabled, before any handler consumes it
+	// up to the largest upload any endpoint accepts
+	var body []byte
+	var resp *Response
+	recorded := h.App.ReplayLogging() && isMutating(r.Method)
+	if recorded {
+		_, maxCSVUploadBytes := h.App.UploadLimits()
+		body, err = ioutil.ReadAll(http.MaxBytesReader(w, r.Body, int64(maxCSVUploadBytes)))
+		if err != nil {
+			resp = badRequestError("failed to read the request body: " + err.Error())
+			recorded = false
+		}
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	}
 
 	// we only care about ParseForm (which is idempotent, and safe to call even
-	// if already called by a handler) to get the pretty parameter which can be used
+	// if already called by a handler) to get the pretty and envelope parameters which can be used
 	// by any endpoint
 	err = r.ParseForm()
 	if err == nil {
 		_, pretty = r.Form["pretty"]
+		wrap = r.Form.Get("envelope") == "true"
 	}
 
 	// run the handler and get the response to be sent to the client
-	resp := h.handlerFunc(h.App, r)
+	if resp == nil {
+		resp = h.handlerFunc(h.App, r)
+	}
+	if recorded {
+		h.App.RecordRequest(r, body, resp.status)
+	}
+	for k, values := range resp.header {
+		for _, v := range values {
+			w.Header().Add(k, v)
+		}
+	}
 	// start by sending the HTTP status code
 	w.WriteHeader(resp.status)
 	// (try to) parse the JSON data and send the response
@@ -63,7 +282,11 @@ func (h Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	if pretty {
 		enc.SetIndent("", "    ")
 	}
-	err = enc.Encode(resp.data)
+	if wrap {
+		err = enc.Encode(newEnvelope(resp.data))
+	} else {
+		err = enc.Encode(resp.data)
+	}
 
 	// all we can do is log the error
 	if err != nil {
@@ -71,6 +294,41 @@ func (h Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// PUT, POST, and DELETE requests change tasks, or the configuration, and are written to the replay log
+func isMutating(method string) bool {
+	return method == http.MethodPut || method == http.MethodPost || method == http.MethodDelete
+}
+
+// wrap the data of a response in the same shape regardless of the endpoint: errors are moved out of the data, and
+// lists of tasks are counted and include the cursor to the next page, if any
+func newEnvelope(data interface{}) envelope {
+	e := envelope{Data: data}
+
+	switch d := data.(type) {
+	case message:
+		if d.Error != "" {
+			e.Data = nil
+			e.Error = d.Error
+		}
+	case validationErrors:
+		e.Data = d.Errors
+		e.Error = d.Error
+	case app.Status:
+		count := len(d.Tasks)
+		e.Meta.Count = &count
+		if d.Next.Name != "" && d.Next.TriggerAt != "" {
+			e.Meta.NextCursor = d.Next.Name + "@" + d.Next.TriggerAt
+		}
+	default:
+		if v := reflect.ValueOf(data); v.Kind() == reflect.Slice {
+			count := v.Len()
+			e.Meta.Count = &count
+		}
+	}
+
+	return e
+}
+
 // auxiliary function to respond with an internal server error
 func internalServerError(msg string) *Response {
 	return &Response{
@@ -86,6 +344,14 @@ func badRequestError(msg string) *Response {
 	}
 }
 
+// auxiliary function to respond with all the reasons a task definition is invalid
+func unprocessableEntityError(errs task.ValidationErrors) *Response {
+	return &Response{
+		status: http.StatusUnprocessableEntity,
+		data:   validationErrors{Error: "invalid task definition", Errors: errs},
+	}
+}
+
 func unknownMethodError() *Response {
 	return &Response{
 		status: http.StatusBadRequest,
@@ -93,6 +359,26 @@ func unknownMethodError() *Response {
 	}
 }
 
+// administrative endpoints require the request to include the header "Authorization: Bearer <ADMIN_TOKEN>";
+// returns nil if the request is authorized, the response to send back otherwise
+func requireAdmin(callme *app.CallMe, r *http.Request) *Response {
+	err := callme.Authorize(strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "))
+	switch err {
+	case nil:
+		return nil
+	case app.ErrAdminDisabled:
+		return &Response{
+			status: http.StatusForbidden,
+			data:   message{Error: err.Error()},
+		}
+	default:
+		return &Response{
+			status: http.StatusUnauthorized,
+			data:   message{Error: err.Error()},
+		}
+	}
+}
+
 func taskHandler(callme *app.CallMe, r *http.Request) *Response {
 	err := r.ParseForm()
 	if err != nil {
@@ -108,6 +394,38 @@ func taskHandler(callme *app.CallMe, r *http.Request) *Response {
 		}
 	}
 
+	// POST /task/reserve, a task named reserve can still be created with PUT
+	if taskName == "reserve" && r.Method == "POST" {
+		return reserveHandler(callme, r)
+	}
+	// likewise for POST /task/fanout
+	if taskName == "fanout" && r.Method == "POST" {
+		return fanOutHandler(callme, r)
+	}
+	// everything below refers to tasks by <task_name>, qualified with the namespace of the request
+	ns, err := requestNamespace(r)
+	if err != nil {
+		return badRequestError(err.Error())
+	}
+	// as chosen by the client, e.g., to name a new task
+	requested := taskName
+	taskName = namespaced(ns, taskName)
+	// /task/<task_name>@<trigger_at>/confirm
+	if strings.HasSuffix(taskName, "/confirm") {
+		return confirmHandler(callme, r, strings.TrimSuffix(taskName, "/confirm"))
+	}
+	// /task/<task_name>@<trigger_at>/retry
+	if strings.HasSuffix(taskName, "/retry") {
+		return retryHandler(callme, r, strings.TrimSuffix(taskName, "/retry"))
+	}
+	// /task/<task_name>/park and /task/<task_name>/unpark
+	if strings.HasSuffix(taskName, "/park") {
+		return parkHandler(callme, r, strings.TrimSuffix(taskName, "/park"), callme.Park)
+	}
+	if strings.HasSuffix(taskName, "/unpark") {
+		return parkHandler(callme, r, strings.TrimSuffix(taskName, "/unpark"), callme.Unpark)
+	}
+
 	defer r.Body.Close()
 	payload, err := ioutil.ReadAll(r.Body)
 	if err != nil {
@@ -128,26 +446,37 @@ func taskHandler(callme *app.CallMe, r *http.Request) *Response {
 			return badRequestError(err.Error())
 		}
 
+		if errs := validateTaskNames(requested, t.OnSuccess); len(errs) > 0 {
+			return unprocessableEntityError(errs)
+		}
 		// the task name is provided in the URL, not the JSON payload
 		t.Name = taskName
+		t.OnSuccess = namespacedFollowUp(ns, t.OnSuccess)
+		// not something clients get to choose
+		t.CreatedBy = task.CreatedByAPI
 
-		// validate required fields
-		err = t.IsValid()
-		if err != nil {
-			return badRequestError(err.Error())
+		t, err = prepareTask(callme, t)
+		if errs, ok := err.(task.ValidationErrors); ok {
+			return unprocessableEntityError(errs)
 		}
-
-		// unmarshal will leave the .TriggerAt field with whatever value the user set,
-		// which may be a relative time specification;
-		// we parse it here so that a well defined Task instance is passed on to callme.CreateTask
-		triggerAt, err := parseTriggerAt(t.TriggerAt)
 		if err != nil {
 			return badRequestError(err.Error())
 		}
-		t.TriggerAt = triggerAt
 
-		// set defaults on all missing fields
-		t.SetDefaults()
+		// the task name is a key chosen by the client, repeating the same request has no effect
+		if r.Form.Get("upsert") == "true" {
+			outcome, err := callme.ReconcileTask(t)
+			if err != nil {
+				callme.Logger.Error("Failed to reconcile task", zap.Error(err))
+				return internalServerError(err.Error())
+			}
+			created := newCreation(withoutNamespace(ns, t), r.Form.Get("structured_id") == "true")
+			created.Message = "task " + outcome
+			return &Response{
+				status: http.StatusOK,
+				data:   created,
+			}
+		}
 
 		err = callme.CreateTask(t)
 		if err != nil {
@@ -157,198 +486,1445 @@ func taskHandler(callme *app.CallMe, r *http.Request) *Response {
 
 		return &Response{
 			status: http.StatusOK,
-			data:   message{Message: "task successfully registered"},
+			data:   newCreation(withoutNamespace(ns, t), r.Form.Get("structured_id") == "true"),
 		}
 	case "DELETE":
-		// TODO:
-		return &Response{
-			status: http.StatusNotImplemented,
-			data:   message{Error: "not yet implemented"},
+		name, triggerAt := parseTaskIdentifier(taskName)
+		if name == "" || triggerAt == "" {
+			return badRequestError("both task name and trigger_at are required: <task_name>@<trigger_at>")
+		}
+
+		err := callme.DeleteTask(task.Task{Name: name, TriggerAt: triggerAt})
+		switch err {
+		case nil:
+			return &Response{
+				status: http.StatusOK,
+				data:   message{Message: "task successfully deleted"},
+			}
+		case app.ErrTaskNotFound:
+			return &Response{
+				status: http.StatusNotFound,
+				data:   message{Error: err.Error()},
+			}
+		default:
+			return internalServerError(err.Error())
 		}
 	default:
 		return unknownMethodError()
 	}
 }
 
-// move a failed task back to the queue
-// - status of a specific task:             /reschedule/<task_name>@<trigger_at>
-// - status of all tasks with a given name: /reschedule/<task_name>
-// defaults to rescheduling only failed tasks, use ?all=true to override
-func rescheduleHandler(callme *app.CallMe, r *http.Request) *Response {
+// immediately retry a task that failed
+func retryHandler(callme *app.CallMe, r *http.Request, taskKey string) *Response {
 	// POST is the only method this endpoint handles
 	if r.Method != "POST" {
 		return unknownMethodError()
 	}
 
-	err := r.ParseForm()
-	if err != nil {
+	taskName, triggerAt := parseTaskIdentifier(taskKey)
+	if taskName == "" || triggerAt == "" {
+		return badRequestError("both task name and trigger_at are required: <task_name>@<trigger_at>")
+	}
+
+	tsk, err := callme.RetryTask(task.Task{Name: taskName, TriggerAt: triggerAt})
+	switch err {
+	case nil:
+		ns, _ := requestNamespace(r)
+		return &Response{
+			status: http.StatusOK,
+			data:   withoutNamespace(ns, tsk),
+		}
+	case app.ErrTaskNotFound:
+		return &Response{
+			status: http.StatusNotFound,
+			data:   message{Error: err.Error()},
+		}
+	case app.ErrTaskNotFailed, app.ErrMaxRetriesReached:
+		return &Response{
+			status: http.StatusConflict,
+			data:   message{Error: err.Error()},
+		}
+	default:
 		return internalServerError(err.Error())
 	}
+}
 
-	taskParam := r.URL.Path[len("/reschedule/"):]
+// create a placeholder for a task whose full definition is not yet known
+func reserveHandler(callme *app.CallMe, r *http.Request) *Response {
+	ns, err := requestNamespace(r)
+	if err != nil {
+		return badRequestError(err.Error())
+	}
 
-	// create a task instance, or part of it if the trigger timestamp is missing, out of the URL path
-	taskName, triggerAt := parseTaskIdentifier(taskParam)
-	tsk := task.Task{
-		Name:      taskName,
-		TriggerAt: triggerAt,
+	defer r.Body.Close()
+	t := task.Task{}
+	err = json.NewDecoder(r.Body).Decode(&t)
+	if err != nil {
+		return badRequestError(err.Error())
 	}
 
-	// get the new time on which the task is supposed to be retried
-	inputTriggerAt := r.Form.Get("trigger_at")
-	if inputTriggerAt == "" {
-		// default to running it now, with a little slack just in case the current minute is already being processed
-		inputTriggerAt = strconv.FormatInt(util.GetUnixMinute()+60, 10)
-	} else {
-		inputTriggerAt, err = parseTriggerAt(inputTriggerAt)
-		if err != nil {
-			return &Response{
-				status: http.StatusBadRequest,
-				data:   message{Error:err.Error()},
-			}
+	if t.Name == "" || t.TriggerAt == "" || strings.ContainsAny(t.Name, "@/") {
+		return badRequestError("both task_name and trigger_at are required to reserve a task")
+	}
+	if errs := validateTaskNames(t.Name, nil); len(errs) > 0 {
+		return badRequestError(errs.Error())
+	}
+	t.TriggerAt, err = task.NormalizeTriggerAt(t.TriggerAt)
+	if err != nil {
+		return badRequestError(err.Error())
+	}
+	t.Name = namespaced(ns, t.Name)
+
+	reserved, err := callme.ReserveTask(t)
+	switch err {
+	case nil:
+		reserved = withoutNamespace(ns, reserved)
+		return &Response{
+			status: http.StatusOK,
+			data:   reservation{TaskID: reserved.Name + "@" + reserved.TriggerAt, ReservedUntil: reserved.ReservedUntil},
+		}
+	case app.ErrTaskExists:
+		return &Response{
+			status: http.StatusConflict,
+			data:   message{Error: err.Error()},
 		}
+	default:
+		return internalServerError(err.Error())
 	}
+}
 
-	// process just the failed entries or all?
-	_, all := r.Form["all"]
+// create one task per payload out of a template; tasks are named <task_name>-<n>, n being the position of their payload,
+// and either all or none are created
+func fanOutHandler(callme *app.CallMe, r *http.Request) *Response {
+	ns, err := requestNamespace(r)
+	if err != nil {
+		return badRequestError(err.Error())
+	}
 
-	callme.Logger.Debug(
-		"Processing request for /reschedule/",
-		zap.String("task", tsk.String()),
-		zap.String("trigger_at", tsk.TriggerAt),
-		zap.Bool("all", all),
-	)
-	newTasks, err := callme.Reschedule(tsk, inputTriggerAt, all)
+	defer r.Body.Close()
+	req := fanOutRequest{}
+	err = json.NewDecoder(r.Body).Decode(&req)
 	if err != nil {
-		return &Response{
-			status: http.StatusInternalServerError,
-			data:   message{Error: err.Error()},
+		return badRequestError(err.Error())
+	}
+	if len(req.Payloads) == 0 || len(req.Payloads) > app.MaxFanOut {
+		return badRequestError("payloads must have between 1 and " + strconv.Itoa(app.MaxFanOut) + " elements")
+	}
+	if strings.ContainsAny(req.Template.Name, "@/") {
+		return badRequestError("task_name cannot include @ or /")
+	}
+	if errs := validateTaskNames(req.Template.Name, req.Template.OnSuccess); len(errs) > 0 {
+		return badRequestError(errs.Error())
+	}
+	// each task has its own payload, and they cannot all have the same UUID
+	if req.Template.Payload != "" || req.Template.PayloadRef != "" || req.Template.UUID != "" {
+		return badRequestError("the template cannot have a payload, payload_ref, or uuid")
+	}
+
+	// the template is validated once, without a payload, and each payload on its own
+	template := req.Template
+	template.Name = namespaced(ns, template.Name)
+	template.OnSuccess = namespacedFollowUp(ns, template.OnSuccess)
+	// not something clients get to choose
+	template.CreatedBy = task.CreatedByAPI
+	template, err = prepareTask(callme, template)
+	if errs, ok := err.(task.ValidationErrors); ok {
+		return unprocessableEntityError(errs)
+	}
+	if err != nil {
+		return badRequestError(err.Error())
+	}
+	maxPayloadBytes, _ := callme.UploadLimits()
+	errs := make(task.ValidationErrors, 0)
+	tasks := make([]task.Task, 0, len(req.Payloads))
+	for i, payload := range req.Payloads {
+		if maxPayloadBytes > 0 && len(payload) > maxPayloadBytes {
+			errs = append(errs, task.ValidationError{
+				Field:   "payloads[" + strconv.Itoa(i) + "]",
+				Message: fmt.Sprintf("payload too large: %d bytes, the maximum is %d", len(payload), maxPayloadBytes),
+			})
+			continue
 		}
+		t := template
+		t.Name = template.Name + "-" + strconv.Itoa(i)
+		t.Payload = payload
+		tasks = append(tasks, t)
+	}
+	if len(errs) > 0 {
+		return unprocessableEntityError(errs)
+	}
+
+	err = callme.CreateTasks(tasks)
+	if err != nil {
+		callme.Logger.Error("Failed to create tasks", zap.Error(err))
+		return internalServerError(err.Error())
+	}
+
+	created := fanOut{Message: "tasks successfully registered", TaskIDs: make([]string, 0, len(tasks))}
+	for _, t := range tasks {
+		t = withoutNamespace(ns, t)
+		created.TaskIDs = append(created.TaskIDs, t.Name+"@"+t.TriggerAt)
 	}
 
-	// respond with the updated task
 	return &Response{
 		status: http.StatusOK,
-		data:   newTasks,
+		data:   created,
 	}
 }
 
-// callme's global status:
-// - status of a specific task:             /status/<task_name>@<trigger_at>
-// - status of all tasks with a given name: /status/<task_name>[?start_from=<task_name>@<trigger_at>&future_only=true]
-// - status of all tasks:                   /status/?start_from=<task_name>@<trigger_at>[?future_only=true]
-func statusHandler(callme *app.CallMe, r *http.Request) *Response {
-	// GET is the only method this endpoint handles
-	if r.Method != "GET" {
+// replace a reserved task with its full definition
+func confirmHandler(callme *app.CallMe, r *http.Request, taskKey string) *Response {
+	// POST is the only method this endpoint handles
+	if r.Method != "POST" {
 		return unknownMethodError()
 	}
 
-	err := r.ParseForm()
+	taskName, triggerAt := parseTaskIdentifier(taskKey)
+	if taskName == "" || triggerAt == "" {
+		return badRequestError("both task name and trigger_at are required: <task_name>@<trigger_at>")
+	}
+
+	defer r.Body.Close()
+	t := task.Task{}
+	err := json.NewDecoder(r.Body).Decode(&t)
 	if err != nil {
-		return internalServerError(err.Error())
+		return badRequestError(err.Error())
 	}
 
-	taskParam := r.URL.Path[len("/status/"):]
+	// the task is identified by the URL, not the JSON payload
+	if errs := validateTaskNames("", t.OnSuccess); len(errs) > 0 {
+		return badRequestError(errs.Error())
+	}
+	ns, _ := requestNamespace(r)
+	t.Name = taskName
+	t.TriggerAt = triggerAt
+	t.OnSuccess = namespacedFollowUp(ns, t.OnSuccess)
+	t.CreatedBy = task.CreatedByAPI
+	t, err = prepareTask(callme, t)
+	if err != nil {
+		return badRequestError(err.Error())
+	}
 
-	// create a task instance, or part of it if the trigger timestamp is missing, out of the URL path
-	taskName, triggerAt := parseTaskIdentifier(taskParam)
-	tsk := task.Task{
-		Name:      taskName,
-		TriggerAt: triggerAt,
+	confirmed, err := callme.ConfirmTask(t)
+	switch err {
+	case nil:
+		return &Response{
+			status: http.StatusOK,
+			data:   withoutNamespace(ns, confirmed),
+		}
+	case app.ErrTaskNotReserved:
+		return &Response{
+			status: http.StatusNotFound,
+			data:   message{Error: err.Error()},
+		}
+	default:
+		return internalServerError(err.Error())
 	}
-	// create a task instance from the start_from parameter, necessary for pagination
-	taskName, triggerAt = parseTaskIdentifier(r.Form.Get("start_from"))
-	startFrom := task.Task{
-		Name:      taskName,
-		TriggerAt: triggerAt,
+}
+
+// park or unpark all tasks with a given name
+func parkHandler(callme *app.CallMe, r *http.Request, taskName string, update func(string) error) *Response {
+	// POST is the only method this endpoint handles
+	if r.Method != "POST" {
+		return unknownMethodError()
 	}
-	// in case the caller just wants us to list tasks scheduled at some point in the future
-	_, futureOnly := r.Form["future_only"]
 
-	callme.Logger.Debug(
-		"Processing request for /status/",
-		zap.String("task", tsk.String()),
-		zap.Bool("future_only", futureOnly),
-		zap.String("start_from", startFrom.String()),
-	)
-	status, err := callme.Status(tsk, startFrom, futureOnly)
+	if taskName == "" || strings.Contains(taskName, "@") {
+		return badRequestError("tasks are parked by name only: /task/<task_name>/park")
+	}
+
+	err := update(taskName)
 	if err != nil {
 		return internalServerError(err.Error())
 	}
 
 	return &Response{
 		status: http.StatusOK,
-		data:   status,
+		data:   message{Message: "parked tasks successfully updated"},
 	}
 }
 
-// given a task key of the form task_name@trigger_at, where trigger_at is optional,
-// parse it and return the individual components
-func parseTaskIdentifier(taskKey string) (string, string) {
-	taskKeyParts := strings.Split(taskKey, "@")
-	taskName := taskKeyParts[0]
-	triggerAt := ""
-	// trigger_at is optional
-	if len(taskKeyParts) == 2 {
-		triggerAt = taskKeyParts[1]
+// validate a user provided task definition and turn it into a well defined Task instance that can be passed on to
+// callme.CreateTask
+func prepareTask(callme *app.CallMe, t task.Task) (task.Task, error) {
+	// validate required fields, collecting all the reasons the task may be invalid
+	maxPayloadBytes, _ := callme.UploadLimits()
+	errs := t.Validate(maxPayloadBytes)
+	// a task can take up all callback slots, but no more than that
+	if callme.MaxConcurrentCallbacks > 0 && t.Weight > callme.MaxConcurrentCallbacks {
+		errs = append(errs, task.ValidationError{
+			Field:   "weight",
+			Message: "weight must not be greater than " + strconv.Itoa(callme.MaxConcurrentCallbacks),
+		})
 	}
 
-	return taskName, triggerAt
-}
-
-// if input is a relative time specification, return the corresponding Unix timestamp with 1-minute resolution
-// if the input provided is already a unix timestamp, ensure it uses 1-minute resolution
-func parseTriggerAt(input string) (string, error) {
-	// future Unix timestamps have way more than 3 characters
-	// a valid format is of the form `+<int><time_identifier>` which cannot be less than 3 chars
-	if len(input) < 3 {
-		return "", errors.New("invalid format for trigger_at: " + input)
-	}
-	// current minute
-	now := util.GetUnixMinute()
-
-	// are we being given a Unix time stamp or a relative time format?
-	// relative time specifications start with +
-	relative := input[:1] == "+"
-	if relative {
-		// validate the input
-		re := regexp.MustCompile("[+]([0-9]+)([mhd])")
-		parts := re.FindStringSubmatch(input)
-		if len(parts) != 3 {
-			return "", errors.New("invalid relative time specification")
-		}
-		// extract the relative time and compute the corresponding Unix time stamp
-		spec := parts[2]
-		inputTime, err := strconv.Atoi(parts[1])
+	// unmarshal will leave the .TriggerAt field with whatever value the user set,
+	// which may be a relative time specification
+	triggerAt := t.TriggerAt
+	if triggerAt != "" {
+		var err error
+		triggerAt, err = task.NormalizeTriggerAt(t.TriggerAt)
 		if err != nil {
-			return "", errors.New("invalid integer in relative time specification")
-		}
-		// convert whatever time value we received to seconds and add to the current time stamp
-		switch spec {
-		case "m":
-			return strconv.FormatInt(now+int64(inputTime)*60, 10), nil
-		case "h":
-			return strconv.FormatInt(now+int64(inputTime)*3600, 10), nil
-		case "d":
-			return strconv.FormatInt(now+int64(inputTime)*60*86400, 10), nil
-		default:
-			return "", errors.New("unknown relative time specifier")
+			errs = append(errs, task.ValidationError{Field: "trigger_at", Message: err.Error()})
 		}
+	}
+	if len(errs) > 0 {
+		return t, errs
+	}
+	t.TriggerAt = triggerAt
+
+	// set defaults on all missing fields
+	t.SetDefaults(callme.TaskDefaults())
+
+	return t, nil
+}
+
+// bulk schedule tasks from a CSV file uploaded as multipart/form-data (in the "file" field)
+// the first line is the header, with column names matching the JSON fields of a task definition
+func tasksCSVHandler(callme *app.CallMe, r *http.Request) *Response {
+	// POST is the only method this endpoint handles
+	if r.Method != "POST" {
+		return unknownMethodError()
+	}
+
+	_, maxCSVUploadBytes := callme.UploadLimits()
+	maxBytes := int64(maxCSVUploadBytes)
+	r.Body = http.MaxBytesReader(nil, r.Body, maxBytes)
+	err := r.ParseMultipartForm(maxBytes)
+	if err != nil {
+		return badRequestError("failed to parse the uploaded file: " + err.Error())
+	}
+
+	file, _, err := r.FormFile("file")
+	if err != nil {
+		return badRequestError("missing CSV file")
+	}
+	defer file.Close()
+
+	ns, err := requestNamespace(r)
+	if err != nil {
+		return badRequestError(err.Error())
+	}
+
+	tasks, errs, err := parseTasksCSV(callme, file)
+	if err != nil {
+		return badRequestError(err.Error())
+	}
+
+	created := 0
+	for _, t := range tasks {
+		t.task.Name = namespaced(ns, t.task.Name)
+		t.task.OnSuccess = namespacedFollowUp(ns, t.task.OnSuccess)
+		t.task.CreatedBy = task.CreatedByImport
+		err = callme.CreateTask(t.task)
+		if err != nil {
+			callme.Logger.Error("Failed to create task", zap.Error(err))
+			errs = append(errs, csvRowError{Row: t.row, Error: err.Error()})
+		} else {
+			created++
+		}
+	}
+
+	return &Response{
+		status: http.StatusOK,
+		data:   csvResponse{Created: created, Errors: errs},
+	}
+}
+
+// schedule tasks from the webhook notifications of Prometheus Alertmanager: a task is created for each firing alert,
+// unless one is still pending, and canceled once the alert is resolved, if it hasn't run yet
+func alertmanagerHandler(callme *app.CallMe, r *http.Request) *Response {
+	// POST is the only method this endpoint handles
+	if r.Method != "POST" {
+		return unknownMethodError()
+	}
+
+	defer r.Body.Close()
+	notification := alertmanagerNotification{}
+	err := json.NewDecoder(r.Body).Decode(&notification)
+	if err != nil {
+		return badRequestError("invalid Alertmanager notification: " + err.Error())
+	}
+
+	resp := alertmanagerResponse{Errors: make([]alertError, 0)}
+	for i, raw := range notification.Alerts {
+		alert := alertmanagerAlert{}
+		err := json.Unmarshal(raw, &alert)
+		if err != nil {
+			resp.Errors = append(resp.Errors, alertError{Alert: i + 1, Error: err.Error()})
+			continue
+		}
+
+		// tasks already scheduled for the same alert
+		pending, err := pendingTasks(callme, alertTaskName(alert))
+		if err != nil {
+			resp.Errors = append(resp.Errors, alertError{Alert: i + 1, Error: err.Error()})
+			continue
+		}
+
+		switch alert.Status {
+		case "firing":
+			// Alertmanager notifies of firing alerts repeatedly until they're resolved
+			if len(pending) > 0 {
+				continue
+			}
+			t, err := taskFromAlert(alert, raw)
+			if err == nil {
+				t, err = prepareTask(callme, t)
+			}
+			if err == nil {
+				err = callme.CreateTask(t)
+			}
+			if err != nil {
+				resp.Errors = append(resp.Errors, alertError{Alert: i + 1, Error: err.Error()})
+				continue
+			}
+			resp.Created++
+		case "resolved":
+			for _, t := range pending {
+				err := callme.DeleteTask(t)
+				if err != nil && err != app.ErrTaskNotFound {
+					resp.Errors = append(resp.Errors, alertError{Alert: i + 1, Error: err.Error()})
+					continue
+				}
+				resp.Canceled++
+			}
+		default:
+			resp.Errors = append(resp.Errors, alertError{Alert: i + 1, Error: "unknown alert status: " + alert.Status})
+		}
+	}
+
+	return &Response{
+		status: http.StatusOK,
+		data:   resp,
+	}
+}
+
+// pending tasks with a given name
+func pendingTasks(callme *app.CallMe, name string) ([]task.Task, error) {
+	status, err := callme.Status(task.Task{Name: name}, app.StatusOptions{})
+	if err != nil && err != app.ErrTaskNotFound {
+		return nil, err
+	}
+
+	pending := make([]task.Task, 0)
+	for _, t := range status.Tasks {
+		if t.TaskState == task.Pending {
+			pending = append(pending, t)
+		}
+	}
+
+	return pending, nil
+}
+
+// task to be scheduled for a firing alert: annotations prefixed with callme_ are mapped to the task fields of the
+// same name (as in CSV files), e.g., callme_callback, and the alert itself (JSON) is the payload; tasks are
+// scheduled for the next minute unless callme_trigger_at is set
+func taskFromAlert(alert alertmanagerAlert, raw []byte) (task.Task, error) {
+	header := make([]string, 0)
+	record := make([]string, 0)
+	for k, v := range alert.Annotations {
+		if strings.HasPrefix(k, "callme_") {
+			header = append(header, strings.TrimPrefix(k, "callme_"))
+			record = append(record, v)
+		}
+	}
+
+	t, err := taskFromCSVRecord(header, record)
+	if err != nil {
+		return t, err
+	}
+	t.Name = alertTaskName(alert)
+	t.Payload = string(raw)
+	t.CreatedBy = task.CreatedByAlert
+	if t.TriggerAt == "" {
+		t.TriggerAt = "+1m"
+	}
+	if alert.Labels["alertname"] != "" {
+		t.Labels = map[string]string{"alertname": alert.Labels["alertname"]}
+	}
+
+	return t, nil
+}
+
+// tasks are named after the fingerprint of the alert they were created for, so that they can be found once it's
+// resolved; the fingerprint is computed from the labels for the (older) versions of Alertmanager that don't send it
+func alertTaskName(alert alertmanagerAlert) string {
+	fingerprint := alert.Fingerprint
+	if fingerprint == "" {
+		names := make([]string, 0, len(alert.Labels))
+		for name := range alert.Labels {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		h := sha256.New()
+		for _, name := range names {
+			fmt.Fprintf(h, "%s=%s\n", name, alert.Labels[name])
+		}
+		fingerprint = hex.EncodeToString(h.Sum(nil))[:16]
+	}
+
+	return "alertmanager-" + fingerprint
+}
+
+// manage the global list of holidays (ISO 8601 dates) skipped by calendar-aware tasks
+func holidaysHandler(callme *app.CallMe, r *http.Request) *Response {
+	switch r.Method {
+	case "GET":
+		return &Response{
+			status: http.StatusOK,
+			data:   holidays{Holidays: callme.Holidays()},
+		}
+	case "PUT":
+		defer r.Body.Close()
+		payload, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			callme.Logger.Error("Failed to read request body", zap.Error(err))
+			return internalServerError("failed to read the request body")
+		}
+
+		h := holidays{}
+		err = json.Unmarshal(payload, &h)
+		if err != nil {
+			return badRequestError(err.Error())
+		}
+
+		for _, day := range h.Holidays {
+			_, err := time.Parse(util.DateLayout, day)
+			if err != nil {
+				return badRequestError("invalid date, expected YYYY-MM-DD: " + day)
+			}
+		}
+
+		err = callme.SetHolidays(h.Holidays)
+		if err != nil {
+			return internalServerError(err.Error())
+		}
+
+		return &Response{
+			status: http.StatusOK,
+			data:   message{Message: "holidays successfully updated"},
+		}
+	default:
+		return unknownMethodError()
+	}
+}
+
+// manage the maintenance windows during which callbacks to some hosts are skipped or delayed
+func maintenanceWindowsHandler(callme *app.CallMe, r *http.Request) *Response {
+	switch r.Method {
+	case "GET":
+		return &Response{
+			status: http.StatusOK,
+			data:   maintenanceWindows{Windows: callme.MaintenanceWindows()},
+		}
+	case "PUT":
+		defer r.Body.Close()
+		payload, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			callme.Logger.Error("Failed to read request body", zap.Error(err))
+			return internalServerError("failed to read the request body")
+		}
+
+		m := maintenanceWindows{}
+		err = json.Unmarshal(payload, &m)
+		if err != nil {
+			return badRequestError(err.Error())
+		}
+
+		err = app.ValidateMaintenanceWindows(m.Windows)
+		if err != nil {
+			return badRequestError(err.Error())
+		}
+
+		err = callme.SetMaintenanceWindows(m.Windows)
+		if err != nil {
+			return internalServerError(err.Error())
+		}
+
+		return &Response{
+			status: http.StatusOK,
+			data:   message{Message: "maintenance windows successfully updated"},
+		}
+	default:
+		return unknownMethodError()
+	}
+}
+
+// move a failed task back to the queue
+// - status of a specific task:             /reschedule/<task_name>@<trigger_at>
+// - status of all tasks with a given name: /reschedule/<task_name>
+// defaults to rescheduling only failed tasks, use ?all=true to override
+func rescheduleHandler(callme *app.CallMe, r *http.Request) *Response {
+	// POST is the only method this endpoint handles
+	if r.Method != "POST" {
+		return unknownMethodError()
+	}
+
+	err := r.ParseForm()
+	if err != nil {
+		return internalServerError(err.Error())
+	}
+
+	taskParam := r.URL.Path[len("/reschedule/"):]
+	ns, err := requestNamespace(r)
+	if err != nil {
+		return badRequestError(err.Error())
+	}
+
+	// create a task instance, or part of it if the trigger timestamp is missing, out of the URL path
+	taskName, triggerAt := parseTaskIdentifier(taskParam)
+	tsk := task.Task{
+		Name:      namespaced(ns, taskName),
+		TriggerAt: triggerAt,
+	}
+
+	// get the new time on which the task is supposed to be retried
+	inputTriggerAt := r.Form.Get("trigger_at")
+	if inputTriggerAt == "" {
+		// default to running it now, with a little slack just in case the current minute is already being processed
+		inputTriggerAt = strconv.FormatInt(util.GetUnixMinute()+60, 10)
 	} else {
-		// input is a Unix time stamp --> validate it
-		inputTime, err := strconv.Atoi(input)
+		inputTriggerAt, err = task.NormalizeTriggerAt(inputTriggerAt)
+		if err != nil {
+			return &Response{
+				status: http.StatusBadRequest,
+				data:   message{Error: err.Error()},
+			}
+		}
+	}
+
+	// process just the failed entries or all?
+	_, all := r.Form["all"]
+	// each rescheduled task can be a distinct execution, with its own UUID
+	newUUID := r.Form.Get("new_uuid") == "true"
+
+	callme.Logger.Debug(
+		"Processing request for /reschedule/",
+		zap.String("task", tsk.String()),
+		zap.String("trigger_at", tsk.TriggerAt),
+		zap.Bool("all", all),
+		zap.Bool("new_uuid", newUUID),
+	)
+	// rescheduling many tasks can take longer than clients are willing to wait, they can poll /jobs/<job_id> instead
+	if r.Form.Get("async") == "true" {
+		job, err := callme.RescheduleAsync(tsk, inputTriggerAt, all, newUUID)
+		if err == app.ErrTooManyJobs {
+			return &Response{
+				status: http.StatusServiceUnavailable,
+				data:   message{Error: err.Error()},
+			}
+		}
 		if err != nil {
-			return "", errors.New("invalid Unix time stamp: " + input)
+			return internalServerError(err.Error())
+		}
+
+		return &Response{
+			status: http.StatusAccepted,
+			data:   job,
+		}
+	}
+	newTasks, err := callme.Reschedule(tsk, inputTriggerAt, all, newUUID)
+	if err != nil {
+		return &Response{
+			status: http.StatusInternalServerError,
+			data:   message{Error: err.Error()},
+		}
+	}
+
+	for i := range newTasks {
+		newTasks[i] = withoutNamespace(ns, newTasks[i])
+	}
+	// respond with the updated task
+	return &Response{
+		status: http.StatusOK,
+		data:   newTasks,
+	}
+}
+
+// state of a job started in the background, e.g., by /reschedule/?async=true
+func jobHandler(callme *app.CallMe, r *http.Request) *Response {
+	// GET is the only method this endpoint handles
+	if r.Method != "GET" {
+		return unknownMethodError()
+	}
+
+	ns, err := requestNamespace(r)
+	if err != nil {
+		return badRequestError(err.Error())
+	}
+
+	job, err := callme.Job(r.URL.Path[len("/jobs/"):])
+	if err != nil {
+		return &Response{
+			status: http.StatusNotFound,
+			data:   message{Error: err.Error()},
+		}
+	}
+	for i := range job.Tasks {
+		job.Tasks[i] = withoutNamespace(ns, job.Tasks[i])
+	}
+
+	return &Response{
+		status: http.StatusOK,
+		data:   job,
+	}
+}
+
+// callme's global status:
+// - status of a specific task:             /status/<task_name>@<trigger_at>
+// - status of all tasks with a given name: /status/<task_name>[?start_from=<task_name>@<trigger_at>&future_only=true]
+// - status of all tasks:                   /status/?start_from=<task_name>@<trigger_at>[?future_only=true]
+func statusHandler(callme *app.CallMe, r *http.Request) *Response {
+	if r.URL.Path == "/status/slow" {
+		return slowHandler(callme, r)
+	}
+	if r.URL.Path == "/status/overdue" {
+		return overdueHandler(callme, r)
+	}
+
+	err := r.ParseForm()
+	if err != nil {
+		return internalServerError(err.Error())
+	}
+
+	// long-poll, returning once a task with the given name changes state, or the timeout elapses
+	if r.Form.Get("wait") != "" && r.Method == "GET" {
+		wait, err := time.ParseDuration(r.Form.Get("wait"))
+		if err != nil || wait <= 0 || wait > maxStatusWait {
+			return badRequestError("wait must be a positive duration, up to " + maxStatusWait.String())
 		}
-		// enforce time with 1-minute resolution
-		if inputTime%60 != 0 {
-			return "", errors.New("trigger_at must be on 1-minute resolution")
+		taskName, _ := parseTaskIdentifier(r.URL.Path[len("/status/"):])
+		if taskName == "" {
+			return badRequestError("wait requires a task name")
 		}
-		// make sure it's in the future
-		if int64(inputTime) <= now {
-			return "", errors.New("trigger_at must be in the future")
+		ns, err := requestNamespace(r)
+		if err != nil {
+			return badRequestError(err.Error())
 		}
-		// all good
-		return input, nil
+
+		callme.WaitForChange(r.Context(), namespaced(ns, taskName), wait)
+	}
+
+	return taskStatus(callme, r, "/status/", callme.Status)
+}
+
+// all tasks whose callback took longer than threshold_ms; otherwise the same as listing all tasks with /status/
+func slowHandler(callme *app.CallMe, r *http.Request) *Response {
+	err := r.ParseForm()
+	if err != nil {
+		return internalServerError(err.Error())
+	}
+
+	threshold, err := strconv.ParseInt(r.Form.Get("threshold_ms"), 10, 64)
+	if err != nil || threshold <= 0 {
+		return badRequestError("threshold_ms must be a positive integer")
 	}
+
+	return taskStatus(callme, r, "/status/slow", func(_ task.Task, opts app.StatusOptions) (app.Status, error) {
+		opts.SlowerThanMs = threshold
+		return callme.Status(task.Task{}, opts)
+	})
+}
+
+// pending tasks whose trigger_at is already past, oldest first, along with how long overdue each one is
+func overdueHandler(callme *app.CallMe, r *http.Request) *Response {
+	// GET is the only method this endpoint handles
+	if r.Method != "GET" {
+		return unknownMethodError()
+	}
+
+	err := r.ParseForm()
+	if err != nil {
+		return internalServerError(err.Error())
+	}
+
+	// tasks in other namespaces are never listed
+	ns, err := requestNamespace(r)
+	if err != nil {
+		return badRequestError(err.Error())
+	}
+	namePrefix := ""
+	if ns != "" {
+		namePrefix = ns + namespaceSeparator
+	}
+	limit := 0
+	if r.Form.Get("limit") != "" {
+		limit, err = strconv.Atoi(r.Form.Get("limit"))
+		if err != nil || limit <= 0 {
+			return badRequestError("limit must be a positive integer")
+		}
+	}
+
+	overdue, err := callme.Overdue(app.StatusOptions{Limit: limit, NamePrefix: namePrefix})
+	if err != nil {
+		return internalServerError(err.Error())
+	}
+	for i := range overdue.Tasks {
+		overdue.Tasks[i].Task = withoutNamespace(ns, overdue.Tasks[i].Task)
+	}
+
+	return &Response{
+		status: http.StatusOK,
+		data:   overdue,
+	}
+}
+
+// same as /status/ but for tasks that have been archived
+func archiveHandler(callme *app.CallMe, r *http.Request) *Response {
+	return taskStatus(callme, r, "/archive/", callme.ArchiveStatus)
+}
+
+// collect the status of the tasks identified in the path of a request to the given endpoint
+func taskStatus(
+	callme *app.CallMe,
+	r *http.Request,
+	endpoint string,
+	statusFunc func(task.Task, app.StatusOptions) (app.Status, error),
+) *Response {
+	// GET is the only method this endpoint handles
+	if r.Method != "GET" {
+		return unknownMethodError()
+	}
+
+	err := r.ParseForm()
+	if err != nil {
+		return internalServerError(err.Error())
+	}
+
+	taskParam := r.URL.Path[len(endpoint):]
+	// tasks in other namespaces are never looked up, nor listed
+	ns, err := requestNamespace(r)
+	if err != nil {
+		return badRequestError(err.Error())
+	}
+	namePrefix := ""
+	if ns != "" {
+		namePrefix = ns + namespaceSeparator
+	}
+
+	// create a task instance, or part of it if the trigger timestamp is missing, out of the URL path
+	taskName, triggerAt := parseTaskIdentifier(taskParam)
+	tsk := task.Task{
+		Name:      namespaced(ns, taskName),
+		TriggerAt: triggerAt,
+	}
+	// create a task instance from the start_from parameter, necessary for pagination
+	startFrom, err := parseCursor(r.Form.Get("start_from"))
+	if err != nil {
+		return badRequestError(err.Error())
+	}
+	startFrom.Name = namespaced(ns, startFrom.Name)
+	// in case the caller just wants us to list tasks scheduled at some point in the future
+	_, futureOnly := r.Form["future_only"]
+	// tasks can optionally be sorted by trigger_at
+	sortDirection := r.Form.Get("sort")
+	if !app.IsValidSortDirection(sortDirection) {
+		return badRequestError("sort must be one of " + app.SortAscending + " or " + app.SortDescending)
+	}
+	// useful to right-size tables, but not something to expose in production
+	consumedCapacity := r.Form.Get("capacity") == "true"
+	if consumedCapacity && !callme.Debug {
+		return badRequestError("capacity is only available in debug mode")
+	}
+	// listing tasks can be restricted to those created in a given way
+	createdBy := r.Form.Get("created_by")
+	// and to those with some labels, each one given as label=<key>=<value>
+	labels := make(map[string]string)
+	for _, label := range r.Form["label"] {
+		kv := strings.SplitN(label, "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			return badRequestError("invalid label, expected <key>=<value>: " + label)
+		}
+		labels[kv[0]] = kv[1]
+	}
+	// listing all tasks can be restricted to a given state
+	state := r.Form.Get("state")
+	if state != "" && !task.IsValidState(state) {
+		return badRequestError("invalid state: " + state)
+	}
+	// the server may return fewer tasks than requested
+	limit := 0
+	if r.Form.Get("limit") != "" {
+		limit, err = strconv.Atoi(r.Form.Get("limit"))
+		if err != nil || limit <= 0 {
+			return badRequestError("limit must be a positive integer")
+		}
+	}
+
+	callme.Logger.Debug(
+		"Processing request for "+endpoint,
+		zap.String("task", tsk.String()),
+		zap.Bool("future_only", futureOnly),
+		zap.String("start_from", startFrom.String()),
+		zap.String("sort", sortDirection),
+	)
+	status, err := statusFunc(tsk, app.StatusOptions{
+		StartFrom:        startFrom,
+		FutureOnly:       futureOnly,
+		SortDirection:    sortDirection,
+		ConsumedCapacity: consumedCapacity,
+		Limit:            limit,
+		State:            state,
+		CreatedBy:        createdBy,
+		Labels:           labels,
+		NamePrefix:       namePrefix,
+	})
+	if err != nil {
+		return internalServerError(err.Error())
+	}
+	// the tasks may be shared with other requests, e.g., coalesced or cached, and are not modified in place
+	tasks := make([]task.Task, 0, len(status.Tasks))
+	for _, t := range status.Tasks {
+		tasks = append(tasks, withoutNamespace(ns, t))
+	}
+	status.Tasks = tasks
+	status.Next = withoutNamespace(ns, status.Next)
+
+	resp := &Response{
+		status: http.StatusOK,
+		data:   status,
+	}
+	// let the client know the task may not reflect the latest changes
+	if status.EventuallyConsistent {
+		resp.header = http.Header{"X-Consistency": []string{"eventual"}}
+	}
+
+	return resp
+}
+
+// liveness, along with the DynamoDB region in use
+func healthHandler(callme *app.CallMe, r *http.Request) *Response {
+	// GET is the only method this endpoint handles
+	if r.Method != "GET" {
+		return unknownMethodError()
+	}
+
+	return &Response{
+		status: http.StatusOK,
+		data:   health{Status: "ok", DynamoDBRegion: callme.ActiveRegion()},
+	}
+}
+
+// ready to execute tasks, i.e., not paused; 503 Service Unavailable otherwise
+func readyzHandler(callme *app.CallMe, r *http.Request) *Response {
+	// GET is the only method this endpoint handles
+	if r.Method != "GET" {
+		return unknownMethodError()
+	}
+
+	if callme.Paused() {
+		return &Response{
+			status: http.StatusServiceUnavailable,
+			data:   readiness{Status: "paused", InFlight: callme.InFlight()},
+		}
+	}
+
+	return &Response{
+		status: http.StatusOK,
+		data:   readiness{Status: "ready", InFlight: callme.InFlight()},
+	}
+}
+
+// absolute time a trigger_at, possibly relative, would be scheduled for right now; nothing is created
+func resolveHandler(callme *app.CallMe, r *http.Request) *Response {
+	// GET is the only method this endpoint handles
+	if r.Method != "GET" {
+		return unknownMethodError()
+	}
+
+	err := r.ParseForm()
+	if err != nil {
+		return internalServerError(err.Error())
+	}
+
+	input := r.Form.Get("trigger_at")
+	// an unescaped + in the query string is decoded as a space
+	if strings.HasPrefix(input, " ") {
+		input = "+" + input[1:]
+	}
+	triggerAt, err := task.NormalizeTriggerAt(input)
+	if err != nil {
+		return badRequestError(err.Error())
+	}
+	// normalized, it's always a valid Unix timestamp
+	unix, _ := strconv.ParseInt(triggerAt, 10, 64)
+
+	return &Response{
+		status: http.StatusOK,
+		data:   resolution{TriggerAt: triggerAt, ISO8601: time.Unix(unix, 0).UTC().Format(time.RFC3339)},
+	}
+}
+
+// effective configuration, secrets redacted
+func configHandler(callme *app.CallMe, r *http.Request) *Response {
+	if resp := requireAdmin(callme, r); resp != nil {
+		return resp
+	}
+
+	// GET is the only method this endpoint handles
+	if r.Method != "GET" {
+		return unknownMethodError()
+	}
+
+	return &Response{
+		status: http.StatusOK,
+		data:   callme.Config(),
+	}
+}
+
+// re-read the configuration from the environment
+func reloadHandler(callme *app.CallMe, r *http.Request) *Response {
+	if resp := requireAdmin(callme, r); resp != nil {
+		return resp
+	}
+
+	// POST is the only method this endpoint handles
+	if r.Method != "POST" {
+		return unknownMethodError()
+	}
+
+	config, err := callme.Reload()
+	if err != nil {
+		return badRequestError(err.Error())
+	}
+
+	return &Response{
+		status: http.StatusOK,
+		data:   config,
+	}
+}
+
+// look for pending tasks in the past and replay them right away, instead of waiting for the next catch up pass;
+// ?task_name=<task_name> restricts it to tasks with that name
+func flushHandler(callme *app.CallMe, r *http.Request) *Response {
+	if resp := requireAdmin(callme, r); resp != nil {
+		return resp
+	}
+
+	// POST is the only method this endpoint handles
+	if r.Method != "POST" {
+		return unknownMethodError()
+	}
+
+	err := r.ParseForm()
+	if err != nil {
+		return internalServerError(err.Error())
+	}
+
+	// all tasks, or only those with a given name
+	n, err := callme.Catchup(r.Form.Get("task_name"))
+	switch err {
+	case nil:
+		return &Response{
+			status: http.StatusOK,
+			data:   flushResponse{Enqueued: n},
+		}
+	case app.ErrCatchupInProgress, app.ErrPaused:
+		return &Response{
+			status: http.StatusConflict,
+			data:   message{Error: err.Error()},
+		}
+	default:
+		return internalServerError(err.Error())
+	}
+}
+
+// stop executing tasks, e.g., before maintenance, letting those in progress finish
+func pauseHandler(callme *app.CallMe, r *http.Request) *Response {
+	if resp := requireAdmin(callme, r); resp != nil {
+		return resp
+	}
+
+	// POST is the only method this endpoint handles
+	if r.Method != "POST" {
+		return unknownMethodError()
+	}
+
+	if !callme.Pause() {
+		return &Response{
+			status: http.StatusConflict,
+			data:   message{Error: "already paused"},
+		}
+	}
+
+	return &Response{
+		status: http.StatusOK,
+		data:   readiness{Status: "paused", InFlight: callme.InFlight()},
+	}
+}
+
+// start executing tasks again, catching up on those due while paused
+func resumeHandler(callme *app.CallMe, r *http.Request) *Response {
+	if resp := requireAdmin(callme, r); resp != nil {
+		return resp
+	}
+
+	// POST is the only method this endpoint handles
+	if r.Method != "POST" {
+		return unknownMethodError()
+	}
+
+	if !callme.Resume() {
+		return &Response{
+			status: http.StatusConflict,
+			data:   message{Error: "not paused"},
+		}
+	}
+
+	return &Response{
+		status: http.StatusOK,
+		data:   readiness{Status: "ready", InFlight: callme.InFlight()},
+	}
+}
+
+// cached metadata of the tasks table; POST refreshes it first
+func metadataHandler(callme *app.CallMe, r *http.Request) *Response {
+	if resp := requireAdmin(callme, r); resp != nil {
+		return resp
+	}
+
+	switch r.Method {
+	case "GET":
+	case "POST":
+		err := callme.RefreshTableMetadata()
+		if err != nil {
+			return internalServerError(err.Error())
+		}
+	default:
+		return unknownMethodError()
+	}
+
+	return &Response{
+		status: http.StatusOK,
+		data:   callme.TableMetadata(),
+	}
+}
+
+// number of pending tasks, as a JSON object
+func pendingCountHandler(callme *app.CallMe, r *http.Request) *Response {
+	// GET is the only method this endpoint handles
+	if r.Method != "GET" {
+		return unknownMethodError()
+	}
+
+	return &Response{
+		status: http.StatusOK,
+		data:   pendingCount{PendingTasks: callme.PendingTasks()},
+	}
+}
+
+// number of tasks scheduled for each minute in a given range that have not completed yet
+func histogramHandler(callme *app.CallMe, r *http.Request) *Response {
+	// GET is the only method this endpoint handles
+	if r.Method != "GET" {
+		return unknownMethodError()
+	}
+
+	err := r.ParseForm()
+	if err != nil {
+		return internalServerError(err.Error())
+	}
+
+	from, err := strconv.ParseInt(r.Form.Get("from"), 10, 64)
+	if err != nil {
+		return badRequestError("from must be a Unix timestamp")
+	}
+	to, err := strconv.ParseInt(r.Form.Get("to"), 10, 64)
+	if err != nil {
+		return badRequestError("to must be a Unix timestamp")
+	}
+
+	histogram, err := callme.TaskHistogram(from, to)
+	switch err {
+	case nil:
+		return &Response{
+			status: http.StatusOK,
+			data:   histogram,
+		}
+	case app.ErrCountersDisabled:
+		return &Response{
+			status: http.StatusNotFound,
+			data:   message{Error: err.Error()},
+		}
+	case app.ErrInvalidRange:
+		return badRequestError(err.Error())
+	default:
+		return internalServerError(err.Error())
+	}
+}
+
+// metrics in the Prometheus text exposition format
+func metricsHandler(callme *app.CallMe, w io.Writer) {
+	fmt.Fprintln(w, "# HELP callme_pending_tasks_total Number of tasks waiting to be executed.")
+	fmt.Fprintln(w, "# TYPE callme_pending_tasks_total gauge")
+	fmt.Fprintln(w, "callme_pending_tasks_total", callme.PendingTasks())
+
+	fmt.Fprintln(w, "# HELP callme_malformed_items_total Number of stored items that could not be read as tasks.")
+	fmt.Fprintln(w, "# TYPE callme_malformed_items_total counter")
+	fmt.Fprintln(w, "callme_malformed_items_total", callme.MalformedItems())
+
+	fmt.Fprintln(w, "# HELP callme_loop_drift_total Number of minutes the scheduler fell behind on.")
+	fmt.Fprintln(w, "# TYPE callme_loop_drift_total counter")
+	fmt.Fprintln(w, "callme_loop_drift_total", callme.LoopDrifts())
+
+	fmt.Fprintln(w, "# HELP callme_pool_overflow_total Number of tasks that could not start on time, all callbacks busy.")
+	fmt.Fprintln(w, "# TYPE callme_pool_overflow_total counter")
+	fmt.Fprintln(w, "callme_pool_overflow_total", callme.PoolOverflows())
+
+	fmt.Fprintln(w, "# HELP callme_creation_webhook_dropped_total Number of created tasks that could not be pushed.")
+	fmt.Fprintln(w, "# TYPE callme_creation_webhook_dropped_total counter")
+	fmt.Fprintln(w, "callme_creation_webhook_dropped_total", callme.CreationWebhookDropped())
+
+	hits, misses := callme.StatusCacheStats()
+	fmt.Fprintln(w, "# HELP callme_status_cache_requests_total Number of lookups of specific tasks, by cache result.")
+	fmt.Fprintln(w, "# TYPE callme_status_cache_requests_total counter")
+	fmt.Fprintln(w, "callme_status_cache_requests_total{result=\"hit\"}", hits)
+	fmt.Fprintln(w, "callme_status_cache_requests_total{result=\"miss\"}", misses)
+
+	fmt.Fprintln(w, "# HELP callme_worker_pool_weight_used Number of callback slots taken by the callbacks running.")
+	fmt.Fprintln(w, "# TYPE callme_worker_pool_weight_used gauge")
+	fmt.Fprintln(w, "callme_worker_pool_weight_used", callme.PoolWeightUsed())
+
+	buckets, sum, count := callme.CallbackDurations()
+	fmt.Fprintln(w, "# HELP callme_callback_duration_ms Time it took to call back, in milliseconds.")
+	fmt.Fprintln(w, "# TYPE callme_callback_duration_ms histogram")
+	for i, le := range app.CallbackDurationBuckets {
+		fmt.Fprintf(w, "callme_callback_duration_ms_bucket{le=\"%d\"} %d\n", le, buckets[i])
+	}
+	fmt.Fprintf(w, "callme_callback_duration_ms_bucket{le=\"+Inf\"} %d\n", buckets[len(buckets)-1])
+	fmt.Fprintln(w, "callme_callback_duration_ms_sum", sum)
+	fmt.Fprintln(w, "callme_callback_duration_ms_count", count)
+
+	catchup := callme.CatchupStats()
+	fmt.Fprintln(w, "# HELP callme_catchup_duration_seconds Time it took to complete a catch up pass, in seconds.")
+	fmt.Fprintln(w, "# TYPE callme_catchup_duration_seconds histogram")
+	for i, le := range app.CatchupDurationBuckets {
+		fmt.Fprintf(w, "callme_catchup_duration_seconds_bucket{le=\"%s\"} %d\n", msToSeconds(le), catchup.DurationBuckets[i])
+	}
+	fmt.Fprintf(
+		w,
+		"callme_catchup_duration_seconds_bucket{le=\"+Inf\"} %d\n",
+		catchup.DurationBuckets[len(catchup.DurationBuckets)-1],
+	)
+	fmt.Fprintln(w, "callme_catchup_duration_seconds_sum", msToSeconds(catchup.DurationSumMs))
+	fmt.Fprintln(w, "callme_catchup_duration_seconds_count", catchup.Passes)
+
+	fmt.Fprintln(w, "# HELP callme_catchup_items_scanned_total Number of items read while catching up.")
+	fmt.Fprintln(w, "# TYPE callme_catchup_items_scanned_total counter")
+	fmt.Fprintln(w, "callme_catchup_items_scanned_total", catchup.Scanned)
+
+	fmt.Fprintln(w, "# HELP callme_catchup_items_executed_total Number of overdue tasks executed while catching up.")
+	fmt.Fprintln(w, "# TYPE callme_catchup_items_executed_total counter")
+	fmt.Fprintln(w, "callme_catchup_items_executed_total", catchup.Executed)
+
+	fmt.Fprintln(w, "# HELP callme_catchup_items_skipped_total Number of tasks, or passes, skipped while catching up.")
+	fmt.Fprintln(w, "# TYPE callme_catchup_items_skipped_total counter")
+	reasons := []string{app.CatchupSkipPastMaxDelay, app.CatchupSkipAlreadyRunning, app.CatchupSkipConcurrentLock}
+	for _, reason := range reasons {
+		fmt.Fprintf(w, "callme_catchup_items_skipped_total{reason=\"%s\"} %d\n", reason, catchup.Skipped[reason])
+	}
+}
+
+// format a number of milliseconds as seconds, e.g., 1500 as 1.5
+func msToSeconds(ms int64) string {
+	return strconv.FormatFloat(float64(ms)/1000, 'f', -1, 64)
+}
+
+// describe a newly created task, optionally including the components of its identifier so that clients do not have to
+// parse it
+func newCreation(t task.Task, structured bool) creation {
+	c := creation{
+		Message: "task successfully registered",
+		TaskID:  t.Name + "@" + t.TriggerAt,
+	}
+	if structured {
+		c.ID = &taskIdentifier{TaskName: t.Name, UUID: t.UUID, TriggerAt: t.TriggerAt}
+	}
+
+	return c
+}
+
+// namespace a request was made in, from the X-Callme-Namespace header, if any
+func requestNamespace(r *http.Request) (string, error) {
+	ns := r.Header.Get(namespaceHeader)
+	if len(ns) > maxNamespaceLength {
+		return "", errors.New(invalidNamespaceError)
+	}
+	for _, c := range ns {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_') {
+			return "", errors.New(invalidNamespaceError)
+		}
+	}
+
+	return ns, nil
+}
+
+// the name a task is stored with, in the given namespace; names (and keys, <task_name>@<trigger_at>) are left as is
+// outside of a namespace, and so are empty ones
+func namespaced(ns string, name string) string {
+	if ns == "" || name == "" {
+		return name
+	}
+
+	return ns + namespaceSeparator + name
+}
+
+// follow-up tasks are created in the same namespace as the ones they follow
+func namespacedFollowUp(ns string, next *task.Task) *task.Task {
+	if next == nil {
+		return nil
+	}
+
+	t := *next
+	t.Name = namespaced(ns, t.Name)
+	t.OnSuccess = namespacedFollowUp(ns, t.OnSuccess)
+
+	return &t
+}
+
+// the names chosen by a client for a task and its follow-ups cannot include the namespace separator, otherwise
+// requests without a namespace could create tasks in any other one, e.g., team-a:<task_name>
+func validateTaskNames(name string, next *task.Task) task.ValidationErrors {
+	errs := task.ValidationErrors{}
+	msg := "task_name cannot include " + namespaceSeparator
+	if strings.Contains(name, namespaceSeparator) {
+		errs = append(errs, task.ValidationError{Field: "task_name", Message: msg})
+	}
+	for field := "on_success."; next != nil; field, next = field+"on_success.", next.OnSuccess {
+		if strings.Contains(next.Name, namespaceSeparator) {
+			errs = append(errs, task.ValidationError{Field: field + "task_name", Message: msg})
+		}
+	}
+
+	return errs
+}
+
+// a task as seen by clients in the given namespace, i.e., as it was named when created
+func withoutNamespace(ns string, t task.Task) task.Task {
+	if ns == "" {
+		return t
+	}
+
+	t.Name = strings.TrimPrefix(t.Name, ns+namespaceSeparator)
+	if t.OnSuccess != nil {
+		next := withoutNamespace(ns, *t.OnSuccess)
+		t.OnSuccess = &next
+	}
+
+	return t
+}
+
+// given a task key of the form task_name@trigger_at, where trigger_at is optional,
+// parse it and return the individual components
+func parseTaskIdentifier(taskKey string) (string, string) {
+	taskKeyParts := strings.Split(taskKey, "@")
+	taskName := taskKeyParts[0]
+	triggerAt := ""
+	// trigger_at is optional
+	if len(taskKeyParts) == 2 {
+		triggerAt = taskKeyParts[1]
+	}
+
+	return taskName, triggerAt
+}
+
+// parse the cursor to the next page of tasks, <task_name>@<trigger_at> as returned in next (or next_cursor), if any;
+// it must identify a task exactly, anything else would have DynamoDB start from an arbitrary key
+func parseCursor(cursor string) (task.Task, error) {
+	if cursor == "" {
+		return task.Task{}, nil
+	}
+
+	parts := strings.Split(cursor, "@")
+	if len(parts) != 2 || parts[0] == "" {
+		return task.Task{}, errors.New("invalid start_from, expected <task_name>@<trigger_at>: " + cursor)
+	}
+	if _, err := strconv.ParseInt(parts[1], 10, 64); err != nil {
+		return task.Task{}, errors.New("invalid start_from, trigger_at must be a Unix timestamp: " + cursor)
+	}
+
+	return task.Task{Name: parts[0], TriggerAt: parts[1]}, nil
+}
+
+// read the task definitions from a CSV file and return the valid ones along with the errors found on the others
+// an error is returned only if the file cannot be read at all
+func parseTasksCSV(callme *app.CallMe, input io.Reader) ([]csvTask, []csvRowError, error) {
+	tasks := make([]csvTask, 0)
+	errs := make([]csvRowError, 0)
+
+	reader := csv.NewReader(input)
+	header, err := reader.Read()
+	if err != nil {
+		return nil, nil, errors.New("failed to read the CSV header")
+	}
+
+	row := 1
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		row++
+		if err != nil {
+			errs = append(errs, csvRowError{Row: row, Error: err.Error()})
+			continue
+		}
+
+		t, err := taskFromCSVRecord(header, record)
+		if errs := validateTaskNames(t.Name, t.OnSuccess); err == nil && len(errs) > 0 {
+			err = errs
+		}
+		if err == nil {
+			t, err = prepareTask(callme, t)
+		}
+		if err != nil {
+			errs = append(errs, csvRowError{Row: row, Error: err.Error()})
+			continue
+		}
+
+		tasks = append(tasks, csvTask{row: row, task: t})
+	}
+
+	return tasks, errs, nil
+}
+
+// create a task instance from a CSV record with the column names in header
+func taskFromCSVRecord(header []string, record []string) (task.Task, error) {
+	t := task.Task{}
+
+	for i, column := range header {
+		value := strings.TrimSpace(record[i])
+		if value == "" {
+			continue
+		}
+
+		var err error
+		switch strings.TrimSpace(column) {
+		case "task_name":
+			t.Name = value
+		case "trigger_at":
+			t.TriggerAt = value
+		case "callback":
+			t.CallbackEndpoint = value
+		case "callback_method":
+			t.CallbackMethod = value
+		case "payload":
+			t.Payload = value
+		case "expected_http_status":
+			t.ExpectedHTTPStatus, err = strconv.Atoi(value)
+		case "retry":
+			t.Retry, err = strconv.Atoi(value)
+		case "max_delay":
+			t.MaxDelay, err = strconv.Atoi(value)
+		default:
+			return t, errors.New("unknown column: " + column)
+		}
+
+		if err != nil {
+			return t, errors.New("invalid integer for " + column + ": " + value)
+		}
+	}
+
+	return t, nil
 }
@@ -1,10 +1,20 @@
 package handlers
 
 import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
 	"strconv"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/marcoalmeida/callme/app"
+	"github.com/marcoalmeida/callme/task"
 	"github.com/marcoalmeida/callme/util"
+	"go.uber.org/zap"
 )
 
 func Test_parseTaskKey(t *testing.T) {
@@ -26,42 +36,639 @@ func Test_parseTaskKey(t *testing.T) {
 	}
 }
 
-func Test_parseTriggerOn(t *testing.T) {
-	// valid (2038 or something like that)
-	_, err := parseTriggerAt("2174245620")
+func Test_parseTasksCSV(t *testing.T) {
+	input := `task_name,trigger_at,callback,callback_method,expected_http_status
+t0,+10m,http://example.com/t0,,
+t1,+1h,http://example.com/t1,POST,204
+t2,invalid,http://example.com/t2,,
+t3,+2d,http://example.com/t3,PUT,
+t4,+5m,http://example.com/t4,GET,not-a-number
+`
+
+	callme := &app.CallMe{}
+	tasks, errs, err := parseTasksCSV(callme, strings.NewReader(input))
 	if err != nil {
-		t.Error("Expected to succeed (Unix time stamp), failed with", err)
+		t.Fatal("Expected to succeed, failed with", err)
+	}
+
+	if len(tasks) != 3 {
+		t.Error("Expected 3 valid tasks, got", len(tasks))
+	}
+	for i, name := range []string{"t0", "t1", "t3"} {
+		if tasks[i].task.Name != name {
+			t.Error("Expected task", name, "got", tasks[i].task.Name)
+		}
+	}
+	if tasks[1].task.CallbackMethod != "POST" || tasks[1].task.ExpectedHTTPStatus != 204 {
+		t.Error("Expected POST and 204, got", tasks[1].task.CallbackMethod, tasks[1].task.ExpectedHTTPStatus)
+	}
+	// defaults must be set on missing fields
+	if tasks[0].task.CallbackMethod != "GET" || tasks[0].task.ExpectedHTTPStatus != 200 {
+		t.Error("Expected GET and 200, got", tasks[0].task.CallbackMethod, tasks[0].task.ExpectedHTTPStatus)
 	}
 
-	// valid relative time
-	currentMinute := util.GetUnixMinute()
-	// 10 minutes from now
-	expect := currentMinute + 600
-	at, err := parseTriggerAt("+10m")
+	if len(errs) != 2 {
+		t.Fatal("Expected 2 errors, got", len(errs))
+	}
+	if errs[0].Row != 4 || errs[1].Row != 6 {
+		t.Error("Expected errors on rows 4 and 6, got", errs[0].Row, "and", errs[1].Row)
+	}
+
+	// missing header
+	_, _, err = parseTasksCSV(callme, strings.NewReader(""))
+	if err == nil {
+		t.Error("Expected to fail without a header")
+	}
+}
+
+func Test_taskFromAlert(t *testing.T) {
+	raw := []byte(`{
+		"status": "firing",
+		"labels": {"alertname": "DiskFull", "instance": "db0"},
+		"annotations": {"summary": "disk full", "callme_callback": "http://example.com/cleanup", "callme_retry": "3"},
+		"fingerprint": "c0ffee"
+	}`)
+	alert := alertmanagerAlert{}
+	err := json.Unmarshal(raw, &alert)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tsk, err := taskFromAlert(alert, raw)
 	if err != nil {
-		t.Error("Expected to succeed (relative time), failed with", err)
+		t.Fatal("Expected to succeed, failed with", err)
+	}
+	if tsk.Name != "alertmanager-c0ffee" || tsk.TriggerAt != "+1m" {
+		t.Error("Expected alertmanager-c0ffee, next minute, got", tsk.Name, tsk.TriggerAt)
+	}
+	if tsk.CallbackEndpoint != "http://example.com/cleanup" || tsk.Retry != 3 {
+		t.Error("Expected the callback and retry from the annotations, got", tsk.CallbackEndpoint, tsk.Retry)
 	}
-	if at != strconv.FormatInt(expect, 10) {
-		t.Error("Expected", expect, "got", at)
+	if tsk.Payload != string(raw) || tsk.CreatedBy != task.CreatedByAlert {
+		t.Error("Expected the alert as the payload, created by", task.CreatedByAlert, "got", tsk.Payload, tsk.CreatedBy)
 	}
+	if tsk.Labels["alertname"] != "DiskFull" {
+		t.Error("Expected the alertname label, got", tsk.Labels)
+	}
+
+	alert.Annotations["callme_unknown"] = "x"
+	_, err = taskFromAlert(alert, raw)
+	if err == nil {
+		t.Error("Expected to fail with an unknown task field")
+	}
+}
+
+func Test_alertTaskName(t *testing.T) {
+	a := alertmanagerAlert{Labels: map[string]string{"alertname": "DiskFull", "instance": "db0"}}
+	b := alertmanagerAlert{Labels: map[string]string{"instance": "db0", "alertname": "DiskFull"}}
+	c := alertmanagerAlert{Labels: map[string]string{"alertname": "DiskFull", "instance": "db1"}}
 
-	// with bad input
-	for _, input := range []string{"", "+", "+m", "+6", "6h", "+6z"} {
-		tm, err := parseTriggerAt(input)
-		if err == nil {
-			t.Error("Expected to fail with bad input", input, ", succeeded returning", tm)
+	// without a fingerprint, the same labels must always name the same task
+	if alertTaskName(a) != alertTaskName(b) {
+		t.Error("Expected the same name for the same labels, got", alertTaskName(a), alertTaskName(b))
+	}
+	if alertTaskName(a) == alertTaskName(c) {
+		t.Error("Expected different names for different labels")
+	}
+}
+
+func Test_resolveHandler(t *testing.T) {
+	next := util.GetUnixMinute() + 86400
+	tests := []struct {
+		query     string
+		triggerAt int64
+	}{
+		{"trigger_at=%2B1d", next},
+		// unescaped
+		{"trigger_at=+1d", next},
+		{"trigger_at=4102444800", 4102444800},
+	}
+
+	for _, test := range tests {
+		resp := resolveHandler(&app.CallMe{}, httptest.NewRequest("GET", "/resolve?"+test.query, nil))
+		if resp.status != http.StatusOK {
+			t.Fatal("Expected", http.StatusOK, "for", test.query, "got", resp.status, resp.data)
+		}
+		resolved := resp.data.(resolution)
+		expected := time.Unix(test.triggerAt, 0).UTC().Format(time.RFC3339)
+		if resolved.TriggerAt != strconv.FormatInt(test.triggerAt, 10) || resolved.ISO8601 != expected {
+			t.Error("Expected", test.triggerAt, expected, "for", test.query, "got", resolved)
 		}
 	}
 
-	// not in the future
-	tm, err := parseTriggerAt("1227560820")
-	if err == nil {
-		t.Error("Expected to fail (past), succeeded returning", tm)
+	for _, query := range []string{"", "trigger_at=tomorrow", "trigger_at=1227560820", "trigger_at=4102444801"} {
+		resp := resolveHandler(&app.CallMe{}, httptest.NewRequest("GET", "/resolve?"+query, nil))
+		if resp.status != http.StatusBadRequest {
+			t.Error("Expected", http.StatusBadRequest, "for", query, "got", resp.status)
+		}
+	}
+}
+
+func Test_alertmanagerHandler_invalid(t *testing.T) {
+	resp := alertmanagerHandler(&app.CallMe{}, httptest.NewRequest("POST", "/tasks/alertmanager", strings.NewReader("{")))
+	if resp.status != http.StatusBadRequest {
+		t.Error("Expected", http.StatusBadRequest, "got", resp.status)
 	}
 
-	// future but not 1-minute resolution
-	tm, err = parseTriggerAt("2174245625")
+	resp = alertmanagerHandler(&app.CallMe{}, httptest.NewRequest("GET", "/tasks/alertmanager", nil))
+	if resp.status != http.StatusBadRequest {
+		t.Error("Expected", http.StatusBadRequest, "for an unknown method, got", resp.status)
+	}
+}
+
+func Test_prepareTask_payloadSize(t *testing.T) {
+	callme := &app.CallMe{MaxPayloadBytes: 16}
+	tsk := task.Task{Name: "t0", TriggerAt: "+10m", CallbackEndpoint: "http://example.com"}
+
+	// under the limit
+	tsk.Payload = "small payload"
+	_, err := prepareTask(callme, tsk)
+	if err != nil {
+		t.Error("Expected to succeed, failed with", err)
+	}
+
+	// over the limit
+	tsk.Payload = "a payload that is way over the limit"
+	_, err = prepareTask(callme, tsk)
 	if err == nil {
-		t.Error("Expected to fail (not 1-minute), succeeded returning", tm)
+		t.Error("Expected to fail with a payload over the limit")
+	}
+}
+
+func Test_prepareTask_weight(t *testing.T) {
+	tsk := task.Task{Name: "t0", TriggerAt: "+10m", CallbackEndpoint: "http://example.com"}
+
+	prepared, err := prepareTask(&app.CallMe{}, tsk)
+	if err != nil || prepared.Weight != 1 {
+		t.Error("Expected a weight of 1 by default, got", prepared.Weight, err)
+	}
+
+	callme := &app.CallMe{MaxConcurrentCallbacks: 10}
+	tsk.Weight = 10
+	if _, err = prepareTask(callme, tsk); err != nil {
+		t.Error("Expected to take up all callback slots, failed with", err)
+	}
+	tsk.Weight = 11
+	if _, err = prepareTask(callme, tsk); err == nil {
+		t.Error("Expected to fail with a weight over MaxConcurrentCallbacks")
+	}
+}
+
+func Test_configHandler(t *testing.T) {
+	callme := &app.CallMe{MaxRetries: 7, AdminToken: "s3cret"}
+
+	// admin endpoint
+	r := httptest.NewRequest("GET", "/config", nil)
+	resp := configHandler(callme, r)
+	if resp.status != http.StatusUnauthorized {
+		t.Error("Expected", http.StatusUnauthorized, "without a token, got", resp.status)
+	}
+
+	r.Header.Set("Authorization", "Bearer s3cret")
+	resp = configHandler(callme, r)
+	if resp.status != http.StatusOK {
+		t.Fatal("Expected", http.StatusOK, "got", resp.status)
+	}
+	config := resp.data.(map[string]interface{})
+	if config["max_retries"] != 7 {
+		t.Error("Expected max_retries to be 7, got", config["max_retries"])
+	}
+	if config["admin_token"] == "s3cret" {
+		t.Error("Expected admin_token to be redacted")
+	}
+}
+
+func Test_retryHandler(t *testing.T) {
+	callme := &app.CallMe{}
+
+	r := httptest.NewRequest("GET", "/task/t0@1800000000/retry", nil)
+	resp := retryHandler(callme, r, "t0@1800000000")
+	if resp.status != http.StatusBadRequest {
+		t.Error("Expected", http.StatusBadRequest, "for an unknown method, got", resp.status)
+	}
+
+	// the trigger time is required to identify the task
+	r = httptest.NewRequest("POST", "/task/t0/retry", nil)
+	resp = retryHandler(callme, r, "t0")
+	if resp.status != http.StatusBadRequest {
+		t.Error("Expected", http.StatusBadRequest, "without trigger_at, got", resp.status)
+	}
+}
+
+func Test_metricsHandler(t *testing.T) {
+	var out strings.Builder
+	metricsHandler(&app.CallMe{}, &out)
+
+	if !strings.Contains(out.String(), "# TYPE callme_pending_tasks_total gauge\ncallme_pending_tasks_total 0\n") {
+		t.Error("Expected the pending tasks gauge, got", out.String())
+	}
+	if !strings.Contains(out.String(), "# TYPE callme_worker_pool_weight_used gauge\ncallme_worker_pool_weight_used 0\n") {
+		t.Error("Expected the pool weight gauge, got", out.String())
+	}
+	if !strings.Contains(out.String(), "callme_callback_duration_ms_bucket{le=\"+Inf\"} 0\n") {
+		t.Error("Expected the callback duration histogram, got", out.String())
+	}
+	if !strings.Contains(out.String(), "callme_catchup_duration_seconds_bucket{le=\"1\"} 0\n") ||
+		!strings.Contains(out.String(), "callme_catchup_items_skipped_total{reason=\"past_max_delay\"} 0\n") {
+		t.Error("Expected the catch up metrics, got", out.String())
+	}
+}
+
+func Test_flushHandler(t *testing.T) {
+	// administrative endpoints are disabled without a token
+	resp := flushHandler(&app.CallMe{}, httptest.NewRequest("POST", "/admin/flush", nil))
+	if resp.status != http.StatusForbidden {
+		t.Error("Expected", http.StatusForbidden, "got", resp.status)
+	}
+
+	callme := &app.CallMe{AdminToken: "s3cret"}
+	resp = flushHandler(callme, httptest.NewRequest("POST", "/admin/flush", nil))
+	if resp.status != http.StatusUnauthorized {
+		t.Error("Expected", http.StatusUnauthorized, "without a token, got", resp.status)
+	}
+
+	r := httptest.NewRequest("GET", "/admin/flush", nil)
+	r.Header.Set("Authorization", "Bearer s3cret")
+	resp = flushHandler(callme, r)
+	if resp.status != http.StatusBadRequest {
+		t.Error("Expected", http.StatusBadRequest, "for an unknown method, got", resp.status)
+	}
+}
+
+func Test_pauseHandler(t *testing.T) {
+	callme := &app.CallMe{AdminToken: "s3cret", Logger: zap.NewNop()}
+	resp := pauseHandler(callme, httptest.NewRequest("POST", "/admin/pause", nil))
+	if resp.status != http.StatusUnauthorized || callme.Paused() {
+		t.Error("Expected", http.StatusUnauthorized, "without a token, got", resp.status)
+	}
+
+	request := func(method string, path string) *http.Request {
+		r := httptest.NewRequest(method, path, nil)
+		r.Header.Set("Authorization", "Bearer s3cret")
+		return r
+	}
+
+	resp = resumeHandler(callme, request("POST", "/admin/resume"))
+	if resp.status != http.StatusConflict {
+		t.Error("Expected", http.StatusConflict, "when not paused, got", resp.status)
+	}
+	resp = readyzHandler(callme, httptest.NewRequest("GET", "/readyz", nil))
+	if resp.status != http.StatusOK || resp.data.(readiness).Status != "ready" {
+		t.Error("Expected to be ready, got", resp.status, resp.data)
+	}
+
+	resp = pauseHandler(callme, request("POST", "/admin/pause"))
+	if resp.status != http.StatusOK || !callme.Paused() {
+		t.Error("Expected to pause, got", resp.status, resp.data)
+	}
+	resp = pauseHandler(callme, request("POST", "/admin/pause"))
+	if resp.status != http.StatusConflict {
+		t.Error("Expected", http.StatusConflict, "when already paused, got", resp.status)
+	}
+	resp = readyzHandler(callme, httptest.NewRequest("GET", "/readyz", nil))
+	if resp.status != http.StatusServiceUnavailable || resp.data.(readiness).Status != "paused" {
+		t.Error("Expected not to be ready while paused, got", resp.status, resp.data)
+	}
+	resp = flushHandler(callme, request("POST", "/admin/flush"))
+	if resp.status != http.StatusConflict {
+		t.Error("Expected", http.StatusConflict, "to catch up while paused, got", resp.status)
+	}
+}
+
+func Test_requestNamespace(t *testing.T) {
+	for ns, valid := range map[string]bool{
+		"":                      true,
+		"team-a":                true,
+		"Team_B2":               true,
+		"team:a":                false,
+		"team a":                false,
+		"../team":               false,
+		strings.Repeat("a", 65): false,
+	} {
+		r := httptest.NewRequest("GET", "/status/", nil)
+		r.Header.Set(namespaceHeader, ns)
+		got, err := requestNamespace(r)
+		if (err == nil) != valid || (valid && got != ns) {
+			t.Error("Expected", ns, "to be valid:", valid, "got", got, err)
+		}
+	}
+}
+
+func Test_namespaced(t *testing.T) {
+	// stored as created under team-a
+	stored := task.Task{Name: namespaced("team-a", "reminder"), OnSuccess: namespacedFollowUp("team-a", &task.Task{
+		Name: "follow-up",
+	})}
+	if stored.Name != "team-a:reminder" || stored.OnSuccess.Name != "team-a:follow-up" {
+		t.Fatal("Expected both tasks in team-a, got", stored.Name, stored.OnSuccess.Name)
+	}
+	// looking up a task by name in team-b never finds it
+	if namespaced("team-b", "reminder") == stored.Name {
+		t.Error("Expected team-b to use a different name")
+	}
+	if strings.HasPrefix(stored.Name, "team-b"+namespaceSeparator) {
+		t.Error("Expected not to be listed in team-b")
+	}
+	// nor can a name escape its namespace
+	if namespaced("team-b", "team-a:reminder") == stored.Name {
+		t.Error("Expected team-b to stay in its namespace")
+	}
+
+	seen := withoutNamespace("team-a", stored)
+	if seen.Name != "reminder" || seen.OnSuccess.Name != "follow-up" || stored.OnSuccess.Name != "team-a:follow-up" {
+		t.Error("Expected the namespace to be stripped from a copy, got", seen.Name, seen.OnSuccess.Name)
+	}
+	// outside of a namespace, tasks are seen as stored
+	if namespaced("", "reminder") != "reminder" || withoutNamespace("", stored).Name != stored.Name {
+		t.Error("Expected names to be left as is without a namespace")
+	}
+}
+
+func Test_taskHandler_invalidNamespace(t *testing.T) {
+	r := httptest.NewRequest("DELETE", "/task/reminder@1800000000", nil)
+	r.Header.Set(namespaceHeader, "team:a")
+	resp := taskHandler(&app.CallMe{}, r)
+	if resp.status != http.StatusBadRequest {
+		t.Error("Expected", http.StatusBadRequest, "for an invalid namespace, got", resp.status)
+	}
+}
+
+func Test_taskHandler_crossNamespace(t *testing.T) {
+	callme := &app.CallMe{Logger: zap.NewNop()}
+	valid := `"trigger_at": "+5m", "callback": "http://example.com"`
+	tests := []struct {
+		method string
+		path   string
+		body   string
+		status int
+	}{
+		{"PUT", "/task/team-a:reminder", `{` + valid + `}`, http.StatusUnprocessableEntity},
+		{"PUT", "/task/reminder", `{` + valid + `, "on_success": {"task_name": "team-a:next"}}`,
+			http.StatusUnprocessableEntity},
+		{"POST", "/task/reserve", `{"task_name": "team-a:reminder", "trigger_at": "+5m"}`, http.StatusBadRequest},
+		{"POST", "/task/fanout", `{"template": {"task_name": "team-a:campaign", ` + valid + `}, "payloads": ["a"]}`,
+			http.StatusBadRequest},
+		{"POST", "/task/reminder@1800000000/confirm", `{"on_success": {"task_name": "team-a:next"}}`,
+			http.StatusBadRequest},
+	}
+
+	// neither without a namespace, nor from another one
+	for _, ns := range []string{"", "team-b"} {
+		for _, test := range tests {
+			r := httptest.NewRequest(test.method, test.path, strings.NewReader(test.body))
+			if ns != "" {
+				r.Header.Set(namespaceHeader, ns)
+			}
+			resp := taskHandler(callme, r)
+			if resp.status != test.status {
+				t.Error("Expected", test.status, "for", test.method, test.path, test.body, "in", ns, "got", resp.status)
+			}
+		}
+	}
+
+	_, errs, err := parseTasksCSV(callme, strings.NewReader("task_name,trigger_at,callback\nteam-a:t0,+5m,http://a\n"))
+	if err != nil || len(errs) != 1 {
+		t.Error("Expected the row to be rejected, got", errs, err)
+	}
+}
+
+func Test_taskHandler_validation(t *testing.T) {
+	callme := &app.CallMe{MaxConcurrentCallbacks: 2, Logger: zap.NewNop()}
+	body := `{"trigger_at": "tomorrow", "callback_method": "FETCH", "weight": 3}`
+	resp := taskHandler(callme, httptest.NewRequest("PUT", "/task/t0", strings.NewReader(body)))
+	if resp.status != http.StatusUnprocessableEntity {
+		t.Fatal("Expected", http.StatusUnprocessableEntity, "for an invalid task, got", resp.status)
+	}
+
+	fields := make([]string, 0)
+	for _, e := range resp.data.(validationErrors).Errors {
+		fields = append(fields, e.Field)
+	}
+	expected := []string{"callback", "callback_method", "weight", "trigger_at"}
+	if !reflect.DeepEqual(fields, expected) {
+		t.Error("Expected errors on", expected, "got", fields)
+	}
+
+	// not a task definition at all
+	resp = taskHandler(callme, httptest.NewRequest("PUT", "/task/t0", strings.NewReader("{")))
+	if resp.status != http.StatusBadRequest {
+		t.Error("Expected", http.StatusBadRequest, "for malformed JSON, got", resp.status)
+	}
+}
+
+func Test_fanOutHandler(t *testing.T) {
+	callme := &app.CallMe{MaxPayloadBytes: 8, Logger: zap.NewNop()}
+	template := `"template": {"task_name": "campaign", "trigger_at": "+5m", "callback": "http://example.com"`
+
+	tests := []struct {
+		body   string
+		status int
+	}{
+		{`{` + template + `}, "payloads": []}`, http.StatusBadRequest},
+		{`{` + template + `}, "payloads": [` + strings.Repeat(`"a", `, app.MaxFanOut) + `"a"]}`, http.StatusBadRequest},
+		{`{` + template + `, "payload": "a"}, "payloads": ["b"]}`, http.StatusBadRequest},
+		{`{"template": {"task_name": "campaign"}, "payloads": ["a"]}`, http.StatusUnprocessableEntity},
+		{`{` + template + `}, "payloads": ["small", "way over the limit"]}`, http.StatusUnprocessableEntity},
+	}
+	for _, test := range tests {
+		resp := taskHandler(callme, httptest.NewRequest("POST", "/task/fanout", strings.NewReader(test.body)))
+		if resp.status != test.status {
+			t.Error("Expected", test.status, "for", test.body, "got", resp.status)
+		}
+	}
+}
+
+func Test_newEnvelope(t *testing.T) {
+	e := newEnvelope(message{Error: "boom"})
+	if e.Data != nil || e.Error != "boom" || e.Meta.Count != nil {
+		t.Error("Expected only the error, got", e)
+	}
+
+	e = newEnvelope(app.Status{
+		Tasks: []task.Task{{Name: "t0"}, {Name: "t1"}},
+		Next:  task.Task{Name: "t1", TriggerAt: "1800000000"},
+	})
+	if e.Meta.Count == nil || *e.Meta.Count != 2 || e.Meta.NextCursor != "t1@1800000000" {
+		t.Error("Expected 2 tasks and a cursor to the next page, got", e.Meta)
+	}
+
+	e = newEnvelope([]task.Task{{Name: "t0"}})
+	if e.Meta.Count == nil || *e.Meta.Count != 1 || e.Meta.NextCursor != "" {
+		t.Error("Expected 1 task and no cursor, got", e.Meta)
+	}
+}
+
+func Test_envelope(t *testing.T) {
+	callme := &app.CallMe{AdminToken: "s3cret", Logger: zap.NewNop()}
+
+	tests := []struct {
+		handlerFunc func(*app.CallMe, *http.Request) *Response
+		method      string
+		path        string
+	}{
+		{taskHandler, "PATCH", "/task/t0"},
+		{rescheduleHandler, "GET", "/reschedule/t0"},
+		{statusHandler, "POST", "/status/"},
+		{statusHandler, "GET", "/status/slow"},
+		{archiveHandler, "POST", "/archive/"},
+		{tasksCSVHandler, "GET", "/tasks/csv"},
+		{holidaysHandler, "GET", "/holidays"},
+		{maintenanceWindowsHandler, "GET", "/maintenance-windows"},
+		{healthHandler, "GET", "/health"},
+		{configHandler, "GET", "/config"},
+		{reloadHandler, "GET", "/admin/reload"},
+		{flushHandler, "GET", "/admin/flush"},
+		{metadataHandler, "GET", "/admin/metadata"},
+		{pendingCountHandler, "GET", "/metrics/pending-count"},
+	}
+
+	for _, test := range tests {
+		r := httptest.NewRequest(test.method, test.path+"?envelope=true", nil)
+		r.Header.Set("Authorization", "Bearer s3cret")
+		w := httptest.NewRecorder()
+		Handler{App: callme, handlerFunc: test.handlerFunc}.ServeHTTP(w, r)
+
+		body := make(map[string]interface{})
+		err := json.Unmarshal(w.Body.Bytes(), &body)
+		if err != nil {
+			t.Fatal("Expected a JSON response from", test.path, "failed with", err)
+		}
+		_, hasData := body["data"]
+		_, hasMeta := body["meta"]
+		_, hasError := body["error"]
+		if !hasData || !hasMeta || hasError != (w.Code >= 400) {
+			t.Error("Expected", test.path, "to return an envelope, got", w.Body.String())
+		}
+	}
+}
+
+func Test_reserveHandler(t *testing.T) {
+	callme := &app.CallMe{}
+
+	for _, body := range []string{"", `{"task_name": "t0"}`, `{"trigger_at": "+5m"}`, `{"task_name": "t0", "trigger_at": "x"}`} {
+		resp := reserveHandler(callme, httptest.NewRequest("POST", "/task/reserve", strings.NewReader(body)))
+		if resp.status != http.StatusBadRequest {
+			t.Error("Expected", http.StatusBadRequest, "for", body, "got", resp.status)
+		}
+	}
+
+	// the task is identified by the path
+	resp := confirmHandler(callme, httptest.NewRequest("POST", "/task/t0/confirm", strings.NewReader("{}")), "t0")
+	if resp.status != http.StatusBadRequest {
+		t.Error("Expected", http.StatusBadRequest, "without trigger_at, got", resp.status)
+	}
+}
+
+func Test_statusHandler_invalidCursor(t *testing.T) {
+	for _, cursor := range []string{"@", "@@", "t0@", "@1800000000", "t0@@1800000000", "t0", "t0@soon", "t0@18e8"} {
+		r := httptest.NewRequest("GET", "/status/?start_from="+url.QueryEscape(cursor), nil)
+		resp := statusHandler(&app.CallMe{}, r)
+		if resp.status != http.StatusBadRequest {
+			t.Error("Expected", http.StatusBadRequest, "for start_from", cursor, "got", resp.status)
+		}
+	}
+
+	startFrom, err := parseCursor("t0@1800000000")
+	if err != nil || startFrom.Name != "t0" || startFrom.TriggerAt != "1800000000" {
+		t.Error("Expected t0@1800000000, got", startFrom, err)
+	}
+}
+
+func Test_jobHandler(t *testing.T) {
+	resp := jobHandler(&app.CallMe{}, httptest.NewRequest("GET", "/jobs/unknown", nil))
+	if resp.status != http.StatusNotFound {
+		t.Error("Expected", http.StatusNotFound, "for an unknown job, got", resp.status)
+	}
+
+	resp = jobHandler(&app.CallMe{}, httptest.NewRequest("DELETE", "/jobs/unknown", nil))
+	if resp.status != http.StatusBadRequest {
+		t.Error("Expected", http.StatusBadRequest, "for an unknown method, got", resp.status)
+	}
+}
+
+func Test_healthHandler(t *testing.T) {
+	resp := healthHandler(&app.CallMe{DynamoDBRegion: "us-east-1"}, httptest.NewRequest("GET", "/health", nil))
+	if resp.status != http.StatusOK || resp.data.(health).DynamoDBRegion != "us-east-1" {
+		t.Error("Expected to be healthy in us-east-1, got", resp.status, resp.data)
+	}
+}
+
+func Test_taskStatus_invalidState(t *testing.T) {
+	resp := statusHandler(&app.CallMe{}, httptest.NewRequest("GET", "/status/?state=lost", nil))
+	if resp.status != http.StatusBadRequest {
+		t.Error("Expected", http.StatusBadRequest, "for an invalid state, got", resp.status)
+	}
+}
+
+func Test_histogramHandler(t *testing.T) {
+	tests := []struct {
+		callme *app.CallMe
+		query  string
+		status int
+	}{
+		{&app.CallMe{CountTasks: true}, "", http.StatusBadRequest},
+		{&app.CallMe{CountTasks: true}, "?from=120", http.StatusBadRequest},
+		{&app.CallMe{CountTasks: true}, "?from=120&to=60", http.StatusBadRequest},
+		{&app.CallMe{}, "?from=60&to=120", http.StatusNotFound},
+	}
+
+	for _, test := range tests {
+		resp := histogramHandler(test.callme, httptest.NewRequest("GET", "/stats/histogram"+test.query, nil))
+		if resp.status != test.status {
+			t.Error("Expected", test.status, "for", test.query, "got", resp.status)
+		}
+	}
+}
+
+func Test_statusHandler_invalidWait(t *testing.T) {
+	for _, url := range []string{"/status/t0?wait=x", "/status/t0?wait=-1s", "/status/t0?wait=2m", "/status/?wait=1s"} {
+		resp := statusHandler(&app.CallMe{}, httptest.NewRequest("GET", url, nil))
+		if resp.status != http.StatusBadRequest {
+			t.Error("Expected", http.StatusBadRequest, "for", url, "got", resp.status)
+		}
+	}
+}
+
+func Test_newCreation(t *testing.T) {
+	tsk := task.Task{Name: "t0", UUID: "0123456789abcdef0123456789abcdef", TriggerAt: "1858809600"}
+
+	c := newCreation(tsk, false)
+	if c.ID != nil {
+		t.Error("Expected no structured id unless requested, got", c.ID)
+	}
+
+	c = newCreation(tsk, true)
+	name, triggerAt := parseTaskIdentifier(c.TaskID)
+	if c.ID == nil || c.ID.TaskName != name || c.ID.TriggerAt != triggerAt || c.ID.UUID != tsk.UUID {
+		t.Error("Expected the structured id to match", c.TaskID, "got", c.ID)
+	}
+}
+
+func TestRegisterPrefix(t *testing.T) {
+	callme := &app.CallMe{DynamoDBRegion: "us-east-1", Logger: zap.NewNop()}
+
+	tests := []struct {
+		prefix string
+		path   string
+		status int
+	}{
+		{"", "/health", http.StatusOK},
+		{"/v1", "/v1/health", http.StatusOK},
+		{"/v1/", "/v1/health", http.StatusOK},
+		{"/v1", "/health", http.StatusNotFound},
+		// the prefix is removed before the path is parsed: no task name
+		{"", "/status/?wait=1s", http.StatusBadRequest},
+		{"/v1", "/v1/status/?wait=1s", http.StatusBadRequest},
+	}
+
+	for _, test := range tests {
+		mux := http.NewServeMux()
+		RegisterPrefix(callme, mux, test.prefix)
+
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest("GET", test.path, nil))
+		if w.Code != test.status {
+			t.Error("Expected", test.status, "for", test.path, "with prefix", test.prefix, "got", w.Code)
+		}
 	}
 }
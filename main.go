@@ -1,11 +1,14 @@
 package main
 
 import (
+	"context"
 	"fmt"
-	"math/rand"
 	"net/http"
 	_ "net/http/pprof"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
 	"time"
 
 	"github.com/marcoalmeida/callme/app"
@@ -14,10 +17,6 @@ import (
 	"go.uber.org/zap/zapcore"
 )
 
-func init() {
-	rand.Seed(time.Now().UnixNano())
-}
-
 func initLogging() (*zap.Logger, *zap.AtomicLevel) {
 	atom := zap.NewAtomicLevel()
 	encoderCfg := zap.NewProductionEncoderConfig()
@@ -44,21 +43,39 @@ func main() {
 	if app.Debug {
 		atom.SetLevel(zap.DebugLevel)
 	}
-	logger.Debug("Application configuration", zap.String("options", fmt.Sprintf("%+v", app)))
+	logger.Debug("Application configuration", zap.Any("options", app.Config()))
 
-	// background task that will periodically scan the table for lost tasks
-	// there are tasks that for some reason were never executed
-	go app.Catchup()
-	// background thread
-	go app.Run()
+	// main loop, periodic catch up for tasks that for some reason were never executed, and archival (if enabled)
+	app.Start(context.Background())
 
 	// listen and serve
-	serve(app)
+	server := serve(app)
+
+	// wait for the signal to stop
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+	sig := <-signals
+	logger.Info("Received signal, shutting down", zap.String("signal", sig.String()))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	err := server.Shutdown(ctx)
+	if err != nil {
+		logger.Error("Failed to shut down the server", zap.Error(err))
+	}
+	app.Shutdown()
 }
 
 // setup handlers, ListenIP and serve ChronosDB
-func serve(app *app.CallMe) {
+func serve(app *app.CallMe) *http.Server {
 	handlers.Register(app)
+	// the profiling endpoints remain on http.DefaultServeMux
+	prefix := strings.TrimSuffix(app.PathPrefix, "/")
+	if prefix == "" {
+		http.Handle("/", app)
+	} else {
+		http.Handle(prefix+"/", http.StripPrefix(prefix, app))
+	}
 
 	app.Logger.Info(
 		"Ready to ListenIP",
@@ -66,9 +83,13 @@ func serve(app *app.CallMe) {
 		zap.String("IP", app.ListenIP),
 	)
 
-	listenOn := fmt.Sprintf("%s:%d", app.ListenIP, app.ListenPort)
-	err := http.ListenAndServe(listenOn, nil)
-	if err != nil {
-		app.Logger.Error("Server error", zap.Error(err))
-	}
+	server := &http.Server{Addr: fmt.Sprintf("%s:%d", app.ListenIP, app.ListenPort)}
+	go func() {
+		err := server.ListenAndServe()
+		if err != nil && err != http.ErrServerClosed {
+			app.Logger.Fatal("Server error", zap.Error(err))
+		}
+	}()
+
+	return server
 }
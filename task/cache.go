@@ -0,0 +1,116 @@
+package task
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+)
+
+// ResponseCache keeps the responses of successful callbacks for a short period of time so that identical callbacks
+// (same endpoints, method, and payload) firing within that window can reuse them instead of sending the same request
+// over and over again. Identical callbacks firing at the same time share a single request.
+type ResponseCache struct {
+	window   time.Duration
+	entries  sync.Map
+	inFlight singleflight.Group
+}
+
+type cachedResponse struct {
+	status      int
+	body        []byte
+	contentType string
+	expiresAt   time.Time
+}
+
+// NewResponseCache returns a ResponseCache instance that keeps responses for windowMs milliseconds
+func NewResponseCache(windowMs int) *ResponseCache {
+	return &ResponseCache{window: time.Duration(windowMs) * time.Millisecond}
+}
+
+// Get returns the cached response for an identical callback, if there is one that has not yet expired
+func (c *ResponseCache) Get(t Task) (int, []byte, string, bool) {
+	key := c.key(t)
+
+	v, ok := c.entries.Load(key)
+	if !ok {
+		return 0, nil, "", false
+	}
+
+	entry := v.(cachedResponse)
+	if time.Now().After(entry.expiresAt) {
+		c.entries.Delete(key)
+		return 0, nil, "", false
+	}
+
+	return entry.status, entry.body, entry.contentType, true
+}
+
+// Add stores the response of a successful callback
+func (c *ResponseCache) Add(t Task, status int, body []byte, contentType string) {
+	c.entries.Store(c.key(t), cachedResponse{
+		status:      status,
+		body:        body,
+		contentType: contentType,
+		expiresAt:   time.Now().Add(c.window),
+	})
+}
+
+// outcome of a request shared by identical callbacks
+type sharedResponse struct {
+	status      int
+	body        []byte
+	contentType string
+	ok          bool
+}
+
+// Do returns the response of an identical callback that succeeded within the window, or waits for the one in
+// progress, if any, and returns its response if it succeeds. Otherwise it calls send, whose response is cached if it
+// returns true. It returns true iff the response was reused rather than returned by send.
+func (c *ResponseCache) Do(t Task, send func() (int, []byte, string, bool)) (int, []byte, string, bool) {
+	if status, body, contentType, ok := c.Get(t); ok {
+		return status, body, contentType, true
+	}
+
+	own := false
+	sendAndAdd := func() (interface{}, error) {
+		own = true
+		status, body, contentType, ok := send()
+		if ok {
+			c.Add(t, status, body, contentType)
+		}
+		return sharedResponse{status, body, contentType, ok}, nil
+	}
+	v, _, _ := c.inFlight.Do(c.key(t), sendAndAdd)
+	response := v.(sharedResponse)
+	if !own && !response.ok {
+		// the identical callback failed, which says nothing about this one
+		v, _ = sendAndAdd()
+		response = v.(sharedResponse)
+	}
+
+	return response.status, response.body, response.contentType, !own
+}
+
+// Evict drops the responses that expired by now, e.g., of callbacks that are not repeated
+func (c *ResponseCache) Evict(now time.Time) {
+	c.entries.Range(func(key, v interface{}) bool {
+		if now.After(v.(cachedResponse).expiresAt) {
+			c.entries.Delete(key)
+		}
+		return true
+	})
+}
+
+// sha256 of the URLs the callback may be sent to, with the query parameters of the task, its method, and payload
+func (c *ResponseCache) key(t Task) string {
+	hash := sha256.New()
+	for _, endpoint := range append([]string{t.CallbackEndpoint}, t.CallbackPool...) {
+		hash.Write([]byte(t.withQueryParams(endpoint) + "\n"))
+	}
+	hash.Write([]byte(t.CallbackMethod + "\n" + t.Payload))
+
+	return hex.EncodeToString(hash.Sum(nil))
+}
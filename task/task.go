@@ -1,10 +1,21 @@
 package task
 
 import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
+	"math"
+	"mime"
 	"net/http"
+	"net/url"
+	"reflect"
+	"regexp"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/marcoalmeida/callme/util"
@@ -12,17 +23,30 @@ import (
 )
 
 const (
-	Pending                    = "pending"
-	Running                    = "running"
-	Successful                 = "successful"
-	Failed                     = "failed"
-	Skipped                    = "skipped"
-	defaultCallbackMethod            = "GET"
-	defaultRetry                     = 1
-	defaultExpectedHTTPStatus        = 200
-	defaultMaxDelay                  = 10
+	Pending                   = "pending"
+	Running                   = "running"
+	Successful                = "successful"
+	Failed                    = "failed"
+	Skipped                   = "skipped"
+	Reserved                  = "reserved"
+	defaultCallbackMethod     = "GET"
+	defaultRetry              = 1
+	defaultExpectedHTTPStatus = 200
+	defaultMaxDelay           = 10
+	defaultWeight             = 1
 	// maximum number of bytes from the response to store
 	maxResponseBytes = 256
+	// maximum number of follow-up tasks that can be chained after an initial one
+	MaxChainDepth = 10
+	// limits on labels: how many a task can have, and the size of their keys and values (bytes)
+	MaxLabels          = 20
+	MaxLabelKeyBytes   = 64
+	MaxLabelValueBytes = 256
+	// how tasks came to be, see CreatedBy; follow-up tasks are created by CreatedByRecurring:<task_name>@<trigger_at>
+	CreatedByAPI       = "api"
+	CreatedByImport    = "import"
+	CreatedByAlert     = "alertmanager"
+	CreatedByRecurring = "recurring"
 )
 
 type Task struct {
@@ -36,37 +60,381 @@ type Task struct {
 	MaxDelay           int    `json:"max_delay,omitempty"`
 	TaskState          string `json:"task_state"`
 	ResponseBody       string `json:"response_body"`
+	ResponseBodyHash   string `json:"response_body_hash,omitempty"`
 	ResponseStatus     int    `json:"response_status"`
 	ExecutedAt         string `json:"executed_at"`
+	// task to schedule once this one succeeds; its trigger_at is relative to the time this one completed
+	OnSuccess  *Task `json:"on_success,omitempty"`
+	ChainDepth int   `json:"chain_depth,omitempty"`
+	// equivalent endpoints to choose from (with optional weights) instead of a single callback endpoint
+	CallbackPool        []string `json:"callback_pool,omitempty"`
+	CallbackPoolWeights []int    `json:"callback_pool_weights,omitempty"`
+	// member of the callback pool that handled the last attempt
+	HandledBy string `json:"handled_by,omitempty"`
+	// do not run on weekends or specific dates (ISO 8601), moving the task to the next allowed day instead
+	SkipWeekends bool     `json:"skip_weekends,omitempty"`
+	SkipHolidays []string `json:"skip_holidays,omitempty"`
+	// number of times the task was explicitly retried after failing
+	RetryCount int `json:"retry_count,omitempty"`
+	// instance that executed the task
+	ClaimedBy string `json:"claimed_by,omitempty"`
+	// do not run if a task with the same name succeeded within this many minutes
+	SkipIfRecentSuccessMinutes int `json:"skip_if_recent_success_minutes,omitempty"`
+	// do not read the whole response into memory, only the part of it that is stored
+	StreamResponse bool `json:"stream_response,omitempty"`
+	// optional, client provided, identifier (32 hex characters) that makes creating the task idempotent
+	UUID string `json:"uuid,omitempty"`
+	// time it took to call back, including retries
+	ExecutionDurationMs int64 `json:"execution_duration_ms,omitempty"`
+	// reserved tasks are placeholders, removed unless confirmed with the full definition by then (Unix timestamp)
+	ReservedUntil string `json:"reserved_until,omitempty"`
+	// how the task was created: by an API call, an import, or as the follow-up of another task
+	CreatedBy string `json:"created_by,omitempty"`
+	// store the payload encrypted with a data key that is itself encrypted with this KMS key; only the encrypted
+	// payload and data key (base64) are stored, the payload is decrypted right before calling back
+	EncryptPayload   bool   `json:"encrypt_payload,omitempty"`
+	PayloadKMSKeyID  string `json:"payload_kms_key_id,omitempty"`
+	EncryptedPayload string `json:"encrypted_payload,omitempty"`
+	EncryptedDataKey string `json:"encrypted_data_key,omitempty"`
+	// arbitrary metadata for clients to tag and filter tasks by, ignored otherwise
+	Labels map[string]string `json:"labels,omitempty"`
+	// call back over HTTP/2 only, with prior knowledge (h2c) for plain HTTP endpoints
+	HTTP2 bool `json:"http2,omitempty"`
+	// http(s) or s3 URL of the payload, fetched right before calling back, instead of an inline payload
+	PayloadRef string `json:"payload_ref,omitempty"`
+	// HTTP statuses, other than ExpectedHTTPStatus, that also mean the callback succeeded, e.g., 301
+	AcceptableHTTPStatuses []int `json:"acceptable_http_statuses,omitempty"`
+	// number of slots the callback takes while running, when their number is limited; heavier callbacks take more
+	Weight int `json:"weight,omitempty"`
+	// added to the query string of the callback URL, for any method, regardless of the payload
+	QueryParams map[string]string `json:"query_params,omitempty"`
+}
+
+// FieldDiff is the value of a field before and after a change
+type FieldDiff struct {
+	From interface{} `json:"from"`
+	To   interface{} `json:"to"`
+}
+
+// PastMaxDelay returns true iff more than MaxDelay minutes have passed between TriggerAt and now (Unix timestamp),
+// i.e., it's too late to call back
+func (t Task) PastMaxDelay(now int64) bool {
+	// by now trigger_at has been validated, it should be safe to ignore the error
+	triggerAt, _ := strconv.ParseInt(t.TriggerAt, 10, 64)
+
+	return now > triggerAt+int64(t.MaxDelay)*60
 }
 
 func (t Task) String() string {
 	return fmt.Sprintf("%s@%s -> %s", t.Name, t.TriggerAt, t.CallbackEndpoint)
 }
 
-func (t Task) IsValid() error {
-	if t.TriggerAt == "" || t.Name == "" || t.CallbackEndpoint == "" {
-		return errors.New("incomplete task definition, required fields missing: trigger_at, task_name, callback")
+// Diff returns the fields, identified by their JSON names, whose values differ between t and other
+func (t Task) Diff(other Task) map[string]FieldDiff {
+	diff := make(map[string]FieldDiff)
+
+	from := reflect.ValueOf(t)
+	to := reflect.ValueOf(other)
+	for i := 0; i < from.NumField(); i++ {
+		field := from.Type().Field(i)
+		if field.PkgPath != "" {
+			continue
+		}
+
+		a := from.Field(i).Interface()
+		b := to.Field(i).Interface()
+		if reflect.DeepEqual(a, b) {
+			continue
+		}
+
+		name := strings.Split(field.Tag.Get("json"), ",")[0]
+		if name == "" {
+			name = field.Name
+		}
+		diff[name] = FieldDiff{From: a, To: b}
 	}
 
-	if !(t.CallbackMethod == "" ||
-		t.CallbackMethod == "GET" ||
-		t.CallbackMethod == "POST" ||
-		t.CallbackMethod == "PUT" ||
-		t.CallbackMethod == "DELETE") {
-		return errors.New("unsupported HTTP method:" + t.CallbackMethod)
+	return diff
+}
+
+// Logger returns a child of base that includes the task's details on every entry
+func (t Task) Logger(base *zap.Logger) *zap.Logger {
+	return base.With(
+		zap.String("task_id", t.Name+"@"+t.TriggerAt),
+		zap.String("task_name", t.Name),
+		zap.String("trigger_at", t.TriggerAt),
+		zap.String("callback", t.CallbackEndpoint),
+	)
+}
+
+// ValidationError is one of the reasons a task definition is invalid, along with the (JSON) field it refers to, e.g.,
+// on_success.trigger_at
+type ValidationError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+func (e ValidationError) Error() string {
+	return e.Message
+}
+
+// ValidationErrors are all the reasons a task definition is invalid
+type ValidationErrors []ValidationError
+
+func (errs ValidationErrors) Error() string {
+	messages := make([]string, 0, len(errs))
+	for _, e := range errs {
+		messages = append(messages, e.Message)
+	}
+
+	return strings.Join(messages, "; ")
+}
+
+// IsValid checks that all required fields are set to sensible values, see Validate, and returns the first reason the
+// task is invalid, if any.
+func (t Task) IsValid(maxPayloadBytes int) error {
+	errs := t.Validate(maxPayloadBytes)
+	if len(errs) > 0 {
+		return errs[0]
 	}
 
 	return nil
 }
 
-func (t *Task) SetDefaults() {
+// Validate checks that all required fields are set to sensible values and returns all the reasons the task is
+// invalid, if any. The payload cannot be longer than maxPayloadBytes; 0 means there is no limit.
+func (t Task) Validate(maxPayloadBytes int) ValidationErrors {
+	errs := make(ValidationErrors, 0)
+	invalid := func(field string, message string) {
+		errs = append(errs, ValidationError{Field: field, Message: message})
+	}
+
+	if t.TriggerAt == "" {
+		invalid("trigger_at", "required field missing: trigger_at")
+	}
+	if t.Name == "" {
+		invalid("task_name", "required field missing: task_name")
+	}
+	if t.CallbackEndpoint == "" && len(t.CallbackPool) == 0 {
+		invalid("callback", "required field missing: callback")
+	}
+
+	if len(t.CallbackPoolWeights) > 0 {
+		if len(t.CallbackPoolWeights) != len(t.CallbackPool) {
+			invalid("callback_pool_weights", "callback_pool_weights must have one weight for each member of callback_pool")
+		}
+		for _, w := range t.CallbackPoolWeights {
+			if w <= 0 {
+				invalid("callback_pool_weights", "callback_pool_weights must be positive integers")
+				break
+			}
+		}
+	}
+
+	if maxPayloadBytes > 0 && len(t.Payload) > maxPayloadBytes {
+		invalid("payload", fmt.Sprintf("payload too large: %d bytes, the maximum is %d", len(t.Payload), maxPayloadBytes))
+	}
+
+	if !(t.CallbackMethod == "" || IsValidCallbackMethod(t.CallbackMethod)) {
+		invalid("callback_method", "unsupported HTTP method:"+t.CallbackMethod)
+	}
+
+	for _, day := range t.SkipHolidays {
+		_, err := time.Parse(util.DateLayout, day)
+		if err != nil {
+			invalid("skip_holidays", "invalid date in skip_holidays, expected YYYY-MM-DD: "+day)
+		}
+	}
+
+	if t.SkipIfRecentSuccessMinutes < 0 {
+		invalid("skip_if_recent_success_minutes", "skip_if_recent_success_minutes cannot be negative")
+	}
+
+	if t.EncryptPayload && t.PayloadKMSKeyID == "" {
+		invalid("payload_kms_key_id", "payload_kms_key_id is required to encrypt the payload")
+	}
+
+	if t.PayloadRef != "" {
+		if t.Payload != "" || t.EncryptPayload {
+			invalid("payload_ref", "payload_ref cannot be combined with an inline or encrypted payload")
+		}
+		u, err := url.Parse(t.PayloadRef)
+		if err != nil || u.Host == "" || !(u.Scheme == "http" || u.Scheme == "https" || u.Scheme == "s3") {
+			invalid("payload_ref", "invalid payload_ref, expected an http(s) or s3 URL: "+t.PayloadRef)
+		}
+	}
+
+	if t.Weight < 0 {
+		invalid("weight", "invalid weight, expected a positive integer: "+strconv.Itoa(t.Weight))
+	}
+
+	for _, status := range t.AcceptableHTTPStatuses {
+		if status < 100 || status > 599 {
+			invalid("acceptable_http_statuses", "invalid HTTP status in acceptable_http_statuses: "+strconv.Itoa(status))
+		}
+	}
+
+	if err := validateLabels(t.Labels); err != nil {
+		invalid("labels", err.Error())
+	}
+
+	if err := t.validateQueryParams(); err != nil {
+		invalid("query_params", err.Error())
+	}
+
+	if t.UUID != "" && !isValidUUID(t.UUID) {
+		invalid("uuid", "invalid uuid, expected 32 hexadecimal characters: "+t.UUID)
+	}
+
+	if t.OnSuccess != nil {
+		if t.ChainDepth >= MaxChainDepth {
+			invalid("on_success", "too many chained tasks, the maximum is "+strconv.Itoa(MaxChainDepth))
+			return errs
+		}
+		// follow-up tasks are always scheduled relative to the time the previous one completed
+		if t.OnSuccess.TriggerAt != "" && !strings.HasPrefix(t.OnSuccess.TriggerAt, "+") {
+			invalid("on_success.trigger_at", "trigger_at must be a relative time specification for on_success tasks")
+		}
+		next := *t.OnSuccess
+		next.ChainDepth = t.ChainDepth + 1
+		for _, e := range next.Validate(maxPayloadBytes) {
+			invalid("on_success."+e.Field, e.Message)
+		}
+	}
+
+	return errs
+}
+
+// labels are bounded in number and size, and keys cannot be empty
+func validateLabels(labels map[string]string) error {
+	if len(labels) > MaxLabels {
+		return fmt.Errorf("too many labels: %d, the maximum is %d", len(labels), MaxLabels)
+	}
+	for k, v := range labels {
+		if k == "" || len(k) > MaxLabelKeyBytes {
+			return fmt.Errorf("invalid label key, expected 1 to %d bytes: %q", MaxLabelKeyBytes, k)
+		}
+		if len(v) > MaxLabelValueBytes {
+			return fmt.Errorf("label value too large, the maximum is %d bytes: %q", MaxLabelValueBytes, k)
+		}
+	}
+
+	return nil
+}
+
+// query parameters need a name, and cannot replace those already in the query string of any callback URL
+func (t Task) validateQueryParams() error {
+	if len(t.QueryParams) == 0 {
+		return nil
+	}
+	for k := range t.QueryParams {
+		if k == "" {
+			return errors.New("invalid query_params, names cannot be empty")
+		}
+	}
+
+	endpoints := append([]string{t.CallbackEndpoint}, t.CallbackPool...)
+	for _, endpoint := range endpoints {
+		if endpoint == "" {
+			continue
+		}
+		u, err := url.Parse(endpoint)
+		if err != nil {
+			return errors.New("invalid callback URL: " + endpoint)
+		}
+		existing := u.Query()
+		for k := range t.QueryParams {
+			if _, ok := existing[k]; ok {
+				return fmt.Errorf("query_params conflicts with the query string of %s: %q", endpoint, k)
+			}
+		}
+	}
+
+	return nil
+}
+
+// add the query parameters of the task to the query string of endpoint
+func (t Task) withQueryParams(endpoint string) string {
+	if len(t.QueryParams) == 0 {
+		return endpoint
+	}
+	// by now the endpoint has been validated, it should be safe to fall back to it as is
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return endpoint
+	}
+	query := u.Query()
+	for k, v := range t.QueryParams {
+		query.Set(k, v)
+	}
+	u.RawQuery = query.Encode()
+
+	return u.String()
+}
+
+// FollowUpUUID returns the UUID of the task created when t succeeds. It is derived from the UUID of t (or its key, if
+// it has none) and the position of the follow-up in the chain, so it's the same every time t is executed.
+func (t Task) FollowUpUUID() string {
+	parent := t.UUID
+	if parent == "" {
+		parent = t.Name + "@" + t.TriggerAt
+	}
+	sum := sha256.Sum256([]byte(parent + "#" + strconv.Itoa(t.ChainDepth+1)))
+
+	return hex.EncodeToString(sum[:16])
+}
+
+// NewUUID returns a random identifier in the format expected for UUID
+func NewUUID() string {
+	b := make([]byte, 16)
+	// never fails, see crypto/rand.Read
+	_, _ = rand.Read(b)
+
+	return hex.EncodeToString(b)
+}
+
+// 32 hexadecimal characters, without dashes
+func isValidUUID(uuid string) bool {
+	if len(uuid) != 32 {
+		return false
+	}
+	_, err := hex.DecodeString(uuid)
+
+	return err == nil
+}
+
+// IsValidState returns true iff state is one of the states a task can be in
+func IsValidState(state string) bool {
+	return state == Pending ||
+		state == Running ||
+		state == Successful ||
+		state == Failed ||
+		state == Skipped ||
+		state == Reserved
+}
+
+// IsValidCallbackMethod returns true iff method is one of the HTTP methods supported for callbacks
+func IsValidCallbackMethod(method string) bool {
+	return method == "GET" ||
+		method == "POST" ||
+		method == "PUT" ||
+		method == "PATCH" ||
+		method == "DELETE" ||
+		method == "HEAD"
+}
+
+// SetDefaults sets all missing fields to their default values. The HTTP method and expected response status
+// default to callbackMethod and expectedHTTPStatus, respectively, or GET and 200 if those are not set either.
+func (t *Task) SetDefaults(callbackMethod string, expectedHTTPStatus int) {
 	// initial status
 	t.TaskState = Pending
 
 	// HTTP method
+	if callbackMethod == "" {
+		callbackMethod = defaultCallbackMethod
+	}
 	if t.CallbackMethod == "" {
-		t.CallbackMethod = defaultCallbackMethod
+		t.CallbackMethod = callbackMethod
 	}
 
 	// set the number of retries to 1, if it has not been defined
@@ -74,40 +442,85 @@ func (t *Task) SetDefaults() {
 		t.Retry = defaultRetry
 	}
 
-	// default the expected response HTTP status to 200
+	// expected response HTTP status
+	if expectedHTTPStatus == 0 {
+		expectedHTTPStatus = defaultExpectedHTTPStatus
+	}
 	if t.ExpectedHTTPStatus == 0 {
-		t.ExpectedHTTPStatus = defaultExpectedHTTPStatus
+		t.ExpectedHTTPStatus = expectedHTTPStatus
 	}
 
 	// default max delay (minutes)
 	if t.MaxDelay == 0 {
 		t.MaxDelay = defaultMaxDelay
 	}
+
+	// a single slot
+	if t.Weight == 0 {
+		t.Weight = defaultWeight
+	}
+}
+
+// CallbackOptions sets how Callback stores the response and when it can avoid sending the request
+type CallbackOptions struct {
+	// otherwise only a SHA-256 hash of the response body is kept
+	StoreResponseBody bool
+	// if not empty, the response body is only kept for these content types, e.g., application/json or text/*
+	StoreContentTypes []string
+	// if not nil, the response of an identical successful callback, recent or in progress, is reused
+	Cache *ResponseCache
+	// whether a task with the given name succeeded after the given Unix timestamp, see SkipIfRecentSuccessMinutes
+	SucceededSince func(string, int64) (bool, error)
 }
 
 // Callback hits the callback endpoint, with the provided payload,
 // using the specified HTTP method. On failure it will retry, using exponential backoff logic,
 // up until the number of times set. Finally, it will update the Status and ResponseBody fields.
-func (t Task) Callback(httpClient *http.Client, updateTask func(Task) error, logger *zap.Logger) {
+// On success, the follow-up task, if any, is created with createTask. Canceling ctx discards the outcome.
+func (t Task) Callback(
+	ctx context.Context,
+	httpClient *http.Client,
+	updateTask func(Task) error,
+	createTask func(Task) error,
+	opts CallbackOptions,
+	logger *zap.Logger,
+) {
 	var status int
 	var response []byte
+	var contentType string
 
-	logger.Debug("Starting callback", zap.String("task", t.String()))
+	logger = t.Logger(logger)
+	logger.Debug("Starting callback")
 
 	// make sure we're not past max delay
 	currentMinute := util.GetUnixMinute()
-	// by now trigger_at has been validated, it should be safe to ignore the error
-	triggerAt, _ := strconv.Atoi(t.TriggerAt)
-	if currentMinute > int64(triggerAt)+int64(t.MaxDelay)*60 {
+	if t.PastMaxDelay(currentMinute) {
 		logger.Error(
 			"Skipping callback because we're past max_delay",
-			zap.String("trigger_at", t.TriggerAt),
 			zap.Int64("current_minute", currentMinute),
 			zap.Int("max_delay", t.MaxDelay),
 		)
 		return
 	}
 
+	// an equivalent task may have just run
+	if t.SkipIfRecentSuccessMinutes > 0 && opts.SucceededSince != nil {
+		since := time.Now().Unix() - int64(t.SkipIfRecentSuccessMinutes)*60
+		succeeded, err := opts.SucceededSince(t.Name, since)
+		if err != nil {
+			// better to run it twice than not at all
+			logger.Error("Failed to check for recent successful executions", zap.Error(err))
+		} else if succeeded {
+			logger.Debug("Skipping callback, the task succeeded recently", zap.Int64("since", since))
+			t.TaskState = Skipped
+			err = updateTask(t)
+			if err != nil {
+				logger.Error("Failed to update task", zap.Error(err))
+			}
+			return
+		}
+	}
+
 	// update the state before starting
 	t.TaskState = Running
 	err := updateTask(t)
@@ -115,40 +528,399 @@ func (t Task) Callback(httpClient *http.Client, updateTask func(Task) error, log
 		logger.Error("Failed to update task", zap.Error(err))
 	}
 
-	status, response = util.SendHTTPRequest(
-		t.CallbackEndpoint,
+	cached := false
+	startTime := time.Now()
+	if opts.Cache != nil {
+		status, response, contentType, cached = opts.Cache.Do(t, func() (int, []byte, string, bool) {
+			status, response, contentType := t.send(ctx, httpClient, logger)
+			return status, response, contentType, ctx.Err() == nil && t.isSuccess(status)
+		})
+	} else {
+		status, response, contentType = t.send(ctx, httpClient, logger)
+	}
+
+	if cached {
+		logger.Debug("Reusing the response of an identical callback")
+		t.TaskState = Successful
+		t.ExecutionDurationMs = 0
+	} else {
+		t.ExecutionDurationMs = time.Since(startTime).Milliseconds()
+
+		// the task is gone, storing the outcome would bring it back
+		if ctx.Err() != nil {
+			logger.Debug("Callback canceled", zap.Error(ctx.Err()))
+			return
+		}
+
+		logger.Debug(
+			"Callback completed",
+			zap.Int("http_status", status),
+			zap.Int64("duration_ms", t.ExecutionDurationMs),
+		)
+
+		// update the task state
+		if t.isSuccess(status) {
+			t.TaskState = Successful
+		} else {
+			t.TaskState = Failed
+		}
+	}
+	// and execution timestamp
+	t.ExecutedAt = strconv.FormatInt(time.Now().Unix(), 10)
+	// and received HTTP response
+	t.ResponseStatus = status
+	if opts.StoreResponseBody && !contentTypeAllowed(contentType, opts.StoreContentTypes) {
+		logger.Debug("Not storing the response body", zap.String("content_type", contentType))
+		t.ResponseBody = ""
+		t.ResponseBodyHash = ""
+	} else {
+		t.setResponseBody(response, opts.StoreResponseBody)
+	}
+
+	// update the task's state now that we're done
+	err = updateTask(t)
+	if err != nil {
+		logger.Error("Failed to update task", zap.Error(err))
+	}
+
+	logger.Debug("Task updated", zap.Int("http_status", status))
+
+	if t.TaskState == Successful && t.OnSuccess != nil {
+		t.scheduleOnSuccess(createTask, logger)
+	}
+}
+
+// whether a callback that got a response with this HTTP status succeeded
+func (t Task) isSuccess(status int) bool {
+	if status == t.ExpectedHTTPStatus {
+		return true
+	}
+	for _, acceptable := range t.AcceptableHTTPStatuses {
+		if status == acceptable {
+			return true
+		}
+	}
+
+	return false
+}
+
+// if the task is scheduled for a day it should skip, return the same time of the day on the next allowed day
+// global holidays only apply to tasks that skip weekends or their own holidays
+func (t Task) nextAllowedDay(holidays []string) (time.Time, bool) {
+	if !t.SkipWeekends && len(t.SkipHolidays) == 0 {
+		return time.Time{}, false
+	}
+
+	// by now trigger_at has been validated, it should be safe to ignore the error
+	triggerAt, _ := strconv.ParseInt(t.TriggerAt, 10, 64)
+	at := time.Unix(triggerAt, 0).UTC()
+
+	skipToday := t.SkipWeekends && (at.Weekday() == time.Saturday || at.Weekday() == time.Sunday)
+	skip := make([]time.Time, 0, len(t.SkipHolidays)+len(holidays))
+	for _, days := range [][]string{t.SkipHolidays, holidays} {
+		for _, day := range days {
+			d, err := time.Parse(util.DateLayout, day)
+			if err != nil {
+				continue
+			}
+			skip = append(skip, d)
+			if d.Format(util.DateLayout) == at.Format(util.DateLayout) {
+				skipToday = true
+			}
+		}
+	}
+
+	if !skipToday {
+		return time.Time{}, false
+	}
+
+	if t.SkipWeekends {
+		return util.NextWorkingDay(at, skip), true
+	}
+	return util.NextDayExcept(at, skip), true
+}
+
+// MoveIfDayOff marks the task as skipped, and creates a new one at the same time of the day on the next allowed day,
+// if it's scheduled for a day it should skip: a weekend with SkipWeekends, one of its SkipHolidays, or, for tasks that
+// set either, one of the global holidays. It returns true iff the task was moved.
+func (t Task) MoveIfDayOff(
+	holidays []string,
+	updateTask func(Task) error,
+	createTask func(Task) error,
+	logger *zap.Logger,
+) bool {
+	next, skip := t.nextAllowedDay(holidays)
+	if skip {
+		t.moveTo(next, updateTask, createTask, logger)
+	}
+
+	return skip
+}
+
+// mark the task as skipped and create a new one scheduled at the given time
+func (t Task) moveTo(at time.Time, updateTask func(Task) error, createTask func(Task) error, logger *zap.Logger) {
+	next := t
+	next.TriggerAt = strconv.FormatInt(at.Unix(), 10)
+	next.TaskState = Pending
+
+	logger.Debug("Skipping day off", zap.String("next", next.TriggerAt))
+
+	t.TaskState = Skipped
+	err := updateTask(t)
+	if err != nil {
+		logger.Error("Failed to update task", zap.Error(err))
+	}
+
+	err = createTask(next)
+	if err != nil {
+		logger.Error("Failed to move task to the next allowed day", zap.Error(err), zap.String("task", next.String()))
+	}
+}
+
+// create the follow-up task, relative to the current time
+func (t Task) scheduleOnSuccess(createTask func(Task) error, logger *zap.Logger) {
+	next := *t.OnSuccess
+	next.ChainDepth = t.ChainDepth + 1
+	// should have been caught by IsValid, but we don't want to risk an infinite chain
+	if next.ChainDepth > MaxChainDepth {
+		logger.Error(
+			"Not scheduling follow-up task, maximum chain depth exceeded",
+			zap.Int("chain_depth", next.ChainDepth),
+		)
+		return
+	}
+
+	triggerAt, err := NormalizeTriggerAt(next.TriggerAt)
+	if err != nil {
+		logger.Error("Invalid trigger_at on follow-up task", zap.Error(err))
+		return
+	}
+	next.TriggerAt = triggerAt
+	// all other defaults are set when the task is created
+	next.TaskState = Pending
+	next.CreatedBy = CreatedByRecurring + ":" + t.Name + "@" + t.TriggerAt
+	// the same one regardless of how many times t is executed, so that it can be created only once
+	next.UUID = t.FollowUpUUID()
+
+	err = createTask(next)
+	if err != nil {
+		logger.Error("Failed to create follow-up task", zap.Error(err), zap.String("task", next.String()))
+	}
+}
+
+// make the request to the callback endpoint or, if one is defined, to the members of the callback pool, rotating
+// between them on failure
+func (t *Task) send(ctx context.Context, httpClient *http.Client, logger *zap.Logger) (int, []byte, string) {
+	if len(t.CallbackPool) == 0 {
+		return t.request(ctx, t.CallbackEndpoint, t.Retry, httpClient, logger)
+	}
+
+	weights := t.CallbackPoolWeights
+	if len(weights) == 0 {
+		weights = make([]int, len(t.CallbackPool))
+		for i := range weights {
+			weights[i] = 1
+		}
+	}
+	order := util.WeightedShuffle(weights)
+
+	var status int
+	var response []byte
+	var contentType string
+	for i := 0; i < t.Retry; i++ {
+		t.HandledBy = t.CallbackPool[order[i%len(order)]]
+		status, response, contentType = t.request(ctx, t.HandledBy, 1, httpClient, logger)
+
+		// success or client side error, no point on trying another endpoint
+		if t.isSuccess(status) || (status >= 400 && status <= 499) || ctx.Err() != nil {
+			return status, response, contentType
+		}
+
+		logger.Error(
+			"Callback failed, moving on to the next endpoint in the pool",
+			zap.String("endpoint", t.HandledBy),
+			zap.Int("http_status", status),
+		)
+		if i < t.Retry-1 && util.BackoffContext(ctx, i, logger) != nil {
+			break
+		}
+	}
+
+	return status, response, contentType
+}
+
+// make the request to a given endpoint and return the status, body, and content type of the response; responses are
+// streamed, and truncated, if the task says so
+func (t *Task) request(
+	ctx context.Context,
+	endpoint string,
+	retries int,
+	httpClient *http.Client,
+	logger *zap.Logger,
+) (int, []byte, string) {
+	// no limit unless streaming
+	response := &truncatingBuffer{}
+	if t.StreamResponse {
+		response.limit = maxResponseBytes
+	}
+	status, err := util.SendHTTPRequestStreamingContext(
+		ctx,
+		t.withQueryParams(endpoint),
 		[]byte(t.Payload),
 		http.Header{},
 		t.CallbackMethod,
 		httpClient,
 		t.ExpectedHTTPStatus,
-		t.Retry,
+		retries,
+		response,
 		logger,
 	)
+	if err != nil {
+		return status, []byte(err.Error()), ""
+	}
 
-	logger.Debug("Callback completed", zap.String("task", t.String()), zap.Int("http_status", status))
+	return status, response.Bytes(), response.header.Get("Content-Type")
+}
 
-	// update the task state
-	if status == t.ExpectedHTTPStatus {
-		t.TaskState = Successful
-	} else {
-		t.TaskState = Failed
+// bytes.Buffer that silently discards everything after the first limit bytes, if limit is greater than 0, and keeps
+// the headers of the response
+type truncatingBuffer struct {
+	bytes.Buffer
+	limit  int
+	header http.Header
+}
+
+func (b *truncatingBuffer) ReceiveHeader(header http.Header) {
+	b.header = header
+}
+
+func (b *truncatingBuffer) Write(p []byte) (int, error) {
+	if b.limit <= 0 {
+		return b.Buffer.Write(p)
 	}
-	// and execution timestamp
-	t.ExecutedAt = strconv.FormatInt(time.Now().Unix(), 10)
-	// and received HTTP response
-	t.ResponseStatus = status
+
+	if room := b.limit - b.Len(); room > 0 {
+		if len(p) > room {
+			b.Buffer.Write(p[:room])
+		} else {
+			b.Buffer.Write(p)
+		}
+	}
+
+	// pretend everything was written so that the rest of the response is consumed
+	return len(p), nil
+}
+
+// store the (possibly truncated) response body or, if storeResponseBody is false, just a hash of it
+func (t *Task) setResponseBody(response []byte, storeResponseBody bool) {
+	if !storeResponseBody {
+		hash := sha256.Sum256(response)
+		t.ResponseBody = ""
+		t.ResponseBodyHash = hex.EncodeToString(hash[:])
+		return
+	}
+
 	if len(response) < maxResponseBytes {
 		t.ResponseBody = string(response)
 	} else {
 		t.ResponseBody = string(response[:maxResponseBytes])
 	}
+}
+
+// check whether the response body should be stored for a given content type; an empty allowlist allows everything
+func contentTypeAllowed(contentType string, allowed []string) bool {
+	if len(allowed) == 0 {
+		return true
+	}
 
-	// update the task's state now that we're done
-	err = updateTask(t)
+	mediaType, _, err := mime.ParseMediaType(contentType)
 	if err != nil {
-		logger.Error("Failed to update task", zap.Error(err), zap.String("task", t.String()))
+		return false
+	}
+
+	for _, a := range allowed {
+		a = strings.ToLower(strings.TrimSpace(a))
+		if a == mediaType || (strings.HasSuffix(a, "/*") && strings.HasPrefix(mediaType, strings.TrimSuffix(a, "*"))) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// NormalizeTriggerAt returns the Unix timestamp with 1-minute resolution corresponding to a relative time
+// specification. If the input provided is already a Unix timestamp, it ensures it uses 1-minute resolution.
+func NormalizeTriggerAt(input string) (string, error) {
+	return normalizeTriggerAt(input, util.GetUnixMinute())
+}
+
+// normalize trigger_at relative to now, the current minute
+func normalizeTriggerAt(input string, now int64) (string, error) {
+	// future Unix timestamps have way more than 3 characters
+	// a valid format is of the form `+<int><time_identifier>` which cannot be less than 3 chars
+	if len(input) < 3 {
+		return "", errors.New("invalid format for trigger_at: " + input)
 	}
 
-	logger.Debug("Task updated", zap.String("task", t.String()), zap.Int("http_status", status))
+	// are we being given a Unix time stamp or a relative time format?
+	// relative time specifications start with +
+	relative := input[:1] == "+"
+	if relative {
+		// validate the input
+		re := regexp.MustCompile("[+]([0-9]+)([mhd])")
+		parts := re.FindStringSubmatch(input)
+		if len(parts) != 3 {
+			return "", errors.New("invalid relative time specification")
+		}
+		// extract the relative time and compute the corresponding Unix time stamp
+		spec := parts[2]
+		inputTime, err := strconv.ParseInt(parts[1], 10, 64)
+		if err != nil {
+			if errors.Is(err, strconv.ErrRange) {
+				return "", errors.New("relative time specification out of range: " + input)
+			}
+			return "", errors.New("invalid integer in relative time specification")
+		}
+		// convert whatever time value we received to seconds and add to the current time stamp
+		var seconds int64
+		switch spec {
+		case "m":
+			seconds = 60
+		case "h":
+			seconds = 3600
+		case "d":
+			seconds = 86400
+		default:
+			return "", errors.New("unknown relative time specifier")
+		}
+		// reject, rather than wrap around, anything past the largest Unix time stamp
+		if inputTime > (math.MaxInt64-now)/seconds {
+			return "", errors.New("relative time specification out of range: " + input)
+		}
+		triggerAt := now + inputTime*seconds
+		// the current minute may already be (or have been) processed, the earliest we can guarantee is the next one
+		if triggerAt < now+60 {
+			triggerAt = now + 60
+		}
+		return strconv.FormatInt(triggerAt, 10), nil
+	} else {
+		// input is a Unix time stamp --> validate it
+		inputTime, err := strconv.ParseInt(input, 10, 64)
+		if err != nil {
+			if errors.Is(err, strconv.ErrRange) {
+				return "", errors.New("Unix time stamp out of range: " + input)
+			}
+			return "", errors.New("invalid Unix time stamp: " + input)
+		}
+		// enforce time with 1-minute resolution
+		if inputTime%60 != 0 {
+			return "", errors.New("trigger_at must be on 1-minute resolution")
+		}
+		// make sure it's in the future
+		if inputTime <= now {
+			return "", errors.New("trigger_at must be in the future")
+		}
+		// all good
+		return input, nil
+	}
 }
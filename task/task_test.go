@@ -0,0 +1,946 @@
+package task
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/marcoalmeida/callme/util"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+// run the callback for a task against a test server responding with body and return the last update
+func runCallback(t *testing.T, tsk Task, body string, storeResponseBody bool) Task {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(body))
+	}))
+	defer ts.Close()
+
+	tsk.CallbackEndpoint = ts.URL
+	if tsk.TriggerAt == "" {
+		tsk.TriggerAt = strconv.FormatInt(util.GetUnixMinute(), 10)
+	}
+	tsk.SetDefaults("", 0)
+
+	var updated Task
+	updateTask := func(t Task) error {
+		updated = t
+		return nil
+	}
+	opts := CallbackOptions{StoreResponseBody: storeResponseBody}
+	tsk.Callback(context.Background(), http.DefaultClient, updateTask, nil, opts, zap.NewNop())
+
+	return updated
+}
+
+func TestCallback_storeResponseBody(t *testing.T) {
+	body := "some response"
+
+	updated := runCallback(t, Task{Name: "t0"}, body, true)
+	if updated.TaskState != Successful {
+		t.Error("Expected task state", Successful, "got", updated.TaskState)
+	}
+	if updated.ResponseBody != body {
+		t.Error("Expected response body", body, "got", updated.ResponseBody)
+	}
+	if updated.ResponseBodyHash != "" {
+		t.Error("Expected no response body hash, got", updated.ResponseBodyHash)
+	}
+}
+
+func TestCallback_streamResponse(t *testing.T) {
+	body := strings.Repeat("a", 2*maxResponseBytes)
+
+	updated := runCallback(t, Task{Name: "t0", StreamResponse: true}, body, true)
+	if updated.TaskState != Successful {
+		t.Error("Expected task state", Successful, "got", updated.TaskState)
+	}
+	if updated.ResponseBody != body[:maxResponseBytes] {
+		t.Error("Expected the response body to be truncated to", maxResponseBytes, "bytes, got", len(updated.ResponseBody))
+	}
+}
+
+func TestCallback_omitResponseBody(t *testing.T) {
+	body := "some sensitive response"
+	hash := sha256.Sum256([]byte(body))
+	expected := hex.EncodeToString(hash[:])
+
+	updated := runCallback(t, Task{Name: "t0"}, body, false)
+	if updated.TaskState != Successful {
+		t.Error("Expected task state", Successful, "got", updated.TaskState)
+	}
+	if updated.ResponseBody != "" {
+		t.Error("Expected the response body to be omitted, got", updated.ResponseBody)
+	}
+	if updated.ResponseBodyHash != expected {
+		t.Error("Expected response body hash", expected, "got", updated.ResponseBodyHash)
+	}
+}
+
+func TestCallback_storeResponseContentTypes(t *testing.T) {
+	tests := []struct {
+		contentType string
+		body        string
+		stored      bool
+	}{
+		{"application/json; charset=utf-8", `{"ok": true}`, true},
+		{"text/plain", "ok", true},
+		{"image/png", "\x89PNG", false},
+	}
+
+	for _, test := range tests {
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", test.contentType)
+			w.Write([]byte(test.body))
+		}))
+
+		tsk := Task{Name: "t0", CallbackEndpoint: ts.URL, TriggerAt: strconv.FormatInt(util.GetUnixMinute(), 10)}
+		tsk.SetDefaults("", 0)
+
+		var updated Task
+		opts := CallbackOptions{StoreResponseBody: true, StoreContentTypes: []string{"application/json", "text/*"}}
+		tsk.Callback(context.Background(), http.DefaultClient, func(t Task) error {
+			updated = t
+			return nil
+		}, nil, opts, zap.NewNop())
+		ts.Close()
+
+		if updated.ResponseStatus != http.StatusOK {
+			t.Error("Expected status", http.StatusOK, "got", updated.ResponseStatus)
+		}
+		if test.stored && updated.ResponseBody != test.body {
+			t.Error("Expected response body", test.body, "got", updated.ResponseBody)
+		}
+		if !test.stored && (updated.ResponseBody != "" || updated.ResponseBodyHash != "") {
+			t.Error("Expected the response body not to be stored for", test.contentType)
+		}
+	}
+}
+
+func TestCallback_deduplicate(t *testing.T) {
+	requests := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Write([]byte("ok"))
+	}))
+	defer ts.Close()
+
+	cache := NewResponseCache(60000)
+	triggerAt := strconv.FormatInt(util.GetUnixMinute(), 10)
+	for _, name := range []string{"t0", "t1", "t2"} {
+		tsk := Task{Name: name, TriggerAt: triggerAt, CallbackEndpoint: ts.URL, Payload: "same"}
+		tsk.SetDefaults("", 0)
+
+		var updated Task
+		tsk.Callback(context.Background(), http.DefaultClient, func(t Task) error {
+			updated = t
+			return nil
+		}, nil, CallbackOptions{StoreResponseBody: true, Cache: cache}, zap.NewNop())
+
+		if updated.TaskState != Successful || updated.ResponseBody != "ok" {
+			t.Error("Expected a successful task with response ok, got", updated.TaskState, updated.ResponseBody)
+		}
+	}
+
+	if requests != 1 {
+		t.Error("Expected a single request to the callback endpoint, got", requests)
+	}
+}
+
+func TestCallback_deduplicateConcurrent(t *testing.T) {
+	var requests int64
+	release := make(chan bool)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&requests, 1)
+		<-release
+		w.Write([]byte("ok"))
+	}))
+	defer ts.Close()
+
+	cache := NewResponseCache(60000)
+	triggerAt := strconv.FormatInt(util.GetUnixMinute(), 10)
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		tsk := Task{Name: "t" + strconv.Itoa(i), TriggerAt: triggerAt, CallbackEndpoint: ts.URL, Payload: "same"}
+		tsk.SetDefaults("", 0)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var updated Task
+			tsk.Callback(context.Background(), http.DefaultClient, func(t Task) error {
+				updated = t
+				return nil
+			}, nil, CallbackOptions{StoreResponseBody: true, Cache: cache}, zap.NewNop())
+			if updated.TaskState != Successful || updated.ResponseBody != "ok" {
+				t.Error("Expected a successful task with response ok, got", updated.TaskState, updated.ResponseBody)
+			}
+		}()
+	}
+	// give every callback the chance to start before the first one completes
+	time.Sleep(100 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if n := atomic.LoadInt64(&requests); n != 1 {
+		t.Error("Expected a single request to the callback endpoint, got", n)
+	}
+}
+
+func TestResponseCache_evict(t *testing.T) {
+	cache := NewResponseCache(1000)
+	tsk := Task{CallbackEndpoint: "http://example.com", Payload: "same"}
+	cache.Add(tsk, 200, []byte("ok"), "text/plain")
+
+	cache.Evict(time.Now())
+	if _, _, _, ok := cache.Get(tsk); !ok {
+		t.Error("Expected the response to be kept until it expires")
+	}
+	cache.Evict(time.Now().Add(2 * time.Second))
+	if _, ok := cache.entries.Load(cache.key(tsk)); ok {
+		t.Error("Expected the expired response to be evicted")
+	}
+}
+
+func TestResponseCache_key(t *testing.T) {
+	cache := NewResponseCache(60000)
+	tsk := Task{CallbackEndpoint: "http://example.com/hook", Payload: "same"}
+
+	for _, other := range []Task{
+		{CallbackEndpoint: "http://example.com/hook", Payload: "same", QueryParams: map[string]string{"id": "1"}},
+		{CallbackEndpoint: "http://example.com/hook", Payload: "same", CallbackPool: []string{"http://example.org"}},
+		{CallbackEndpoint: "http://example.com/hook", Payload: "same", CallbackMethod: "PUT"},
+		{CallbackEndpoint: "http://example.com/hook", Payload: "other"},
+	} {
+		if cache.key(other) == cache.key(tsk) {
+			t.Errorf("Expected %+v and %+v to be different callbacks", other, tsk)
+		}
+	}
+
+	// the same request, whether the parameters are part of the endpoint or not
+	same := Task{CallbackEndpoint: "http://example.com/hook?id=1", Payload: "same"}
+	other := Task{CallbackEndpoint: "http://example.com/hook", Payload: "same", QueryParams: map[string]string{"id": "1"}}
+	if cache.key(same) != cache.key(other) {
+		t.Error("Expected the same key for", same, "and", other)
+	}
+}
+
+func TestCallback_onSuccess(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/fail" {
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer ts.Close()
+
+	triggerAt := strconv.FormatInt(util.GetUnixMinute(), 10)
+	followUp := &Task{Name: "t1", TriggerAt: "+10m", CallbackEndpoint: ts.URL}
+
+	for _, test := range []struct {
+		endpoint   string
+		chainDepth int
+		created    bool
+	}{
+		{ts.URL, 0, true},
+		{ts.URL + "/fail", 0, false},
+		{ts.URL, MaxChainDepth, false},
+	} {
+		tsk := Task{
+			Name:             "t0",
+			TriggerAt:        triggerAt,
+			CallbackEndpoint: test.endpoint,
+			OnSuccess:        followUp,
+			ChainDepth:       test.chainDepth,
+		}
+		tsk.SetDefaults("", 0)
+
+		created := make([]Task, 0)
+		tsk.Callback(
+			context.Background(),
+			http.DefaultClient,
+			func(t Task) error { return nil },
+			func(t Task) error {
+				created = append(created, t)
+				return nil
+			},
+			CallbackOptions{StoreResponseBody: true},
+			zap.NewNop(),
+		)
+
+		if !test.created {
+			if len(created) != 0 {
+				t.Error("Expected no follow-up task for", test.endpoint, "at depth", test.chainDepth)
+			}
+			continue
+		}
+
+		if len(created) != 1 {
+			t.Fatal("Expected one follow-up task, got", len(created))
+		}
+		next := created[0]
+		expected := strconv.FormatInt(util.GetUnixMinute()+600, 10)
+		if next.Name != "t1" || next.TriggerAt != expected || next.TaskState != Pending || next.ChainDepth != 1 {
+			t.Error("Unexpected follow-up task", next, next.TaskState, next.ChainDepth)
+		}
+		if next.CreatedBy != CreatedByRecurring+":t0@"+triggerAt {
+			t.Error("Expected the follow-up task to be created by t0, got", next.CreatedBy)
+		}
+	}
+}
+
+func TestCallback_callbackPool(t *testing.T) {
+	live := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer live.Close()
+	dead := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	dead.Close()
+
+	// heavily favor the dead endpoint, it will most likely be tried first
+	util.SetRandSource(rand.NewSource(1))
+	tsk := Task{
+		Name:                "t0",
+		TriggerAt:           strconv.FormatInt(util.GetUnixMinute(), 10),
+		CallbackPool:        []string{dead.URL, live.URL},
+		CallbackPoolWeights: []int{100, 1},
+		Retry:               2,
+	}
+	tsk.SetDefaults("", 0)
+
+	var updated Task
+	tsk.Callback(context.Background(), http.DefaultClient, func(t Task) error {
+		updated = t
+		return nil
+	}, nil, CallbackOptions{StoreResponseBody: true}, zap.NewNop())
+
+	if updated.TaskState != Successful {
+		t.Error("Expected task state", Successful, "got", updated.TaskState)
+	}
+	if updated.HandledBy != live.URL {
+		t.Error("Expected the task to be handled by", live.URL, "got", updated.HandledBy)
+	}
+}
+
+func TestIsValid_callbackPool(t *testing.T) {
+	tsk := Task{Name: "t0", TriggerAt: "+1m", CallbackPool: []string{"http://a.example.com", "http://b.example.com"}}
+	if err := tsk.IsValid(0); err != nil {
+		t.Error("Expected a callback pool to replace the callback endpoint, failed with", err)
+	}
+
+	tsk.CallbackPoolWeights = []int{1}
+	if tsk.IsValid(0) == nil {
+		t.Error("Expected to fail with missing weights")
+	}
+
+	tsk.CallbackPoolWeights = []int{1, 0}
+	if tsk.IsValid(0) == nil {
+		t.Error("Expected to fail with a non-positive weight")
+	}
+}
+
+func TestIsValid_chainDepth(t *testing.T) {
+	tsk := Task{Name: "t0", TriggerAt: "+1m", CallbackEndpoint: "http://example.com"}
+	// build a chain one task longer than allowed
+	for i := 0; i <= MaxChainDepth; i++ {
+		next := tsk
+		tsk = Task{Name: "t0", TriggerAt: "+1m", CallbackEndpoint: "http://example.com", OnSuccess: &next}
+	}
+	if tsk.IsValid(0) == nil {
+		t.Error("Expected to fail with a chain longer than", MaxChainDepth)
+	}
+
+	// but a short chain is fine
+	if tsk.OnSuccess.OnSuccess.IsValid(0) != nil {
+		t.Error("Expected a chain of", MaxChainDepth, "tasks to be valid")
+	}
+
+	// follow-up tasks must be relative
+	tsk = Task{
+		Name:             "t0",
+		TriggerAt:        "+1m",
+		CallbackEndpoint: "http://example.com",
+		OnSuccess:        &Task{Name: "t1", TriggerAt: "2174245620", CallbackEndpoint: "http://example.com"},
+	}
+	if tsk.IsValid(0) == nil {
+		t.Error("Expected to fail with an absolute trigger_at on the follow-up task")
+	}
+}
+
+func TestMoveIfDayOff(t *testing.T) {
+	now := time.Unix(util.GetUnixMinute(), 0).UTC()
+	today := now.Format(util.DateLayout)
+	tomorrow := now.AddDate(0, 0, 1).Format(util.DateLayout)
+
+	for _, test := range []struct {
+		skipHolidays []string
+		holidays     []string
+		moved        bool
+	}{
+		{[]string{today}, nil, true},
+		{[]string{tomorrow}, []string{today}, true},
+		{[]string{tomorrow}, nil, false},
+		// global holidays only apply to calendar-aware tasks
+		{nil, []string{today}, false},
+	} {
+		tsk := Task{
+			Name:             "t0",
+			TriggerAt:        strconv.FormatInt(now.Unix(), 10),
+			CallbackEndpoint: "http://example.com",
+			SkipHolidays:     test.skipHolidays,
+		}
+		tsk.SetDefaults("", 0)
+
+		var updated Task
+		created := make([]Task, 0)
+		moved := tsk.MoveIfDayOff(
+			test.holidays,
+			func(t Task) error {
+				updated = t
+				return nil
+			},
+			func(t Task) error {
+				created = append(created, t)
+				return nil
+			},
+			zap.NewNop(),
+		)
+
+		if !test.moved {
+			if moved || updated.TaskState != "" || len(created) != 0 {
+				t.Error("Expected the task to run on", today, "with", test.skipHolidays, test.holidays)
+			}
+			continue
+		}
+
+		if !moved || updated.TaskState != Skipped {
+			t.Error("Expected the task to be skipped on", today, "with", test.skipHolidays, test.holidays)
+		}
+		if len(created) != 1 {
+			t.Fatal("Expected the task to be moved, got", len(created), "new tasks")
+		}
+		// both today and tomorrow are skipped in the second case
+		days := 1
+		if len(test.holidays) > 0 {
+			days = 2
+		}
+		expected := strconv.FormatInt(now.AddDate(0, 0, days).Unix(), 10)
+		if created[0].TriggerAt != expected || created[0].TaskState != Pending {
+			t.Error("Expected a pending task at", expected, "got", created[0].TriggerAt, created[0].TaskState)
+		}
+	}
+}
+
+func TestSetDefaults(t *testing.T) {
+	// built-in defaults
+	tsk := Task{}
+	tsk.SetDefaults("", 0)
+	if tsk.CallbackMethod != "GET" || tsk.ExpectedHTTPStatus != 200 || tsk.TaskState != Pending {
+		t.Error("Expected GET, 200, and pending, got", tsk.CallbackMethod, tsk.ExpectedHTTPStatus, tsk.TaskState)
+	}
+
+	// configured defaults apply to missing fields
+	tsk = Task{}
+	tsk.SetDefaults("POST", 202)
+	if tsk.CallbackMethod != "POST" || tsk.ExpectedHTTPStatus != 202 {
+		t.Error("Expected POST and 202, got", tsk.CallbackMethod, tsk.ExpectedHTTPStatus)
+	}
+
+	// but per-task values still win
+	tsk = Task{CallbackMethod: "PUT", ExpectedHTTPStatus: 204}
+	tsk.SetDefaults("POST", 202)
+	if tsk.CallbackMethod != "PUT" || tsk.ExpectedHTTPStatus != 204 {
+		t.Error("Expected PUT and 204, got", tsk.CallbackMethod, tsk.ExpectedHTTPStatus)
+	}
+}
+
+func TestIsValid_skipHolidays(t *testing.T) {
+	tsk := Task{Name: "t0", TriggerAt: "+1m", CallbackEndpoint: "http://example.com"}
+
+	tsk.SkipHolidays = []string{"2024-12-25", "2025-01-01"}
+	if err := tsk.IsValid(0); err != nil {
+		t.Error("Expected to succeed, failed with", err)
+	}
+
+	tsk.SkipHolidays = []string{"2024-12-25", "25/12/2024"}
+	if tsk.IsValid(0) == nil {
+		t.Error("Expected to fail with an invalid date")
+	}
+}
+
+func TestIsValid_payloadSize(t *testing.T) {
+	tsk := Task{Name: "t0", TriggerAt: "+1m", CallbackEndpoint: "http://example.com", Payload: "0123456789"}
+
+	if tsk.IsValid(9) == nil {
+		t.Error("Expected to fail with a payload over the limit")
+	}
+
+	for _, max := range []int{0, 10, 11} {
+		err := tsk.IsValid(max)
+		if err != nil {
+			t.Error("Expected to succeed with a limit of", max, "failed with", err)
+		}
+	}
+
+	// the limit also applies to follow-up tasks
+	tsk.OnSuccess = &Task{Name: "t1", TriggerAt: "+1m", CallbackEndpoint: "http://example.com", Payload: "0123456789"}
+	tsk.Payload = ""
+	if tsk.IsValid(9) == nil {
+		t.Error("Expected to fail with a follow-up task payload over the limit")
+	}
+}
+
+func TestIsValid_uuid(t *testing.T) {
+	tsk := Task{Name: "t0", TriggerAt: "+1m", CallbackEndpoint: "http://example.com"}
+
+	for _, uuid := range []string{"", "0123456789abcdef0123456789ABCDEF"} {
+		tsk.UUID = uuid
+		err := tsk.IsValid(0)
+		if err != nil {
+			t.Error("Expected to succeed with uuid", uuid, "failed with", err)
+		}
+	}
+
+	for _, uuid := range []string{"0123", "0123456789abcdef0123456789abcdeg", "01234567-89ab-cdef-0123-456789abcdef"} {
+		tsk.UUID = uuid
+		if tsk.IsValid(0) == nil {
+			t.Error("Expected to fail with uuid", uuid)
+		}
+	}
+}
+
+func TestIsValid_payloadRef(t *testing.T) {
+	tsk := Task{Name: "t0", TriggerAt: "+1m", CallbackEndpoint: "http://example.com"}
+
+	for _, ref := range []string{"https://example.com/payload.json", "s3://bucket/path/payload.json"} {
+		tsk.PayloadRef = ref
+		err := tsk.IsValid(0)
+		if err != nil {
+			t.Error("Expected to succeed with payload_ref", ref, "failed with", err)
+		}
+	}
+
+	for _, ref := range []string{"ftp://example.com/payload.json", "s3:///payload.json", "payload.json"} {
+		tsk.PayloadRef = ref
+		if tsk.IsValid(0) == nil {
+			t.Error("Expected to fail with payload_ref", ref)
+		}
+	}
+
+	// one payload or the other
+	tsk.PayloadRef = "https://example.com/payload.json"
+	tsk.Payload = "inline"
+	if tsk.IsValid(0) == nil {
+		t.Error("Expected to fail with both payload and payload_ref")
+	}
+}
+
+func TestNewUUID(t *testing.T) {
+	first, second := NewUUID(), NewUUID()
+	if !isValidUUID(first) || first == second {
+		t.Error("Expected two distinct, valid, UUIDs, got", first, "and", second)
+	}
+}
+
+func TestFollowUpUUID(t *testing.T) {
+	tsk := Task{Name: "t0", TriggerAt: "1800000000"}
+	first := tsk.FollowUpUUID()
+	if !isValidUUID(first) || tsk.FollowUpUUID() != first {
+		t.Error("Expected the same valid UUID every time, got", first, "and", tsk.FollowUpUUID())
+	}
+
+	for _, other := range []Task{
+		{Name: "t0", TriggerAt: "1800000060"},
+		{Name: "t0", TriggerAt: "1800000000", ChainDepth: 1},
+		{Name: "t0", TriggerAt: "1800000000", UUID: NewUUID()},
+	} {
+		if other.FollowUpUUID() == first {
+			t.Error("Expected a different follow-up UUID for", other)
+		}
+	}
+}
+
+func TestIsValid_weight(t *testing.T) {
+	tsk := Task{Name: "t0", TriggerAt: "+1m", CallbackEndpoint: "http://example.com", Weight: 5}
+	if err := tsk.IsValid(0); err != nil {
+		t.Error("Expected to succeed with a weight of 5, failed with", err)
+	}
+
+	tsk.Weight = -1
+	if err := tsk.IsValid(0); err == nil {
+		t.Error("Expected to fail with a negative weight")
+	}
+}
+
+func TestIsValid_labels(t *testing.T) {
+	tsk := Task{Name: "t0", TriggerAt: "+1m", CallbackEndpoint: "http://example.com"}
+
+	tsk.Labels = map[string]string{"team": "billing", "env": ""}
+	err := tsk.IsValid(0)
+	if err != nil {
+		t.Error("Expected to succeed with labels", tsk.Labels, "failed with", err)
+	}
+
+	tooMany := make(map[string]string)
+	for i := 0; i <= MaxLabels; i++ {
+		tooMany[strconv.Itoa(i)] = ""
+	}
+	for _, labels := range []map[string]string{
+		{"": "billing"},
+		{strings.Repeat("k", MaxLabelKeyBytes+1): "billing"},
+		{"team": strings.Repeat("v", MaxLabelValueBytes+1)},
+		tooMany,
+	} {
+		tsk.Labels = labels
+		if tsk.IsValid(0) == nil {
+			t.Error("Expected to fail with labels", labels)
+		}
+	}
+}
+
+func Test_normalizeTriggerAt_nextMinute(t *testing.T) {
+	// the current minute
+	now := int64(1800000000)
+	next := strconv.FormatInt(now+60, 10)
+
+	for _, input := range []string{"+0m", "+0h", "+0d", "+1m"} {
+		at, err := normalizeTriggerAt(input, now)
+		if err != nil {
+			t.Error("Expected to succeed with", input, "failed with", err)
+		}
+		if at != next {
+			t.Error("Expected", input, "to resolve to the next minute", next, "got", at)
+		}
+	}
+
+	// further in the future is left alone
+	at, err := normalizeTriggerAt("+2m", now)
+	if err != nil || at != strconv.FormatInt(now+120, 10) {
+		t.Error("Expected", now+120, "got", at, err)
+	}
+
+	// submitting at the very end of a minute resolves relative to the start of that minute
+	at, err = normalizeTriggerAt("+1m", util.GetUnixMinute())
+	if err != nil {
+		t.Error("Expected to succeed, failed with", err)
+	}
+	triggerAt, _ := strconv.ParseInt(at, 10, 64)
+	if triggerAt <= time.Now().Unix() {
+		t.Error("Expected", at, "to be strictly in the future")
+	}
+}
+
+func TestNormalizeTriggerAt(t *testing.T) {
+	// valid (2038 or something like that)
+	_, err := NormalizeTriggerAt("2174245620")
+	if err != nil {
+		t.Error("Expected to succeed (Unix time stamp), failed with", err)
+	}
+
+	// valid relative time
+	currentMinute := util.GetUnixMinute()
+	// 10 minutes from now
+	expect := currentMinute + 600
+	at, err := NormalizeTriggerAt("+10m")
+	if err != nil {
+		t.Error("Expected to succeed (relative time), failed with", err)
+	}
+	if at != strconv.FormatInt(expect, 10) {
+		t.Error("Expected", expect, "got", at)
+	}
+	// a day from now
+	expect = currentMinute + 86400
+	at, err = NormalizeTriggerAt("+1d")
+	if err != nil {
+		t.Error("Expected to succeed (relative time), failed with", err)
+	}
+	if at != strconv.FormatInt(expect, 10) {
+		t.Error("Expected", expect, "got", at)
+	}
+
+	// with bad input
+	for _, input := range []string{"", "+", "+m", "+6", "6h", "+6z"} {
+		tm, err := NormalizeTriggerAt(input)
+		if err == nil {
+			t.Error("Expected to fail with bad input", input, ", succeeded returning", tm)
+		}
+	}
+
+	// not in the future
+	tm, err := NormalizeTriggerAt("1227560820")
+	if err == nil {
+		t.Error("Expected to fail (past), succeeded returning", tm)
+	}
+
+	// future but not 1-minute resolution
+	tm, err = NormalizeTriggerAt("2174245625")
+	if err == nil {
+		t.Error("Expected to fail (not 1-minute), succeeded returning", tm)
+	}
+}
+
+func TestNormalizeTriggerAt_int64(t *testing.T) {
+	// 2100-01-01, well past the 32-bit rollover in 2038
+	at, err := NormalizeTriggerAt("4102444800")
+	if err != nil {
+		t.Error("Expected to succeed (beyond 2038), failed with", err)
+	}
+	if at != "4102444800" {
+		t.Error("Expected 4102444800, got", at)
+	}
+
+	// too large for an int64, must not wrap around
+	for _, input := range []string{"99999999999999999999", "+99999999999999999999m", "+9223372036854775807m"} {
+		tm, err := NormalizeTriggerAt(input)
+		if err == nil {
+			t.Error("Expected to fail (out of range)", input, ", succeeded returning", tm)
+		} else if !strings.Contains(err.Error(), "out of range") {
+			t.Error("Expected an out of range error for", input, ", got", err)
+		}
+	}
+}
+
+func TestCallback_acceptableHTTPStatuses(t *testing.T) {
+	// a redirect that cannot be followed
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusMovedPermanently)
+	}))
+	defer ts.Close()
+
+	tests := []struct {
+		acceptable []int
+		state      string
+	}{
+		{nil, Failed},
+		{[]int{http.StatusMovedPermanently}, Successful},
+		{[]int{http.StatusFound}, Failed},
+	}
+
+	for _, test := range tests {
+		tsk := Task{
+			Name:                   "t0",
+			CallbackEndpoint:       ts.URL,
+			TriggerAt:              strconv.FormatInt(util.GetUnixMinute(), 10),
+			AcceptableHTTPStatuses: test.acceptable,
+		}
+		tsk.SetDefaults("", 0)
+
+		var updated Task
+		tsk.Callback(context.Background(), http.DefaultClient, func(t Task) error {
+			updated = t
+			return nil
+		}, nil, CallbackOptions{StoreResponseBody: true}, zap.NewNop())
+
+		if updated.TaskState != test.state || updated.ResponseStatus != http.StatusMovedPermanently {
+			t.Error("Expected", test.state, "with 301, acceptable", test.acceptable,
+				"got", updated.TaskState, updated.ResponseStatus)
+		}
+	}
+}
+
+func TestIsValid_acceptableHTTPStatuses(t *testing.T) {
+	tsk := Task{Name: "t0", TriggerAt: "+1m", CallbackEndpoint: "http://example.com"}
+
+	tsk.AcceptableHTTPStatuses = []int{301, 302}
+	err := tsk.IsValid(0)
+	if err != nil {
+		t.Error("Expected to succeed, failed with", err)
+	}
+
+	tsk.AcceptableHTTPStatuses = []int{301, 3020}
+	if tsk.IsValid(0) == nil {
+		t.Error("Expected to fail with an invalid HTTP status")
+	}
+}
+
+func TestCallback_logger(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ts.Close()
+
+	tsk := Task{Name: "t0", TriggerAt: strconv.FormatInt(util.GetUnixMinute(), 10), CallbackEndpoint: ts.URL}
+	tsk.SetDefaults("", 0)
+
+	core, logs := observer.New(zap.DebugLevel)
+	noop := func(Task) error { return nil }
+	opts := CallbackOptions{StoreResponseBody: true}
+	tsk.Callback(context.Background(), http.DefaultClient, noop, noop, opts, zap.New(core))
+
+	if logs.Len() == 0 {
+		t.Fatal("Expected the callback to be logged")
+	}
+	for _, entry := range logs.All() {
+		fields := entry.ContextMap()
+		if fields["task_id"] != "t0@"+tsk.TriggerAt || fields["callback"] != ts.URL {
+			t.Error("Expected the task's details on all entries, got", fields)
+		}
+	}
+}
+
+func TestCallback_skipIfRecentSuccess(t *testing.T) {
+	requests := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+	}))
+	defer ts.Close()
+
+	tests := []struct {
+		succeeded bool
+		requests  int
+		state     string
+	}{
+		{true, 0, Skipped},
+		{false, 1, Successful},
+	}
+
+	for _, test := range tests {
+		tsk := Task{
+			Name:                       "t0",
+			TriggerAt:                  strconv.FormatInt(util.GetUnixMinute(), 10),
+			CallbackEndpoint:           ts.URL,
+			SkipIfRecentSuccessMinutes: 10,
+		}
+		tsk.SetDefaults("", 0)
+
+		requests = 0
+		var updated Task
+		tsk.Callback(
+			context.Background(),
+			http.DefaultClient,
+			func(t Task) error {
+				updated = t
+				return nil
+			},
+			nil,
+			CallbackOptions{StoreResponseBody: true, SucceededSince: func(name string, since int64) (bool, error) {
+				if name != "t0" || since > time.Now().Unix()-600 {
+					t.Error("Expected to look for t0 within the last 10 minutes, got", name, since)
+				}
+				return test.succeeded, nil
+			}},
+			zap.NewNop(),
+		)
+
+		if requests != test.requests {
+			t.Error("Expected", test.requests, "requests, got", requests)
+		}
+		if updated.TaskState != test.state {
+			t.Error("Expected task state", test.state, "got", updated.TaskState)
+		}
+	}
+}
+
+func TestDiff(t *testing.T) {
+	before := Task{Name: "t0", TriggerAt: "100", TaskState: Pending, CallbackEndpoint: "http://localhost"}
+	after := before
+	after.TriggerAt = "200"
+	after.TaskState = Running
+
+	expected := map[string]FieldDiff{
+		"trigger_at": {From: "100", To: "200"},
+		"task_state": {From: Pending, To: Running},
+	}
+	diff := before.Diff(after)
+	if !reflect.DeepEqual(diff, expected) {
+		t.Error("Expected diff", expected, "got", diff)
+	}
+
+	if diff := before.Diff(before); len(diff) != 0 {
+		t.Error("Expected no differences, got", diff)
+	}
+}
+
+func TestIsValid_queryParams(t *testing.T) {
+	tsk := Task{Name: "t0", TriggerAt: "+1m", CallbackEndpoint: "http://example.com/hook?source=callme"}
+
+	tsk.QueryParams = map[string]string{"id": "42"}
+	err := tsk.IsValid(0)
+	if err != nil {
+		t.Error("Expected to succeed, failed with", err)
+	}
+
+	tsk.QueryParams = map[string]string{"source": "other"}
+	if tsk.IsValid(0) == nil {
+		t.Error("Expected to fail with a query parameter already in the callback URL")
+	}
+
+	tsk.QueryParams = map[string]string{"id": "42"}
+	tsk.CallbackPool = []string{"http://a.example.com/hook?id=1"}
+	if tsk.IsValid(0) == nil {
+		t.Error("Expected to fail with a query parameter already in a callback pool URL")
+	}
+
+	tsk.CallbackPool = nil
+	tsk.QueryParams = map[string]string{"": "42"}
+	if tsk.IsValid(0) == nil {
+		t.Error("Expected to fail with an empty query parameter name")
+	}
+}
+
+func TestCallback_queryParams(t *testing.T) {
+	var query url.Values
+	var body string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query = r.URL.Query()
+		b, _ := ioutil.ReadAll(r.Body)
+		body = string(b)
+	}))
+	defer ts.Close()
+
+	tsk := Task{
+		Name:             "t0",
+		TriggerAt:        strconv.FormatInt(util.GetUnixMinute(), 10),
+		CallbackEndpoint: ts.URL + "/hook?source=callme",
+		CallbackMethod:   "POST",
+		Payload:          "a=b",
+		QueryParams:      map[string]string{"id": "42", "note": "a b&c"},
+	}
+	tsk.SetDefaults("", 0)
+	noop := func(Task) error { return nil }
+	opts := CallbackOptions{StoreResponseBody: true}
+	tsk.Callback(context.Background(), http.DefaultClient, noop, noop, opts, zap.NewNop())
+
+	if query.Get("source") != "callme" || query.Get("id") != "42" || query.Get("note") != "a b&c" {
+		t.Error("Expected the query parameters to be merged into the callback URL, got", query)
+	}
+	if body != "a=b" {
+		t.Error("Expected the payload as the body, got", body)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tsk := Task{
+		Name:                       "t0",
+		CallbackEndpoint:           "http://example.com",
+		CallbackMethod:             "FETCH",
+		SkipIfRecentSuccessMinutes: -1,
+		OnSuccess:                  &Task{Name: "t1", TriggerAt: "+1m", Weight: -1},
+	}
+
+	errs := tsk.Validate(0)
+	fields := make([]string, 0, len(errs))
+	for _, e := range errs {
+		fields = append(fields, e.Field)
+	}
+	expected := []string{"trigger_at", "callback_method", "skip_if_recent_success_minutes", "on_success.callback",
+		"on_success.weight"}
+	if !reflect.DeepEqual(fields, expected) {
+		t.Error("Expected errors on", expected, "got", fields)
+	}
+	if tsk.IsValid(0) != errs[0] {
+		t.Error("Expected IsValid to return the first error, got", tsk.IsValid(0))
+	}
+
+	tsk = Task{Name: "t0", TriggerAt: "+1m", CallbackEndpoint: "http://example.com"}
+	if errs := tsk.Validate(0); len(errs) != 0 {
+		t.Error("Expected no errors, got", errs)
+	}
+}
@@ -0,0 +1,64 @@
+package util
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// ErrBodyReadTimeout is returned when reading the body of a response takes longer than the body timeout of the client
+// it was sent with, e.g., a server that sends the headers right away but trickles the body
+var ErrBodyReadTimeout = errors.New("timed out reading the response body")
+
+// cuts off reading the body of a response some time after its headers were received, regardless of the overall
+// timeout of the client
+type bodyTimeoutTransport struct {
+	http.RoundTripper
+	timeout time.Duration
+}
+
+func (t bodyTimeoutTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx, cancel := context.WithCancel(req.Context())
+	resp, err := t.RoundTripper.RoundTrip(req.WithContext(ctx))
+	if err != nil {
+		cancel()
+		return resp, err
+	}
+
+	body := &deadlineBody{ReadCloser: resp.Body, cancel: cancel}
+	body.timer = time.AfterFunc(t.timeout, func() {
+		atomic.StoreInt32(&body.expired, 1)
+		cancel()
+	})
+	resp.Body = body
+
+	return resp, nil
+}
+
+// response body that fails with ErrBodyReadTimeout once its deadline has passed
+type deadlineBody struct {
+	io.ReadCloser
+	cancel  context.CancelFunc
+	timer   *time.Timer
+	expired int32
+}
+
+func (b *deadlineBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if err != nil && err != io.EOF && atomic.LoadInt32(&b.expired) == 1 {
+		return n, ErrBodyReadTimeout
+	}
+
+	return n, err
+}
+
+func (b *deadlineBody) Close() error {
+	b.timer.Stop()
+	err := b.ReadCloser.Close()
+	b.cancel()
+
+	return err
+}
@@ -0,0 +1,113 @@
+package util
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Cron is a parsed cron expression with the standard 5 fields: minute, hour, day of month, month, and day of week.
+// Each field may be *, a value, a range (a-b), a list (a,b), or any of these with a step (*/n, a-b/n).
+type Cron struct {
+	minute     map[int]bool
+	hour       map[int]bool
+	dayOfMonth map[int]bool
+	month      map[int]bool
+	dayOfWeek  map[int]bool
+	// as in the standard cron, if both day fields are restricted, matching either one is enough
+	anyDayOfMonth bool
+	anyDayOfWeek  bool
+}
+
+// ParseCron parses a cron expression
+func ParseCron(spec string) (Cron, error) {
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return Cron{}, errors.New("invalid cron expression, expected 5 fields: " + spec)
+	}
+
+	var c Cron
+	var err error
+	bounds := [][2]int{{0, 59}, {0, 23}, {1, 31}, {1, 12}, {0, 6}}
+	values := []*map[int]bool{&c.minute, &c.hour, &c.dayOfMonth, &c.month, &c.dayOfWeek}
+	for i, field := range fields {
+		*values[i], err = parseCronField(field, bounds[i][0], bounds[i][1])
+		if err != nil {
+			return Cron{}, errors.New("invalid cron expression: " + spec + ": " + err.Error())
+		}
+	}
+	c.anyDayOfMonth = fields[2] == "*"
+	c.anyDayOfWeek = fields[4] == "*"
+
+	return c, nil
+}
+
+// parse a single field of a cron expression into the set of values it matches
+func parseCronField(field string, min int, max int) (map[int]bool, error) {
+	values := make(map[int]bool)
+
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			n, err := strconv.Atoi(part[i+1:])
+			if err != nil || n <= 0 {
+				return nil, errors.New("invalid step: " + part)
+			}
+			step = n
+			part = part[:i]
+		}
+
+		from, to := min, max
+		if part != "*" {
+			bounds := strings.SplitN(part, "-", 2)
+			n, err := strconv.Atoi(bounds[0])
+			if err != nil {
+				return nil, errors.New("invalid value: " + part)
+			}
+			from, to = n, n
+			if len(bounds) == 2 {
+				to, err = strconv.Atoi(bounds[1])
+				if err != nil {
+					return nil, errors.New("invalid range: " + part)
+				}
+			}
+		}
+		if from < min || to > max || from > to {
+			return nil, errors.New("out of range: " + part)
+		}
+
+		for v := from; v <= to; v += step {
+			values[v] = true
+		}
+	}
+
+	return values, nil
+}
+
+// Matches returns true iff the expression matches the minute of t (in UTC)
+func (c Cron) Matches(t time.Time) bool {
+	t = t.UTC()
+	if !c.minute[t.Minute()] || !c.hour[t.Hour()] || !c.month[int(t.Month())] {
+		return false
+	}
+
+	dayOfMonth := c.dayOfMonth[t.Day()]
+	dayOfWeek := c.dayOfWeek[int(t.Weekday())]
+	if c.anyDayOfMonth || c.anyDayOfWeek {
+		return dayOfMonth && dayOfWeek
+	}
+	return dayOfMonth || dayOfWeek
+}
+
+// Previous returns the last minute, at or before t, matched by the expression, looking back at most limit
+func (c Cron) Previous(t time.Time, limit time.Duration) (time.Time, bool) {
+	t = t.UTC().Truncate(time.Minute)
+	for at := t; t.Sub(at) <= limit; at = at.Add(-time.Minute) {
+		if c.Matches(at) {
+			return at, true
+		}
+	}
+
+	return time.Time{}, false
+}
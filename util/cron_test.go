@@ -0,0 +1,67 @@
+package util
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseCron(t *testing.T) {
+	for _, spec := range []string{"* * * * *", "*/15 2-4 1,15 * 1-5", "0 22 * * 5", "30 1-23/2 * 1-12 0"} {
+		_, err := ParseCron(spec)
+		if err != nil {
+			t.Error("Expected to parse", spec, "failed with", err)
+		}
+	}
+
+	for _, spec := range []string{"", "* * * *", "60 * * * *", "* 24 * * *", "*/0 * * * *", "5-1 * * * *", "a * * * *"} {
+		_, err := ParseCron(spec)
+		if err == nil {
+			t.Error("Expected to fail parsing", spec)
+		}
+	}
+}
+
+func TestCron_Matches(t *testing.T) {
+	// Friday, 2024-03-15 22:30 UTC
+	at := time.Date(2024, 3, 15, 22, 30, 0, 0, time.UTC)
+
+	tests := []struct {
+		spec     string
+		expected bool
+	}{
+		{"* * * * *", true},
+		{"30 22 * * 5", true},
+		{"*/15 22 * * *", true},
+		{"*/20 22 * * *", false},
+		{"30 22 * * 1-4", false},
+		// either day field matches when both are restricted
+		{"30 22 1 * 5", true},
+		{"30 22 15 * 1", true},
+		{"30 22 1 * 1", false},
+	}
+
+	for _, test := range tests {
+		c, err := ParseCron(test.spec)
+		if err != nil {
+			t.Fatal("Expected to parse", test.spec, "failed with", err)
+		}
+		if c.Matches(at) != test.expected {
+			t.Error("Expected", test.spec, "to match", test.expected)
+		}
+	}
+}
+
+func TestCron_Previous(t *testing.T) {
+	c, _ := ParseCron("0 22 * * *")
+	at := time.Date(2024, 3, 15, 21, 59, 0, 0, time.UTC)
+
+	previous, ok := c.Previous(at, 48*time.Hour)
+	if !ok || !previous.Equal(time.Date(2024, 3, 14, 22, 0, 0, 0, time.UTC)) {
+		t.Error("Expected 2024-03-14 22:00, got", previous, ok)
+	}
+
+	_, ok = c.Previous(at, time.Hour)
+	if ok {
+		t.Error("Expected nothing within the last hour")
+	}
+}
@@ -0,0 +1,45 @@
+package util
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net"
+)
+
+// PermanentError is returned by SendHTTPRequestStreaming when a request fails in a way that retrying it cannot fix,
+// e.g., the host does not exist
+type PermanentError struct {
+	Reason string
+	Err    error
+}
+
+func (e *PermanentError) Error() string {
+	return "permanent error, " + e.Reason + ": " + e.Err.Error()
+}
+
+func (e *PermanentError) Unwrap() error {
+	return e.Err
+}
+
+// classify an error returned by http.Client.Do: a non-empty reason means it is permanent, anything else (connection
+// refused or reset, timeouts, ...) is transient and worth retrying
+func permanentErrorReason(err error) string {
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) && dnsErr.IsNotFound {
+		return "host not found"
+	}
+
+	var certErr *tls.CertificateVerificationError
+	var hostnameErr x509.HostnameError
+	var authorityErr x509.UnknownAuthorityError
+	var invalidErr x509.CertificateInvalidError
+	if errors.As(err, &certErr) ||
+		errors.As(err, &hostnameErr) ||
+		errors.As(err, &authorityErr) ||
+		errors.As(err, &invalidErr) {
+		return "invalid certificate"
+	}
+
+	return ""
+}
@@ -0,0 +1,49 @@
+package util
+
+import (
+	"net"
+	"strconv"
+	"strings"
+)
+
+// StatsD sends metrics over UDP in the DogStatsD format (as expected by Datadog): <name>:<value>|<type>|#<tags>
+// Metrics are sent on a best-effort basis, errors are ignored.
+type StatsD struct {
+	conn net.Conn
+	// tags added to every metric
+	tags []string
+}
+
+// NewStatsD returns a client that sends metrics to the StatsD server listening on addr (host:port)
+func NewStatsD(addr string, tags ...string) (*StatsD, error) {
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	return &StatsD{conn: conn, tags: tags}, nil
+}
+
+// Count adds value to a counter
+func (s *StatsD) Count(name string, value int64, tags ...string) {
+	s.send(name, strconv.FormatInt(value, 10), "c", tags)
+}
+
+// Histogram records a value in a histogram
+func (s *StatsD) Histogram(name string, value float64, tags ...string) {
+	s.send(name, strconv.FormatFloat(value, 'f', -1, 64), "h", tags)
+}
+
+func (s *StatsD) send(name string, value string, metricType string, tags []string) {
+	all := append(append(make([]string, 0, len(tags)+len(s.tags)), tags...), s.tags...)
+	_, _ = s.conn.Write([]byte(formatStatsD(name, value, metricType, all)))
+}
+
+func formatStatsD(name string, value string, metricType string, tags []string) string {
+	metric := name + ":" + value + "|" + metricType
+	if len(tags) > 0 {
+		metric += "|#" + strings.Join(tags, ",")
+	}
+
+	return metric
+}
@@ -2,16 +2,72 @@ package util
 
 import (
 	"bytes"
+	"context"
+	"io"
 	"io/ioutil"
 	"math/rand"
 	"net"
 	"net/http"
 	"runtime"
+	"sync"
 	"time"
 
 	"go.uber.org/zap"
 )
 
+// DateLayout is the format of ISO 8601 dates (without time)
+const DateLayout = "2006-01-02"
+
+// source of randomness used to add jitter; it can be replaced (e.g., by tests) with SetRandSource
+var (
+	randMutex sync.Mutex
+	random    = rand.New(rand.NewSource(time.Now().UnixNano()))
+)
+
+// SetRandSource replaces the source of randomness used to add jitter. Using a source with a fixed seed makes the
+// wait periods computed by Backoff deterministic.
+func SetRandSource(src rand.Source) {
+	randMutex.Lock()
+	defer randMutex.Unlock()
+
+	random = rand.New(src)
+}
+
+// random number in [0, n) -- rand.Rand is not safe for concurrent use
+func randInt63n(n int64) int64 {
+	randMutex.Lock()
+	defer randMutex.Unlock()
+
+	return random.Int63n(n)
+}
+
+// WeightedShuffle returns a random permutation of the indexes of weights, where the probability of an index coming
+// before the remaining ones is proportional to its weight. All weights must be positive.
+func WeightedShuffle(weights []int) []int {
+	remaining := make([]int, len(weights))
+	total := int64(0)
+	for i, w := range weights {
+		remaining[i] = i
+		total += int64(w)
+	}
+
+	order := make([]int, 0, len(weights))
+	for len(remaining) > 0 {
+		r := randInt63n(total)
+		for j, i := range remaining {
+			r -= int64(weights[i])
+			if r < 0 {
+				order = append(order, i)
+				total -= int64(weights[i])
+				remaining = append(remaining[:j], remaining[j+1:]...)
+				break
+			}
+		}
+	}
+
+	return order
+}
+
 // GetCurrentMinuteUnix returns the Unix current timestamp with 1-minute resolution
 func GetUnixMinute() int64 {
 	now := time.Now().Unix()
@@ -43,11 +99,40 @@ func getCaller(logger *zap.Logger) string {
 // increasingly high values for i. The random factor is used to introduce jitter and avoid deterministic wait periods
 // between retries. The parameter logger is a pointer to an already initialized instance of zap.Logger.
 func Backoff(i int, logger *zap.Logger) {
-	caller := getCaller(logger)
+	_ = backoff(context.Background(), i, 0, getCaller(logger), logger)
+}
+
+// BackoffAtLeast is the same as Backoff, but never sleeps for less than floor, e.g., to respect the rate at which a
+// downstream service accepts requests.
+func BackoffAtLeast(i int, floor time.Duration, logger *zap.Logger) {
+	_ = backoff(context.Background(), i, floor, getCaller(logger), logger)
+}
+
+// BackoffContext is the same as Backoff, but returns ctx.Err() as soon as ctx is done instead of sleeping through it.
+func BackoffContext(ctx context.Context, i int, logger *zap.Logger) error {
+	return backoff(ctx, i, 0, getCaller(logger), logger)
+}
+
+// sleep for the i-th retry, at least floor, unless ctx is done first
+func backoff(ctx context.Context, i int, floor time.Duration, caller string, logger *zap.Logger) error {
 	if caller == "" {
 		caller = "unknown"
 	}
 
+	wait := backoffWait(i, floor.Milliseconds())
+	logger.Debug("Exponential back off", zap.Int64("ms", wait), zap.String("caller", caller))
+	timer := time.NewTimer(time.Duration(wait) * time.Millisecond)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// number of milliseconds to wait on the i-th retry, but never less than floor
+func backoffWait(i int, floor int64) int64 {
 	// 2^i -- this will always be used for very small values (number of retries), so the signed/unsigned type casts
 	// are safe
 	var wait int64 = 1
@@ -58,27 +143,83 @@ func Backoff(i int, logger *zap.Logger) {
 	wait *= 100
 	// add jitter -- random(wait/2, wait)
 	min := wait / 2
-	wait = rand.Int63n(wait-min) + min
-	logger.Debug("Exponential back off", zap.Int64("ms", wait), zap.String("caller", caller))
-	time.Sleep(time.Duration(wait) * time.Millisecond)
+	wait = randInt63n(wait-min) + min
+	if wait < floor {
+		return floor
+	}
+	return wait
 }
 
-// NewHTTPClient initializes and returns an HTTP client instance with proper connect and client timeout values
-func NewHTTPClient(connectTimeout int, clientTimeout int) *http.Client {
-	tr := &http.Transport{
+// NewHTTPClient initializes and returns an HTTP client instance with proper connect and client timeout values.
+// Reading the body of a response fails with ErrBodyReadTimeout bodyTimeout milliseconds after its headers were
+// received, if positive; otherwise only the client timeout applies.
+// Connections are kept alive and reused: up to maxIdleConns idle connections (all of which may be to the same host)
+// are kept for idleConnTimeout milliseconds. The number of connections per host is limited to maxConnsPerHost,
+// 0 meaning no limit.
+func NewHTTPClient(
+	connectTimeout int,
+	clientTimeout int,
+	bodyTimeout int,
+	maxIdleConns int,
+	idleConnTimeout int,
+	maxConnsPerHost int,
+) *http.Client {
+	return newHTTPClient(
+		newTransport(connectTimeout, maxIdleConns, idleConnTimeout, maxConnsPerHost),
+		clientTimeout,
+		bodyTimeout,
+	)
+}
+
+// NewHTTP2Client is the same as NewHTTPClient, but requests are only ever made over HTTP/2: negotiated with ALPN over
+// TLS and, for plain HTTP, with prior knowledge (h2c). Servers that do not speak HTTP/2 cannot be reached with it.
+func NewHTTP2Client(
+	connectTimeout int,
+	clientTimeout int,
+	bodyTimeout int,
+	maxIdleConns int,
+	idleConnTimeout int,
+	maxConnsPerHost int,
+) *http.Client {
+	tr := newTransport(connectTimeout, maxIdleConns, idleConnTimeout, maxConnsPerHost)
+	protocols := new(http.Protocols)
+	protocols.SetHTTP2(true)
+	protocols.SetUnencryptedHTTP2(true)
+	tr.Protocols = protocols
+
+	return newHTTPClient(tr, clientTimeout, bodyTimeout)
+}
+
+// client with an overall timeout and, if bodyTimeout is positive, a separate one (both in milliseconds) to read the
+// body of responses once their headers have been received
+func newHTTPClient(tr *http.Transport, clientTimeout int, bodyTimeout int) *http.Client {
+	var rt http.RoundTripper = tr
+	if bodyTimeout > 0 {
+		rt = bodyTimeoutTransport{RoundTripper: tr, timeout: time.Duration(bodyTimeout) * time.Millisecond}
+	}
+
+	return &http.Client{
+		Transport: rt,
+		Timeout:   time.Duration(clientTimeout) * time.Millisecond,
+	}
+}
+
+func newTransport(connectTimeout int, maxIdleConns int, idleConnTimeout int, maxConnsPerHost int) *http.Transport {
+	return &http.Transport{
 		Proxy: http.ProxyFromEnvironment,
 		DialContext: (&net.Dialer{
 			Timeout:   time.Duration(connectTimeout) * time.Millisecond,
 			DualStack: true,
 		}).DialContext,
-	}
-
-	return &http.Client{
-		Transport: tr,
-		Timeout:   time.Duration(clientTimeout) * time.Millisecond,
+		MaxIdleConns:        maxIdleConns,
+		MaxIdleConnsPerHost: maxIdleConns,
+		MaxConnsPerHost:     maxConnsPerHost,
+		IdleConnTimeout:     time.Duration(idleConnTimeout) * time.Millisecond,
 	}
 }
 
+// SendHTTPRequest makes a request, retrying on server side errors, and returns the status code and body of the
+// response
 func SendHTTPRequest(
 	url string,
 	payload []byte,
@@ -89,16 +230,85 @@ func SendHTTPRequest(
 	maxRetries int,
 	logger *zap.Logger,
 ) (int, []byte) {
-	// we always want to return the status and body, so it must exist outside of the scope of the for loop
+	var body bytes.Buffer
+
+	status, err := SendHTTPRequestStreaming(
+		url,
+		payload,
+		headers,
+		method,
+		client,
+		expectedStatusCode,
+		maxRetries,
+		&body,
+		logger,
+	)
+	if err != nil {
+		return status, []byte(err.Error())
+	}
+
+	return status, body.Bytes()
+}
+
+// HeaderReceiver may be implemented by the io.Writer passed to SendHTTPRequestStreaming to also get the headers of
+// the response whose body is written to it
+type HeaderReceiver interface {
+	ReceiveHeader(header http.Header)
+}
+
+// SendHTTPRequestStreaming is the same as SendHTTPRequest but, instead of reading the whole response body into
+// memory, it copies it to w. Only the body of the response that is returned is written, i.e., that of the last
+// attempt unless it succeeds or fails with a client side error before that.
+func SendHTTPRequestStreaming(
+	url string,
+	payload []byte,
+	headers http.Header,
+	method string,
+	client *http.Client,
+	expectedStatusCode int,
+	maxRetries int,
+	w io.Writer,
+	logger *zap.Logger,
+) (int, error) {
+	return SendHTTPRequestStreamingContext(
+		context.Background(),
+		url,
+		payload,
+		headers,
+		method,
+		client,
+		expectedStatusCode,
+		maxRetries,
+		w,
+		logger,
+	)
+}
+
+// SendHTTPRequestStreamingContext is the same as SendHTTPRequestStreaming but requests are bound to ctx; once it's
+// canceled, the request in progress, or the wait before the next one, is aborted and no more attempts are made.
+// Requests that fail in a way retrying cannot fix, such as an unknown host or an invalid certificate, are not retried
+// either: a *PermanentError is returned right away.
+func SendHTTPRequestStreamingContext(
+	ctx context.Context,
+	url string,
+	payload []byte,
+	headers http.Header,
+	method string,
+	client *http.Client,
+	expectedStatusCode int,
+	maxRetries int,
+	w io.Writer,
+	logger *zap.Logger,
+) (int, error) {
+	// we always want to return the status, so it must exist outside of the scope of the for loop
 	var status int
 	var err error
 	var req *http.Request
-	var body []byte
 
 	for i := 0; i < maxRetries; i++ {
 		var resp *http.Response
 
-		req, err = http.NewRequest(method, url, bytes.NewReader(payload))
+		req, err = http.NewRequestWithContext(ctx, method, url, bytes.NewReader(payload))
 		if err != nil {
 			logger.Error("Failed to create HTTP request", zap.Error(err))
 		}
@@ -109,50 +319,113 @@ func SendHTTPRequest(
 			}
 		}
 
-		if method == "POST" {
+		switch method {
+		case "POST":
 			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		case "PATCH":
+			req.Header.Set("Content-Type", "application/json")
 		}
 
 		resp, err = client.Do(req)
+		if ctx.Err() != nil {
+			if err == nil {
+				resp.Body.Close()
+			}
+			return status, ctx.Err()
+		}
 		if err != nil {
+			if reason := permanentErrorReason(err); reason != "" {
+				logger.Error("Failed "+method+", not retrying", zap.String("reason", reason), zap.Error(err))
+				return status, &PermanentError{Reason: reason, Err: err}
+			}
 			logger.Error(
 				"Failed "+method,
 				zap.Int("attempt", i),
 				zap.Error(err),
 			)
-			Backoff(i, logger)
+			if err := BackoffContext(ctx, i, logger); err != nil {
+				return status, err
+			}
 			continue
 		}
-		body, err = ioutil.ReadAll(resp.Body)
+
+		// the body is only kept if this is the response we're returning
+		final := resp.StatusCode == expectedStatusCode ||
+			(resp.StatusCode >= 300 && resp.StatusCode <= 499) ||
+			i == maxRetries-1
+		dst := ioutil.Discard
+		if final {
+			dst = w
+			if r, ok := w.(HeaderReceiver); ok {
+				r.ReceiveHeader(resp.Header)
+			}
+		}
+		// responses to HEAD requests have no body
+		if method != "HEAD" {
+			_, err = io.Copy(dst, resp.Body)
+		}
 		resp.Body.Close()
 		if err != nil {
 			logger.Error("Failed to read the response body", zap.Error(err))
-			Backoff(i, logger)
+			// part of the body may have already been written, retrying would only corrupt it
+			if final {
+				return status, err
+			}
+			if err := BackoffContext(ctx, i, logger); err != nil {
+				return status, err
+			}
 			continue
 		}
 
 		if resp.StatusCode == expectedStatusCode {
 			// success, we can stop here
-			return resp.StatusCode, body
+			return resp.StatusCode, nil
 		} else {
-			// client side error, no point on trying to continue
-			if resp.StatusCode >= 400 && resp.StatusCode <= 499 {
-				return resp.StatusCode, body
+			// client side error, or a redirect that was not followed (e.g., no Location), no point on trying to continue
+			if resp.StatusCode >= 300 && resp.StatusCode <= 499 {
+				return resp.StatusCode, nil
 			}
 			// server side error, could be a number of things; we should wait and retry
 			if resp.StatusCode >= 500 && resp.StatusCode <= 599 {
 				// save for return
 				status = resp.StatusCode
-				Backoff(i, logger)
+				if err := BackoffContext(ctx, i, logger); err != nil {
+					return status, err
+				}
 			}
 		}
 	}
 
 	// if we made it this far, the write failed
 	// the status code will be 5XY or 0 (initialized as), depending on whether or not a connection was actually
-	if err != nil {
-		return status, []byte(err.Error())
+	return status, err
+}
+
+// NextWorkingDay returns the same time of day on the first day after t that is neither on a weekend nor on any of
+// the days in skip (only the date, in UTC, is taken into account).
+func NextWorkingDay(t time.Time, skip []time.Time) time.Time {
+	return nextDay(t, skip, true)
+}
+
+// NextDayExcept returns the same time of day on the first day after t that is not on any of the days in skip
+// (only the date, in UTC, is taken into account).
+func NextDayExcept(t time.Time, skip []time.Time) time.Time {
+	return nextDay(t, skip, false)
+}
+
+func nextDay(t time.Time, skip []time.Time, skipWeekends bool) time.Time {
+	skipped := make(map[string]bool, len(skip))
+	for _, day := range skip {
+		skipped[day.UTC().Format(DateLayout)] = true
 	}
 
-	return status, body
+	next := t.AddDate(0, 0, 1)
+	for {
+		utc := next.UTC()
+		weekend := utc.Weekday() == time.Saturday || utc.Weekday() == time.Sunday
+		if !(skipWeekends && weekend) && !skipped[utc.Format(DateLayout)] {
+			return next
+		}
+		next = next.AddDate(0, 0, 1)
+	}
 }
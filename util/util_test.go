@@ -1,6 +1,15 @@
 package util
 
 import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httptrace"
+	"syscall"
 	"testing"
 	"time"
 
@@ -9,6 +18,7 @@ import (
 
 func TestBackoff(t *testing.T) {
 	logger := zap.NewNop()
+	SetRandSource(rand.NewSource(1))
 
 	for i := 0; i < 3; i++ {
 		start := time.Now()
@@ -21,6 +31,194 @@ func TestBackoff(t *testing.T) {
 	}
 }
 
+func TestBackoffContext(t *testing.T) {
+	logger := zap.NewNop()
+
+	if err := BackoffContext(context.Background(), 0, logger); err != nil {
+		t.Error("Expected to wait, got", err)
+	}
+
+	// 2^10*100ms, unless canceled
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(10*time.Millisecond, cancel)
+	start := time.Now()
+	err := BackoffContext(ctx, 10, logger)
+	if err != context.Canceled {
+		t.Error("Expected", context.Canceled, "got", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Error("Expected to return as soon as the context was canceled, took", elapsed)
+	}
+}
+
+func TestBackoffAtLeast(t *testing.T) {
+	start := time.Now()
+	// random(50, 100)ms, but at least 150ms
+	BackoffAtLeast(0, 150*time.Millisecond, zap.NewNop())
+	if elapsed := time.Since(start); elapsed < 150*time.Millisecond {
+		t.Error("Expected to sleep for at least 150ms, slept for", elapsed)
+	}
+}
+
+func Test_backoffWait_floor(t *testing.T) {
+	for i := 0; i < 5; i++ {
+		for j := 0; j < 100; j++ {
+			if wait := backoffWait(i, 300); wait < 300 {
+				t.Fatal("Expected to wait for at least 300ms, got", wait, "on retry", i)
+			}
+		}
+	}
+
+	// the floor only applies to shorter waits, the longest ones are left alone
+	SetRandSource(rand.NewSource(42))
+	long := backoffWait(5, 0)
+	SetRandSource(rand.NewSource(42))
+	if backoffWait(5, 300) != long {
+		t.Error("Expected a wait of", long, "got", backoffWait(5, 300))
+	}
+}
+
+func Test_backoffWait(t *testing.T) {
+	run := func() []int64 {
+		SetRandSource(rand.NewSource(42))
+		waits := make([]int64, 0)
+		for i := 0; i < 5; i++ {
+			waits = append(waits, backoffWait(i, 0))
+		}
+		return waits
+	}
+
+	first := run()
+	second := run()
+	for i := range first {
+		if first[i] != second[i] {
+			t.Error("Expected reproducible wait periods, got", first, "and", second)
+			break
+		}
+		// random(2^i*100/2, 2^i*100)
+		max := int64(100 << uint(i))
+		if first[i] < max/2 || first[i] >= max {
+			t.Error("Expected wait in [", max/2, "--", max, ") got", first[i])
+		}
+	}
+}
+
+func TestSendHTTPRequest_patch(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		if r.Method != "PATCH" || r.Header.Get("Content-Type") != "application/json" || string(body) != `{"a":1}` {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.Write([]byte("patched"))
+	}))
+	defer ts.Close()
+
+	status, body := SendHTTPRequest(
+		ts.URL, []byte(`{"a":1}`), http.Header{}, "PATCH", http.DefaultClient, 200, 1, zap.NewNop(),
+	)
+	if status != 200 || string(body) != "patched" {
+		t.Error("Expected 200 and patched, got", status, string(body))
+	}
+}
+
+func TestSendHTTPRequest_redirect(t *testing.T) {
+	attempts := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		// no Location, the client cannot follow it
+		w.WriteHeader(http.StatusMovedPermanently)
+		w.Write([]byte("moved"))
+	}))
+	defer ts.Close()
+
+	status, body := SendHTTPRequest(ts.URL, nil, http.Header{}, "GET", http.DefaultClient, 200, 3, zap.NewNop())
+	if status != http.StatusMovedPermanently || string(body) != "moved" {
+		t.Error("Expected 301 and moved, got", status, string(body))
+	}
+	if attempts != 1 {
+		t.Error("Expected a redirect not to be retried, got", attempts, "attempts")
+	}
+}
+
+func TestSendHTTPRequest_head(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "HEAD" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer ts.Close()
+
+	status, body := SendHTTPRequest(ts.URL, nil, http.Header{}, "HEAD", http.DefaultClient, 204, 1, zap.NewNop())
+	if status != 204 || len(body) != 0 {
+		t.Error("Expected 204 and no body, got", status, string(body))
+	}
+}
+
+func TestSendHTTPRequestStreaming(t *testing.T) {
+	attempts := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		if attempts == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write([]byte("unavailable"))
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer ts.Close()
+
+	// only the body of the response that is returned is written
+	var body bytes.Buffer
+	status, err := SendHTTPRequestStreaming(
+		ts.URL, nil, http.Header{}, "GET", http.DefaultClient, 200, 2, &body, zap.NewNop(),
+	)
+	if err != nil || status != 200 || body.String() != "ok" {
+		t.Error("Expected 200 and ok, got", status, body.String(), err)
+	}
+}
+
+func TestWeightedShuffle(t *testing.T) {
+	SetRandSource(rand.NewSource(1))
+
+	first := make([]int, 3)
+	for i := 0; i < 1000; i++ {
+		order := WeightedShuffle([]int{1, 10, 100})
+		if len(order) != 3 || order[0]+order[1]+order[2] != 3 || order[0] == order[1] {
+			t.Fatal("Expected a permutation of [0, 1, 2], got", order)
+		}
+		first[order[0]]++
+	}
+
+	// the heavier the weight the more likely to come first
+	if !(first[2] > first[1] && first[1] > first[0]) {
+		t.Error("Expected the number of times each index came first to follow the weights, got", first)
+	}
+}
+
+func TestNextWorkingDay(t *testing.T) {
+	// Friday, 2024-12-20 10:30 UTC
+	friday := time.Date(2024, 12, 20, 10, 30, 0, 0, time.UTC)
+	monday := time.Date(2024, 12, 23, 10, 30, 0, 0, time.UTC)
+	tuesday := time.Date(2024, 12, 24, 10, 30, 0, 0, time.UTC)
+	saturday := time.Date(2024, 12, 21, 10, 30, 0, 0, time.UTC)
+
+	if next := NextWorkingDay(friday, nil); !next.Equal(monday) {
+		t.Error("Expected", monday, "got", next)
+	}
+	if next := NextWorkingDay(friday, []time.Time{monday}); !next.Equal(tuesday) {
+		t.Error("Expected", tuesday, "got", next)
+	}
+	if next := NextDayExcept(friday, nil); !next.Equal(saturday) {
+		t.Error("Expected", saturday, "got", next)
+	}
+	if next := NextDayExcept(friday, []time.Time{saturday}); !next.Equal(saturday.AddDate(0, 0, 1)) {
+		t.Error("Expected", saturday.AddDate(0, 0, 1), "got", next)
+	}
+}
+
 func Test_getCaller(t *testing.T) {
 	logger := zap.NewNop()
 
@@ -30,3 +228,167 @@ func Test_getCaller(t *testing.T) {
 		t.Error("Failed to get caller. Expected", expected, ", got", caller)
 	}
 }
+
+func BenchmarkNewHTTPClient_connectionReuse(b *testing.B) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ts.Close()
+
+	client := NewHTTPClient(1000, 3000, 0, 100, 90000, 0)
+
+	// count the number of new connections established
+	newConns := 0
+	trace := &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			if !info.Reused {
+				newConns++
+			}
+		},
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		req, _ := http.NewRequest("GET", ts.URL, nil)
+		req = req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
+		resp, err := client.Do(req)
+		if err != nil {
+			b.Fatal("Request failed:", err)
+		}
+		ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+	}
+	b.StopTimer()
+
+	if newConns != 1 {
+		b.Error("Expected a single connection to be reused across requests, got", newConns)
+	}
+}
+
+func TestStatsD(t *testing.T) {
+	server, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("Failed to listen:", err)
+	}
+	defer server.Close()
+
+	client, err := NewStatsD(server.LocalAddr().String(), "env:test")
+	if err != nil {
+		t.Fatal("Expected to succeed, failed with", err)
+	}
+
+	tests := []struct {
+		send     func()
+		expected string
+	}{
+		{func() { client.Count("callme.task.executed", 1, "state:failed") }, "callme.task.executed:1|c|#state:failed,env:test"},
+		{func() { client.Histogram("callme.callback.duration", 12.5) }, "callme.callback.duration:12.5|h|#env:test"},
+	}
+
+	buf := make([]byte, 1024)
+	for _, test := range tests {
+		test.send()
+		server.SetReadDeadline(time.Now().Add(time.Second))
+		n, _, err := server.ReadFrom(buf)
+		if err != nil || string(buf[:n]) != test.expected {
+			t.Error("Expected", test.expected, "got", string(buf[:n]), err)
+		}
+	}
+}
+
+// HTTP client whose every connection attempt fails with err
+func failingClient(err error, dials *int) *http.Client {
+	return &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			*dials++
+			return nil, err
+		},
+	}}
+}
+
+func TestSendHTTPRequestStreaming_permanentError(t *testing.T) {
+	dials := 0
+	client := failingClient(&net.DNSError{Err: "no such host", Name: "nowhere.invalid", IsNotFound: true}, &dials)
+
+	var body bytes.Buffer
+	_, err := SendHTTPRequestStreaming(
+		"http://nowhere.invalid", nil, http.Header{}, "GET", client, 200, 3, &body, zap.NewNop(),
+	)
+	permanent, ok := err.(*PermanentError)
+	if !ok || permanent.Reason != "host not found" {
+		t.Error("Expected a permanent error, got", err)
+	}
+	if dials != 1 {
+		t.Error("Expected no retries, got", dials, "attempts")
+	}
+}
+
+func TestSendHTTPRequestStreaming_transientError(t *testing.T) {
+	dials := 0
+	client := failingClient(&net.OpError{Op: "read", Net: "tcp", Err: syscall.ECONNRESET}, &dials)
+
+	var body bytes.Buffer
+	_, err := SendHTTPRequestStreaming(
+		"http://example.com", nil, http.Header{}, "GET", client, 200, 2, &body, zap.NewNop(),
+	)
+	if _, ok := err.(*PermanentError); ok || err == nil {
+		t.Error("Expected a transient error, got", err)
+	}
+	if dials != 2 {
+		t.Error("Expected 2 attempts, got", dials)
+	}
+}
+
+func TestNewHTTP2Client(t *testing.T) {
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ProtoMajor != 2 {
+			w.WriteHeader(http.StatusHTTPVersionNotSupported)
+			return
+		}
+		w.Write([]byte("h2"))
+	}))
+	// plain HTTP/2 only, i.e., h2c with prior knowledge
+	ts.Config.Protocols = new(http.Protocols)
+	ts.Config.Protocols.SetUnencryptedHTTP2(true)
+	ts.Start()
+	defer ts.Close()
+
+	client := NewHTTP2Client(1000, 1000, 0, 1, 1000, 0)
+	status, body := SendHTTPRequest(ts.URL, nil, http.Header{}, "GET", client, 200, 1, zap.NewNop())
+	if status != 200 || string(body) != "h2" {
+		t.Error("Expected 200 and h2, got", status, string(body))
+	}
+}
+
+func TestSendHTTPRequestStreaming_bodyTimeout(t *testing.T) {
+	stalled := make(chan bool)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("partial"))
+		w.(http.Flusher).Flush()
+		// the rest of the body never comes
+		if r.URL.Path == "/stall" {
+			<-stalled
+		}
+	}))
+	defer ts.Close()
+	// before closing the server, which waits for the handler to return
+	defer close(stalled)
+
+	// the body has to be read within 100ms of receiving the headers, well before the client times out
+	client := NewHTTPClient(1000, 5000, 100, 1, 1000, 0)
+
+	var body bytes.Buffer
+	start := time.Now()
+	_, err := SendHTTPRequestStreaming(ts.URL+"/stall", nil, http.Header{}, "GET", client, 200, 1, &body, zap.NewNop())
+	if err != ErrBodyReadTimeout {
+		t.Error("Expected the body read to time out, got", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Error("Expected to give up on the body within 100ms, took", elapsed)
+	}
+
+	// bodies read in time are not affected
+	body.Reset()
+	status, err := SendHTTPRequestStreaming(ts.URL, nil, http.Header{}, "GET", client, 200, 1, &body, zap.NewNop())
+	if err != nil || status != 200 || body.String() != "partial" {
+		t.Error("Expected 200 partial, got", status, body.String(), err)
+	}
+}